@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/mailer"
+	"github.com/mathieuhays/auth/internal/passwords"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAPITestHandler(t testing.TB, opts serverOptions) (http.Handler, *users.UserMemoryStore, *mailer.MemoryMailer, *apikey.Service) {
+	t.Helper()
+	emailTemplates, err := EmailTemplates()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	memoryMailer := mailer.NewMemoryMailer()
+	notifier := mailer.NewNotifier(memoryMailer, emailTemplates, "no-reply@example.com", "https://example.com")
+
+	userStore := users.NewUserMemoryStore()
+	hasher := passwords.NewManager(passwords.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	userService := user.NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(), user.WithPasswordHasher(hasher))
+
+	apiKeyService := apikey.NewService(apikeys.NewAPIKeyMemoryStore(), userStore)
+
+	return newAPIHandler(userService, apiKeyService, notifier, audit.NewLogger(audit.NewRing(100)), opts), userStore, memoryMailer, apiKeyService
+}
+
+func apiRequest(t testing.TB, handler http.Handler, method, target, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	request := httptest.NewRequest(method, target, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func decodeAPIResponse(t testing.TB, response *httptest.ResponseRecorder, value any) {
+	t.Helper()
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		t.Fatalf("invalid JSON response: %s", err)
+	}
+}
+
+type apiTestError struct {
+	Error struct {
+		Code   string              `json:"code"`
+		Fields map[string][]string `json:"fields"`
+	} `json:"error"`
+}
+
+func apiLogin(t testing.TB, handler http.Handler, email, password string) string {
+	t.Helper()
+	response := apiRequest(t, handler, http.MethodPost, "/api/v1/login", "", map[string]string{"email": email, "password": password})
+	asserts.StatusCode(t, response, http.StatusOK)
+
+	var login struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	decodeAPIResponse(t, response, &login)
+	if login.AccessToken == "" || login.TokenType != "Bearer" {
+		t.Fatalf("unexpected login response: %v", login)
+	}
+
+	return login.AccessToken
+}
+
+func TestAPI(t *testing.T) {
+	handler, _, memoryMailer, _ := newAPITestHandler(t, serverOptions{})
+	credentials := map[string]string{"email": "test@example.com", "password": "correct horse battery"}
+
+	t.Run("register", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", credentials)
+		asserts.StatusCode(t, response, http.StatusAccepted)
+		asserts.JSONContentType(t, response)
+
+		// an existing account gets the same answer
+		response = apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", credentials)
+		asserts.StatusCode(t, response, http.StatusAccepted)
+
+		if messages := memoryMailer.Messages(); len(messages) != 2 {
+			t.Errorf("expected a confirmation and an account exists email. got: %d", len(messages))
+		}
+	})
+
+	t.Run("register validation", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", map[string]string{"email": "invalid", "password": "short"})
+		asserts.StatusCode(t, response, http.StatusUnprocessableEntity)
+
+		var body apiTestError
+		decodeAPIResponse(t, response, &body)
+		if body.Error.Code != "validation_failed" || len(body.Error.Fields["email"]) == 0 || len(body.Error.Fields["password"]) == 0 {
+			t.Errorf("unexpected error body: %+v", body)
+		}
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodPost, "/api/v1/login", "", map[string]string{"email": "test@example.com", "password": "wrong"})
+		asserts.StatusCode(t, response, http.StatusUnauthorized)
+
+		var body apiTestError
+		decodeAPIResponse(t, response, &body)
+		if body.Error.Code != "invalid_credentials" {
+			t.Errorf("unexpected error code: %s", body.Error.Code)
+		}
+	})
+
+	token := apiLogin(t, handler, credentials["email"], credentials["password"])
+
+	t.Run("me", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/me", token, nil)
+		asserts.StatusCode(t, response, http.StatusOK)
+		asserts.BodyContains(t, response, `"email":"test@example.com"`)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/me", "", nil)
+		asserts.StatusCode(t, response, http.StatusUnauthorized)
+		if response.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("WWW-Authenticate header missing")
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		other := apiLogin(t, handler, credentials["email"], credentials["password"])
+
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/sessions", token, nil)
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		var list []struct {
+			ID      string `json:"id"`
+			Current bool   `json:"current"`
+		}
+		decodeAPIResponse(t, response, &list)
+		if len(list) != 2 {
+			t.Fatalf("unexpected sessions: %v", list)
+		}
+
+		var otherID string
+		for _, s := range list {
+			if !s.Current {
+				otherID = s.ID
+			}
+		}
+
+		response = apiRequest(t, handler, http.MethodDelete, "/api/v1/sessions/"+otherID, token, nil)
+		asserts.StatusCode(t, response, http.StatusNoContent)
+
+		response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", other, nil)
+		asserts.StatusCode(t, response, http.StatusUnauthorized)
+
+		response = apiRequest(t, handler, http.MethodDelete, "/api/v1/sessions/"+otherID, token, nil)
+		asserts.StatusCode(t, response, http.StatusNotFound)
+	})
+
+	t.Run("change password", func(t *testing.T) {
+		other := apiLogin(t, handler, credentials["email"], credentials["password"])
+
+		response := apiRequest(t, handler, http.MethodPut, "/api/v1/me/password", token,
+			map[string]string{"current_password": "wrong", "new_password": "another battery staple"})
+		asserts.StatusCode(t, response, http.StatusUnprocessableEntity)
+
+		response = apiRequest(t, handler, http.MethodPut, "/api/v1/me/password", token,
+			map[string]string{"current_password": "correct horse battery", "new_password": "another battery staple"})
+		asserts.StatusCode(t, response, http.StatusNoContent)
+
+		if response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", other, nil); response.Code != http.StatusUnauthorized {
+			t.Errorf("other sessions should be revoked. got: %d", response.Code)
+		}
+
+		if response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", token, nil); response.Code != http.StatusOK {
+			t.Errorf("current session should remain. got: %d", response.Code)
+		}
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/unknown", token, nil)
+		asserts.StatusCode(t, response, http.StatusNotFound)
+		asserts.JSONContentType(t, response)
+	})
+}
+
+func TestAPIRequireConfirmedEmail(t *testing.T) {
+	handler, userStore, _, _ := newAPITestHandler(t, serverOptions{requireConfirmedEmail: true})
+	apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", map[string]string{"email": "test@example.com", "password": "correct horse battery"})
+	token := apiLogin(t, handler, "test@example.com", "correct horse battery")
+
+	response := apiRequest(t, handler, http.MethodGet, "/api/v1/sessions", token, nil)
+	asserts.StatusCode(t, response, http.StatusForbidden)
+
+	// the account status stays readable
+	response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", token, nil)
+	asserts.StatusCode(t, response, http.StatusOK)
+
+	u, _ := userStore.GetByEmail("test@example.com")
+	confirmedAt := time.Now()
+	u.EmailConfirmed = &confirmedAt
+	_, _ = userStore.Update(*u)
+
+	response = apiRequest(t, handler, http.MethodGet, "/api/v1/sessions", token, nil)
+	asserts.StatusCode(t, response, http.StatusOK)
+}
+
+func TestAPIRefreshToken(t *testing.T) {
+	handler, _, _, _ := newAPITestHandler(t, serverOptions{})
+	apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", map[string]string{"email": "test@example.com", "password": "correct horse battery"})
+
+	response := apiRequest(t, handler, http.MethodPost, "/api/v1/login", "", map[string]string{"email": "test@example.com", "password": "correct horse battery"})
+	asserts.StatusCode(t, response, http.StatusOK)
+
+	var login struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeAPIResponse(t, response, &login)
+
+	response = apiRequest(t, handler, http.MethodPost, "/api/v1/token/refresh", "", map[string]string{"refresh_token": login.RefreshToken})
+	asserts.StatusCode(t, response, http.StatusOK)
+
+	var refreshed struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeAPIResponse(t, response, &refreshed)
+
+	if response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", refreshed.AccessToken, nil); response.Code != http.StatusOK {
+		t.Fatalf("refreshed access token rejected: %d", response.Code)
+	}
+
+	// replaying the first refresh token revokes the session, the refreshed tokens stop working too
+	response = apiRequest(t, handler, http.MethodPost, "/api/v1/token/refresh", "", map[string]string{"refresh_token": login.RefreshToken})
+	asserts.StatusCode(t, response, http.StatusUnauthorized)
+
+	response = apiRequest(t, handler, http.MethodPost, "/api/v1/token/refresh", "", map[string]string{"refresh_token": refreshed.RefreshToken})
+	asserts.StatusCode(t, response, http.StatusUnauthorized)
+
+	response = apiRequest(t, handler, http.MethodGet, "/api/v1/me", refreshed.AccessToken, nil)
+	asserts.StatusCode(t, response, http.StatusUnauthorized)
+}
+
+func TestAPIKeys(t *testing.T) {
+	handler, userStore, _, apiKeyService := newAPITestHandler(t, serverOptions{requireConfirmedEmail: true})
+	apiRequest(t, handler, http.MethodPost, "/api/v1/register", "", map[string]string{"email": "test@example.com", "password": "correct horse battery"})
+
+	u, _ := userStore.GetByEmail("test@example.com")
+	confirmedAt := time.Now()
+	u.EmailConfirmed = &confirmedAt
+	_, _ = userStore.Update(*u)
+
+	_, key, err := apiKeyService.Create(u.ID, "CI", []string{apikey.ScopeProfileRead}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	t.Run("bearer", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/me", key, nil)
+		asserts.StatusCode(t, response, http.StatusOK)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		request.Header.Set("X-API-Key", key)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		asserts.StatusCode(t, response, http.StatusOK)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/me", key+"0", nil)
+		asserts.StatusCode(t, response, http.StatusUnauthorized)
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   any
+	}{
+		{"missing scope", http.MethodGet, "/api/v1/sessions", nil},
+		{"session only endpoint", http.MethodPut, "/api/v1/me/password",
+			map[string]string{"current_password": "correct horse battery", "new_password": "another battery staple"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := apiRequest(t, handler, tc.method, tc.target, key, tc.body)
+			asserts.StatusCode(t, response, http.StatusForbidden)
+
+			var body apiTestError
+			decodeAPIResponse(t, response, &body)
+			if body.Error.Code != "insufficient_scope" {
+				t.Errorf("unexpected error code: %s", body.Error.Code)
+			}
+		})
+	}
+
+	t.Run("sessions scope", func(t *testing.T) {
+		_, sessionsKey, _ := apiKeyService.Create(u.ID, "Monitoring", []string{apikey.ScopeSessionsRead}, nil)
+		response := apiRequest(t, handler, http.MethodGet, "/api/v1/sessions", sessionsKey, nil)
+		asserts.StatusCode(t, response, http.StatusOK)
+	})
+}
@@ -2,30 +2,69 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/mathieuhays/auth"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/certs"
+	"github.com/mathieuhays/auth/internal/config"
+	"github.com/mathieuhays/auth/internal/health"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/mailer"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"github.com/mathieuhays/auth/internal/passwords"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/passkey"
 	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
 	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
 	"github.com/mathieuhays/auth/internal/stores/users"
 	"github.com/mathieuhays/auth/internal/templates"
+	"github.com/mathieuhays/auth/internal/validate"
+	"github.com/mathieuhays/auth/internal/webauthn"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
-var errInvalidPort = errors.New("invalid PORT")
+const (
+	sessionReaperInterval = time.Minute
+	mailQueueSize         = 100
+	// auditRingSize is the number of audit events kept in memory for the security activity pages
+	auditRingSize = 1000
+)
+
+func run(ctx context.Context, args []string, getenv func(string) string, stdout io.Writer, stderr io.Writer) error {
+	cfg, err := config.Load(args, getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		_, _ = fmt.Fprintf(stdout, "Settings are read from the config file, then environment variables, then flags.\n\n")
+		return config.Usage(stdout)
+	}
 
-func run(ctx context.Context, getenv func(string) string, stdout io.Writer, stderr io.Writer) error {
-	port := getenv("PORT")
-	if port == "" {
-		return errInvalidPort
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// log.Printf calls outside of requests go through the same logger
+	logger, err := newLogger(cfg.Log, stderr)
+	if err != nil {
+		return fmt.Errorf("logger: %w", err)
 	}
+	slog.SetDefault(logger)
 
 	tpl, err := auth.Templates()
 	if err != nil {
@@ -34,45 +73,322 @@ func run(ctx context.Context, getenv func(string) string, stdout io.Writer, stde
 
 	tplEngine := templates.NewEngine(tpl)
 
-	userStore := users.NewUserMemoryStore()
-	sessionStore := sessions.NewSessionMemoryStore()
-	userService := user.NewService(userStore, sessionStore)
+	emailTemplates, err := auth.EmailTemplates()
+	if err != nil {
+		return fmt.Errorf("email templates: %s", err)
+	}
+
+	// delivery happens in the background so response times do not reveal whether an email was sent
+	asyncMailer := mailer.NewAsyncMailer(newMailer(cfg.Mail), mailQueueSize)
+	defer asyncMailer.Close()
+
+	notifier := mailer.NewNotifier(asyncMailer, emailTemplates, cfg.Mail.From, cfg.Server.BaseURL)
+
+	registry := metrics.NewRegistry()
+	userMemoryStore := users.NewUserMemoryStore()
+	sessionMemoryStore := sessions.NewSessionMemoryStore()
+	userStore := users.NewInstrumentedUserStore(userMemoryStore, registry)
+	sessionStore := sessions.NewInstrumentedSessionStore(sessionMemoryStore, registry)
+
+	checks := health.New(health.DefaultTimeout)
+	checks.Register("users_store", health.CheckerFunc(userMemoryStore.Ping))
+	checks.Register("sessions_store", health.CheckerFunc(sessionMemoryStore.Ping))
+	checks.Register("templates", tplEngine)
+	checks.Register("mailer", health.CheckerFunc(asyncMailer.Ping))
+
+	serviceOptions, err := newServiceOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	userService := user.NewService(userStore, sessionStore, tokens.NewTokenMemoryStore(), serviceOptions...)
+	instrumentedUserService := user.NewInstrumentedService(userService, registry)
+
+	relyingParty := newRelyingParty(cfg.Server.BaseURL, "http://localhost:"+cfg.Server.Port, cfg.WebAuthn.RPName, stderr)
+	passkeyService := passkey.NewService(relyingParty, credentials.NewCredentialMemoryStore(), userStore, instrumentedUserService)
+	apiKeyService := apikey.NewService(apikeys.NewAPIKeyMemoryStore(), userStore)
+
+	auditLog, closeAuditLog, err := newAuditLog(cfg.Audit, stderr)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+
+	serverOptions := []auth.ServerOption{
+		auth.WithLogger(logger),
+		auth.WithMetrics(registry),
+		auth.WithHealth(checks),
+		auth.WithCookiePolicy(newCookiePolicy(cfg)),
+		auth.WithStaticDir(cfg.Server.StaticDir),
+	}
+	if cfg.Server.RequireEmailConfirmation {
+		serverOptions = append(serverOptions, auth.WithRequireConfirmedEmail())
+	}
+
+	trustedProxies, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	if len(trustedProxies) > 0 {
+		serverOptions = append(serverOptions, auth.WithTrustedProxies(trustedProxies))
+	}
 
 	server := &http.Server{
-		Addr:              net.JoinHostPort("", port),
-		Handler:           auth.NewServer(&tplEngine, userService),
-		ReadHeaderTimeout: time.Second * 5,
-		WriteTimeout:      time.Second * 5,
+		Addr:              net.JoinHostPort("", cfg.Server.Port),
+		Handler:           auth.NewServer(&tplEngine, instrumentedUserService, passkeyService, apiKeyService, notifier, auditLog, serverOptions...),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+	}
+
+	var reloader *certs.Reloader
+	if cfg.TLS.Enabled() {
+		if reloader, err = newCertificateReloader(cfg.TLS, stdout); err != nil {
+			return fmt.Errorf("tls certificate: %w", err)
+		}
+
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+	}
+
+	var redirectServer *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		redirectServer = &http.Server{
+			Addr:              net.JoinHostPort("", cfg.TLS.RedirectPort),
+			Handler:           auth.NewHTTPSRedirect(cfg.Server.Port),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			WriteTimeout:      cfg.Server.WriteTimeout,
+		}
 	}
 
 	serverWG := sync.WaitGroup{}
-	serverWG.Add(1)
-	serverDone := make(chan struct{}, 1)
+	serverWG.Add(2)
+	// serverDone receives the error ListenAndServe returned, http.ErrServerClosed after a graceful shutdown
+	serverDone := make(chan error, 1)
+
+	reaperCtx, stopReaper := context.WithCancel(ctx)
+	defer stopReaper()
+
+	go func() {
+		defer serverWG.Done()
+		user.RunSessionReaper(reaperCtx, userService, sessionReaperInterval)
+	}()
+
+	if reloader != nil {
+		serverWG.Add(1)
+		go func() {
+			defer serverWG.Done()
+			reloader.Watch(reaperCtx, cfg.TLS.ReloadInterval)
+		}()
+	}
+
+	if redirectServer != nil {
+		serverWG.Add(1)
+		go func() {
+			defer serverWG.Done()
+
+			_, _ = fmt.Fprintf(stdout, "Redirecting HTTP to HTTPS on %s\n", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				_, _ = fmt.Fprintf(stderr, "redirect listen and serve err: %s\n", err)
+			}
+		}()
+	}
 
 	go func() {
 		defer serverWG.Done()
 
 		_, _ = fmt.Fprintf(stdout, "Starting server on %s\n", server.Addr)
-		if err = server.ListenAndServe(); err != nil {
-			_, _ = fmt.Fprintf(stderr, "listen and serve err: %s\n", err)
+		if server.TLSConfig != nil {
+			// the certificate comes from TLSConfig.GetCertificate
+			serverDone <- server.ListenAndServeTLS("", "")
+		} else {
+			serverDone <- server.ListenAndServe()
 		}
-
-		serverDone <- struct{}{}
 	}()
 
+	var shutdownErr error
+
 	select {
 	case <-ctx.Done():
 		_, _ = fmt.Fprintf(stdout, "graceful shutdown\n")
-		if err = server.Shutdown(ctx); err != nil {
-			panic(err)
+		// readiness fails from now on, the delay gives load balancers time to stop routing to this instance
+		checks.ShutDown()
+		time.Sleep(cfg.Server.ShutdownDrainDelay)
+
+		// ctx is done already, in-flight requests get their own grace period
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancelShutdown()
+
+		if redirectServer != nil {
+			_ = redirectServer.Shutdown(shutdownCtx)
+		}
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			shutdownErr = fmt.Errorf("shutdown: %w", err)
+			_ = server.Close()
+		}
+	case err := <-serverDone:
+		// the port is taken or the certificate is unusable, the process must not exit successfully
+		shutdownErr = fmt.Errorf("listen and serve: %w", err)
+		if redirectServer != nil {
+			_ = redirectServer.Close()
 		}
-	case <-serverDone:
-		_, _ = fmt.Fprintf(stdout, "server has shutdown on its own\n")
 	}
 
+	stopReaper()
+
 	serverWG.Wait()
 
-	return nil
+	return shutdownErr
+}
+
+// newLogger writes log records to stderr, the config has been validated already
+func newLogger(cfg config.Log, stderr io.Writer) (*slog.Logger, error) {
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	return logging.New(stderr, cfg.Format, level)
+}
+
+// newServiceOptions maps the config to the user service options
+func newServiceOptions(cfg config.Config) ([]user.Option, error) {
+	passwordPolicy := validate.DefaultPasswordPolicy
+	if cfg.Password.BreachDir != "" {
+		passwordPolicy.Breaches = validate.BreachedPasswordDirectory{Dir: cfg.Password.BreachDir}
+	}
+
+	options := []user.Option{
+		user.WithPasswordPolicy(passwordPolicy),
+		user.WithPasswordHasher(newPasswordManager(cfg.Password)),
+		user.WithSessionPolicy(user.SessionPolicy{
+			IdleTimeout:      cfg.Session.IdleTimeout,
+			AbsoluteLifetime: cfg.Session.AbsoluteLifetime,
+		}),
+		user.WithCookiePolicy(newCookiePolicy(cfg)),
+		user.WithTokenIssuer(cfg.Server.BaseURL),
+	}
+
+	box, err := cfg.Secrets.Box()
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+
+	if box != nil {
+		options = append(options, user.WithSecretBox(box))
+	}
+
+	if len(cfg.Admin.Emails) > 0 {
+		options = append(options, user.WithAdminEmails(cfg.Admin.Emails...))
+	}
+
+	keys, err := cfg.Secrets.TokenKeys()
+	if err != nil {
+		return nil, fmt.Errorf("token keys: %w", err)
+	}
+
+	if keys != nil {
+		options = append(options, user.WithTokenKeys(keys))
+	}
+
+	return options, nil
+}
+
+// newPasswordManager hashes new passwords with the configured algorithm, hashes made by the others are still
+// accepted and upgraded on the next login
+func newPasswordManager(cfg config.Password) *passwords.Manager {
+	bcryptHasher := passwords.Bcrypt{Cost: cfg.BcryptCost}
+	if cfg.Hasher == config.HasherBcrypt {
+		return passwords.NewManager(bcryptHasher, passwords.DefaultArgon2id, passwords.DefaultScrypt)
+	}
+
+	return passwords.NewManager(passwords.DefaultArgon2id, bcryptHasher, passwords.DefaultScrypt)
+}
+
+func newCookiePolicy(cfg config.Config) user.CookiePolicy {
+	return user.CookiePolicy{Secure: cfg.SecureCookies(), Domain: cfg.Cookies.Domain}
+}
+
+// newCertificateReloader loads the configured certificate, or the cached self-signed one in dev mode
+func newCertificateReloader(cfg config.TLS, stdout io.Writer) (*certs.Reloader, error) {
+	certFile, keyFile := cfg.CertFile, cfg.KeyFile
+	if cfg.Dev {
+		var err error
+		if certFile, keyFile, err = certs.SelfSigned(cfg.DevDir, cfg.DevHosts); err != nil {
+			return nil, err
+		}
+
+		_, _ = fmt.Fprintf(stdout, "Using the self-signed certificate %s for %s\n", certFile, strings.Join(cfg.DevHosts, ", "))
+	}
+
+	return certs.NewReloader(certFile, keyFile)
+}
+
+// newAuditLog keeps the latest events in memory and appends every event to the audit log file. The in-memory ring
+// is seeded from the file so the security activity pages survive a restart. A broken chain or unreadable lines are
+// reported but do not prevent the server from starting, the file is evidence and should be kept as is.
+// The server does not start without its audit log file.
+func newAuditLog(cfg config.Audit, stderr io.Writer) (*audit.Logger, func(), error) {
+	file, events, err := audit.NewFileSink(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+	if errors.Is(err, audit.ErrCorruptLine) {
+		_, _ = fmt.Fprintf(stderr, "audit log %s has unreadable lines, the chain carries on from the last readable event: %s\n", cfg.File, err)
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("audit log: %w", err)
+	}
+
+	if err = audit.Verify(events); err != nil {
+		_, _ = fmt.Fprintf(stderr, "audit log %s failed verification: %s\n", cfg.File, err)
+	}
+
+	ring := audit.NewRing(auditRingSize)
+	for _, event := range events[max(len(events)-auditRingSize, 0):] {
+		_ = ring.Write(event)
+	}
+
+	return audit.NewLogger(ring, file), func() {
+		if err := file.Close(); err != nil {
+			_, _ = fmt.Fprintf(stderr, "audit log close error: %s\n", err)
+		}
+	}, nil
+}
+
+// newRelyingParty scopes passkeys to the host of the base URL, which must be the origin browsers see.
+// Passkeys registered against the fallback URL stop working once a valid BASE_URL is configured.
+func newRelyingParty(baseURL, fallbackURL, name string, stderr io.Writer) *webauthn.RelyingParty {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Hostname() == "" {
+		_, _ = fmt.Fprintf(stderr, "invalid BASE_URL for passkeys, using %s instead\n", fallbackURL)
+		parsed, _ = url.Parse(fallbackURL)
+	}
+
+	if name == "" {
+		name = user.DefaultTOTPIssuer
+	}
+
+	return webauthn.New(webauthn.Config{
+		RPID:    parsed.Hostname(),
+		RPName:  name,
+		Origins: []string{parsed.Scheme + "://" + parsed.Host},
+	})
+}
+
+func newMailer(cfg config.Mail) mailer.Mailer {
+	switch cfg.Backend {
+	case config.MailBackendSMTP:
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:          cfg.SMTPHost,
+			Port:          cfg.SMTPPort,
+			Username:      cfg.SMTPUsername,
+			Password:      cfg.SMTPPassword,
+			AllowInsecure: cfg.SMTPAllowInsecure,
+		})
+	case config.MailBackendMemory:
+		return mailer.NewMemoryMailer()
+	}
+
+	return mailer.NewFileMailer(cfg.Dir)
 }
 
 func main() {
@@ -86,7 +402,12 @@ func main() {
 		log.Println("no .env file found. skipping")
 	}
 
-	if err := run(context.Background(), os.Getenv, os.Stdout, os.Stderr); err != nil {
+	// SIGTERM is how orchestrators ask for a graceful shutdown, run drains and stops once ctx is done
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr)
+	stop()
+
+	if err != nil {
 		log.Fatalf("run error: %s", err)
 	}
 
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"github.com/mathieuhays/auth/internal/config"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -25,7 +29,7 @@ func TestRun(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			err := run(ctx, func(s string) string {
+			err := run(ctx, nil, func(s string) string {
 				return ""
 			}, os.Stdout, os.Stderr)
 			errCh <- err
@@ -44,8 +48,8 @@ func TestRun(t *testing.T) {
 			t.Fatalf("expected error but none were returned")
 		}
 
-		if !errors.Is(err, errInvalidPort) {
-			t.Fatalf("unexpected error. expected: %s. got: %s", errInvalidPort, err)
+		if !errors.Is(err, config.ErrInvalidPort) {
+			t.Fatalf("unexpected error. expected: %s. got: %s", config.ErrInvalidPort, err)
 		}
 	})
 
@@ -62,9 +66,7 @@ func TestRun(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			err := run(ctx, func(s string) string {
-				return port
-			}, os.Stdout, os.Stderr)
+			err := run(ctx, nil, mapEnv(map[string]string{"PORT": port, "MAIL_BACKEND": "memory"}), os.Stdout, os.Stderr)
 			errCh <- err
 		}()
 
@@ -77,6 +79,8 @@ func TestRun(t *testing.T) {
 		baseUrl := fmt.Sprintf("http://127.0.0.1:%s", port)
 		assertRequestStatusCode(t, baseUrl+"/", http.StatusOK)
 		assertRequestStatusCode(t, baseUrl+"/lksjdf", http.StatusNotFound)
+		assertRequestStatusCode(t, baseUrl+"/healthz", http.StatusOK)
+		assertRequestStatusCode(t, baseUrl+"/readyz", http.StatusOK)
 
 		cancel()
 
@@ -93,6 +97,153 @@ func TestRun(t *testing.T) {
 	})
 }
 
+func TestRun_ShutdownWaitsForInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	port := "12346"
+
+	go func() {
+		errCh <- run(ctx, nil, mapEnv(map[string]string{"PORT": port, "MAIL_BACKEND": "memory"}), os.Stdout, os.Stderr)
+	}()
+
+	time.Sleep(time.Millisecond * 500)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	// the handler is blocked reading the form until the rest of the body arrives
+	body := "email=user%40example.com&password=password"
+	if _, err = fmt.Fprintf(conn, "POST /login HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:10]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+
+	if _, err = conn.Write([]byte(body[10:])); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("in-flight request was dropped: %s", err)
+	}
+	_ = response.Body.Close()
+
+	// no csrf token, what matters is that the request was answered
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status code. expected: %d. got: %d", http.StatusForbidden, response.StatusCode)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatalf("graceful shutdown did not complete")
+	case err = <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected shutdown error: %s", err)
+		}
+	}
+}
+
+func TestRun_ShutdownFailsReadinessFirst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	port := "12347"
+
+	go func() {
+		errCh <- run(ctx, nil, mapEnv(map[string]string{"PORT": port, "MAIL_BACKEND": "memory", "SHUTDOWN_DRAIN_DELAY": "500ms"}), os.Stdout, os.Stderr)
+	}()
+
+	time.Sleep(time.Millisecond * 500)
+
+	baseUrl := fmt.Sprintf("http://127.0.0.1:%s", port)
+	assertRequestStatusCode(t, baseUrl+"/readyz", http.StatusOK)
+
+	cancel()
+	time.Sleep(time.Millisecond * 100)
+
+	// the listener is still open during the drain delay, load balancers see the instance is going away
+	assertRequestStatusCode(t, baseUrl+"/readyz", http.StatusServiceUnavailable)
+	assertRequestStatusCode(t, baseUrl+"/healthz", http.StatusOK)
+
+	select {
+	case <-time.After(time.Second * 2):
+		t.Fatalf("graceful shutdown did not complete")
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected shutdown error: %s", err)
+		}
+	}
+}
+
+func TestRun_ListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", ":12348")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run(context.Background(), nil, mapEnv(map[string]string{"PORT": "12348", "MAIL_BACKEND": "memory"}), os.Stdout, os.Stderr)
+	}()
+
+	select {
+	case <-time.After(time.Second * 2):
+		t.Fatalf("run should return when the port is taken")
+	case err = <-errCh:
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("unexpected error. expected the listen error. got: %v", err)
+		}
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	err := run(context.Background(), []string{"-log.format", "xml"}, mapEnv(map[string]string{"PORT": "12345"}), os.Stdout, os.Stderr)
+
+	if !errors.Is(err, config.ErrInvalidValue) {
+		t.Fatalf("unexpected error. expected: %s. got: %v", config.ErrInvalidValue, err)
+	}
+}
+
+func TestRun_InvalidSecret(t *testing.T) {
+	err := run(context.Background(), nil, mapEnv(map[string]string{"PORT": "12345", "ENCRYPTION_KEY": "not base64"}), os.Stdout, os.Stderr)
+
+	var fieldErr *config.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "secrets.encryption_key" {
+		t.Fatalf("unexpected error. expected secrets.encryption_key to be reported. got: %v", err)
+	}
+}
+
+func TestRun_UnreadableAuditLog(t *testing.T) {
+	// a directory cannot be read as the audit log, the server must not start without it
+	err := run(context.Background(), nil, mapEnv(map[string]string{"PORT": "12345", "AUDIT_LOG_FILE": t.TempDir()}), os.Stdout, os.Stderr)
+
+	if err == nil || !strings.Contains(err.Error(), "audit log") {
+		t.Fatalf("unexpected error. expected the audit log to be reported. got: %v", err)
+	}
+}
+
+// mapEnv stands in for os.Getenv, unset variables are empty. It runs in dev mode unless DEV is set, so tests do not
+// need to configure secrets.
+func mapEnv(values map[string]string) func(string) string {
+	return func(key string) string {
+		if value, ok := values[key]; ok || key != "DEV" {
+			return value
+		}
+
+		return "true"
+	}
+}
+
 func assertRequestStatusCode(t testing.TB, url string, statusCode int) {
 	t.Helper()
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	// csrfCookieName holds the double-submit token used before a session exists (login, register)
+	csrfCookieName = "csrf_token"
+)
+
+// csrfResponseWriter exposes the request's CSRF token to the template engine
+type csrfResponseWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+func (w csrfResponseWriter) CSRFToken() string {
+	return w.token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+func generateCSRFToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func newCSRFMiddleware(tpl errorTemplates, userService user.ServiceInterface, cookies user.CookiePolicy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// static files never render a token, there is no need to look the session up
+			if isSafeMethod(r.Method) && strings.HasPrefix(r.URL.Path, "/static/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var expected string
+
+			if session, err := userService.SessionFromRequest(r); err == nil {
+				expected = session.CSRFToken
+			} else if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+				expected = cookie.Value
+			}
+
+			if !isSafeMethod(r.Method) {
+				submitted := r.Header.Get(csrfHeaderName)
+				if submitted == "" {
+					submitted = r.PostFormValue(csrfFieldName)
+				}
+
+				if expected == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) != 1 {
+					w.WriteHeader(http.StatusForbidden)
+					if err := tpl.Error(w, "Error 403", "Invalid or missing CSRF token. Please reload the page and try again."); err != nil {
+						logging.FromContext(r.Context()).Error("template error", "error", err)
+					}
+					return
+				}
+			}
+
+			if expected == "" {
+				token, err := generateCSRFToken()
+				if err != nil {
+					logging.FromContext(r.Context()).Error("csrf token generation error", "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				http.SetCookie(w, cookies.Apply(&http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				}))
+				expected = token
+			}
+
+			next.ServeHTTP(csrfResponseWriter{ResponseWriter: w, token: expected}, r)
+		})
+	}
+}
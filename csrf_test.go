@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type csrfTestTemplates struct{}
+
+func (c csrfTestTemplates) Error(writer io.Writer, title, description string) error {
+	_, err := io.WriteString(writer, title)
+	return err
+}
+
+type csrfTestUserService struct {
+	user.ServiceInterface
+	session *sessions.Session
+}
+
+func (c csrfTestUserService) SessionFromRequest(request *http.Request) (*sessions.Session, error) {
+	if c.session == nil {
+		return nil, errors.New("not logged in")
+	}
+
+	return c.session, nil
+}
+
+func newCSRFTestHandler(session *sessions.Session, seenToken *string) http.Handler {
+	middleware := newCSRFMiddleware(csrfTestTemplates{}, csrfTestUserService{session: session}, user.DefaultCookiePolicy)
+
+	return middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tokenWriter, ok := w.(interface{ CSRFToken() string }); ok {
+			*seenToken = tokenWriter.CSRFToken()
+		}
+	}))
+}
+
+func newCSRFTestPost(token string) *http.Request {
+	form := url.Values{}
+	form.Set(csrfFieldName, token)
+	request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return request
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	t.Run("issues a pre-session token on safe requests", func(t *testing.T) {
+		var seenToken string
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(nil, &seenToken).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+			t.Fatalf("expected csrf cookie to be set. got: %v", cookies)
+		}
+
+		if seenToken == "" || seenToken != cookies[0].Value {
+			t.Errorf("handler did not receive the issued token. expected: %s. got: %s", cookies[0].Value, seenToken)
+		}
+	})
+
+	t.Run("rejects unsafe requests without token", func(t *testing.T) {
+		var seenToken string
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(nil, &seenToken).ServeHTTP(response, newCSRFTestPost(""))
+
+		asserts.StatusCode(t, response, http.StatusForbidden)
+		asserts.BodyContains(t, response, "403")
+	})
+
+	t.Run("accepts matching double-submit token", func(t *testing.T) {
+		var seenToken string
+		request := newCSRFTestPost("pre-session-token")
+		request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "pre-session-token"})
+
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(nil, &seenToken).ServeHTTP(response, request)
+
+		asserts.StatusCode(t, response, http.StatusOK)
+	})
+
+	t.Run("rejects mismatching double-submit token", func(t *testing.T) {
+		var seenToken string
+		request := newCSRFTestPost("forged")
+		request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "pre-session-token"})
+
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(nil, &seenToken).ServeHTTP(response, request)
+
+		asserts.StatusCode(t, response, http.StatusForbidden)
+	})
+
+	t.Run("requires the session token once logged in", func(t *testing.T) {
+		session := &sessions.Session{CSRFToken: "session-token"}
+
+		var seenToken string
+		request := newCSRFTestPost("pre-session-token")
+		request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "pre-session-token"})
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(session, &seenToken).ServeHTTP(response, request)
+
+		asserts.StatusCode(t, response, http.StatusForbidden)
+
+		request = newCSRFTestPost("session-token")
+		response = httptest.NewRecorder()
+		newCSRFTestHandler(session, &seenToken).ServeHTTP(response, request)
+
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		if seenToken != session.CSRFToken {
+			t.Errorf("unexpected token exposed to handler. expected: %s. got: %s", session.CSRFToken, seenToken)
+		}
+	})
+	t.Run("skips static files", func(t *testing.T) {
+		var seenToken string
+		response := httptest.NewRecorder()
+		newCSRFTestHandler(&sessions.Session{CSRFToken: "session-token"}, &seenToken).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/static/app.css", nil))
+
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		if cookies := response.Result().Cookies(); len(cookies) != 0 || seenToken != "" {
+			t.Errorf("static files should not get a token. got cookies: %v, token: %q", cookies, seenToken)
+		}
+	})
+}
@@ -3,7 +3,9 @@ module github.com/mathieuhays/auth
 go 1.23.0
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.28.0 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.28.0
 )
+
+require golang.org/x/sys v0.26.0 // indirect
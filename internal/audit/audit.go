@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChainBroken = errors.New("audit chain broken")
+	ErrNoQuerier   = errors.New("no audit sink can be queried")
+)
+
+type EventType string
+
+const (
+	EventLoginSucceeded           EventType = "login.succeeded"
+	EventLoginFailed              EventType = "login.failed"
+	EventUserRegistered           EventType = "user.registered"
+	EventSessionCreated           EventType = "session.created"
+	EventSessionRevoked           EventType = "session.revoked"
+	EventPasswordChanged          EventType = "password.changed"
+	EventPasswordReset            EventType = "password.reset"
+	EventAdminUserDisabled        EventType = "admin.user_disabled"
+	EventAdminUserEnabled         EventType = "admin.user_enabled"
+	EventAdminPasswordResetForced EventType = "admin.password_reset_forced"
+	EventAdminSessionsRevoked     EventType = "admin.sessions_revoked"
+	EventAdminAccountUnlocked     EventType = "admin.account_unlocked"
+	EventAdminRoleGranted         EventType = "admin.role_granted"
+	EventAdminRoleRevoked         EventType = "admin.role_revoked"
+)
+
+var eventLabels = map[EventType]string{
+	EventLoginSucceeded:           "Signed in",
+	EventLoginFailed:              "Failed sign-in attempt",
+	EventUserRegistered:           "Account created",
+	EventSessionCreated:           "Session started",
+	EventSessionRevoked:           "Session ended",
+	EventPasswordChanged:          "Password changed",
+	EventPasswordReset:            "Password reset",
+	EventAdminUserDisabled:        "Account disabled by an administrator",
+	EventAdminUserEnabled:         "Account enabled by an administrator",
+	EventAdminPasswordResetForced: "Password reset required by an administrator",
+	EventAdminSessionsRevoked:     "Sessions revoked by an administrator",
+	EventAdminAccountUnlocked:     "Account unlocked by an administrator",
+	EventAdminRoleGranted:         "Role granted by an administrator",
+	EventAdminRoleRevoked:         "Role revoked by an administrator",
+}
+
+// Label is the human readable name of the event type
+func (t EventType) Label() string {
+	if label, ok := eventLabels[t]; ok {
+		return label
+	}
+
+	return string(t)
+}
+
+// Event is a single audit entry. ActorID is the user who did it, TargetID the user it was done to,
+// they are the same user for most events and uuid.Nil when unknown, such as failed sign-ins on unknown accounts.
+// Sequence, PrevHash and Hash are set by the Logger.
+type Event struct {
+	Sequence  uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Type      EventType         `json:"type"`
+	ActorID   uuid.UUID         `json:"actor_id"`
+	TargetID  uuid.UUID         `json:"target_id"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// Involves tells whether the user did the event or had it done to them
+func (e Event) Involves(userID uuid.UUID) bool {
+	return userID != uuid.Nil && (e.ActorID == userID || e.TargetID == userID)
+}
+
+// ComputeHash chains the event to the previous one. Editing, removing or reordering entries breaks the chain.
+func (e Event) ComputeHash() (string, error) {
+	e.Hash = ""
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(e.PrevHash), encoded...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks the hash of every event and that each one links to the event before it.
+// The first event is trusted to link to whatever came before, such as a rotated file.
+func Verify(events []Event) error {
+	for i, event := range events {
+		hash, err := event.ComputeHash()
+		if err != nil {
+			return err
+		}
+
+		if hash != event.Hash {
+			return fmt.Errorf("%w: event %d was modified", ErrChainBroken, event.Sequence)
+		}
+
+		if i > 0 && (event.PrevHash != events[i-1].Hash || event.Sequence != events[i-1].Sequence+1) {
+			return fmt.Errorf("%w: event %d does not follow event %d", ErrChainBroken, event.Sequence, events[i-1].Sequence)
+		}
+	}
+
+	return nil
+}
+
+// Sink stores events once the Logger has chained them
+type Sink interface {
+	Write(event Event) error
+}
+
+// Querier is implemented by sinks events can be read back from
+type Querier interface {
+	Query(filter Filter) ([]Event, error)
+}
+
+// Filter selects events. Zero values match everything, Limit 0 means no limit.
+type Filter struct {
+	UserID uuid.UUID
+	Types  []EventType
+	Since  time.Time
+	Limit  int
+}
+
+func (f Filter) Match(event Event) bool {
+	if f.UserID != uuid.Nil && !event.Involves(f.UserID) {
+		return false
+	}
+
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+
+	return f.Since.IsZero() || !event.Time.Before(f.Since)
+}
+
+// lastEventSink is implemented by sinks that persist events, so the chain carries on after a restart
+type lastEventSink interface {
+	Last() (Event, bool)
+}
+
+// Logger chains events and writes them to every sink.
+type Logger struct {
+	mu       sync.Mutex
+	sinks    []Sink
+	sequence uint64
+	lastHash string
+	now      func() time.Time
+}
+
+func NewLogger(sinks ...Sink) *Logger {
+	logger := &Logger{sinks: sinks, now: time.Now}
+
+	for _, sink := range sinks {
+		if s, ok := sink.(lastEventSink); ok {
+			if last, found := s.Last(); found && last.Sequence >= logger.sequence {
+				logger.sequence = last.Sequence
+				logger.lastHash = last.Hash
+			}
+		}
+	}
+
+	return logger
+}
+
+// Record stamps the event, chains it and writes it to every sink. Every sink is attempted even when one fails.
+func (l *Logger) Record(event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = l.now()
+	}
+
+	event.Time = event.Time.UTC()
+	event.Sequence = l.sequence + 1
+	event.PrevHash = l.lastHash
+
+	hash, err := event.ComputeHash()
+	if err != nil {
+		return err
+	}
+
+	event.Hash = hash
+	l.sequence = event.Sequence
+	l.lastHash = hash
+
+	var errs []error
+	for _, sink := range l.sinks {
+		if err = sink.Write(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Query reads events back from the first sink that supports it, newest first.
+func (l *Logger) Query(filter Filter) ([]Event, error) {
+	for _, sink := range l.sinks {
+		if querier, ok := sink.(Querier); ok {
+			return querier.Query(filter)
+		}
+	}
+
+	return nil, ErrNoQuerier
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestLogger_Record(t *testing.T) {
+	ring := NewRing(10)
+	logger := NewLogger(ring)
+	userID := uuid.New()
+
+	for _, eventType := range []EventType{EventUserRegistered, EventLoginSucceeded, EventPasswordChanged} {
+		if err := logger.Record(Event{Type: eventType, ActorID: userID, TargetID: userID}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	events, err := logger.Query(Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(events) != 3 || events[0].Type != EventPasswordChanged || events[0].Sequence != 3 {
+		t.Fatalf("unexpected events, newest should come first. got: %+v", events)
+	}
+
+	if events[2].PrevHash != "" || events[1].PrevHash != events[2].Hash || events[0].PrevHash != events[1].Hash {
+		t.Errorf("events are not chained")
+	}
+
+	if events[0].Time.IsZero() || events[0].Time.Location() != time.UTC {
+		t.Errorf("event time not stamped in UTC. got: %s", events[0].Time)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ring := NewRing(10)
+	logger := NewLogger(ring)
+	for i := 0; i < 4; i++ {
+		if err := logger.Record(Event{Type: EventLoginFailed, IP: "10.0.0.1", Details: map[string]string{"email": "test@example.com"}}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	newest, _ := ring.Query(Filter{})
+	chronological := func() []Event {
+		events := make([]Event, len(newest))
+		for i, event := range newest {
+			events[len(newest)-1-i] = event
+		}
+		return events
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		if err := Verify(chronological()); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		events := chronological()
+		events[1].IP = "10.0.0.2"
+		if err := Verify(events); !errors.Is(err, ErrChainBroken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrChainBroken, err)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		events := chronological()
+		events = append(events[:1], events[2:]...)
+		if err := Verify(events); !errors.Is(err, ErrChainBroken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrChainBroken, err)
+		}
+	})
+}
+
+func TestRing_Query(t *testing.T) {
+	ring := NewRing(3)
+	logger := NewLogger(ring)
+	alice, bob := uuid.New(), uuid.New()
+
+	records := []Event{
+		{Type: EventUserRegistered, ActorID: alice, TargetID: alice},
+		{Type: EventLoginSucceeded, ActorID: alice, TargetID: alice},
+		{Type: EventLoginSucceeded, ActorID: bob, TargetID: bob},
+		{Type: EventAdminUserDisabled, ActorID: bob, TargetID: alice},
+	}
+	for _, event := range records {
+		if err := logger.Record(event); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	t.Run("oldest dropped", func(t *testing.T) {
+		events, _ := ring.Query(Filter{})
+		if len(events) != 3 || events[2].Sequence != 2 {
+			t.Errorf("unexpected events. got: %+v", events)
+		}
+	})
+
+	t.Run("by user", func(t *testing.T) {
+		events, _ := ring.Query(Filter{UserID: alice})
+		if len(events) != 2 || events[0].Type != EventAdminUserDisabled || events[1].Type != EventLoginSucceeded {
+			t.Errorf("unexpected events. got: %+v", events)
+		}
+	})
+
+	t.Run("by type with limit", func(t *testing.T) {
+		events, _ := ring.Query(Filter{Types: []EventType{EventLoginSucceeded}, Limit: 1})
+		if len(events) != 1 || events[0].ActorID != bob {
+			t.Errorf("unexpected events. got: %+v", events)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrCorruptLine is reported for lines of an audit log file that are not a JSON event
+var ErrCorruptLine = errors.New("corrupt audit log line")
+
+const (
+	DefaultMaxFileSize    = 10 << 20
+	DefaultMaxFileBackups = 5
+)
+
+// FileSink appends events to a JSON lines file. Once the file would grow past MaxSize it is renamed
+// to path.1, previous backups shift to path.2 and so on, and the oldest one past MaxBackups is deleted.
+// The file is only created on the first write.
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	last       *Event
+	// torn is set when the file does not end with a newline
+	torn bool
+}
+
+// NewFileSink reads the events of an existing file so the Logger carries on with the chain, and returns them.
+// Lines that cannot be decoded, such as one torn by a crash, are skipped: the sink is still returned along with
+// an error wrapping ErrCorruptLine and the chain carries on from the last readable event.
+// maxSize and maxBackups fall back to their default when not positive.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, []Event, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxFileBackups
+	}
+
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sink, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// the next event must not be appended to a torn line
+	sink.torn = len(data) > 0 && data[len(data)-1] != '\n'
+
+	events, err := readEvents(bytes.NewReader(data))
+	if err != nil && !errors.Is(err, ErrCorruptLine) {
+		return nil, nil, err
+	}
+
+	if len(events) > 0 {
+		sink.last = &events[len(events)-1]
+	}
+
+	return sink, events, err
+}
+
+func (f *FileSink) Last() (Event, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.last == nil {
+		return Event{}, false
+	}
+
+	return *f.last, true
+}
+
+func (f *FileSink) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			return err
+		}
+	}
+
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if f.torn {
+		line = append([]byte{'\n'}, line...)
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	f.torn = false
+
+	f.last = &event
+	return nil
+}
+
+func (f *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o750); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	f.torn = false
+
+	if err := os.Remove(backupPath(f.path, f.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(f.path, i), backupPath(f.path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.path, backupPath(f.path, 1)); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func backupPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// ReadFile decodes every event of a JSON lines file, in the order they were written.
+// Lines that cannot be decoded are skipped and reported with ErrCorruptLine, the other events are still returned.
+func ReadFile(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readEvents(file)
+}
+
+func readEvents(reader io.Reader) ([]Event, error) {
+	var events []Event
+	var errs []error
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w: %w", line, ErrCorruptLine, err))
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return events, err
+	}
+
+	return events, errors.Join(errs...)
+}
+
+// VerifyFile checks the chain of a single file, see Verify.
+func VerifyFile(path string) error {
+	events, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return Verify(events)
+}
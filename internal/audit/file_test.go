@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Run("not created until written", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+		if _, _, err := NewFileSink(path, 0, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("file should not exist yet. got: %v", err)
+		}
+	})
+
+	t.Run("chain resumes after a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		for i := 0; i < 2; i++ {
+			sink, _, err := NewFileSink(path, 0, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			logger := NewLogger(sink)
+			for j := 0; j < 2; j++ {
+				if err = logger.Record(Event{Type: EventLoginFailed}); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			if err = sink.Close(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		events, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(events) != 4 || events[3].Sequence != 4 {
+			t.Errorf("unexpected events. got: %+v", events)
+		}
+
+		if err = VerifyFile(path); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("torn last line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink, _, err := NewFileSink(path, 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		logger := NewLogger(sink)
+		for i := 0; i < 2; i++ {
+			if err = logger.Record(Event{Type: EventLoginFailed}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		_ = sink.Close()
+
+		// a crash in the middle of a write
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, _ = file.WriteString(`{"type":"login.fa`)
+		_ = file.Close()
+
+		sink, events, err := NewFileSink(path, 0, 0)
+		if !errors.Is(err, ErrCorruptLine) || sink == nil {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrCorruptLine, err)
+		}
+
+		if len(events) != 2 {
+			t.Errorf("events before the torn line should be kept. got: %+v", events)
+		}
+
+		if err = NewLogger(sink).Record(Event{Type: EventLoginSucceeded}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_ = sink.Close()
+
+		events, err = ReadFile(path)
+		if !errors.Is(err, ErrCorruptLine) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrCorruptLine, err)
+		}
+
+		if len(events) != 3 || events[2].Type != EventLoginSucceeded || events[2].Sequence != 3 {
+			t.Fatalf("unexpected events. got: %+v", events)
+		}
+
+		if err = Verify(events); err != nil {
+			t.Errorf("chain should carry on from the last readable event: %s", err)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink, _, err := NewFileSink(path, 400, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer sink.Close()
+
+		logger := NewLogger(sink)
+		for i := 0; i < 10; i++ {
+			if err = logger.Record(Event{Type: EventLoginFailed}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if _, err = os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("too many backups kept. got: %v", err)
+		}
+
+		previous, err := ReadFile(path + ".1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		current, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(previous) == 0 || len(current) == 0 || current[0].PrevHash != previous[len(previous)-1].Hash {
+			t.Errorf("chain does not carry on across files")
+		}
+
+		for _, file := range []string{path, path + ".1", path + ".2"} {
+			if err = VerifyFile(file); err != nil {
+				t.Errorf("unexpected error for %s: %s", file, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package audit
+
+import "sync"
+
+// Ring keeps the latest events in memory, the oldest ones are dropped once it is full.
+type Ring struct {
+	mu     sync.RWMutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{events: make([]Event, max(size, 1))}
+}
+
+func (r *Ring) Write(event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+
+	return nil
+}
+
+// Query returns the matching events, newest first
+func (r *Ring) Query(filter Filter) ([]Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.events)
+	}
+
+	var events []Event
+	for i := 1; i <= count; i++ {
+		event := r.events[(r.next-i+len(r.events))%len(r.events)]
+		if !filter.Match(event) {
+			continue
+		}
+
+		events = append(events, event)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,123 @@
+package certs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("no hosts", func(t *testing.T) {
+		if _, _, err := SelfSigned(dir, nil); !errors.Is(err, ErrNoHosts) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrNoHosts, err)
+		}
+	})
+
+	certFile, keyFile, err := SelfSigned(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reloader, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error while loading the pair: %s", err)
+	}
+
+	certificate, _ := reloader.GetCertificate(nil)
+	if err = certificate.Leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate does not cover localhost: %s", err)
+	}
+
+	if err = certificate.Leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("certificate does not cover 127.0.0.1: %s", err)
+	}
+
+	original, _ := os.ReadFile(certFile)
+
+	t.Run("cached", func(t *testing.T) {
+		if _, _, err := SelfSigned(dir, []string{"127.0.0.1", "localhost"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if cached, _ := os.ReadFile(certFile); !bytes.Equal(cached, original) {
+			t.Errorf("certificate should be reused for the same hosts")
+		}
+	})
+
+	t.Run("hosts changed", func(t *testing.T) {
+		if _, _, err := SelfSigned(dir, []string{"auth.test"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if regenerated, _ := os.ReadFile(certFile); bytes.Equal(regenerated, original) {
+			t.Errorf("certificate should be regenerated for other hosts")
+		}
+	})
+}
+
+func TestReloader(t *testing.T) {
+	certFile, keyFile, err := SelfSigned(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reloader, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged files should not be reloaded. got: %t, %v", reloaded, err)
+	}
+
+	before, _ := reloader.GetCertificate(nil)
+
+	// a renewal in another directory, copied over the files in use
+	renewedCert, renewedKey, err := SelfSigned(t.TempDir(), []string{"auth.test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for source, destination := range map[string]string{renewedCert: certFile, renewedKey: keyFile} {
+		data, _ := os.ReadFile(source)
+		if err = os.WriteFile(destination, data, 0o600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// modification times can be too coarse to tell both writes apart
+		_ = os.Chtimes(destination, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	}
+
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed files should be reloaded. got: %t, %v", reloaded, err)
+	}
+
+	after, _ := reloader.GetCertificate(nil)
+	if after == before || after.Leaf.VerifyHostname("auth.test") != nil {
+		t.Errorf("renewed certificate is not served")
+	}
+
+	t.Run("invalid pair keeps the current certificate", func(t *testing.T) {
+		if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := reloader.Reload(); err == nil {
+			t.Errorf("expected an error")
+		}
+
+		if current, _ := reloader.GetCertificate(nil); current != after {
+			t.Errorf("current certificate should still be served")
+		}
+	})
+
+	t.Run("missing files", func(t *testing.T) {
+		if _, err := NewReloader(filepath.Join(t.TempDir(), "cert.pem"), keyFile); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("unexpected error. expected: %s. got: %v", os.ErrNotExist, err)
+		}
+	})
+}
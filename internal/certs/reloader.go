@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and key pair through GetCertificate and reloads it once the files change,
+// so renewed certificates are picked up without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certStamp   fileStamp
+	keyStamp    fileStamp
+}
+
+// fileStamp tells whether a file changed since it was last loaded
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// NewReloader fails when the pair cannot be loaded, later reload failures keep the current certificate
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the pair again when either file changed since the last load and reports whether it did
+func (r *Reloader) Reload() (bool, error) {
+	certStamp, err := stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+
+	keyStamp, err := stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.certificate != nil && certStamp == r.certStamp && keyStamp == r.keyStamp
+	r.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.certificate = &certificate
+	r.certStamp = certStamp
+	r.keyStamp = keyStamp
+	return true, nil
+}
+
+// GetCertificate is meant for tls.Config
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.certificate, nil
+}
+
+// Watch checks the files every interval until ctx is done. While a renewal is being written the cert and the
+// key may not match, the error is logged and the next check picks the pair up once both are written.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				slog.Error("certificate reload error", "cert_file", r.certFile, "error", err)
+			} else if reloaded {
+				slog.Info("certificate reloaded", "cert_file", r.certFile)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	SelfSignedCertFile = "dev-cert.pem"
+	SelfSignedKeyFile  = "dev-key.pem"
+
+	selfSignedLifetime = time.Hour * 24 * 90
+	// selfSignedRenewal renews certificates this close to expiry. Renewal only happens when SelfSigned runs, on startup,
+	// so a dev server running for longer than selfSignedLifetime - selfSignedRenewal must be restarted.
+	selfSignedRenewal = time.Hour * 24 * 7
+)
+
+var ErrNoHosts = errors.New("self-signed certificates need at least one host")
+
+// SelfSigned returns the certificate and key files of a self-signed certificate for hosts, stored in dir.
+// The cached certificate is reused across restarts, so browsers only need an exception once, and is
+// regenerated when it is about to expire or no longer covers the same hosts. Meant for local development.
+func SelfSigned(dir string, hosts []string) (certFile string, keyFile string, err error) {
+	if len(hosts) == 0 {
+		return "", "", ErrNoHosts
+	}
+
+	certFile = filepath.Join(dir, SelfSignedCertFile)
+	keyFile = filepath.Join(dir, SelfSignedKeyFile)
+
+	if cached, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && covers(cached.Leaf, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(hosts, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+
+	// the key is written first, a reloader only picks the pair up once the certificate changes
+	if err = os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+
+	if err = os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+
+	return certFile, keyFile, nil
+}
+
+// covers reports whether leaf is valid for a while and for exactly these hosts
+func covers(leaf *x509.Certificate, hosts []string) bool {
+	if leaf == nil || time.Now().Add(selfSignedRenewal).After(leaf.NotAfter) {
+		return false
+	}
+
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	expected := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		expected = append(expected, host)
+	}
+
+	slices.Sort(names)
+	slices.Sort(expected)
+	return slices.Equal(names, slices.Compact(expected))
+}
+
+func generateSelfSigned(hosts []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"auth development"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !slices.ContainsFunc(template.IPAddresses, ip.Equal) {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if !slices.Contains(template.DNSNames, host) {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,359 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/logging"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPort  = errors.New("invalid port")
+	ErrInvalidValue = errors.New("invalid value")
+	ErrUnknownKey   = errors.New("unknown key")
+)
+
+const (
+	MailBackendFile   = "file"
+	MailBackendSMTP   = "smtp"
+	MailBackendMemory = "memory"
+
+	HasherArgon2id = "argon2id"
+	HasherBcrypt   = "bcrypt"
+)
+
+// Config holds every setting of the server. Each field is read from the config file under its key, from its
+// environment variable and from the flag named after its key, see Load.
+type Config struct {
+	Server   Server   `key:"server"`
+	TLS      TLS      `key:"tls"`
+	Cookies  Cookies  `key:"cookies"`
+	Session  Session  `key:"session"`
+	Password Password `key:"password"`
+	Log      Log      `key:"log"`
+	Audit    Audit    `key:"audit"`
+	Mail     Mail     `key:"mail"`
+	Secrets  Secrets  `key:"secrets"`
+	WebAuthn WebAuthn `key:"webauthn"`
+	Admin    Admin    `key:"admin"`
+}
+
+type Server struct {
+	// Dev relaxes the settings a production server must have, such as the secrets
+	Dev     bool   `key:"dev" env:"DEV" usage:"development mode, missing secrets are replaced by random keys"`
+	Port    string `key:"port" env:"PORT" usage:"port to listen on, required"`
+	BaseURL string `key:"base_url" env:"BASE_URL" usage:"URL users reach the server at, defaults to http(s)://localhost:PORT"`
+	// ReadHeaderTimeout and WriteTimeout are passed to http.Server
+	ReadHeaderTimeout time.Duration `key:"read_header_timeout" env:"READ_HEADER_TIMEOUT" usage:"time allowed to read request headers"`
+	WriteTimeout      time.Duration `key:"write_timeout" env:"WRITE_TIMEOUT" usage:"time allowed to write a response"`
+	// ShutdownDrainDelay is how long /readyz reports shutting_down before the server stops accepting connections
+	ShutdownDrainDelay time.Duration `key:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY" usage:"time readiness fails before shutting down"`
+	// ShutdownTimeout bounds how long in-flight requests get to complete once the server stops accepting connections
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"time in-flight requests get to complete on shutdown"`
+	// TrustedProxies are the only peers whose X-Forwarded-For and X-Real-IP headers are read, see user.AugmentRequestWithClientIP
+	TrustedProxies           []string `key:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"comma separated CIDRs of the proxies trusted to report the client IP"`
+	StaticDir                string   `key:"static_dir" env:"STATIC_DIR" usage:"directory served on /static/"`
+	RequireEmailConfirmation bool     `key:"require_email_confirmation" env:"REQUIRE_EMAIL_CONFIRMATION" usage:"restrict unconfirmed accounts to the confirmation page"`
+}
+
+// TLS serves HTTPS from CertFile and KeyFile, or from a self-signed certificate for DevHosts in dev mode
+type TLS struct {
+	CertFile string `key:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain, enables HTTPS with key_file"`
+	KeyFile  string `key:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key"`
+	// ReloadInterval is how often the files are checked for a renewed certificate
+	ReloadInterval time.Duration `key:"reload_interval" env:"TLS_RELOAD_INTERVAL" usage:"how often certificate files are checked for changes"`
+	// RedirectPort serves a plain HTTP listener redirecting every request to HTTPS
+	RedirectPort string   `key:"redirect_port" env:"TLS_REDIRECT_PORT" usage:"port redirecting HTTP to HTTPS, disabled when empty"`
+	Dev          bool     `key:"dev" env:"TLS_DEV" usage:"serve HTTPS with a cached self-signed certificate, for development"`
+	DevHosts     []string `key:"dev_hosts" env:"TLS_DEV_HOSTS" usage:"comma separated host names and IPs of the self-signed certificate"`
+	DevDir       string   `key:"dev_dir" env:"TLS_DEV_DIR" usage:"directory the self-signed certificate is cached in"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies
+func (s Server) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func (t TLS) Enabled() bool {
+	return t.Dev || t.CertFile != ""
+}
+
+type Cookies struct {
+	// Secure is auto, true or false, see Config.SecureCookies
+	Secure string `key:"secure" env:"COOKIE_SECURE" usage:"only send cookies over HTTPS: auto, true or false"`
+	Domain string `key:"domain" env:"COOKIE_DOMAIN" usage:"domain cookies are scoped to, the request host when empty"`
+}
+
+const (
+	SecureCookiesAuto   = "auto"
+	SecureCookiesAlways = "true"
+	SecureCookiesNever  = "false"
+)
+
+type Session struct {
+	IdleTimeout      time.Duration `key:"idle_timeout" env:"SESSION_IDLE_TIMEOUT" usage:"maximum time a session can go unused, 0 disables it"`
+	AbsoluteLifetime time.Duration `key:"absolute_lifetime" env:"SESSION_ABSOLUTE_LIFETIME" usage:"maximum age of a session, 0 disables it"`
+}
+
+type Password struct {
+	// Hasher is the algorithm new passwords are hashed with, hashes made by the other one are upgraded on login
+	Hasher     string `key:"hasher" env:"PASSWORD_HASHER" usage:"argon2id or bcrypt"`
+	BcryptCost int    `key:"bcrypt_cost" env:"BCRYPT_COST" usage:"bcrypt cost, from 4 to 31"`
+	BreachDir  string `key:"breach_dir" env:"PASSWORD_BREACH_DIR" usage:"directory of Have I Been Pwned range files, empty to disable"`
+}
+
+type Log struct {
+	Format string `key:"format" env:"LOG_FORMAT" usage:"text or json"`
+	Level  string `key:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+}
+
+type Audit struct {
+	File       string `key:"file" env:"AUDIT_LOG_FILE" usage:"JSON lines file audit events are appended to"`
+	MaxSizeMB  int    `key:"max_size_mb" env:"AUDIT_LOG_MAX_SIZE_MB" usage:"size in megabytes the audit log is rotated at"`
+	MaxBackups int    `key:"max_backups" env:"AUDIT_LOG_MAX_BACKUPS" usage:"rotated audit logs to keep"`
+}
+
+type Mail struct {
+	Backend           string `key:"backend" env:"MAIL_BACKEND" usage:"smtp, file or memory"`
+	Dir               string `key:"dir" env:"MAIL_DIR" usage:"directory of the file backend"`
+	From              string `key:"from" env:"MAIL_FROM" usage:"sender of every email"`
+	SMTPHost          string `key:"smtp_host" env:"SMTP_HOST" usage:"SMTP server host, required by the smtp backend"`
+	SMTPPort          string `key:"smtp_port" env:"SMTP_PORT" usage:"SMTP server port"`
+	SMTPUsername      string `key:"smtp_username" env:"SMTP_USERNAME" usage:"SMTP username, no authentication when empty"`
+	SMTPPassword      string `key:"smtp_password" env:"SMTP_PASSWORD" usage:"SMTP password"`
+	SMTPAllowInsecure bool   `key:"smtp_allow_insecure" env:"SMTP_ALLOW_INSECURE" usage:"send without STARTTLS, e.g. to a local mail catcher"`
+}
+
+// Secrets are decoded when the config is validated, invalid keys prevent the server from starting. Only in dev mode
+// are missing keys replaced by random ones.
+type Secrets struct {
+	EncryptionKey  string `key:"encryption_key" env:"ENCRYPTION_KEY" usage:"base64 encoded 32 bytes key encrypting TOTP secrets"`
+	JWTAlgorithm   string `key:"jwt_algorithm" env:"JWT_ALGORITHM" usage:"EdDSA or HS256"`
+	JWTKey         string `key:"jwt_key" env:"JWT_KEY" usage:"base64 ed25519 seed or HMAC secret signing access tokens"`
+	JWTPreviousKey string `key:"jwt_previous_key" env:"JWT_PREVIOUS_KEY" usage:"key access tokens were signed with before a rotation"`
+}
+
+type WebAuthn struct {
+	RPName string `key:"rp_name" env:"WEBAUTHN_RP_NAME" usage:"name shown by browsers when creating a passkey"`
+}
+
+type Admin struct {
+	Emails []string `key:"emails" env:"ADMIN_EMAILS" usage:"comma separated emails holding the admin role, checked on every login"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			ReadHeaderTimeout: time.Second * 5,
+			WriteTimeout:      time.Second * 5,
+			ShutdownTimeout:   time.Second * 10,
+			StaticDir:         "./static",
+		},
+		TLS: TLS{
+			ReloadInterval: time.Second * 30,
+			DevHosts:       []string{"localhost", "127.0.0.1", "::1"},
+			DevDir:         "tmp/certs",
+		},
+		Cookies: Cookies{Secure: SecureCookiesAuto},
+		Session: Session{
+			IdleTimeout:      time.Hour * 24,
+			AbsoluteLifetime: time.Hour * 24 * 30,
+		},
+		Password: Password{Hasher: HasherArgon2id, BcryptCost: bcrypt.DefaultCost},
+		Log:      Log{Format: logging.FormatText, Level: "info"},
+		Audit:    Audit{File: "tmp/audit.jsonl", MaxSizeMB: 10, MaxBackups: 5},
+		Mail: Mail{
+			Backend:  MailBackendFile,
+			Dir:      "tmp/mail",
+			From:     "no-reply@localhost",
+			SMTPPort: "587",
+		},
+		Secrets: Secrets{JWTAlgorithm: jwt.AlgorithmEdDSA},
+	}
+}
+
+// FieldError reports an invalid setting, Source is where the value came from
+type FieldError struct {
+	Key    string
+	Source string
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("%s: %s", e.Key, e.Err)
+	}
+
+	return fmt.Sprintf("%s (%s): %s", e.Key, e.Source, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func invalid(key, reason string) error {
+	return &FieldError{Key: key, Err: fmt.Errorf("%w: %s", ErrInvalidValue, reason)}
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, &FieldError{Key: "server.port", Err: ErrInvalidPort})
+	}
+
+	if parsed, err := url.Parse(c.Server.BaseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs = append(errs, invalid("server.base_url", "must be an absolute http or https URL"))
+	}
+
+	if c.Server.ReadHeaderTimeout <= 0 {
+		errs = append(errs, invalid("server.read_header_timeout", "must be positive"))
+	}
+
+	if c.Server.WriteTimeout <= 0 {
+		errs = append(errs, invalid("server.write_timeout", "must be positive"))
+	}
+
+	if c.Server.ShutdownDrainDelay < 0 {
+		errs = append(errs, invalid("server.shutdown_drain_delay", "cannot be negative"))
+	}
+
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, invalid("server.trusted_proxies", "must be CIDRs such as 10.0.0.0/8"))
+	}
+
+	if c.TLS.Dev && (c.TLS.CertFile != "" || c.TLS.KeyFile != "") {
+		errs = append(errs, invalid("tls.dev", "cannot be combined with cert_file and key_file"))
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, invalid("tls.key_file", "cert_file and key_file go together"))
+	}
+
+	if c.TLS.ReloadInterval <= 0 {
+		errs = append(errs, invalid("tls.reload_interval", "must be positive"))
+	}
+
+	if c.TLS.RedirectPort != "" {
+		if port, err := strconv.Atoi(c.TLS.RedirectPort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, &FieldError{Key: "tls.redirect_port", Err: ErrInvalidPort})
+		} else if !c.TLS.Enabled() {
+			errs = append(errs, invalid("tls.redirect_port", "requires HTTPS to be enabled"))
+		} else if c.TLS.RedirectPort == c.Server.Port {
+			errs = append(errs, invalid("tls.redirect_port", "must differ from server.port"))
+		}
+	}
+
+	if c.TLS.Dev && len(c.TLS.DevHosts) == 0 {
+		errs = append(errs, invalid("tls.dev_hosts", "required in dev mode"))
+	}
+
+	if !slices.Contains([]string{SecureCookiesAuto, SecureCookiesAlways, SecureCookiesNever}, c.Cookies.Secure) {
+		errs = append(errs, invalid("cookies.secure", "must be auto, true or false"))
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, invalid("server.shutdown_timeout", "must be positive"))
+	}
+
+	if c.Server.StaticDir == "" {
+		errs = append(errs, invalid("server.static_dir", "cannot be empty"))
+	}
+
+	if c.Session.IdleTimeout < 0 {
+		errs = append(errs, invalid("session.idle_timeout", "cannot be negative"))
+	}
+
+	if c.Session.AbsoluteLifetime < 0 {
+		errs = append(errs, invalid("session.absolute_lifetime", "cannot be negative"))
+	}
+
+	if !slices.Contains([]string{HasherArgon2id, HasherBcrypt}, c.Password.Hasher) {
+		errs = append(errs, invalid("password.hasher", "must be argon2id or bcrypt"))
+	}
+
+	if c.Password.BcryptCost < bcrypt.MinCost || c.Password.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, invalid("password.bcrypt_cost", fmt.Sprintf("must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)))
+	}
+
+	if !slices.Contains([]string{logging.FormatText, logging.FormatJSON}, c.Log.Format) {
+		errs = append(errs, invalid("log.format", "must be text or json"))
+	}
+
+	if _, err := c.Log.SlogLevel(); err != nil {
+		errs = append(errs, invalid("log.level", "must be debug, info, warn or error"))
+	}
+
+	if c.Audit.File == "" {
+		errs = append(errs, invalid("audit.file", "cannot be empty"))
+	}
+
+	if c.Audit.MaxSizeMB <= 0 {
+		errs = append(errs, invalid("audit.max_size_mb", "must be positive"))
+	}
+
+	if c.Audit.MaxBackups <= 0 {
+		errs = append(errs, invalid("audit.max_backups", "must be positive"))
+	}
+
+	switch c.Mail.Backend {
+	case MailBackendFile:
+		if c.Mail.Dir == "" {
+			errs = append(errs, invalid("mail.dir", "required by the file backend"))
+		}
+	case MailBackendSMTP:
+		if c.Mail.SMTPHost == "" {
+			errs = append(errs, invalid("mail.smtp_host", "required by the smtp backend"))
+		}
+	case MailBackendMemory:
+	default:
+		errs = append(errs, invalid("mail.backend", "must be smtp, file or memory"))
+	}
+
+	if !slices.Contains([]string{jwt.AlgorithmEdDSA, jwt.AlgorithmHS256}, c.Secrets.JWTAlgorithm) {
+		errs = append(errs, invalid("secrets.jwt_algorithm", "must be EdDSA or HS256"))
+	}
+
+	errs = append(errs, c.validateSecrets()...)
+
+	return errors.Join(errs...)
+}
+
+// SecureCookies reports whether cookies are only sent over HTTPS. In auto mode they are when the server serves
+// HTTPS or when the base URL is HTTPS, which covers a TLS terminating proxy in front of the server.
+// Browsers drop secure cookies set over plain HTTP, except on localhost.
+func (c Config) SecureCookies() bool {
+	switch c.Cookies.Secure {
+	case SecureCookiesAlways:
+		return true
+	case SecureCookiesNever:
+		return false
+	}
+
+	return c.TLS.Enabled() || strings.HasPrefix(c.Server.BaseURL, "https://")
+}
+
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env runs in dev mode unless DEV is set, so tests do not need to configure secrets
+func env(values map[string]string) func(string) string {
+	return func(key string) string {
+		if value, ok := values[key]; ok || key != "DEV" {
+			return value
+		}
+
+		return "true"
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		config, err := Load(nil, env(map[string]string{"PORT": "8080"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if config.Server.BaseURL != "http://localhost:8080" {
+			t.Errorf("unexpected base url: %s", config.Server.BaseURL)
+		}
+
+		if config.Server.WriteTimeout != time.Second*5 || config.Cookies.Secure != SecureCookiesAuto || config.Mail.Backend != MailBackendFile {
+			t.Errorf("unexpected defaults: %+v", config)
+		}
+	})
+
+	t.Run("missing port", func(t *testing.T) {
+		_, err := Load(nil, env(nil))
+		if !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidPort, err)
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		config, err := Load(nil, env(map[string]string{
+			"PORT":                 "9000",
+			"WRITE_TIMEOUT":        "30s",
+			"COOKIE_SECURE":        "false",
+			"BCRYPT_COST":          "12",
+			"ADMIN_EMAILS":         "a@example.com, b@example.com,",
+			"SESSION_IDLE_TIMEOUT": "0s",
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if config.Server.WriteTimeout != time.Second*30 || config.SecureCookies() || config.Password.BcryptCost != 12 {
+			t.Errorf("environment was not applied: %+v", config)
+		}
+
+		if !slices.Equal(config.Admin.Emails, []string{"a@example.com", "b@example.com"}) {
+			t.Errorf("unexpected admin emails: %v", config.Admin.Emails)
+		}
+
+		if config.Session.IdleTimeout != 0 {
+			t.Errorf("unexpected idle timeout: %s", config.Session.IdleTimeout)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		path := writeFile(t, "config.toml", `
+[server]
+port = "7000"
+static_dir = "/srv/static"
+write_timeout = "10s"
+`)
+
+		config, err := Load([]string{"-config", path, "-server.write_timeout", "20s"}, env(map[string]string{
+			"STATIC_DIR":    "/env/static",
+			"WRITE_TIMEOUT": "15s",
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if config.Server.Port != "7000" {
+			t.Errorf("file was not applied. got: %s", config.Server.Port)
+		}
+
+		if config.Server.StaticDir != "/env/static" {
+			t.Errorf("environment should override the file. got: %s", config.Server.StaticDir)
+		}
+
+		if config.Server.WriteTimeout != time.Second*20 {
+			t.Errorf("flags should override the environment. got: %s", config.Server.WriteTimeout)
+		}
+	})
+
+	t.Run("config file from environment", func(t *testing.T) {
+		path := writeFile(t, "config.json", `{"server": {"port": 7001, "require_email_confirmation": true}, "admin": {"emails": ["a@example.com"]}}`)
+
+		config, err := Load(nil, env(map[string]string{ConfigFileEnv: path}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if config.Server.Port != "7001" || !config.Server.RequireEmailConfirmation || len(config.Admin.Emails) != 1 {
+			t.Errorf("file was not applied: %+v", config)
+		}
+	})
+
+	t.Run("boolean flag", func(t *testing.T) {
+		config, err := Load([]string{"-server.port", "8080", "-server.require_email_confirmation"}, env(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !config.Server.RequireEmailConfirmation {
+			t.Errorf("boolean flag was not applied")
+		}
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		_, err := Load([]string{"-server.port", "8080"}, env(map[string]string{
+			"WRITE_TIMEOUT": "soon",
+			"LOG_FORMAT":    "xml",
+			"MAIL_BACKEND":  "smtp",
+		}))
+
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Key != "server.write_timeout" || fieldErr.Source != "WRITE_TIMEOUT" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// parsing errors are reported before validation runs
+		if strings.Contains(err.Error(), "log.format") {
+			t.Errorf("validation should not run on unparsable values: %s", err)
+		}
+
+		_, err = Load([]string{"-server.port", "8080"}, env(map[string]string{"LOG_FORMAT": "xml", "MAIL_BACKEND": "smtp"}))
+		for _, key := range []string{"log.format", "mail.smtp_host"} {
+			if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), key) {
+				t.Errorf("expected %s to be reported. got: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("unknown file key", func(t *testing.T) {
+		path := writeFile(t, "config.toml", "[server]\nprot = \"8080\"\n")
+
+		_, err := Load([]string{"-config", path, "-server.port", "8080"}, env(nil))
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownKey, err)
+		}
+	})
+
+	t.Run("unknown file format", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "server:\n  port: 8080\n")
+
+		_, err := Load([]string{"-config", path}, env(nil))
+		if !errors.Is(err, ErrUnknownFileFormat) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownFileFormat, err)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		config, err := Load([]string{"-server.port", "8443", "-tls.dev", "-tls.redirect_port", "8080"}, env(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if config.Server.BaseURL != "https://localhost:8443" {
+			t.Errorf("unexpected base url: %s", config.Server.BaseURL)
+		}
+
+		invalid := [][]string{
+			{"-tls.cert_file", "cert.pem"},
+			{"-tls.dev", "-tls.cert_file", "cert.pem", "-tls.key_file", "key.pem"},
+			{"-tls.redirect_port", "8080"},
+			{"-tls.dev", "-tls.redirect_port", "8443"},
+		}
+		for _, args := range invalid {
+			if _, err = Load(append([]string{"-server.port", "8443"}, args...), env(nil)); !errors.Is(err, ErrInvalidValue) {
+				t.Errorf("unexpected error for %v. expected: %s. got: %v", args, ErrInvalidValue, err)
+			}
+		}
+	})
+
+	t.Run("trusted proxies", func(t *testing.T) {
+		config, err := Load(nil, env(map[string]string{"PORT": "8080", "TRUSTED_PROXIES": "10.0.0.1/8, ::1/128"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		prefixes, _ := config.Server.TrustedProxyPrefixes()
+		if len(prefixes) != 2 || prefixes[0].String() != "10.0.0.0/8" {
+			t.Errorf("unexpected trusted proxies: %v", prefixes)
+		}
+
+		if _, err = Load(nil, env(map[string]string{"PORT": "8080", "TRUSTED_PROXIES": "10.0.0.1"})); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidValue, err)
+		}
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+		config, err := Load([]string{"-server.port", "8080"}, env(map[string]string{"DEV": "false", "ENCRYPTION_KEY": key, "JWT_KEY": key}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if keys, err := config.Secrets.TokenKeys(); err != nil || keys == nil {
+			t.Errorf("unexpected token keys: %v, %v", keys, err)
+		}
+
+		_, err = Load([]string{"-server.port", "8080"}, env(map[string]string{"DEV": "false"}))
+		for _, field := range []string{"secrets.encryption_key", "secrets.jwt_key"} {
+			if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), field) {
+				t.Errorf("expected %s to be required outside dev mode. got: %v", field, err)
+			}
+		}
+
+		// dev mode only tolerates missing keys, malformed ones still prevent the server from starting
+		invalid := []map[string]string{
+			{"ENCRYPTION_KEY": "not base64"},
+			{"ENCRYPTION_KEY": base64.StdEncoding.EncodeToString(make([]byte, 16))},
+			{"JWT_KEY": "not base64"},
+			{"JWT_KEY": base64.StdEncoding.EncodeToString(make([]byte, 16)), "JWT_ALGORITHM": "HS256"},
+			{"JWT_KEY": key, "JWT_PREVIOUS_KEY": "AAAA"},
+			{"JWT_PREVIOUS_KEY": key},
+		}
+		for _, values := range invalid {
+			if _, err = Load([]string{"-server.port", "8080"}, env(values)); !errors.Is(err, ErrInvalidValue) {
+				t.Errorf("unexpected error for %v. expected: %s. got: %v", values, ErrInvalidValue, err)
+			}
+		}
+	})
+
+	t.Run("help", func(t *testing.T) {
+		_, err := Load([]string{"-h"}, env(nil))
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("unexpected error. expected: %s. got: %v", flag.ErrHelp, err)
+		}
+	})
+}
+
+func TestConfig_SecureCookies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		secure   string
+		baseURL  string
+		tls      TLS
+		expected bool
+	}{
+		{"auto over http", SecureCookiesAuto, "http://auth.test:8080", TLS{}, false},
+		{"auto behind an https proxy", SecureCookiesAuto, "https://auth.test", TLS{}, true},
+		{"auto with tls", SecureCookiesAuto, "http://auth.test:8080", TLS{Dev: true}, true},
+		{"always", SecureCookiesAlways, "http://auth.test:8080", TLS{}, true},
+		{"never", SecureCookiesNever, "https://auth.test", TLS{Dev: true}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := Default()
+			config.Cookies.Secure = tc.secure
+			config.Server.BaseURL = tc.baseURL
+			config.TLS = tc.tls
+
+			if secure := config.SecureCookies(); secure != tc.expected {
+				t.Errorf("unexpected value. expected: %t. got: %t", tc.expected, secure)
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML([]byte(`
+# comment
+[server]
+port = "8080" # trailing comment
+base_url = 'https://example.com'
+
+[password]
+bcrypt_cost = 1_2
+
+[admin]
+emails = ["a@example.com", 'b@example.com']
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"server.port":          "8080",
+		"server.base_url":      "https://example.com",
+		"password.bcrypt_cost": "12",
+		"admin.emails":         "a@example.com,b@example.com",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("unexpected value for %s. expected: %q. got: %q", key, value, values[key])
+		}
+	}
+
+	invalid := []string{
+		"port = 8080 8081",
+		"[server",
+		"port = unquoted",
+		`port = "unterminated`,
+		"emails = [\"a\" \"b\"]",
+		"port = \"1\"\nport = \"2\"",
+	}
+	for _, document := range invalid {
+		if _, err = parseTOML([]byte(document)); err == nil {
+			t.Errorf("expected an error for %q", document)
+		}
+	}
+}
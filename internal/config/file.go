@@ -0,0 +1,230 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownFileFormat = errors.New("unknown config file format, expected .json or .toml")
+
+// readFile flattens the file into raw values keyed by their dotted path, such as server.port.
+// Values are formatted the way environment variables are so both go through the same parsing.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSON(data)
+	case ".toml":
+		return parseTOML(data)
+	}
+
+	return nil, ErrUnknownFileFormat
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	var document map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if err := flattenJSON(values, "", document); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func flattenJSON(values map[string]string, prefix string, document map[string]any) error {
+	for key, value := range document {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch typed := value.(type) {
+		case map[string]any:
+			if err := flattenJSON(values, key, typed); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(typed))
+			for _, item := range typed {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("%s: lists can only hold strings", key)
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+		case string:
+			values[key] = typed
+		case json.Number:
+			values[key] = typed.String()
+		case bool:
+			values[key] = strconv.FormatBool(typed)
+		case nil:
+		default:
+			return fmt.Errorf("%s: unsupported value", key)
+		}
+	}
+
+	return nil
+}
+
+// parseTOML supports the subset of TOML settings need: [tables], comments, and key = value pairs
+// holding strings, integers, booleans or single line arrays of strings.
+func parseTOML(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	table := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			end := strings.Index(text, "]")
+			if end == -1 || !isComment(text[end+1:]) {
+				return nil, fmt.Errorf("line %d: invalid table header", line)
+			}
+
+			table = strings.TrimSpace(text[1:end])
+			if table == "" {
+				return nil, fmt.Errorf("line %d: empty table name", line)
+			}
+			continue
+		}
+
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", line)
+		}
+
+		if table != "" {
+			key = table + "." + key
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %s", line, key)
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+func isComment(text string) bool {
+	text = strings.TrimSpace(text)
+	return text == "" || strings.HasPrefix(text, "#")
+}
+
+func parseTOMLValue(text string) (string, error) {
+	if strings.HasPrefix(text, "[") {
+		var items []string
+		rest := strings.TrimSpace(text[1:])
+		for !strings.HasPrefix(rest, "]") {
+			item, remaining, err := parseTOMLString(rest)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+
+			rest = strings.TrimSpace(remaining)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", errors.New("expected , or ] in array")
+			}
+		}
+
+		if !isComment(rest[1:]) {
+			return "", errors.New("unexpected content after array")
+		}
+
+		return strings.Join(items, ","), nil
+	}
+
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		value, rest, err := parseTOMLString(text)
+		if err != nil {
+			return "", err
+		}
+
+		if !isComment(rest) {
+			return "", errors.New("unexpected content after string")
+		}
+
+		return value, nil
+	}
+
+	value, _, _ := strings.Cut(text, "#")
+	value = strings.TrimSpace(value)
+
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %q, strings must be quoted", value)
+}
+
+// parseTOMLString reads a basic "string" or a literal 'string' and returns what follows it
+func parseTOMLString(text string) (string, string, error) {
+	if strings.HasPrefix(text, "'") {
+		end := strings.Index(text[1:], "'")
+		if end == -1 {
+			return "", "", errors.New("unterminated string")
+		}
+
+		return text[1 : end+1], text[end+2:], nil
+	}
+
+	if !strings.HasPrefix(text, `"`) {
+		return "", "", errors.New("expected a string")
+	}
+
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(text[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string: %w", err)
+			}
+
+			return value, text[i+1:], nil
+		}
+	}
+
+	return "", "", errors.New("unterminated string")
+}
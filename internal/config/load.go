@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// ConfigFileEnv and ConfigFileFlag point to the optional config file
+	ConfigFileEnv  = "CONFIG_FILE"
+	ConfigFileFlag = "config"
+)
+
+// field is a setting of Config, key is its dotted path such as server.port
+type field struct {
+	key   string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+// fields lists the settings of config in declaration order, sections are tagged with the prefix of their keys
+func fields(config *Config) []field {
+	var all []field
+
+	sections := reflect.ValueOf(config).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		prefix := sections.Type().Field(i).Tag.Get("key")
+		section := sections.Field(i)
+
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+			all = append(all, field{
+				key:   prefix + "." + tag.Get("key"),
+				env:   tag.Get("env"),
+				usage: tag.Get("usage"),
+				value: section.Field(j),
+			})
+		}
+	}
+
+	return all
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses raw according to the type of the field. Lists are comma separated.
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a duration such as 30s or 1h", ErrInvalidValue, raw)
+		}
+		f.value.SetInt(int64(duration))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a boolean", ErrInvalidValue, raw)
+		}
+		f.value.SetBool(value)
+	case f.value.Kind() == reflect.Int:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an integer", ErrInvalidValue, raw)
+		}
+		f.value.SetInt(int64(value))
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		f.value.Set(reflect.ValueOf(values))
+	default:
+		panic("config: unsupported type " + f.value.Type().String())
+	}
+
+	return nil
+}
+
+func (f field) String() string {
+	if f.value.Kind() == reflect.Slice {
+		return strings.Join(f.value.Interface().([]string), ",")
+	}
+
+	return fmt.Sprint(f.value.Interface())
+}
+
+// flagValue records the raw value of a flag, settings are only parsed once every source has been read
+type flagValue struct {
+	raw    string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	return v.raw
+}
+
+func (v *flagValue) Set(raw string) error {
+	v.raw = raw
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Load starts from Default and overrides it with the config file, then environment variables, then flags.
+// The file is the -config flag or CONFIG_FILE, JSON or TOML depending on its extension. Empty environment
+// variables are ignored. args excludes the program name, flag.ErrHelp is returned for -h and -help.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	config := Default()
+	all := fields(&config)
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configFile := flags.String(ConfigFileFlag, "", "")
+
+	flagValues := make(map[string]*flagValue, len(all))
+	for _, f := range all {
+		value := &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		flagValues[f.key] = value
+		flags.Var(value, f.key, f.usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if flags.NArg() > 0 {
+		return config, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	var errs []error
+
+	path := *configFile
+	if path == "" {
+		path = getenv(ConfigFileEnv)
+	}
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return config, fmt.Errorf("config file %s: %w", path, err)
+		}
+
+		known := make(map[string]bool, len(all))
+		for _, f := range all {
+			known[f.key] = true
+			if raw, ok := values[f.key]; ok {
+				if err = f.set(raw); err != nil {
+					errs = append(errs, &FieldError{Key: f.key, Source: path, Err: err})
+				}
+			}
+		}
+
+		for key := range values {
+			if !known[key] {
+				errs = append(errs, &FieldError{Key: key, Source: path, Err: ErrUnknownKey})
+			}
+		}
+	}
+
+	for _, f := range all {
+		if raw := getenv(f.env); raw != "" {
+			if err := f.set(raw); err != nil {
+				errs = append(errs, &FieldError{Key: f.key, Source: f.env, Err: err})
+			}
+		}
+	}
+
+	flags.Visit(func(flag *flag.Flag) {
+		for _, f := range all {
+			if f.key == flag.Name {
+				if err := f.set(flagValues[f.key].raw); err != nil {
+					errs = append(errs, &FieldError{Key: f.key, Source: "-" + f.key, Err: err})
+				}
+			}
+		}
+	})
+
+	if len(errs) > 0 {
+		return config, errors.Join(errs...)
+	}
+
+	if config.Server.BaseURL == "" && config.Server.Port != "" {
+		scheme := "http"
+		if config.TLS.Enabled() {
+			scheme = "https"
+		}
+		config.Server.BaseURL = scheme + "://localhost:" + config.Server.Port
+	}
+
+	return config, config.Validate()
+}
+
+// Usage lists every setting with its flag, environment variable and default value
+func Usage(writer io.Writer) error {
+	config := Default()
+
+	w := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "FLAG\tENV\tDEFAULT\tDESCRIPTION\n")
+	_, _ = fmt.Fprintf(w, "-%s\t%s\t\tconfig file, .json or .toml\n", ConfigFileFlag, ConfigFileEnv)
+	for _, f := range fields(&config) {
+		_, _ = fmt.Fprintf(w, "-%s\t%s\t%s\t%s\n", f.key, f.env, f, f.usage)
+	}
+
+	return w.Flush()
+}
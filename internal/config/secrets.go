@@ -0,0 +1,121 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/mathieuhays/auth/internal/encryption"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"slices"
+)
+
+// Box decodes the base64 encoded 32 bytes EncryptionKey, it is nil when none is configured
+func (s Secrets) Box() (*encryption.Box, error) {
+	if s.EncryptionKey == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.NewBox(raw)
+}
+
+// TokenKeys builds the access token keys from JWTKey, and JWTPreviousKey during a rotation. The key set is nil when
+// no key is configured.
+func (s Secrets) TokenKeys() (*jwt.KeySet, error) {
+	if s.JWTKey == "" {
+		return nil, nil
+	}
+
+	current, err := tokenKey(s.JWTAlgorithm, s.JWTKey)
+	if err != nil {
+		return nil, &FieldError{Key: "secrets.jwt_key", Err: err}
+	}
+
+	var previous []jwt.Key
+	if s.JWTPreviousKey != "" {
+		key, err := tokenKey(s.JWTAlgorithm, s.JWTPreviousKey)
+		if err != nil {
+			return nil, &FieldError{Key: "secrets.jwt_previous_key", Err: err}
+		}
+
+		previous = append(previous, key)
+	}
+
+	return jwt.NewKeySet(current, previous...), nil
+}
+
+// tokenKey decodes a base64 ed25519 seed or HMAC secret. The key ID is derived from the key so rotating
+// keys does not require picking IDs.
+func tokenKey(algorithm, encoded string) (jwt.Key, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case jwt.AlgorithmEdDSA:
+		if len(raw) != ed25519.SeedSize {
+			return nil, jwt.ErrInvalidKey
+		}
+
+		private := ed25519.NewKeyFromSeed(raw)
+		return jwt.NewEdDSAKey(tokenKeyID(private.Public().(ed25519.PublicKey)), private, nil)
+	case jwt.AlgorithmHS256:
+		return jwt.NewHS256Key(tokenKeyID(raw), raw)
+	}
+
+	return nil, jwt.ErrUnsupportedAlgorithm
+}
+
+func tokenKeyID(material []byte) string {
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+// validateSecrets decodes every configured secret. Outside dev mode the encryption and signing keys are required,
+// random ones would make TOTP secrets unreadable and sign out API clients on every restart.
+func (c Config) validateSecrets() []error {
+	var errs []error
+
+	if _, err := c.Secrets.Box(); err != nil {
+		errs = append(errs, invalid("secrets.encryption_key", "must be a base64 encoded 32 bytes key"))
+	} else if c.Secrets.EncryptionKey == "" && !c.Server.Dev {
+		errs = append(errs, invalid("secrets.encryption_key", "required outside dev mode"))
+	}
+
+	keyReason := "must be a base64 encoded 32 bytes ed25519 seed"
+	if c.Secrets.JWTAlgorithm == jwt.AlgorithmHS256 {
+		keyReason = "must be a base64 encoded secret of at least 32 bytes"
+	}
+
+	switch {
+	case c.Secrets.JWTKey == "" && !c.Server.Dev:
+		errs = append(errs, invalid("secrets.jwt_key", "required outside dev mode"))
+	case c.Secrets.JWTKey == "" && c.Secrets.JWTPreviousKey != "":
+		errs = append(errs, invalid("secrets.jwt_previous_key", "requires jwt_key"))
+	}
+
+	// an unsupported algorithm is already reported on its own
+	if !slices.Contains([]string{jwt.AlgorithmEdDSA, jwt.AlgorithmHS256}, c.Secrets.JWTAlgorithm) {
+		return errs
+	}
+
+	if c.Secrets.JWTKey != "" {
+		if _, err := tokenKey(c.Secrets.JWTAlgorithm, c.Secrets.JWTKey); err != nil {
+			errs = append(errs, invalid("secrets.jwt_key", keyReason))
+		}
+	}
+
+	if c.Secrets.JWTPreviousKey != "" {
+		if _, err := tokenKey(c.Secrets.JWTAlgorithm, c.Secrets.JWTPreviousKey); err != nil {
+			errs = append(errs, invalid("secrets.jwt_previous_key", keyReason))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,75 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const KeySize = 32
+
+var (
+	ErrInvalidKey = errors.New("encryption key must be 32 bytes")
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// Box encrypts small secrets at rest with AES-256-GCM.
+// Sealed values are the base64 encoded nonce followed by the ciphertext.
+type Box struct {
+	aead cipher.AEAD
+}
+
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Seal encrypts plaintext. additionalData is authenticated but not encrypted,
+// it binds the value to its owner so that it cannot be copied to another record.
+func (b *Box) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+func (b *Box) Open(sealed string, additionalData []byte) ([]byte, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,74 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBox(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	box, err := NewBox(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	sealed, err := box.Seal([]byte("secret"), []byte("owner"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		plaintext, err := box.Open(sealed, []byte("owner"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if string(plaintext) != "secret" {
+			t.Errorf("unexpected plaintext: %s", plaintext)
+		}
+	})
+
+	t.Run("nonce is random", func(t *testing.T) {
+		other, _ := box.Seal([]byte("secret"), []byte("owner"))
+		if other == sealed {
+			t.Errorf("sealing twice should not produce the same value")
+		}
+	})
+
+	testCases := []struct {
+		name           string
+		sealed         string
+		additionalData string
+	}{
+		{"other owner", sealed, "someone else"},
+		{"tampered", sealed[:len(sealed)-2] + "AA", "owner"},
+		{"too short", "AAAA", "owner"},
+		{"not base64", "!!!", "owner"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := box.Open(tc.sealed, []byte(tc.additionalData)); !errors.Is(err, ErrDecryption) {
+				t.Errorf("unexpected error. expected: %s. got: %v", ErrDecryption, err)
+			}
+		})
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		otherKey, _ := GenerateKey()
+		otherBox, _ := NewBox(otherKey)
+		if _, err := otherBox.Open(sealed, []byte("owner")); !errors.Is(err, ErrDecryption) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrDecryption, err)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		if _, err := NewBox([]byte("short")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidKey, err)
+		}
+	})
+}
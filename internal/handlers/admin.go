@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// AdminAction is an operation an administrator runs on an account, see AdminUserActionHandler
+type AdminAction string
+
+const (
+	AdminActionDisable        AdminAction = "disable"
+	AdminActionEnable         AdminAction = "enable"
+	AdminActionResetPassword  AdminAction = "reset-password"
+	AdminActionRevokeSessions AdminAction = "revoke-sessions"
+	AdminActionUnlock         AdminAction = "unlock"
+	// AdminActionGrantRole and AdminActionRevokeRole read the role from the role form value
+	AdminActionGrantRole  AdminAction = "grant-role"
+	AdminActionRevokeRole AdminAction = "revoke-role"
+)
+
+// adminActionEvents are the audit events recorded for each action
+var adminActionEvents = map[AdminAction]audit.EventType{
+	AdminActionDisable:        audit.EventAdminUserDisabled,
+	AdminActionEnable:         audit.EventAdminUserEnabled,
+	AdminActionResetPassword:  audit.EventAdminPasswordResetForced,
+	AdminActionRevokeSessions: audit.EventAdminSessionsRevoked,
+	AdminActionUnlock:         audit.EventAdminAccountUnlocked,
+	AdminActionGrantRole:      audit.EventAdminRoleGranted,
+	AdminActionRevokeRole:     audit.EventAdminRoleRevoked,
+}
+
+// adminActionMessages are shown on the user page once the action succeeded
+var adminActionMessages = map[AdminAction]string{
+	AdminActionDisable:        "The account has been disabled and signed out everywhere.",
+	AdminActionEnable:         "The account has been enabled.",
+	AdminActionResetPassword:  "A password reset email has been sent, the current password no longer works.",
+	AdminActionRevokeSessions: "All sessions have been revoked.",
+	AdminActionUnlock:         "The account has been unlocked, failed sign-in attempts are forgotten.",
+	AdminActionGrantRole:      "The role has been granted.",
+	AdminActionRevokeRole:     "The role has been revoked.",
+}
+
+type adminUsersTemplates interface {
+	AdminUsers(writer io.Writer, u *users.User, results *user.UserPage) error
+}
+
+type adminUserTemplates interface {
+	AdminUser(writer io.Writer, u *users.User, target *users.User, userSessions []sessions.Session, message string) error
+	Error(writer io.Writer, title, description string) error
+}
+
+// AdminUsersHandler lists the users matching the q query parameter, one page at a time.
+func AdminUsersHandler(tpl adminUsersTemplates, userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		results, err := userService.SearchUsers(r.URL.Query().Get("q"), page, user.DefaultUsersPerPage)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("admin user search error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err = tpl.AdminUsers(w, u, results); err != nil {
+			logging.FromContext(r.Context()).Error("template error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
+
+// adminTarget loads the user matching the {id} path value, rendering a 404 page when there is none
+func adminTarget(w http.ResponseWriter, r *http.Request, tpl adminUserTemplates, userService user.ServiceInterface) (*users.User, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err == nil {
+		var target *users.User
+		if target, err = userService.GetUser(id); err == nil {
+			return target, true
+		}
+	}
+
+	if !errors.Is(err, users.ErrUserNotFound) {
+		logging.FromContext(r.Context()).Error("admin user lookup error", "error", err)
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	if err = tpl.Error(w, "Error 404", "User not found"); err != nil {
+		logging.FromContext(r.Context()).Error("template error", "error", err)
+	}
+
+	return nil, false
+}
+
+// AdminUserHandler shows an account along with all of its sessions.
+func AdminUserHandler(tpl adminUserTemplates, userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		target, ok := adminTarget(w, r, tpl, userService)
+		if !ok {
+			return
+		}
+
+		userSessions, err := userService.Sessions(target.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("admin sessions error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		message := adminActionMessages[AdminAction(r.URL.Query().Get("done"))]
+		if err = tpl.AdminUser(w, u, target, userSessions, message); err != nil {
+			logging.FromContext(r.Context()).Error("template error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
+
+// AdminUserActionHandler runs the action on the user matching the {id} path value, then goes back to the user page.
+// Permissions are checked by the router, administrators cannot run actions on their own account.
+// Every action is recorded in the audit log with the administrator as the actor.
+func AdminUserActionHandler(tpl adminUserTemplates, userService user.ServiceInterface, notifier passwordResetNotifier, recorder auditRecorder, action AdminAction) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		target, ok := adminTarget(w, r, tpl, userService)
+		if !ok {
+			return
+		}
+
+		if target.ID == admin.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			if err = tpl.Error(w, "Error 400", "You cannot run this action on your own account."); err != nil {
+				logging.FromContext(r.Context()).Error("template error", "error", err)
+			}
+			return
+		}
+
+		role := users.Role(r.PostFormValue("role"))
+
+		switch action {
+		case AdminActionDisable:
+			err = userService.DisableUser(target.ID)
+		case AdminActionEnable:
+			err = userService.EnableUser(target.ID)
+		case AdminActionRevokeSessions:
+			err = userService.RevokeAllSessions(target.ID)
+		case AdminActionUnlock:
+			err = userService.UnlockAccount(target.ID)
+		case AdminActionGrantRole:
+			err = userService.GrantRole(target.ID, role)
+		case AdminActionRevokeRole:
+			err = userService.RevokeRole(target.ID, role)
+		case AdminActionResetPassword:
+			var token string
+			if _, token, err = userService.ForcePasswordReset(target.ID); err == nil {
+				err = notifier.PasswordReset(target.Email, "/password/reset?token="+url.QueryEscape(token))
+			}
+		default:
+			err = errors.New("unknown action")
+		}
+
+		if errors.Is(err, user.ErrUnknownRole) || errors.Is(err, user.ErrConfiguredRole) {
+			description := "Unknown role."
+			if errors.Is(err, user.ErrConfiguredRole) {
+				description = "This role is granted by the admin emails setting, remove the email from it instead."
+			}
+
+			w.WriteHeader(http.StatusBadRequest)
+			if err = tpl.Error(w, "Error 400", description); err != nil {
+				logging.FromContext(r.Context()).Error("template error", "error", err)
+			}
+			return
+		}
+
+		if err != nil {
+			logging.FromContext(r.Context()).Error("admin action error", "error", err, "action", action, "admin_id", admin.ID, "user_id", target.ID)
+			w.WriteHeader(http.StatusInternalServerError)
+			if err = tpl.Error(w, "Error 500", "The action failed. Please try again."); err != nil {
+				logging.FromContext(r.Context()).Error("template error", "error", err)
+			}
+			return
+		}
+
+		event := newAuditEvent(r, adminActionEvents[action], admin.ID, target.ID)
+		event.Details = map[string]string{"email": target.Email}
+		if action == AdminActionGrantRole || action == AdminActionRevokeRole {
+			event.Details["role"] = string(role)
+		}
+		recordAudit(r, recorder, event)
+
+		http.Redirect(w, r, "/admin/users/"+target.ID.String()+"?done="+string(action), http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type adminUserTpl struct {
+	title string
+}
+
+func (a *adminUserTpl) AdminUser(writer io.Writer, u *users.User, target *users.User, userSessions []sessions.Session, message string) error {
+	return nil
+}
+
+func (a *adminUserTpl) Error(writer io.Writer, title, description string) error {
+	a.title = title
+	return nil
+}
+
+type passwordResetNotifierStub struct {
+	to, path string
+}
+
+func (p *passwordResetNotifierStub) PasswordReset(to, path string) error {
+	p.to = to
+	p.path = path
+	return nil
+}
+
+func TestAdminUserActionHandler(t *testing.T) {
+	userStore := users.NewUserMemoryStore()
+	sessionStore := sessions.NewSessionMemoryStore()
+	userService := user.NewService(userStore, sessionStore, tokens.NewTokenMemoryStore())
+	auditRing := audit.NewRing(10)
+	auditLog := audit.NewLogger(auditRing)
+	admin, err := userStore.Create(users.User{Email: "admin@example.com", Roles: []users.Role{users.RoleAdmin}})
+	if err != nil {
+		t.Fatalf("unexpected error while creating admin: %s", err)
+	}
+
+	target, err := userStore.Create(users.User{Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error while creating user: %s", err)
+	}
+
+	runWithForm := func(id uuid.UUID, action AdminAction, notifier *passwordResetNotifierStub, form url.Values) (*adminUserTpl, *http.Response) {
+		tpl := &adminUserTpl{}
+		request := newPostRequest("/admin/users/"+id.String()+"/"+string(action), form)
+		request.SetPathValue("id", id.String())
+		request = user.AugmentRequestWithAuth(request, admin, &sessions.Session{})
+		response := httptest.NewRecorder()
+		AdminUserActionHandler(tpl, userService, notifier, auditLog, action).ServeHTTP(response, request)
+		return tpl, response.Result()
+	}
+
+	run := func(id uuid.UUID, action AdminAction, notifier *passwordResetNotifierStub) (*adminUserTpl, *http.Response) {
+		return runWithForm(id, action, notifier, url.Values{})
+	}
+
+	t.Run("disable", func(t *testing.T) {
+		_, response := run(target.ID, AdminActionDisable, &passwordResetNotifierStub{})
+		if response.StatusCode != http.StatusSeeOther || response.Header.Get("Location") != "/admin/users/"+target.ID.String()+"?done=disable" {
+			t.Fatalf("unexpected response. status: %d. location: %q", response.StatusCode, response.Header.Get("Location"))
+		}
+
+		if u, _ := userStore.Get(target.ID); !u.Disabled() {
+			t.Errorf("user was not disabled")
+		}
+
+		events, _ := auditRing.Query(audit.Filter{Limit: 1})
+		if len(events) != 1 || events[0].Type != audit.EventAdminUserDisabled || events[0].ActorID != admin.ID || events[0].TargetID != target.ID {
+			t.Errorf("admin action was not recorded. got: %+v", events)
+		}
+	})
+
+	t.Run("enable", func(t *testing.T) {
+		run(target.ID, AdminActionEnable, &passwordResetNotifierStub{})
+		if u, _ := userStore.Get(target.ID); u.Disabled() {
+			t.Errorf("user is still disabled")
+		}
+	})
+
+	t.Run("reset password", func(t *testing.T) {
+		notifier := &passwordResetNotifierStub{}
+		run(target.ID, AdminActionResetPassword, notifier)
+
+		if notifier.to != target.Email || !strings.HasPrefix(notifier.path, "/password/reset?token=") {
+			t.Errorf("reset email was not sent. got: %+v", notifier)
+		}
+
+		if u, _ := userStore.Get(target.ID); !u.PasswordResetRequired {
+			t.Errorf("password reset was not required")
+		}
+	})
+
+	t.Run("unlock", func(t *testing.T) {
+		locked, _ := userStore.Get(target.ID)
+		until := time.Now().Add(time.Hour)
+		locked.LockedUntil = &until
+		locked.FailedLogins = 5
+		if _, err := userStore.Update(*locked); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, response := run(target.ID, AdminActionUnlock, &passwordResetNotifierStub{})
+		if response.StatusCode != http.StatusSeeOther {
+			t.Fatalf("unexpected status code. expected: %d. got: %d", http.StatusSeeOther, response.StatusCode)
+		}
+
+		if u, _ := userStore.Get(target.ID); u.LockedUntil != nil || u.FailedLogins != 0 {
+			t.Errorf("user is still locked. got: %+v", u)
+		}
+
+		events, _ := auditRing.Query(audit.Filter{Limit: 1})
+		if len(events) != 1 || events[0].Type != audit.EventAdminAccountUnlocked || events[0].ActorID != admin.ID || events[0].TargetID != target.ID {
+			t.Errorf("unlock was not recorded. got: %+v", events)
+		}
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		form := url.Values{"role": {string(users.RoleSupport)}}
+		if _, response := runWithForm(target.ID, AdminActionGrantRole, &passwordResetNotifierStub{}, form); response.StatusCode != http.StatusSeeOther {
+			t.Fatalf("unexpected status code. expected: %d. got: %d", http.StatusSeeOther, response.StatusCode)
+		}
+
+		if u, _ := userStore.Get(target.ID); !u.HasRole(users.RoleSupport) {
+			t.Errorf("role was not granted. got: %v", u.Roles)
+		}
+
+		events, _ := auditRing.Query(audit.Filter{Limit: 1})
+		if len(events) != 1 || events[0].Type != audit.EventAdminRoleGranted || events[0].Details["role"] != string(users.RoleSupport) {
+			t.Errorf("role grant was not recorded. got: %+v", events)
+		}
+
+		runWithForm(target.ID, AdminActionRevokeRole, &passwordResetNotifierStub{}, form)
+		if u, _ := userStore.Get(target.ID); u.HasRole(users.RoleSupport) {
+			t.Errorf("role was not revoked. got: %v", u.Roles)
+		}
+
+		tpl, response := runWithForm(target.ID, AdminActionGrantRole, &passwordResetNotifierStub{}, url.Values{"role": {"owner"}})
+		if response.StatusCode != http.StatusBadRequest || tpl.title != "Error 400" {
+			t.Errorf("unexpected response. status: %d. page: %q", response.StatusCode, tpl.title)
+		}
+	})
+
+	t.Run("own account", func(t *testing.T) {
+		tpl, response := run(admin.ID, AdminActionDisable, &passwordResetNotifierStub{})
+		if response.StatusCode != http.StatusBadRequest || tpl.title != "Error 400" {
+			t.Errorf("unexpected response. status: %d. page: %q", response.StatusCode, tpl.title)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		tpl, response := run(uuid.New(), AdminActionDisable, &passwordResetNotifierStub{})
+		if response.StatusCode != http.StatusNotFound || tpl.title != "Error 404" {
+			t.Errorf("unexpected response. status: %d. page: %q", response.StatusCode, tpl.title)
+		}
+	})
+}
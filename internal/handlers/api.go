@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// API error codes, clients should rely on them rather than on the messages
+const (
+	APIErrorInvalidRequest        = "invalid_request"
+	APIErrorValidation            = "validation_failed"
+	APIErrorInvalidCredentials    = "invalid_credentials"
+	APIErrorInvalidRefreshToken   = "invalid_refresh_token"
+	APIErrorSecondFactorRequired  = "second_factor_required"
+	APIErrorInvalidSecondFactor   = "invalid_second_factor"
+	APIErrorTooManyAttempts       = "too_many_attempts"
+	APIErrorUnauthorized          = "unauthorized"
+	APIErrorEmailNotConfirmed     = "email_not_confirmed"
+	APIErrorInsufficientScope     = "insufficient_scope"
+	APIErrorAccountDisabled       = "account_disabled"
+	APIErrorPasswordResetRequired = "password_reset_required"
+	APIErrorNotFound              = "not_found"
+	APIErrorInternal              = "internal_error"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields holds the validation errors per request field
+	Fields map[string][]string `json:"fields,omitempty"`
+	// Challenge is set along with second_factor_required, see /api/v1/login/verify
+	Challenge string `json:"challenge,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiUser struct {
+	ID               uuid.UUID    `json:"id"`
+	Email            string       `json:"email"`
+	EmailConfirmed   bool         `json:"email_confirmed"`
+	TwoFactorEnabled bool         `json:"two_factor_enabled"`
+	Roles            []users.Role `json:"roles"`
+	CreatedAt        time.Time    `json:"created_at"`
+}
+
+type apiTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	// User is only set on login
+	User *apiUser `json:"user,omitempty"`
+}
+
+type apiSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	Current   bool      `json:"current"`
+}
+
+type apiCredentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type apiLoginVerifyRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type apiRefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type apiChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func newAPIUser(u *users.User) apiUser {
+	return apiUser{
+		ID:               u.ID,
+		Email:            u.Email,
+		EmailConfirmed:   u.EmailConfirmed != nil,
+		TwoFactorEnabled: u.TwoFactorEnabled(),
+		Roles:            append([]users.Role{}, u.Roles...),
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+// APIError writes a typed JSON error body
+func APIError(writer http.ResponseWriter, request *http.Request, status int, code, message string) {
+	writeJSON(writer, request, status, apiErrorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+func apiValidationError(writer http.ResponseWriter, request *http.Request, fields map[string][]string) {
+	writeJSON(writer, request, http.StatusUnprocessableEntity, apiErrorResponse{Error: apiError{
+		Code:    APIErrorValidation,
+		Message: "some fields are invalid",
+		Fields:  fields,
+	}})
+}
+
+func apiInternalError(writer http.ResponseWriter, request *http.Request) {
+	APIError(writer, request, http.StatusInternalServerError, APIErrorInternal, "something went wrong. please try again")
+}
+
+// apiPasswordViolations returns the policy violations, or false when err is not a policy error
+func apiPasswordViolations(err error) ([]string, bool) {
+	var policyErr *validate.PolicyError
+	if !errors.As(err, &policyErr) {
+		return nil, false
+	}
+
+	var messages []string
+	for _, violation := range policyErr.Violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return messages, true
+}
+
+// newAPITokenResponse describes a token pair, along with the user it was issued to when u is set
+func newAPITokenResponse(pair *user.TokenPair, u *users.User) apiTokenResponse {
+	response := apiTokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(math.Ceil(time.Until(pair.ExpiresAt).Seconds())),
+		RefreshToken: pair.RefreshToken,
+	}
+
+	if u != nil {
+		apiU := newAPIUser(u)
+		response.User = &apiU
+	}
+
+	return response
+}
+
+// apiLoginResult answers the outcome of a login attempt, shared by the password and second factor steps
+func apiLoginResult(writer http.ResponseWriter, request *http.Request, userService user.ServiceInterface, u *users.User, s *sessions.Session, err error) {
+	var throttled user.ThrottledError
+	var secondFactor user.SecondFactorRequiredError
+
+	var pair *user.TokenPair
+	if err == nil {
+		if pair, err = userService.IssueTokens(s); err != nil {
+			logging.FromContext(request.Context()).Error("api login: token issuance error", "error", err)
+			apiInternalError(writer, request)
+			return
+		}
+	}
+
+	switch {
+	case err == nil:
+		writeJSON(writer, request, http.StatusOK, newAPITokenResponse(pair, u))
+	case errors.As(err, &secondFactor):
+		writeJSON(writer, request, http.StatusUnauthorized, apiErrorResponse{Error: apiError{
+			Code:      APIErrorSecondFactorRequired,
+			Message:   "a second factor is required, send it along with the challenge to /api/v1/login/verify",
+			Challenge: secondFactor.Challenge,
+		}})
+	case errors.As(err, &throttled):
+		logging.FromContext(request.Context()).Warn("api login throttled", "error", err)
+		writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		APIError(writer, request, http.StatusTooManyRequests, APIErrorTooManyAttempts,
+			"too many login attempts. please try again in "+formatRetryAfter(throttled.RetryAfter))
+	case errors.Is(err, user.ErrTooManyAttempts):
+		APIError(writer, request, http.StatusTooManyRequests, APIErrorTooManyAttempts, "too many login attempts. please try again later")
+	case errors.Is(err, user.ErrInvalidToken):
+		APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidCredentials, "the challenge is invalid or expired. please log in again")
+	case errors.Is(err, user.ErrInvalidSecondFactor):
+		APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidSecondFactor, "invalid code")
+	case errors.Is(err, user.ErrAccountDisabled):
+		APIError(writer, request, http.StatusForbidden, APIErrorAccountDisabled, errAccountDisabled.Error())
+	case errors.Is(err, user.ErrPasswordResetRequired):
+		APIError(writer, request, http.StatusForbidden, APIErrorPasswordResetRequired, "the password must be reset before logging in")
+	default:
+		logging.FromContext(request.Context()).Warn("api login error", "error", err)
+		APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidCredentials, "invalid credentials")
+	}
+}
+
+// APIRegisterHandler answers the same way whether the email is already used or not, like RegisterHandler.
+func APIRegisterHandler(userService user.ServiceInterface, notifier registrationNotifier, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body apiCredentialsRequest
+		if err := decodeJSON(writer, request, &body); err != nil {
+			APIError(writer, request, http.StatusBadRequest, APIErrorInvalidRequest, "invalid JSON body")
+			return
+		}
+
+		fields := map[string][]string{}
+		if validate.Email(body.Email) != nil {
+			fields["email"] = []string{"invalid email"}
+		}
+
+		_, err := userService.ValidatePassword(body.Password, body.Email)
+		if violations, ok := apiPasswordViolations(err); ok {
+			fields["password"] = violations
+		} else if err != nil {
+			logging.FromContext(request.Context()).Error("api register: password validation error", "error", err)
+			apiInternalError(writer, request)
+			return
+		}
+
+		if len(fields) > 0 {
+			apiValidationError(writer, request, fields)
+			return
+		}
+
+		u, err := userService.Register(body.Email, body.Password)
+		switch {
+		case err == nil:
+			recordAudit(request, recorder, newAuditEvent(request, audit.EventUserRegistered, u.ID, u.ID))
+			if err = sendEmailConfirmation(userService, notifier, u); err != nil {
+				logging.FromContext(request.Context()).Error("api register: email confirmation error", "error", err)
+			}
+		case errors.Is(err, users.ErrEmailAlreadyUsed):
+			if err = notifier.AccountExists(body.Email); err != nil {
+				logging.FromContext(request.Context()).Error("api register: account exists notification error", "error", err)
+			}
+		default:
+			if violations, ok := apiPasswordViolations(err); ok {
+				apiValidationError(writer, request, map[string][]string{"password": violations})
+				return
+			}
+
+			logging.FromContext(request.Context()).Error("api registration error", "error", err)
+			apiInternalError(writer, request)
+			return
+		}
+
+		writeJSON(writer, request, http.StatusAccepted, struct {
+			Message string `json:"message"`
+		}{Message: "check your inbox to confirm your email address"})
+	})
+}
+
+func APILoginHandler(userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body apiCredentialsRequest
+		if err := decodeJSON(writer, request, &body); err != nil {
+			APIError(writer, request, http.StatusBadRequest, APIErrorInvalidRequest, "invalid JSON body")
+			return
+		}
+
+		if body.Email == "" || body.Password == "" {
+			APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidCredentials, "invalid credentials")
+			return
+		}
+
+		u, s, err := userService.LoginWithCredentials(body.Email, body.Password, user.ClientIP(request))
+		recordLoginResult(recorder, request, "password", body.Email, u, s, err)
+		apiLoginResult(writer, request, userService, u, s, err)
+	})
+}
+
+// APILoginVerifyHandler is the second login step for accounts with two-factor authentication.
+func APILoginVerifyHandler(userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body apiLoginVerifyRequest
+		if err := decodeJSON(writer, request, &body); err != nil || body.Challenge == "" {
+			APIError(writer, request, http.StatusBadRequest, APIErrorInvalidRequest, "challenge and code are required")
+			return
+		}
+
+		u, s, err := userService.LoginWithSecondFactor(body.Challenge, body.Code, user.ClientIP(request))
+		recordLoginResult(recorder, request, "second_factor", "", u, s, err)
+		apiLoginResult(writer, request, userService, u, s, err)
+	})
+}
+
+// APIRefreshTokenHandler exchanges a refresh token for a new token pair, the refresh token cannot be used again.
+func APIRefreshTokenHandler(userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body apiRefreshTokenRequest
+		if err := decodeJSON(writer, request, &body); err != nil || body.RefreshToken == "" {
+			APIError(writer, request, http.StatusBadRequest, APIErrorInvalidRequest, "refresh_token is required")
+			return
+		}
+
+		pair, err := userService.RefreshTokens(body.RefreshToken)
+		switch {
+		case err == nil:
+			writeJSON(writer, request, http.StatusOK, newAPITokenResponse(pair, nil))
+		case errors.Is(err, user.ErrRefreshTokenReused):
+			APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidRefreshToken,
+				"the refresh token was already used, the session has been revoked. please log in again")
+		case errors.Is(err, user.ErrInvalidRefreshToken), errors.Is(err, user.ErrSessionExpired):
+			APIError(writer, request, http.StatusUnauthorized, APIErrorInvalidRefreshToken, "the refresh token is invalid or expired. please log in again")
+		default:
+			logging.FromContext(request.Context()).Error("api refresh token error", "error", err)
+			apiInternalError(writer, request)
+		}
+	})
+}
+
+func APIMeHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, err := user.RetrieveUser(request)
+		if err != nil {
+			APIError(writer, request, http.StatusUnauthorized, APIErrorUnauthorized, "authentication required")
+			return
+		}
+
+		writeJSON(writer, request, http.StatusOK, newAPIUser(u))
+	})
+}
+
+func APISessionsHandler(userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, err := user.RetrieveUser(request)
+		if err != nil {
+			APIError(writer, request, http.StatusUnauthorized, APIErrorUnauthorized, "authentication required")
+			return
+		}
+
+		// requests made with an API key have no current session
+		_, current, _ := user.RetrieveAuthDetails(request)
+
+		userSessions, err := userService.Sessions(u.ID)
+		if err != nil {
+			logging.FromContext(request.Context()).Error("api sessions error", "error", err)
+			apiInternalError(writer, request)
+			return
+		}
+
+		response := make([]apiSession, 0, len(userSessions))
+		for _, s := range userSessions {
+			response = append(response, apiSession{
+				ID:        s.ID,
+				CreatedAt: s.CreatedAt,
+				LastUsed:  s.LastUsed,
+				Current:   current != nil && s.ID == current.ID,
+			})
+		}
+
+		writeJSON(writer, request, http.StatusOK, response)
+	})
+}
+
+// APIRevokeSessionHandler deletes the session matching the {id} path value. Revoking the current session logs out.
+func APIRevokeSessionHandler(userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, err := user.RetrieveUser(request)
+		if err != nil {
+			APIError(writer, request, http.StatusUnauthorized, APIErrorUnauthorized, "authentication required")
+			return
+		}
+
+		id, err := uuid.Parse(request.PathValue("id"))
+		if err == nil {
+			err = userService.RevokeSession(u.ID, id)
+		}
+
+		switch {
+		case err == nil:
+			event := newAuditEvent(request, audit.EventSessionRevoked, u.ID, u.ID)
+			event.Details = map[string]string{"session_id": id.String(), "reason": "revoked"}
+			recordAudit(request, recorder, event)
+			writer.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, sessions.ErrSessionNotFound), id == uuid.Nil:
+			APIError(writer, request, http.StatusNotFound, APIErrorNotFound, "session not found")
+		default:
+			logging.FromContext(request.Context()).Error("api revoke session error", "error", err)
+			apiInternalError(writer, request)
+		}
+	})
+}
+
+// APIChangePasswordHandler requires the current password. Other sessions are revoked.
+// Wrong current passwords count towards the login throttle and end up answered with 429.
+func APIChangePasswordHandler(userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, current, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			APIError(writer, request, http.StatusUnauthorized, APIErrorUnauthorized, "authentication required")
+			return
+		}
+
+		var body apiChangePasswordRequest
+		if err = decodeJSON(writer, request, &body); err != nil {
+			APIError(writer, request, http.StatusBadRequest, APIErrorInvalidRequest, "invalid JSON body")
+			return
+		}
+
+		err = userService.ChangePassword(u.ID, current.ID, body.CurrentPassword, body.NewPassword)
+		if violations, ok := apiPasswordViolations(err); ok {
+			apiValidationError(writer, request, map[string][]string{"new_password": violations})
+			return
+		}
+
+		var throttled user.ThrottledError
+		switch {
+		case err == nil:
+			recordAudit(request, recorder, newAuditEvent(request, audit.EventPasswordChanged, u.ID, u.ID))
+			writer.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, user.ErrInvalidCredentials):
+			apiValidationError(writer, request, map[string][]string{"current_password": {"invalid password"}})
+		case errors.As(err, &throttled):
+			logging.FromContext(request.Context()).Warn("api change password throttled", "error", err)
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			APIError(writer, request, http.StatusTooManyRequests, APIErrorTooManyAttempts,
+				"too many attempts. please try again in "+formatRetryAfter(throttled.RetryAfter))
+		default:
+			logging.FromContext(request.Context()).Error("api change password error", "error", err)
+			apiInternalError(writer, request)
+		}
+	})
+}
+
+// JWKSHandler publishes the keys access tokens are signed with so other services can verify them.
+func JWKSHandler(userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(writer).Encode(userService.JWKS()); err != nil {
+			logging.FromContext(request.Context()).Error("jwks encoding error", "error", err)
+		}
+	})
+}
+
+// APINotFoundHandler answers unknown API routes with a JSON body instead of the HTML error page
+func APINotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		APIError(writer, request, http.StatusNotFound, APIErrorNotFound, "not found")
+	})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/passwords"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type apiLoginUserService struct {
+	user.ServiceInterface
+	err error
+}
+
+func (a apiLoginUserService) LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error) {
+	if a.err != nil {
+		return nil, nil, a.err
+	}
+
+	return &users.User{Email: email}, &sessions.Session{Token: "session"}, nil
+}
+
+func (a apiLoginUserService) IssueTokens(session *sessions.Session) (*user.TokenPair, error) {
+	return &user.TokenPair{AccessToken: "access", ExpiresAt: time.Now().Add(time.Minute), RefreshToken: "refresh"}, nil
+}
+
+func TestAPILoginHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		retryAfter string
+		events     []audit.EventType
+	}{
+		{"success", nil, http.StatusOK, "", "", []audit.EventType{audit.EventSessionCreated, audit.EventLoginSucceeded}},
+		{"invalid credentials", user.ErrInvalidCredentials, http.StatusUnauthorized, APIErrorInvalidCredentials, "", []audit.EventType{audit.EventLoginFailed}},
+		{"second factor", user.SecondFactorRequiredError{Challenge: "challenge"}, http.StatusUnauthorized, APIErrorSecondFactorRequired, "", nil},
+		{"throttled", user.ThrottledError{RetryAfter: time.Second * 90}, http.StatusTooManyRequests, APIErrorTooManyAttempts, "90", []audit.EventType{audit.EventLoginFailed}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := newJSONRequest(t, "/api/v1/login", apiCredentialsRequest{Email: "test@example.com", Password: "password"})
+			response := httptest.NewRecorder()
+			auditRing := audit.NewRing(10)
+			APILoginHandler(apiLoginUserService{err: tc.err}, audit.NewLogger(auditRing)).ServeHTTP(response, request)
+
+			asserts.StatusCode(t, response, tc.status)
+			asserts.JSONContentType(t, response)
+
+			if retryAfter := response.Header().Get("Retry-After"); retryAfter != tc.retryAfter {
+				t.Errorf("unexpected Retry-After. expected: %q. got: %q", tc.retryAfter, retryAfter)
+			}
+
+			var body struct {
+				AccessToken  string   `json:"access_token"`
+				RefreshToken string   `json:"refresh_token"`
+				ExpiresIn    int      `json:"expires_in"`
+				Error        apiError `json:"error"`
+			}
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON response: %s", err)
+			}
+
+			if body.Error.Code != tc.code {
+				t.Errorf("unexpected error code. expected: %q. got: %q", tc.code, body.Error.Code)
+			}
+
+			if tc.err == nil && (body.AccessToken != "access" || body.RefreshToken != "refresh" || body.ExpiresIn != 60) {
+				t.Errorf("unexpected tokens: %+v", body)
+			}
+
+			if tc.code == APIErrorSecondFactorRequired && body.Error.Challenge != "challenge" {
+				t.Errorf("challenge missing from the error body")
+			}
+
+			events, _ := auditRing.Query(audit.Filter{})
+			var eventTypes []audit.EventType
+			for _, event := range events {
+				eventTypes = append(eventTypes, event.Type)
+			}
+
+			if !slices.Equal(eventTypes, tc.events) {
+				t.Errorf("unexpected audit events. expected: %v. got: %v", tc.events, eventTypes)
+			}
+		})
+	}
+}
+
+type apiRefreshUserService struct {
+	user.ServiceInterface
+}
+
+func (a apiRefreshUserService) RefreshTokens(refreshToken string) (*user.TokenPair, error) {
+	switch refreshToken {
+	case "valid":
+		return &user.TokenPair{AccessToken: "access", ExpiresAt: time.Now().Add(time.Minute), RefreshToken: "next"}, nil
+	case "reused":
+		return nil, user.ErrRefreshTokenReused
+	}
+
+	return nil, user.ErrInvalidRefreshToken
+}
+
+func TestAPIRefreshTokenHandler(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		status int
+		code   string
+	}{
+		{"valid", "valid", http.StatusOK, ""},
+		{"missing", "", http.StatusBadRequest, APIErrorInvalidRequest},
+		{"reused", "reused", http.StatusUnauthorized, APIErrorInvalidRefreshToken},
+		{"unknown", "unknown", http.StatusUnauthorized, APIErrorInvalidRefreshToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := newJSONRequest(t, "/api/v1/token/refresh", apiRefreshTokenRequest{RefreshToken: tc.token})
+			response := httptest.NewRecorder()
+			APIRefreshTokenHandler(apiRefreshUserService{}).ServeHTTP(response, request)
+
+			asserts.StatusCode(t, response, tc.status)
+
+			var body struct {
+				RefreshToken string   `json:"refresh_token"`
+				User         *apiUser `json:"user"`
+				Error        apiError `json:"error"`
+			}
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON response: %s", err)
+			}
+
+			if body.Error.Code != tc.code {
+				t.Errorf("unexpected error code. expected: %q. got: %q", tc.code, body.Error.Code)
+			}
+
+			if tc.code == "" && (body.RefreshToken != "next" || body.User != nil) {
+				t.Errorf("unexpected body: %+v", body)
+			}
+		})
+	}
+}
+
+type jwksUserService struct {
+	user.ServiceInterface
+}
+
+func (j jwksUserService) JWKS() jwt.JWKS {
+	return jwt.JWKS{Keys: []jwt.JWK{{KeyType: "OKP", Curve: "Ed25519", X: "x", KeyID: "key", Algorithm: jwt.AlgorithmEdDSA, Use: "sig"}}}
+}
+
+func TestAPIChangePasswordHandler(t *testing.T) {
+	policy := user.LoginThrottlePolicy{Window: time.Minute, MaxAccountFailures: 3, MaxIPFailures: 5, BaseLockout: time.Minute, MaxLockout: time.Hour}
+	userService := user.NewService(users.NewUserMemoryStore(), sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		user.WithLoginThrottlePolicy(policy), user.WithPasswordHasher(passwords.NewManager(passwords.Bcrypt{Cost: bcrypt.MinCost})))
+	u, err := userService.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	changePassword := func(current string) *httptest.ResponseRecorder {
+		body := apiChangePasswordRequest{CurrentPassword: current, NewPassword: "another battery staple"}
+		request := user.AugmentRequestWithAuth(newJSONRequest(t, "/api/v1/me/password", body), u, &sessions.Session{})
+		response := httptest.NewRecorder()
+		APIChangePasswordHandler(userService, audit.NewLogger(audit.NewRing(10))).ServeHTTP(response, request)
+		return response
+	}
+
+	for i := 0; i < policy.MaxAccountFailures; i++ {
+		if response := changePassword("wrong"); response.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("attempt %d: unexpected status code. expected: %d. got: %d", i, http.StatusUnprocessableEntity, response.Code)
+		}
+	}
+
+	// the right password is rejected too until the lockout expires
+	response := changePassword("correct horse battery")
+	asserts.StatusCode(t, response, http.StatusTooManyRequests)
+
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter == "" {
+		t.Errorf("Retry-After header missing")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	response := httptest.NewRecorder()
+	JWKSHandler(jwksUserService{}).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	asserts.StatusCode(t, response, http.StatusOK)
+	asserts.JSONContentType(t, response)
+
+	if cacheControl := response.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "public") {
+		t.Errorf("the key set should be cacheable. got: %q", cacheControl)
+	}
+
+	var body jwt.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON response: %s", err)
+	}
+
+	if len(body.Keys) != 1 || body.Keys[0].KeyID != "key" {
+		t.Errorf("unexpected key set: %+v", body)
+	}
+}
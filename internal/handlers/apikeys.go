@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type apiKeysTemplate interface {
+	APIKeys(writer io.Writer, u *users.User, keys []apikeys.APIKey, scopes []apikey.Scope, created string, err error) error
+}
+
+// APIKeysHandler lists the API keys of the current user. POST requests carry an action: create or revoke.
+// A created key is shown once, it cannot be retrieved afterward.
+func APIKeysHandler(tpl apiKeysTemplate, apiKeyService apikey.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			http.Redirect(writer, request, "/login", http.StatusFound)
+			return
+		}
+
+		var created string
+		var actionErr error
+
+		if request.Method == http.MethodPost {
+			switch request.PostFormValue("action") {
+			case "create":
+				var expiresAt *time.Time
+				if days, parseErr := strconv.Atoi(request.PostFormValue("expires_in_days")); parseErr == nil && days > 0 {
+					expiry := time.Now().AddDate(0, 0, days)
+					expiresAt = &expiry
+				}
+
+				_, created, err = apiKeyService.Create(u.ID, request.PostFormValue("name"), request.PostForm["scopes"], expiresAt)
+			case "revoke":
+				var id uuid.UUID
+				if id, err = uuid.Parse(request.PostFormValue("id")); err == nil {
+					err = apiKeyService.Revoke(u.ID, id)
+				}
+
+				if err == nil {
+					http.Redirect(writer, request, "/account/api-keys", http.StatusSeeOther)
+					return
+				}
+			default:
+				err = errors.New("unknown action")
+			}
+
+			switch {
+			case err == nil:
+			case errors.Is(err, apikey.ErrMissingScope), errors.Is(err, apikey.ErrUnknownScope):
+				actionErr = errors.New("select at least one of the listed permissions")
+			default:
+				logging.FromContext(request.Context()).Error("api key error", "error", err)
+				actionErr = errors.New("something went wrong. please try again")
+			}
+		}
+
+		keys, err := apiKeyService.Keys(u.ID)
+		if err != nil {
+			logging.FromContext(request.Context()).Error("api key list error", "error", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if created != "" {
+			writer.Header().Set("Cache-Control", "no-store")
+		}
+
+		if err = tpl.APIKeys(writer, u, keys, apikey.Scopes, created, actionErr); err != nil {
+			logging.FromContext(request.Context()).Error("template error", "error", err)
+		}
+	})
+}
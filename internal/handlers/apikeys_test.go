@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type apiKeysTpl struct {
+	keys    []apikeys.APIKey
+	created string
+	err     error
+}
+
+func (a *apiKeysTpl) APIKeys(writer io.Writer, u *users.User, keys []apikeys.APIKey, scopes []apikey.Scope, created string, err error) error {
+	a.keys = keys
+	a.created = created
+	a.err = err
+	return nil
+}
+
+func TestAPIKeysHandler(t *testing.T) {
+	u := &users.User{ID: uuid.New(), Email: "test@example.com"}
+	apiKeyService := apikey.NewService(apikeys.NewAPIKeyMemoryStore(), users.NewUserMemoryStore())
+
+	post := func(values url.Values) (*apiKeysTpl, *http.Response) {
+		tpl := &apiKeysTpl{}
+		request := user.AugmentRequestWithAuth(newPostRequest("/account/api-keys", values), u, &sessions.Session{})
+		response := httptest.NewRecorder()
+		APIKeysHandler(tpl, apiKeyService).ServeHTTP(response, request)
+		return tpl, response.Result()
+	}
+
+	t.Run("create", func(t *testing.T) {
+		tpl, response := post(url.Values{"action": {"create"}, "name": {"CI"}, "scopes": {apikey.ScopeProfileRead, apikey.ScopeSessionsRead}, "expires_in_days": {"30"}})
+
+		if response.StatusCode != http.StatusOK || tpl.err != nil || tpl.created == "" {
+			t.Fatalf("unexpected result. status: %d. error: %v", response.StatusCode, tpl.err)
+		}
+
+		if cacheControl := response.Header.Get("Cache-Control"); cacheControl != "no-store" {
+			t.Errorf("the page showing the key should not be cached. got: %q", cacheControl)
+		}
+
+		if len(tpl.keys) != 1 || len(tpl.keys[0].Scopes) != 2 || tpl.keys[0].ExpiresAt == nil {
+			t.Errorf("unexpected keys: %v", tpl.keys)
+		}
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		tpl, _ := post(url.Values{"action": {"create"}, "name": {"CI"}})
+		if tpl.err == nil || tpl.created != "" {
+			t.Errorf("a key without scopes should not be created")
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		keys, _ := apiKeyService.Keys(u.ID)
+		_, response := post(url.Values{"action": {"revoke"}, "id": {keys[0].ID.String()}})
+
+		if response.StatusCode != http.StatusSeeOther {
+			t.Errorf("unexpected status: %d", response.StatusCode)
+		}
+
+		if keys, _ = apiKeyService.Keys(u.ID); len(keys) != 0 {
+			t.Errorf("key was not revoked")
+		}
+	})
+}
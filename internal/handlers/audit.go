@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+)
+
+const securityActivityLimit = 100
+
+type auditRecorder interface {
+	Record(event audit.Event) error
+}
+
+type auditQuerier interface {
+	Query(filter audit.Filter) ([]audit.Event, error)
+}
+
+type securityActivityTemplate interface {
+	SecurityActivity(writer io.Writer, u *users.User, events []audit.Event) error
+}
+
+func newAuditEvent(request *http.Request, eventType audit.EventType, actorID, targetID uuid.UUID) audit.Event {
+	return audit.Event{
+		Type:      eventType,
+		ActorID:   actorID,
+		TargetID:  targetID,
+		IP:        user.ClientIP(request),
+		UserAgent: request.UserAgent(),
+	}
+}
+
+// recordAudit only logs failures, a sink being down should not prevent users from signing in
+func recordAudit(request *http.Request, recorder auditRecorder, event audit.Event) {
+	if err := recorder.Record(event); err != nil {
+		logging.FromContext(request.Context()).Error("audit error", "error", err)
+	}
+}
+
+// loginFailureReason names the reason a sign-in failed in audit events
+func loginFailureReason(err error) string {
+	var throttled user.ThrottledError
+	switch {
+	case errors.As(err, &throttled), errors.Is(err, user.ErrTooManyAttempts):
+		return "throttled"
+	case errors.Is(err, user.ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, user.ErrPasswordResetRequired):
+		return "password_reset_required"
+	case errors.Is(err, user.ErrInvalidSecondFactor):
+		return "invalid_second_factor"
+	case errors.Is(err, user.ErrInvalidToken):
+		return "invalid_challenge"
+	}
+
+	return "invalid_credentials"
+}
+
+// recordLoginResult records a sign-in along with the session it started, or its failure.
+// Failures target the matched account, if any, so they show up in its security activity.
+// Asking for a second factor is neither, the second step records the outcome.
+func recordLoginResult(recorder auditRecorder, request *http.Request, method, email string, u *users.User, s *sessions.Session, err error) {
+	var secondFactor user.SecondFactorRequiredError
+	if errors.As(err, &secondFactor) {
+		return
+	}
+
+	userID := uuid.Nil
+	if u != nil {
+		userID = u.ID
+	}
+
+	if err != nil {
+		// whoever failed to sign in is not authenticated, the account is only the target
+		event := newAuditEvent(request, audit.EventLoginFailed, uuid.Nil, userID)
+		event.Details = map[string]string{"method": method, "reason": loginFailureReason(err)}
+		if email != "" {
+			event.Details["email"] = email
+		}
+
+		recordAudit(request, recorder, event)
+		return
+	}
+
+	event := newAuditEvent(request, audit.EventLoginSucceeded, userID, userID)
+	event.Details = map[string]string{"method": method}
+	recordAudit(request, recorder, event)
+
+	event = newAuditEvent(request, audit.EventSessionCreated, userID, userID)
+	event.Details = map[string]string{"session_id": s.ID.String()}
+	recordAudit(request, recorder, event)
+}
+
+// SecurityActivityHandler lists the latest audit events involving the current user.
+func SecurityActivityHandler(tpl securityActivityTemplate, auditLog auditQuerier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		events, err := auditLog.Query(audit.Filter{UserID: u.ID, Limit: securityActivityLimit})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("security activity error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err = tpl.SecurityActivity(w, u, events); err != nil {
+			logging.FromContext(r.Context()).Error("template error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
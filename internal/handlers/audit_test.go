@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type failedLoginUserService struct {
+	user.ServiceInterface
+	target *users.User
+}
+
+func (f failedLoginUserService) LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error) {
+	return f.target, nil, user.ErrInvalidCredentials
+}
+
+type securityActivityTpl struct {
+	events []audit.Event
+}
+
+func (s *securityActivityTpl) SecurityActivity(writer io.Writer, u *users.User, events []audit.Event) error {
+	s.events = events
+	return nil
+}
+
+func TestSecurityActivityHandler(t *testing.T) {
+	u := &users.User{ID: uuid.New(), Email: "test@example.com"}
+	auditLog := audit.NewLogger(audit.NewRing(10))
+	for _, event := range []audit.Event{
+		{Type: audit.EventLoginSucceeded, ActorID: u.ID, TargetID: u.ID},
+		{Type: audit.EventLoginSucceeded, ActorID: uuid.New()},
+		{Type: audit.EventAdminUserDisabled, ActorID: uuid.New(), TargetID: u.ID},
+	} {
+		if err := auditLog.Record(event); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	tpl := &securityActivityTpl{}
+	request := user.AugmentRequestWithAuth(httptest.NewRequest(http.MethodGet, "/account/activity", nil), u, &sessions.Session{})
+	response := httptest.NewRecorder()
+	SecurityActivityHandler(tpl, auditLog).ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status. got: %d", response.Code)
+	}
+
+	if len(tpl.events) != 2 || tpl.events[0].Type != audit.EventAdminUserDisabled {
+		t.Errorf("unexpected events. got: %+v", tpl.events)
+	}
+}
+
+func TestLoginHandlerFailureShowsInTargetActivity(t *testing.T) {
+	victim := &users.User{ID: uuid.New(), Email: "victim@example.com"}
+	auditLog := audit.NewLogger(audit.NewRing(10))
+
+	request := newPostRequest("/login", url.Values{"email": {victim.Email}, "password": {"wrong"}})
+	LoginHandler(&loginVerifyTpl{}, failedLoginUserService{target: victim}, user.DefaultCookiePolicy, auditLog).ServeHTTP(httptest.NewRecorder(), request)
+
+	tpl := &securityActivityTpl{}
+	request = user.AugmentRequestWithAuth(httptest.NewRequest(http.MethodGet, "/account/activity", nil), victim, &sessions.Session{})
+	SecurityActivityHandler(tpl, auditLog).ServeHTTP(httptest.NewRecorder(), request)
+
+	if len(tpl.events) != 1 || tpl.events[0].Type != audit.EventLoginFailed {
+		t.Fatalf("failed login should show in the activity of its target. got: %+v", tpl.events)
+	}
+
+	if event := tpl.events[0]; event.TargetID != victim.ID || event.ActorID != uuid.Nil {
+		t.Errorf("unexpected actor and target. got: %s, %s", event.ActorID, event.TargetID)
+	}
+}
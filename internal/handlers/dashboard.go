@@ -1,11 +1,11 @@
 package handlers
 
 import (
+	"github.com/mathieuhays/auth/internal/logging"
 	"github.com/mathieuhays/auth/internal/services/user"
 	"github.com/mathieuhays/auth/internal/stores/sessions"
 	"github.com/mathieuhays/auth/internal/stores/users"
 	"io"
-	"log"
 	"net/http"
 )
 
@@ -22,7 +22,7 @@ func DashboardHandler(tpl dashboardTemplates) http.Handler {
 		}
 
 		if err := tpl.Dashboard(w, u, session); err != nil {
-			log.Printf("template error: %s", err)
+			logging.FromContext(r.Context()).Error("template error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type confirmEmailTemplate interface {
+	ConfirmEmail(writer io.Writer, confirmed bool) error
+}
+
+type confirmEmailPendingTemplate interface {
+	ConfirmEmailPending(writer io.Writer, u *users.User, sent bool, err error) error
+}
+
+type emailConfirmationNotifier interface {
+	EmailConfirmation(to, path string) error
+}
+
+func sendEmailConfirmation(userService user.ServiceInterface, notifier emailConfirmationNotifier, u *users.User) error {
+	token, err := userService.RequestEmailConfirmation(u)
+	if err != nil {
+		return err
+	}
+
+	return notifier.EmailConfirmation(u.Email, "/confirm-email?token="+url.QueryEscape(token))
+}
+
+func ConfirmEmailHandler(tpl confirmEmailTemplate, userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := userService.ConfirmEmail(r.URL.Query().Get("token"))
+		if err != nil && !errors.Is(err, user.ErrInvalidToken) {
+			logging.FromContext(r.Context()).Error("email confirmation error", "error", err)
+		}
+
+		if err := tpl.ConfirmEmail(w, err == nil); err != nil {
+			logging.FromContext(r.Context()).Error("template error", "error", err)
+		}
+	})
+}
+
+// ConfirmEmailPendingHandler shows the "please verify" page and resends the confirmation email on POST.
+func ConfirmEmailPendingHandler(tpl confirmEmailPendingTemplate, userService user.ServiceInterface, notifier emailConfirmationNotifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		if u.EmailConfirmed != nil {
+			http.Redirect(w, r, "/dashboard", http.StatusFound)
+			return
+		}
+
+		sent := false
+		var resendErr error
+
+		if r.Method == http.MethodPost {
+			err = sendEmailConfirmation(userService, notifier, u)
+			switch {
+			case err == nil:
+				sent = true
+			case errors.Is(err, user.ErrConfirmationThrottled):
+				w.WriteHeader(http.StatusTooManyRequests)
+				resendErr = fmt.Errorf("a confirmation email was sent recently. please wait a minute before trying again")
+			default:
+				logging.FromContext(r.Context()).Error("email confirmation resend error", "error", err)
+				resendErr = fmt.Errorf("something went wrong. please try again")
+			}
+		}
+
+		if err = tpl.ConfirmEmailPending(w, u, sent, resendErr); err != nil {
+			logging.FromContext(r.Context()).Error("template error", "error", err)
+		}
+	})
+}
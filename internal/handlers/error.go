@@ -1,8 +1,8 @@
 package handlers
 
 import (
+	"github.com/mathieuhays/auth/internal/logging"
 	"io"
-	"log"
 	"net/http"
 )
 
@@ -15,7 +15,7 @@ func ErrorHandler(tpl errorTemplates) http.Handler {
 		w.WriteHeader(http.StatusNotFound)
 
 		if err := tpl.Error(w, "Error 404", "Page not found"); err != nil {
-			log.Printf("template error: %s", err)
+			logging.FromContext(r.Context()).Error("template error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	})
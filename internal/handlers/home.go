@@ -1,8 +1,8 @@
 package handlers
 
 import (
+	"github.com/mathieuhays/auth/internal/logging"
 	"io"
-	"log"
 	"net/http"
 )
 
@@ -13,7 +13,7 @@ type homeTemplates interface {
 func HomeHandler(tpl homeTemplates) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := tpl.Index(w); err != nil {
-			log.Printf("template error: %s", err)
+			logging.FromContext(r.Context()).Error("template error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/mathieuhays/auth/internal/logging"
+	"net/http"
+)
+
+// maxJSONRequestSize bounds the JSON request bodies
+const maxJSONRequestSize = 64 << 10
+
+// writeJSON answers with value, encoding errors are logged with the request
+func writeJSON(writer http.ResponseWriter, request *http.Request, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		logging.FromContext(request.Context()).Error("json encoding error", "error", err)
+	}
+}
+
+func decodeJSON(writer http.ResponseWriter, request *http.Request, value any) error {
+	return json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxJSONRequestSize)).Decode(value)
+}
@@ -1,20 +1,24 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/logging"
 	"github.com/mathieuhays/auth/internal/services/user"
 	"github.com/mathieuhays/auth/internal/validate"
 	"io"
-	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type loginTemplate interface {
 	Login(writer io.Writer, form *forms.Form) error
 }
 
-func LoginHandler(tpl loginTemplate, userService user.ServiceInterface) http.Handler {
+func LoginHandler(tpl loginTemplate, userService user.ServiceInterface, cookies user.CookiePolicy, recorder auditRecorder) http.Handler {
 
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		emailField := forms.Field{
@@ -46,27 +50,55 @@ func LoginHandler(tpl loginTemplate, userService user.ServiceInterface) http.Han
 			loginForm.Validate()
 
 			if !loginForm.HasErrors() {
-				_, s, err := userService.LoginWithCredentials(
-					loginForm.Fields["email"].Value,
-					loginForm.Fields["password"].Value)
-				if err == nil {
+				email := loginForm.Fields["email"].Value
+				u, s, err := userService.LoginWithCredentials(email, loginForm.Fields["password"].Value, user.ClientIP(request))
+				recordLoginResult(recorder, request, "password", email, u, s, err)
+
+				var throttled user.ThrottledError
+				var secondFactor user.SecondFactorRequiredError
+				if errors.As(err, &secondFactor) {
+					setLoginChallenge(writer, cookies, secondFactor.Challenge)
+					http.Redirect(writer, request, "/login/verify", http.StatusFound)
+					return
+				} else if errors.As(err, &throttled) {
+					logging.FromContext(request.Context()).Warn("login throttled", "error", err)
+					retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+					writer.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+					writer.WriteHeader(http.StatusTooManyRequests)
+					loginForm.Error = fmt.Errorf("too many login attempts. please try again in %s", formatRetryAfter(throttled.RetryAfter))
+				} else if errors.Is(err, user.ErrAccountDisabled) {
+					loginForm.Error = errAccountDisabled
+				} else if errors.Is(err, user.ErrPasswordResetRequired) {
+					loginForm.Error = fmt.Errorf("your password must be reset before you can log in. check your inbox or request a new reset link")
+				} else if err == nil {
 					err2 := userService.SetAuthResponse(writer, s)
 					if err2 == nil {
 						http.Redirect(writer, request, "/dashboard", http.StatusFound)
 						return
 					}
 
-					log.Printf("login: setAuthResponse error: %s", err2)
+					logging.FromContext(request.Context()).Error("login: setAuthResponse error", "error", err2)
 					loginForm.Error = fmt.Errorf("something went wrong. please try agin")
 				} else {
-					log.Printf("login error: %s", err)
+					logging.FromContext(request.Context()).Warn("login error", "error", err)
 					loginForm.Error = fmt.Errorf("invalid credentials")
 				}
 			}
 		}
 
 		if err := tpl.Login(writer, loginForm); err != nil {
-			log.Printf("login error: %s", err)
+			logging.FromContext(request.Context()).Warn("login error", "error", err)
 		}
 	})
 }
+
+// errAccountDisabled is shown to users an administrator disabled, whatever the way they sign in
+var errAccountDisabled = errors.New("this account has been disabled. please contact an administrator")
+
+func formatRetryAfter(retryAfter time.Duration) string {
+	if retryAfter <= time.Minute {
+		return "a minute"
+	}
+
+	return fmt.Sprintf("%d minutes", int(math.Ceil(retryAfter.Minutes())))
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"net/http"
+)
+
+func LogoutHandler(userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, session, _ := userService.RetrieveAuthFromRequest(r)
+
+		if err := userService.Logout(w, session); err != nil {
+			logging.FromContext(r.Context()).Error("logout error", "error", err)
+		} else if session != nil {
+			event := newAuditEvent(r, audit.EventSessionRevoked, session.UserID, session.UserID)
+			event.Details = map[string]string{"session_id": session.ID.String(), "reason": "logout"}
+			recordAudit(r, recorder, event)
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+}
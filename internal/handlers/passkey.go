@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/passkey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type passkeysTemplate interface {
+	Passkeys(writer io.Writer, u *users.User, passkeys []credentials.Credential, err error) error
+}
+
+type passkeyBeginResponse struct {
+	Ceremony  string `json:"ceremony"`
+	PublicKey any    `json:"publicKey"`
+}
+
+type passkeyRegistrationRequest struct {
+	Ceremony   string                        `json:"ceremony"`
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+type passkeyLoginRequest struct {
+	Ceremony   string                     `json:"ceremony"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// passkeyRedirectResponse tells the script where to go once the ceremony succeeded
+type passkeyRedirectResponse struct {
+	Redirect string `json:"redirect"`
+}
+
+type passkeyErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// PasskeysHandler lists the passkeys of the current user. POST requests delete the passkey matching id.
+func PasskeysHandler(tpl passkeysTemplate, passkeyService passkey.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			http.Redirect(writer, request, "/login", http.StatusFound)
+			return
+		}
+
+		var actionErr error
+
+		if request.Method == http.MethodPost {
+			id, err := uuid.Parse(request.PostFormValue("id"))
+			if err == nil {
+				err = passkeyService.DeleteCredential(u.ID, id)
+			}
+
+			if err == nil {
+				http.Redirect(writer, request, "/account/passkeys", http.StatusSeeOther)
+				return
+			}
+
+			logging.FromContext(request.Context()).Error("passkey deletion error", "error", err)
+			actionErr = errors.New("the passkey could not be removed. please try again")
+		}
+
+		passkeys, err := passkeyService.Credentials(u.ID)
+		if err != nil {
+			logging.FromContext(request.Context()).Error("passkey list error", "error", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err = tpl.Passkeys(writer, u, passkeys, actionErr); err != nil {
+			logging.FromContext(request.Context()).Error("template error", "error", err)
+		}
+	})
+}
+
+// PasskeyRegistrationBeginHandler returns the options for navigator.credentials.create()
+func PasskeyRegistrationBeginHandler(passkeyService passkey.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			writeJSON(writer, request, http.StatusUnauthorized, passkeyErrorResponse{Error: "not logged in"})
+			return
+		}
+
+		ceremony, options, err := passkeyService.BeginRegistration(u)
+		if err != nil {
+			logging.FromContext(request.Context()).Error("passkey registration error", "error", err)
+			writeJSON(writer, request, http.StatusInternalServerError, passkeyErrorResponse{Error: "something went wrong. please try again"})
+			return
+		}
+
+		writeJSON(writer, request, http.StatusOK, passkeyBeginResponse{Ceremony: ceremony, PublicKey: options})
+	})
+}
+
+func PasskeyRegistrationFinishHandler(passkeyService passkey.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			writeJSON(writer, request, http.StatusUnauthorized, passkeyErrorResponse{Error: "not logged in"})
+			return
+		}
+
+		var body passkeyRegistrationRequest
+		if err = decodeJSON(writer, request, &body); err != nil {
+			writeJSON(writer, request, http.StatusBadRequest, passkeyErrorResponse{Error: "invalid request"})
+			return
+		}
+
+		if _, err = passkeyService.FinishRegistration(u, body.Ceremony, body.Name, body.Credential); err != nil {
+			logging.FromContext(request.Context()).Error("passkey registration error", "error", err)
+
+			if errors.Is(err, credentials.ErrCredentialIDAlreadyExists) {
+				writeJSON(writer, request, http.StatusConflict, passkeyErrorResponse{Error: "this passkey is already registered"})
+				return
+			}
+
+			writeJSON(writer, request, http.StatusBadRequest, passkeyErrorResponse{Error: "the passkey could not be verified. please try again"})
+			return
+		}
+
+		writeJSON(writer, request, http.StatusOK, passkeyRedirectResponse{Redirect: "/account/passkeys"})
+	})
+}
+
+// PasskeyLoginBeginHandler returns the options for navigator.credentials.get()
+func PasskeyLoginBeginHandler(passkeyService passkey.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ceremony, options, err := passkeyService.BeginLogin(user.ClientIP(request))
+		var throttled user.ThrottledError
+		if errors.As(err, &throttled) {
+			logging.FromContext(request.Context()).Warn("passkey login throttled", "error", err)
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			writeJSON(writer, request, http.StatusTooManyRequests, passkeyErrorResponse{
+				Error: "too many login attempts. please try again in " + formatRetryAfter(throttled.RetryAfter),
+			})
+			return
+		}
+
+		if err != nil {
+			logging.FromContext(request.Context()).Warn("passkey login error", "error", err)
+			writeJSON(writer, request, http.StatusInternalServerError, passkeyErrorResponse{Error: "something went wrong. please try again"})
+			return
+		}
+
+		writeJSON(writer, request, http.StatusOK, passkeyBeginResponse{Ceremony: ceremony, PublicKey: options})
+	})
+}
+
+func PasskeyLoginFinishHandler(passkeyService passkey.ServiceInterface, userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body passkeyLoginRequest
+		if err := decodeJSON(writer, request, &body); err != nil {
+			writeJSON(writer, request, http.StatusBadRequest, passkeyErrorResponse{Error: "invalid request"})
+			return
+		}
+
+		u, s, err := passkeyService.FinishLogin(body.Ceremony, body.Credential)
+		recordLoginResult(recorder, request, "passkey", "", u, s, err)
+
+		var throttled user.ThrottledError
+		switch {
+		case err == nil:
+		case errors.As(err, &throttled):
+			logging.FromContext(request.Context()).Warn("passkey login throttled", "error", err)
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			writeJSON(writer, request, http.StatusTooManyRequests, passkeyErrorResponse{
+				Error: "too many login attempts. please try again in " + formatRetryAfter(throttled.RetryAfter),
+			})
+			return
+		case errors.Is(err, user.ErrAccountDisabled):
+			writeJSON(writer, request, http.StatusForbidden, passkeyErrorResponse{Error: errAccountDisabled.Error()})
+			return
+		default:
+			logging.FromContext(request.Context()).Warn("passkey login error", "error", err)
+			writeJSON(writer, request, http.StatusBadRequest, passkeyErrorResponse{Error: "the passkey could not be verified. please try again"})
+			return
+		}
+
+		if err = userService.SetAuthResponse(writer, s); err != nil {
+			logging.FromContext(request.Context()).Error("passkey login: setAuthResponse error", "error", err)
+			writeJSON(writer, request, http.StatusInternalServerError, passkeyErrorResponse{Error: "something went wrong. please try again"})
+			return
+		}
+
+		writeJSON(writer, request, http.StatusOK, passkeyRedirectResponse{Redirect: "/dashboard"})
+	})
+}
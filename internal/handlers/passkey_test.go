@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/services/passkey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"github.com/mathieuhays/auth/internal/webauthn/webauthntest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const passkeyTestOrigin = "https://auth.example.com"
+
+func newJSONRequest(t testing.TB, target string, body any) *http.Request {
+	t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	return request
+}
+
+// serveJSON runs the handler and decodes its JSON response into value
+func serveJSON(t testing.TB, handler http.Handler, request *http.Request, value any) *httptest.ResponseRecorder {
+	t.Helper()
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		t.Fatalf("invalid JSON response: %s", err)
+	}
+
+	return response
+}
+
+func TestPasskeyHandlers(t *testing.T) {
+	userStore := users.NewUserMemoryStore()
+	userService := user.NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore())
+	relyingParty := webauthn.New(webauthn.Config{RPID: "auth.example.com", Origins: []string{passkeyTestOrigin}})
+	passkeyService := passkey.NewService(relyingParty, credentials.NewCredentialMemoryStore(), userStore, userService)
+	authenticator := webauthntest.New(passkeyTestOrigin)
+
+	u, _ := userStore.Create(users.User{Email: "test@example.com"})
+
+	t.Run("registration", func(t *testing.T) {
+		var begin struct {
+			Ceremony  string                   `json:"ceremony"`
+			PublicKey webauthn.CreationOptions `json:"publicKey"`
+		}
+		request := user.AugmentRequestWithAuth(newJSONRequest(t, "/account/passkeys/register/begin", nil), u, &sessions.Session{})
+		response := serveJSON(t, PasskeyRegistrationBeginHandler(passkeyService), request, &begin)
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		credential, err := authenticator.Create(begin.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected authenticator error: %s", err)
+		}
+
+		var finish passkeyRedirectResponse
+		request = newJSONRequest(t, "/account/passkeys/register/finish", passkeyRegistrationRequest{
+			Ceremony:   begin.Ceremony,
+			Name:       "Laptop",
+			Credential: credential,
+		})
+		request = user.AugmentRequestWithAuth(request, u, &sessions.Session{})
+		response = serveJSON(t, PasskeyRegistrationFinishHandler(passkeyService), request, &finish)
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		if list, _ := passkeyService.Credentials(u.ID); len(list) != 1 || list[0].Name != "Laptop" {
+			t.Errorf("passkey not stored. got: %v", list)
+		}
+	})
+
+	t.Run("login", func(t *testing.T) {
+		var begin struct {
+			Ceremony  string                  `json:"ceremony"`
+			PublicKey webauthn.RequestOptions `json:"publicKey"`
+		}
+		response := serveJSON(t, PasskeyLoginBeginHandler(passkeyService), newJSONRequest(t, "/login/passkey/begin", nil), &begin)
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		assertion, err := authenticator.Get(begin.PublicKey)
+		if err != nil {
+			t.Fatalf("unexpected authenticator error: %s", err)
+		}
+
+		body := passkeyLoginRequest{Ceremony: begin.Ceremony, Credential: assertion}
+
+		var finish passkeyRedirectResponse
+		response = serveJSON(t, PasskeyLoginFinishHandler(passkeyService, userService, audit.NewLogger()), newJSONRequest(t, "/login/passkey/finish", body), &finish)
+		asserts.StatusCode(t, response, http.StatusOK)
+
+		if finish.Redirect != "/dashboard" {
+			t.Errorf("unexpected redirect: %s", finish.Redirect)
+		}
+
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "session_token" || cookies[0].Value == "" {
+			t.Errorf("session cookie not set. got: %v", cookies)
+		}
+
+		// replaying the same assertion must fail
+		var replay passkeyErrorResponse
+		response = serveJSON(t, PasskeyLoginFinishHandler(passkeyService, userService, audit.NewLogger()), newJSONRequest(t, "/login/passkey/finish", body), &replay)
+		asserts.StatusCode(t, response, http.StatusBadRequest)
+		if len(response.Result().Cookies()) != 0 {
+			t.Errorf("no cookie should be set on a replayed assertion")
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type forgotPasswordTemplate interface {
+	ForgotPassword(writer io.Writer, form *forms.Form, sent bool) error
+}
+
+type passwordResetNotifier interface {
+	PasswordReset(to, path string) error
+}
+
+type resetPasswordTemplate interface {
+	ResetPassword(writer io.Writer, form *forms.Form, token string, done bool) error
+}
+
+func ForgotPasswordHandler(tpl forgotPasswordTemplate, userService user.ServiceInterface, notifier passwordResetNotifier) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forgotForm := forms.NewForm(forms.Field{
+			Name:     "email",
+			Validate: emailFieldValidation,
+		})
+		sent := false
+
+		if request.Method == http.MethodPost {
+			forgotForm.LoadValuesFromRequest(request)
+			forgotForm.Validate()
+
+			if !forgotForm.HasErrors() {
+				u, token, err := userService.RequestPasswordReset(forgotForm.Fields["email"].Value)
+				if err == nil {
+					err = notifier.PasswordReset(u.Email, "/password/reset?token="+url.QueryEscape(token))
+					if err != nil {
+						logging.FromContext(request.Context()).Error("password reset email error", "error", err)
+					}
+				} else if !errors.Is(err, users.ErrUserNotFound) {
+					logging.FromContext(request.Context()).Error("password reset request error", "error", err)
+				}
+
+				// same outcome whether the account exists or not
+				sent = true
+			}
+		}
+
+		if err := tpl.ForgotPassword(writer, forgotForm, sent); err != nil {
+			logging.FromContext(request.Context()).Error("forgot password error", "error", err)
+		}
+	})
+}
+
+func ResetPasswordHandler(tpl resetPasswordTemplate, userService user.ServiceInterface, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token := request.URL.Query().Get("token")
+		if request.Method == http.MethodPost {
+			token = request.PostFormValue("token")
+		}
+
+		var target *users.User
+		email := ""
+		if u, err := userService.VerifyPasswordResetToken(token); err != nil {
+			token = ""
+		} else {
+			target = u
+			email = u.Email
+		}
+
+		resetForm := forms.NewForm(
+			forms.Field{
+				Name: "password",
+				Validate: passwordFieldValidation(request, userService, func(form *forms.Form) string {
+					return email
+				}),
+			},
+			forms.Field{
+				Name:     "password_confirm",
+				Validate: requiredFieldValidation,
+			},
+		)
+		resetForm.SetValidation(func(form *forms.Form) {
+			if form.Fields["password"].Error == nil && form.Fields["password"].Value != form.Fields["password_confirm"].Value {
+				form.Fields["password"].Error = fmt.Errorf("passwords do not match")
+			}
+		})
+
+		done := false
+
+		if token != "" && request.Method == http.MethodPost {
+			resetForm.LoadValuesFromRequest(request)
+			resetForm.Validate()
+
+			if !resetForm.HasErrors() {
+				err := userService.ResetPassword(token, resetForm.Fields["password"].Value)
+				var policyErr *validate.PolicyError
+				if err == nil {
+					done = true
+					recordAudit(request, recorder, newAuditEvent(request, audit.EventPasswordReset, target.ID, target.ID))
+				} else if errors.Is(err, user.ErrInvalidToken) {
+					token = ""
+				} else if errors.As(err, &policyErr) {
+					resetForm.Fields["password"].Error = policyErr
+				} else {
+					logging.FromContext(request.Context()).Error("password reset error", "error", err)
+					resetForm.Error = fmt.Errorf("something went wrong. please try again")
+				}
+			}
+		}
+
+		if err := tpl.ResetPassword(writer, resetForm, token, done); err != nil {
+			logging.FromContext(request.Context()).Error("reset password error", "error", err)
+		}
+	})
+}
+
+type passwordStrengthResponse struct {
+	Score      int      `json:"score"`
+	Label      string   `json:"label"`
+	Violations []string `json:"violations"`
+}
+
+// PasswordStrengthHandler scores a password for the strength meter, nothing is stored.
+func PasswordStrengthHandler(validator passwordValidator) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		strength, err := validator.ValidatePassword(request.PostFormValue("password"), request.PostFormValue("email"))
+
+		response := passwordStrengthResponse{
+			Score:      int(strength),
+			Label:      strength.String(),
+			Violations: []string{},
+		}
+
+		var policyErr *validate.PolicyError
+		if errors.As(err, &policyErr) {
+			for _, violation := range policyErr.Violations {
+				response.Violations = append(response.Violations, violation.Message)
+			}
+		} else if err != nil {
+			logging.FromContext(request.Context()).Error("password strength error", "error", err)
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+		if err = json.NewEncoder(writer).Encode(response); err != nil {
+			logging.FromContext(request.Context()).Error("password strength encoding error", "error", err)
+		}
+	})
+}
@@ -1,17 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"github.com/mathieuhays/auth/internal/audit"
 	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/logging"
 	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
 	"github.com/mathieuhays/auth/internal/validate"
 	"io"
-	"log"
 	"net/http"
 )
 
 type registerTemplate interface {
-	Register(writer io.Writer, form *forms.Form) error
+	Register(writer io.Writer, form *forms.Form, registered bool) error
+}
+
+type registrationNotifier interface {
+	emailConfirmationNotifier
+	AccountExists(to string) error
 }
 
 func emailFieldValidation(field *forms.Field, form *forms.Form) {
@@ -26,19 +34,40 @@ func emailFieldValidation(field *forms.Field, form *forms.Form) {
 	}
 }
 
-func passwordFieldValidation(field *forms.Field, form *forms.Form) {
+type passwordValidator interface {
+	ValidatePassword(password, email string) (validate.Strength, error)
+}
+
+func requiredFieldValidation(field *forms.Field, form *forms.Form) {
 	if field.Value == "" {
 		field.Error = fmt.Errorf("this field is required")
-		return
 	}
+}
+
+// passwordFieldValidation checks the password against the password policy.
+// email returns the address of the account, the policy may reject passwords containing it.
+// Validator errors are logged with the logger of request.
+func passwordFieldValidation(request *http.Request, validator passwordValidator, email func(form *forms.Form) string) func(field *forms.Field, form *forms.Form) {
+	return func(field *forms.Field, form *forms.Form) {
+		if field.Value == "" {
+			field.Error = fmt.Errorf("this field is required")
+			return
+		}
 
-	err := validate.Password(field.Value)
-	if err != nil {
-		field.Error = err
+		_, err := validator.ValidatePassword(field.Value, email(form))
+		var policyErr *validate.PolicyError
+
+		switch {
+		case errors.As(err, &policyErr):
+			field.Error = policyErr
+		case err != nil:
+			logging.FromContext(request.Context()).Error("password validation error", "error", err)
+			field.Error = fmt.Errorf("your password could not be checked. please try again")
+		}
 	}
 }
 
-func RegisterHandler(tpl registerTemplate, userService user.ServiceInterface) http.Handler {
+func RegisterHandler(tpl registerTemplate, userService user.ServiceInterface, notifier registrationNotifier, recorder auditRecorder) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		emailField := forms.Field{
 			Name:     "email",
@@ -49,12 +78,14 @@ func RegisterHandler(tpl registerTemplate, userService user.ServiceInterface) ht
 			Validate: emailFieldValidation,
 		}
 		passwordField := forms.Field{
-			Name:     "password",
-			Validate: passwordFieldValidation,
+			Name: "password",
+			Validate: passwordFieldValidation(request, userService, func(form *forms.Form) string {
+				return form.Fields["email"].Value
+			}),
 		}
 		passwordConfirmField := forms.Field{
 			Name:     "password_confirm",
-			Validate: passwordFieldValidation,
+			Validate: requiredFieldValidation,
 		}
 
 		registrationForm := forms.NewForm(emailField, emailConfirmField, passwordField, passwordConfirmField)
@@ -72,37 +103,41 @@ func RegisterHandler(tpl registerTemplate, userService user.ServiceInterface) ht
 			}
 		})
 
+		registered := false
+
 		if request.Method == http.MethodPost {
 			registrationForm.LoadValuesFromRequest(request)
 			registrationForm.Validate()
 
 			if !registrationForm.HasErrors() {
-				u, err := userService.Register(
-					registrationForm.Fields["email"].Value,
-					registrationForm.Fields["password"].Value)
-				if err == nil {
-					log.Printf("registration successful")
-					_, session, err2 := userService.Login(u)
-					if err2 == nil {
-						err3 := userService.SetAuthResponse(writer, session)
-						if err3 != nil {
-							log.Printf("register: failed setAuthResponse: %s", err3)
-						}
-						http.Redirect(writer, request, "/dashboard", http.StatusFound)
-						return
-					}
+				email := registrationForm.Fields["email"].Value
+				u, err := userService.Register(email, registrationForm.Fields["password"].Value)
+				var policyErr *validate.PolicyError
 
-					log.Printf("register: login error: %s", err2)
-					http.Redirect(writer, request, "/login", http.StatusFound)
-					return
+				switch {
+				case err == nil:
+					recordAudit(request, recorder, newAuditEvent(request, audit.EventUserRegistered, u.ID, u.ID))
+					if err = sendEmailConfirmation(userService, notifier, u); err != nil {
+						logging.FromContext(request.Context()).Error("register: email confirmation error", "error", err)
+					}
+					registered = true
+				case errors.Is(err, users.ErrEmailAlreadyUsed):
+					// the visitor gets the same answer, the account owner is notified instead
+					if err = notifier.AccountExists(email); err != nil {
+						logging.FromContext(request.Context()).Error("register: account exists notification error", "error", err)
+					}
+					registered = true
+				case errors.As(err, &policyErr):
+					registrationForm.Fields["password"].Error = policyErr
+				default:
+					logging.FromContext(request.Context()).Error("registration error", "error", err)
+					registrationForm.Error = fmt.Errorf("something went wrong. please try again")
 				}
-
-				registrationForm.Error = fmt.Errorf("error while registering: %s", err)
 			}
 		}
 
-		if err := tpl.Register(writer, registrationForm); err != nil {
-			log.Printf("login error: %s", err)
+		if err := tpl.Register(writer, registrationForm, registered); err != nil {
+			logging.FromContext(request.Context()).Warn("login error", "error", err)
 		}
 	})
 }
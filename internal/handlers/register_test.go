@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type registerHandlerTpl struct {
+	form       *forms.Form
+	registered bool
+}
+
+func (r *registerHandlerTpl) Register(writer io.Writer, form *forms.Form, registered bool) error {
+	r.form = form
+	r.registered = registered
+	return nil
+}
+
+type registerHandlerUserService struct {
+	user.ServiceInterface
+	existing map[string]bool
+}
+
+func (r registerHandlerUserService) Register(email, password string) (*users.User, error) {
+	if r.existing[email] {
+		return nil, users.ErrEmailAlreadyUsed
+	}
+
+	return &users.User{Email: email}, nil
+}
+
+func (r registerHandlerUserService) ValidatePassword(password, email string) (validate.Strength, error) {
+	return validate.DefaultPasswordPolicy.Strength(password, email), validate.DefaultPasswordPolicy.Check(password, email)
+}
+
+func (r registerHandlerUserService) RequestEmailConfirmation(u *users.User) (string, error) {
+	return "token", nil
+}
+
+type registerHandlerNotifier struct {
+	confirmations []string
+	accountExists []string
+}
+
+func (r *registerHandlerNotifier) EmailConfirmation(to, path string) error {
+	r.confirmations = append(r.confirmations, to)
+	return nil
+}
+
+func (r *registerHandlerNotifier) AccountExists(to string) error {
+	r.accountExists = append(r.accountExists, to)
+	return nil
+}
+
+func newRegisterRequest(email string) *http.Request {
+	return newRegisterRequestWithPassword(email, "correct horse battery")
+}
+
+func newRegisterRequestWithPassword(email, password string) *http.Request {
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("email_confirm", email)
+	form.Set("password", password)
+	form.Set("password_confirm", password)
+
+	request := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return request
+}
+
+func TestRegisterHandler(t *testing.T) {
+	userService := registerHandlerUserService{existing: map[string]bool{"taken@example.com": true}}
+
+	testCases := []struct {
+		name         string
+		email        string
+		confirmation bool
+	}{
+		{"new account", "new@example.com", true},
+		{"existing account", "taken@example.com", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl := &registerHandlerTpl{}
+			notifier := &registerHandlerNotifier{}
+
+			response := httptest.NewRecorder()
+			RegisterHandler(tpl, userService, notifier, audit.NewLogger()).ServeHTTP(response, newRegisterRequest(tc.email))
+
+			// both outcomes must look identical to the visitor
+			asserts.StatusCode(t, response, http.StatusOK)
+			if !tpl.registered || tpl.form.Error != nil {
+				t.Errorf("unexpected outcome. registered: %v. error: %v", tpl.registered, tpl.form.Error)
+			}
+
+			if len(response.Result().Cookies()) != 0 {
+				t.Errorf("no cookie should be set on registration. got: %v", response.Result().Cookies())
+			}
+
+			if tc.confirmation && len(notifier.confirmations) != 1 {
+				t.Errorf("confirmation email not sent")
+			}
+
+			if !tc.confirmation && len(notifier.accountExists) != 1 {
+				t.Errorf("account owner not notified")
+			}
+		})
+	}
+}
+
+func TestRegisterHandlerPasswordPolicy(t *testing.T) {
+	tpl := &registerHandlerTpl{}
+	notifier := &registerHandlerNotifier{}
+
+	response := httptest.NewRecorder()
+	request := newRegisterRequestWithPassword("letmein@example.com", "letmein")
+	RegisterHandler(tpl, registerHandlerUserService{}, notifier, audit.NewLogger()).ServeHTTP(response, request)
+
+	if tpl.registered {
+		t.Fatalf("weak password should not register")
+	}
+
+	var policyErr *validate.PolicyError
+	if !errors.As(tpl.form.Fields["password"].Error, &policyErr) {
+		t.Fatalf("expected a policy error on the password field. got: %v", tpl.form.Fields["password"].Error)
+	}
+
+	for _, expected := range []error{validate.ErrPasswordTooShort, validate.ErrPasswordCommon, validate.ErrPasswordContainsEmail} {
+		if !errors.Is(policyErr, expected) {
+			t.Errorf("missing violation: %s", expected)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/qrcode"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// loginChallengeCookie carries the challenge between the password and the second factor steps
+const loginChallengeCookie = "login_challenge"
+
+type loginVerifyTemplate interface {
+	LoginVerify(writer io.Writer, form *forms.Form) error
+}
+
+type twoFactorTemplate interface {
+	TwoFactor(writer io.Writer, u *users.User, enrollment *user.TOTPEnrollment, qrCode string, recoveryCodes []string, err error) error
+}
+
+func setLoginChallenge(writer http.ResponseWriter, cookies user.CookiePolicy, challenge string) {
+	http.SetCookie(writer, cookies.Apply(&http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    challenge,
+		Path:     "/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}))
+}
+
+func clearLoginChallenge(writer http.ResponseWriter, cookies user.CookiePolicy) {
+	http.SetCookie(writer, cookies.Apply(&http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}))
+}
+
+// LoginVerifyHandler is the second login step for accounts with two-factor authentication.
+func LoginVerifyHandler(tpl loginVerifyTemplate, userService user.ServiceInterface, cookies user.CookiePolicy, recorder auditRecorder) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		cookie, err := request.Cookie(loginChallengeCookie)
+		if err != nil {
+			http.Redirect(writer, request, "/login", http.StatusFound)
+			return
+		}
+
+		verifyForm := forms.NewForm(forms.Field{
+			Name:     "code",
+			Validate: requiredFieldValidation,
+		})
+
+		if request.Method == http.MethodPost {
+			verifyForm.LoadValuesFromRequest(request)
+			verifyForm.Validate()
+
+			if !verifyForm.HasErrors() {
+				u, s, err := userService.LoginWithSecondFactor(cookie.Value, verifyForm.Fields["code"].Value, user.ClientIP(request))
+				recordLoginResult(recorder, request, "second_factor", "", u, s, err)
+
+				var throttled user.ThrottledError
+				switch {
+				case err == nil:
+					clearLoginChallenge(writer, cookies)
+					if err = userService.SetAuthResponse(writer, s); err == nil {
+						http.Redirect(writer, request, "/dashboard", http.StatusFound)
+						return
+					}
+
+					logging.FromContext(request.Context()).Error("login verify: setAuthResponse error", "error", err)
+					verifyForm.Error = fmt.Errorf("something went wrong. please try again")
+				case errors.Is(err, user.ErrInvalidToken):
+					// the challenge expired, start over
+					clearLoginChallenge(writer, cookies)
+					http.Redirect(writer, request, "/login", http.StatusFound)
+					return
+				case errors.As(err, &throttled):
+					logging.FromContext(request.Context()).Warn("login verify throttled", "error", err)
+					writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+					writer.WriteHeader(http.StatusTooManyRequests)
+					verifyForm.Error = fmt.Errorf("too many login attempts. please try again in %s", formatRetryAfter(throttled.RetryAfter))
+				case errors.Is(err, user.ErrInvalidSecondFactor):
+					verifyForm.Fields["code"].Error = fmt.Errorf("invalid code")
+				case errors.Is(err, user.ErrAccountDisabled):
+					clearLoginChallenge(writer, cookies)
+					verifyForm.Error = errAccountDisabled
+				default:
+					logging.FromContext(request.Context()).Error("login verify error", "error", err)
+					verifyForm.Error = fmt.Errorf("something went wrong. please try again")
+				}
+			}
+		}
+
+		if err = tpl.LoginVerify(writer, verifyForm); err != nil {
+			logging.FromContext(request.Context()).Error("template error", "error", err)
+		}
+	})
+}
+
+// TwoFactorHandler manages two-factor authentication for the current user.
+// POST requests carry an action: enable, disable or regenerate (recovery codes).
+func TwoFactorHandler(tpl twoFactorTemplate, userService user.ServiceInterface) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(request)
+		if err != nil {
+			http.Redirect(writer, request, "/login", http.StatusFound)
+			return
+		}
+
+		var recoveryCodes []string
+		var actionErr error
+
+		if request.Method == http.MethodPost {
+			code := request.PostFormValue("code")
+
+			switch request.PostFormValue("action") {
+			case "enable":
+				recoveryCodes, err = userService.EnableTOTP(u.ID, code)
+			case "regenerate":
+				recoveryCodes, err = userService.RegenerateRecoveryCodes(u.ID, code)
+			case "disable":
+				if err = userService.DisableTOTP(u.ID, code); err == nil {
+					http.Redirect(writer, request, "/account/two-factor", http.StatusSeeOther)
+					return
+				}
+			default:
+				err = fmt.Errorf("unknown action")
+			}
+
+			var throttled user.ThrottledError
+			switch {
+			case err == nil:
+			case errors.As(err, &throttled):
+				logging.FromContext(request.Context()).Warn("two-factor throttled", "error", err)
+				writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+				writer.WriteHeader(http.StatusTooManyRequests)
+				actionErr = fmt.Errorf("too many attempts. please try again in %s", formatRetryAfter(throttled.RetryAfter))
+			case errors.Is(err, user.ErrInvalidSecondFactor):
+				actionErr = fmt.Errorf("invalid code. please try again")
+			default:
+				logging.FromContext(request.Context()).Error("two-factor error", "error", err)
+				actionErr = fmt.Errorf("something went wrong. please try again")
+			}
+		}
+
+		var enrollment *user.TOTPEnrollment
+		var qrCode string
+
+		if !u.TwoFactorEnabled() && recoveryCodes == nil {
+			if enrollment, err = userService.BeginTOTPEnrollment(u); err != nil {
+				logging.FromContext(request.Context()).Error("two-factor enrollment error", "error", err)
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			code, err := qrcode.Encode(enrollment.URI)
+			if err != nil {
+				logging.FromContext(request.Context()).Error("two-factor qr code error", "error", err)
+			} else {
+				qrCode = code.SVG(4)
+			}
+		}
+
+		if err = tpl.TwoFactor(writer, u, enrollment, qrCode, recoveryCodes, actionErr); err != nil {
+			logging.FromContext(request.Context()).Error("template error", "error", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/audit"
+	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type loginVerifyTpl struct {
+	form *forms.Form
+}
+
+func (l *loginVerifyTpl) Login(writer io.Writer, form *forms.Form) error {
+	l.form = form
+	return nil
+}
+
+func (l *loginVerifyTpl) LoginVerify(writer io.Writer, form *forms.Form) error {
+	l.form = form
+	return nil
+}
+
+type loginVerifyUserService struct {
+	user.ServiceInterface
+}
+
+func (l loginVerifyUserService) LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error) {
+	return nil, nil, user.SecondFactorRequiredError{Challenge: "challenge"}
+}
+
+func (l loginVerifyUserService) LoginWithSecondFactor(challenge, code, ip string) (*users.User, *sessions.Session, error) {
+	switch {
+	case challenge != "challenge":
+		return nil, nil, user.ErrInvalidToken
+	case code != "123456":
+		return nil, nil, user.ErrInvalidSecondFactor
+	}
+
+	return &users.User{}, &sessions.Session{Token: "session"}, nil
+}
+
+func (l loginVerifyUserService) SetAuthResponse(writer http.ResponseWriter, session *sessions.Session) error {
+	http.SetCookie(writer, &http.Cookie{Name: "session_token", Value: session.Token})
+	return nil
+}
+
+type twoFactorTpl struct {
+	err error
+}
+
+func (tf *twoFactorTpl) TwoFactor(writer io.Writer, u *users.User, enrollment *user.TOTPEnrollment, qrCode string, recoveryCodes []string, err error) error {
+	tf.err = err
+	return nil
+}
+
+type throttledTwoFactorUserService struct {
+	user.ServiceInterface
+}
+
+func (t throttledTwoFactorUserService) DisableTOTP(userID uuid.UUID, code string) error {
+	return user.ThrottledError{RetryAfter: time.Second * 90}
+}
+
+func newPostRequest(target string, values url.Values) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request
+}
+
+func TestLoginHandlerSecondFactor(t *testing.T) {
+	response := httptest.NewRecorder()
+	request := newPostRequest("/login", url.Values{"email": {"test@example.com"}, "password": {"secret"}})
+	LoginHandler(&loginVerifyTpl{}, loginVerifyUserService{}, user.DefaultCookiePolicy, audit.NewLogger()).ServeHTTP(response, request)
+
+	asserts.StatusCode(t, response, http.StatusFound)
+	if location := response.Header().Get("Location"); location != "/login/verify" {
+		t.Errorf("unexpected redirect: %s", location)
+	}
+
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != loginChallengeCookie || cookies[0].Value != "challenge" {
+		t.Errorf("expected only the challenge cookie. got: %v", cookies)
+	}
+}
+
+func TestLoginVerifyHandler(t *testing.T) {
+	testCases := []struct {
+		name      string
+		challenge string
+		code      string
+		status    int
+		location  string
+		session   bool
+	}{
+		{"no challenge", "", "123456", http.StatusFound, "/login", false},
+		{"expired challenge", "expired", "123456", http.StatusFound, "/login", false},
+		{"wrong code", "challenge", "000000", http.StatusOK, "", false},
+		{"valid code", "challenge", "123456", http.StatusFound, "/dashboard", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl := &loginVerifyTpl{}
+			request := newPostRequest("/login/verify", url.Values{"code": {tc.code}})
+			if tc.challenge != "" {
+				request.AddCookie(&http.Cookie{Name: loginChallengeCookie, Value: tc.challenge})
+			}
+
+			response := httptest.NewRecorder()
+			LoginVerifyHandler(tpl, loginVerifyUserService{}, user.DefaultCookiePolicy, audit.NewLogger()).ServeHTTP(response, request)
+
+			asserts.StatusCode(t, response, tc.status)
+			if location := response.Header().Get("Location"); location != tc.location {
+				t.Errorf("unexpected redirect. expected: %q. got: %q", tc.location, location)
+			}
+
+			hasSession := false
+			for _, cookie := range response.Result().Cookies() {
+				if cookie.Name == "session_token" {
+					hasSession = true
+				}
+			}
+
+			if hasSession != tc.session {
+				t.Errorf("unexpected session cookie. expected: %t. got: %t", tc.session, hasSession)
+			}
+
+			if tc.name == "wrong code" && (tpl.form == nil || tpl.form.Fields["code"].Error == nil) {
+				t.Errorf("expected an error on the code field")
+			}
+		})
+	}
+}
+
+func TestTwoFactorHandlerThrottled(t *testing.T) {
+	now := time.Now()
+	u := &users.User{ID: uuid.New(), TOTPEnabledAt: &now}
+
+	tpl := &twoFactorTpl{}
+	request := user.AugmentRequestWithAuth(newPostRequest("/account/two-factor", url.Values{"action": {"disable"}, "code": {"000000"}}), u, &sessions.Session{})
+	response := httptest.NewRecorder()
+	TwoFactorHandler(tpl, throttledTwoFactorUserService{}).ServeHTTP(response, request)
+
+	asserts.StatusCode(t, response, http.StatusTooManyRequests)
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "90" {
+		t.Errorf("unexpected Retry-After. expected: %q. got: %q", "90", retryAfter)
+	}
+
+	if tpl.err == nil || !strings.Contains(tpl.err.Error(), "too many attempts") {
+		t.Errorf("expected the throttling message. got: %v", tpl.err)
+	}
+}
@@ -0,0 +1,167 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mathieuhays/auth/internal/logging"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultTimeout = time.Second * 2
+
+const (
+	StatusOK           = "ok"
+	StatusFailed       = "failed"
+	StatusReady        = "ready"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Checker reports whether a dependency can serve requests, such as a store or the mailer
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type namedChecker struct {
+	name    string
+	checker Checker
+}
+
+// Health backs the liveness and readiness probes. The process is live as long as it answers,
+// it is ready when every checker passes and it is not shutting down.
+type Health struct {
+	mu           sync.RWMutex
+	checkers     []namedChecker
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+// New runs every check with the given timeout, DefaultTimeout when not positive
+func New(timeout time.Duration) *Health {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Health{timeout: timeout}
+}
+
+func (h *Health) Register(name string, checker Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checkers = append(h.checkers, namedChecker{name: name, checker: checker})
+}
+
+// ShutDown makes readiness fail for good, so load balancers stop sending requests while in-flight ones complete
+func (h *Health) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+type CheckResult struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Check runs every checker concurrently. Checks are skipped once shutting down.
+func (h *Health) Check(ctx context.Context) Report {
+	if h.shuttingDown.Load() {
+		return Report{Status: StatusShuttingDown}
+	}
+
+	h.mu.RLock()
+	checkers := h.checkers
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(checkers))
+	wg := sync.WaitGroup{}
+	for i, c := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, c.checker)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(checkers))}
+	for i, c := range checkers {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusNotReady
+		}
+	}
+
+	return report
+}
+
+// run gives up once the context is done, even when the checker ignores it
+func run(ctx context.Context, checker Checker) CheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: StatusOK, Duration: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(r.Context()).Error("health report encoding error", "error", err)
+	}
+}
+
+// LivenessHandler answers as long as the process serves requests, dependencies are left to readiness
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, r, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler answers 503 when a check fails or when shutting down
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+
+		status := http.StatusOK
+		if report.Status != StatusReady {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeReport(w, r, status, report)
+	})
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mathieuhays/auth/internal/asserts"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(ctx context.Context) error {
+	return nil
+}
+
+func readiness(t *testing.T, h *Health) (*httptest.ResponseRecorder, Report) {
+	t.Helper()
+
+	response := httptest.NewRecorder()
+	h.ReadinessHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf("unexpected error while decoding the report: %s", err)
+	}
+
+	return response, report
+}
+
+func TestHealth_LivenessHandler(t *testing.T) {
+	h := New(0)
+	h.Register("failing", CheckerFunc(func(ctx context.Context) error { return errors.New("down") }))
+
+	response := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	asserts.StatusCode(t, response, http.StatusOK)
+}
+
+func TestHealth_ReadinessHandler(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		h := New(0)
+		h.Register("users", CheckerFunc(ok))
+		h.Register("templates", CheckerFunc(ok))
+
+		response, report := readiness(t, h)
+
+		asserts.StatusCode(t, response, http.StatusOK)
+		if report.Status != StatusReady {
+			t.Errorf("unexpected status. expected: %s. got: %s", StatusReady, report.Status)
+		}
+
+		if len(report.Checks) != 2 || report.Checks["users"].Status != StatusOK {
+			t.Errorf("unexpected checks: %+v", report.Checks)
+		}
+	})
+
+	t.Run("failing check", func(t *testing.T) {
+		h := New(0)
+		h.Register("users", CheckerFunc(ok))
+		h.Register("mailer", CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") }))
+
+		response, report := readiness(t, h)
+
+		asserts.StatusCode(t, response, http.StatusServiceUnavailable)
+		if report.Status != StatusNotReady {
+			t.Errorf("unexpected status. expected: %s. got: %s", StatusNotReady, report.Status)
+		}
+
+		mailer := report.Checks["mailer"]
+		if mailer.Status != StatusFailed || mailer.Error != "connection refused" {
+			t.Errorf("unexpected mailer check: %+v", mailer)
+		}
+
+		if report.Checks["users"].Status != StatusOK {
+			t.Errorf("unexpected users check: %+v", report.Checks["users"])
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		h := New(time.Millisecond * 20)
+		block := make(chan struct{})
+		t.Cleanup(func() { close(block) })
+		h.Register("stuck", CheckerFunc(func(ctx context.Context) error {
+			<-block
+			return nil
+		}))
+
+		response, report := readiness(t, h)
+
+		asserts.StatusCode(t, response, http.StatusServiceUnavailable)
+		if stuck := report.Checks["stuck"]; stuck.Error != context.DeadlineExceeded.Error() {
+			t.Errorf("unexpected error. expected: %s. got: %s", context.DeadlineExceeded, stuck.Error)
+		}
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		called := false
+		h := New(0)
+		h.Register("users", CheckerFunc(func(ctx context.Context) error {
+			called = true
+			return nil
+		}))
+		h.ShutDown()
+
+		response, report := readiness(t, h)
+
+		asserts.StatusCode(t, response, http.StatusServiceUnavailable)
+		if report.Status != StatusShuttingDown {
+			t.Errorf("unexpected status. expected: %s. got: %s", StatusShuttingDown, report.Status)
+		}
+
+		if called {
+			t.Errorf("checks should not run once shutting down")
+		}
+	})
+}
@@ -0,0 +1,275 @@
+// Package jwt signs and verifies compact JSON Web Tokens with HS256 or EdDSA keys.
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	// minHMACKeySize follows RFC 7518, the key must be at least as long as the hash output
+	minHMACKeySize = 32
+)
+
+var (
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrTokenExpired         = errors.New("token expired")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrInvalidKey           = errors.New("invalid signing key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Claims are the registered claims used by this service, plus the session ID.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Key signs and verifies tokens with a single algorithm.
+type Key interface {
+	ID() string
+	Algorithm() string
+	Sign(data []byte) ([]byte, error)
+	Verify(data, signature []byte) bool
+	// JWK returns the public key to publish, or nil for symmetric keys
+	JWK() *JWK
+}
+
+type hmacKey struct {
+	id     string
+	secret []byte
+}
+
+// NewHS256Key returns a symmetric key, tokens signed with it can only be verified by holders of the secret.
+func NewHS256Key(id string, secret []byte) (Key, error) {
+	if len(secret) < minHMACKeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return hmacKey{id: id, secret: bytes.Clone(secret)}, nil
+}
+
+func (k hmacKey) ID() string        { return k.id }
+func (k hmacKey) Algorithm() string { return AlgorithmHS256 }
+func (k hmacKey) JWK() *JWK         { return nil }
+
+func (k hmacKey) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (k hmacKey) Verify(data, signature []byte) bool {
+	expected, _ := k.Sign(data)
+	return hmac.Equal(expected, signature)
+}
+
+type eddsaKey struct {
+	id      string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// NewEdDSAKey returns an Ed25519 key. Without the private key, the key can only verify tokens.
+func NewEdDSAKey(id string, private ed25519.PrivateKey, public ed25519.PublicKey) (Key, error) {
+	if private != nil {
+		if len(private) != ed25519.PrivateKeySize {
+			return nil, ErrInvalidKey
+		}
+		public = private.Public().(ed25519.PublicKey)
+	}
+
+	if len(public) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return eddsaKey{id: id, private: private, public: public}, nil
+}
+
+// GenerateEdDSAKey returns a new random Ed25519 key.
+func GenerateEdDSAKey(id string) (Key, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEdDSAKey(id, private, nil)
+}
+
+func (k eddsaKey) ID() string        { return k.id }
+func (k eddsaKey) Algorithm() string { return AlgorithmEdDSA }
+
+func (k eddsaKey) Sign(data []byte) ([]byte, error) {
+	if k.private == nil {
+		return nil, ErrInvalidKey
+	}
+
+	return ed25519.Sign(k.private, data), nil
+}
+
+func (k eddsaKey) Verify(data, signature []byte) bool {
+	return ed25519.Verify(k.public, data, signature)
+}
+
+func (k eddsaKey) JWK() *JWK {
+	return &JWK{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         encoding.EncodeToString(k.public),
+		KeyID:     k.id,
+		Algorithm: AlgorithmEdDSA,
+		Use:       "sig",
+	}
+}
+
+// JWK is a public key as published in a JSON Web Key Set (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet signs with its current key and verifies with any of its keys, which allows key rotation.
+type KeySet struct {
+	current Key
+	keys    map[string]Key
+}
+
+// NewKeySet uses current to sign new tokens. Previous keys are only used to verify tokens.
+func NewKeySet(current Key, previous ...Key) *KeySet {
+	set := &KeySet{current: current, keys: map[string]Key{current.ID(): current}}
+	for _, key := range previous {
+		set.keys[key.ID()] = key
+	}
+
+	return set
+}
+
+func (s *KeySet) Sign(claims Claims) (string, error) {
+	encodedHeader, err := json.Marshal(header{Algorithm: s.current.Algorithm(), Type: "JWT", KeyID: s.current.ID()})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(encodedHeader) + "." + encoding.EncodeToString(payload)
+	signature, err := s.current.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature and the validity period. The algorithm is dictated by the key, not by the token.
+func (s *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err = json.Unmarshal(rawHeader, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if h.Algorithm != key.Algorithm() {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !key.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// JWKS returns the public keys of the set, symmetric keys are left out.
+func (s *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	// the current key first, then the others in a stable order
+	if jwk := s.current.JWK(); jwk != nil {
+		set.Keys = append(set.Keys, *jwk)
+	}
+
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		if id != s.current.ID() {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		if jwk := s.keys[id].JWK(); jwk != nil {
+			set.Keys = append(set.Keys, *jwk)
+		}
+	}
+
+	return set
+}
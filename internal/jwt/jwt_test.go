@@ -0,0 +1,138 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHS256Key(t testing.TB, id string) Key {
+	t.Helper()
+	key, err := NewHS256Key(id, []byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return key
+}
+
+func newTestEdDSAKey(t testing.TB, id string) Key {
+	t.Helper()
+	key, err := GenerateEdDSAKey(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return key
+}
+
+// test vector from RFC 8037 appendix A.4
+func TestEdDSAKey_Sign(t *testing.T) {
+	seed, _ := encoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	key, err := NewEdDSAKey("rfc8037", ed25519.NewKeyFromSeed(seed), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	signature, err := key.Sign([]byte("eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	if got := encoding.EncodeToString(signature); got != expected {
+		t.Errorf("unexpected signature. expected: %s. got: %s", expected, got)
+	}
+
+	if x := key.JWK().X; x != "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo" {
+		t.Errorf("unexpected public key: %s", x)
+	}
+}
+
+func TestKeySet_Verify(t *testing.T) {
+	now := time.Now()
+	claims := Claims{Subject: "user", SessionID: "session", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+
+	for _, key := range []Key{newTestHS256Key(t, "hmac"), newTestEdDSAKey(t, "ed25519")} {
+		t.Run(key.Algorithm(), func(t *testing.T) {
+			set := NewKeySet(key)
+			token, err := set.Sign(claims)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			verified, err := set.Verify(token, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if *verified != claims {
+				t.Errorf("unexpected claims. expected: %v. got: %v", claims, verified)
+			}
+
+			if _, err = set.Verify(token, now.Add(time.Minute)); !errors.Is(err, ErrTokenExpired) {
+				t.Errorf("unexpected error. expected: %s. got: %v", ErrTokenExpired, err)
+			}
+
+			parts := strings.Split(token, ".")
+			tampered, _ := json.Marshal(Claims{Subject: "admin", ExpiresAt: claims.ExpiresAt})
+			forged := parts[0] + "." + encoding.EncodeToString(tampered) + "." + parts[2]
+			if _, err = set.Verify(forged, now); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidToken, err)
+			}
+		})
+	}
+}
+
+func TestKeySet_VerifyAlgorithmConfusion(t *testing.T) {
+	now := time.Now()
+	edKey := newTestEdDSAKey(t, "shared-id")
+	set := NewKeySet(edKey)
+
+	// a token signed with HMAC, using the published public key as the secret, must not be accepted
+	public, _ := encoding.DecodeString(edKey.JWK().X)
+	hmac, _ := NewHS256Key("shared-id", public)
+	token, _ := NewKeySet(hmac).Sign(Claims{Subject: "admin", ExpiresAt: now.Add(time.Minute).Unix()})
+
+	if _, err := set.Verify(token, now); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrUnsupportedAlgorithm, err)
+	}
+
+	header := encoding.EncodeToString([]byte(`{"alg":"none","kid":"shared-id"}`))
+	payload := encoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`))
+	if _, err := set.Verify(header+"."+payload+".", now); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("unexpected error for alg none. got: %v", err)
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	now := time.Now()
+	previous := newTestEdDSAKey(t, "previous")
+	current := newTestEdDSAKey(t, "current")
+	claims := Claims{Subject: "user", ExpiresAt: now.Add(time.Minute).Unix()}
+
+	token, _ := NewKeySet(previous).Sign(claims)
+
+	set := NewKeySet(current, previous, newTestHS256Key(t, "hmac"))
+	if _, err := set.Verify(token, now); err != nil {
+		t.Errorf("tokens signed with a previous key should verify. got: %s", err)
+	}
+
+	if _, err := NewKeySet(current).Verify(token, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownKey, err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "current" || jwks.Keys[1].KeyID != "previous" {
+		t.Errorf("unexpected JWKS, symmetric keys must not be published. got: %v", jwks)
+	}
+}
+
+func TestNewHS256Key(t *testing.T) {
+	if _, err := NewHS256Key("short", []byte("too short")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidKey, err)
+	}
+}
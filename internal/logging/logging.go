@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown log format")
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type contextKey string
+
+const (
+	loggerContextKey    contextKey = "logger"
+	requestIDContextKey contextKey = "request_id"
+)
+
+// New returns a logger writing text or JSON records at the given level, text when format is empty.
+func New(writer io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(writer, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(writer, options)), nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the request logger, which carries the request ID, or the default logger outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	serve := func(t *testing.T, requestID string) (*httptest.ResponseRecorder, map[string]any, string) {
+		t.Helper()
+		var output bytes.Buffer
+		logger, err := New(&output, FormatJSON, slog.LevelInfo)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var handlerRequestID string
+		handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerRequestID = RequestID(r.Context())
+			FromContext(r.Context()).Info("handler")
+			w.WriteHeader(http.StatusTeapot)
+			_, _ = w.Write([]byte("short and stout"))
+		}))
+
+		request := httptest.NewRequest(http.MethodGet, "/teapot", nil)
+		if requestID != "" {
+			request.Header.Set(RequestIDHeader, requestID)
+		}
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected a handler and an access log line. got: %q", output.String())
+		}
+
+		var handlerLine, accessLine map[string]any
+		if err = json.Unmarshal([]byte(lines[0]), &handlerLine); err != nil {
+			t.Fatalf("invalid JSON log line: %s", err)
+		}
+		if err = json.Unmarshal([]byte(lines[1]), &accessLine); err != nil {
+			t.Fatalf("invalid JSON log line: %s", err)
+		}
+
+		if handlerLine["request_id"] != handlerRequestID || accessLine["request_id"] != handlerRequestID {
+			t.Errorf("request ID missing from the log lines. got: %v and %v", handlerLine, accessLine)
+		}
+
+		return response, accessLine, handlerRequestID
+	}
+
+	t.Run("access log", func(t *testing.T) {
+		response, accessLine, id := serve(t, "")
+
+		if id == "" || response.Header().Get(RequestIDHeader) != id {
+			t.Errorf("request ID not sent back. expected: %q. got: %q", id, response.Header().Get(RequestIDHeader))
+		}
+
+		if accessLine["status"] != float64(http.StatusTeapot) || accessLine["bytes"] != float64(15) || accessLine["path"] != "/teapot" {
+			t.Errorf("unexpected access log. got: %v", accessLine)
+		}
+	})
+
+	t.Run("propagated request ID", func(t *testing.T) {
+		response, _, id := serve(t, "upstream-1234")
+		if id != "upstream-1234" || response.Header().Get(RequestIDHeader) != id {
+			t.Errorf("request ID not propagated. got: %q", id)
+		}
+	})
+
+	t.Run("invalid request ID", func(t *testing.T) {
+		_, _, id := serve(t, "bad id\nwith=newline")
+		if id == "" || strings.ContainsAny(id, " \n") {
+			t.Errorf("invalid request ID was kept. got: %q", id)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", slog.LevelInfo); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownFormat, err)
+	}
+}
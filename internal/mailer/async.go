@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	ErrQueueFull    = errors.New("mail queue is full")
+	ErrMailerClosed = errors.New("mailer is closed")
+)
+
+// AsyncMailer hands messages over to a background worker, so that response times
+// do not depend on whether an email was sent or on how long delivery takes.
+type AsyncMailer struct {
+	mailer Mailer
+	queue  chan Message
+	done   chan struct{}
+	closed bool
+	mu     sync.RWMutex
+}
+
+func NewAsyncMailer(mailer Mailer, queueSize int) *AsyncMailer {
+	a := &AsyncMailer{
+		mailer: mailer,
+		queue:  make(chan Message, queueSize),
+		done:   make(chan struct{}),
+	}
+
+	go a.work()
+
+	return a
+}
+
+func (a *AsyncMailer) work() {
+	defer close(a.done)
+
+	for message := range a.queue {
+		if err := a.mailer.Send(message); err != nil {
+			log.Printf("mail delivery error: %s", err)
+		}
+	}
+}
+
+func (a *AsyncMailer) Send(message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return ErrMailerClosed
+	}
+
+	select {
+	case a.queue <- message:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Ping fails once closed, otherwise it checks the underlying mailer when it is a Pinger
+func (a *AsyncMailer) Ping(ctx context.Context) error {
+	a.mu.RLock()
+	closed := a.closed
+	a.mu.RUnlock()
+
+	if closed {
+		return ErrMailerClosed
+	}
+
+	if pinger, ok := a.mailer.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return ctx.Err()
+}
+
+// Close stops accepting messages and waits for the queued ones to be delivered.
+func (a *AsyncMailer) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file in Dir. Meant for local development.
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{Dir: dir}
+}
+
+func (f *FileMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405") + "-" + id[:8] + ".eml"
+
+	return os.WriteFile(filepath.Join(f.Dir, name), data, 0o644)
+}
+
+// Ping checks that Dir is a directory. A missing one is fine, Send creates it.
+func (f *FileMailer) Ping(ctx context.Context) error {
+	info, err := os.Stat(f.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", f.Dir)
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,161 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoRecipients = errors.New("message has no recipients")
+	ErrEmptyMessage = errors.New("message has no body")
+)
+
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Pinger is implemented by mailers able to tell whether messages can be delivered, for readiness checks
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func generateID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (m Message) validate() error {
+	if len(m.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	if m.Text == "" && m.HTML == "" {
+		return ErrEmptyMessage
+	}
+
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func writeQuotedPrintable(writer *multipart.Writer, contentType, body string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(body)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// Bytes renders the message in the RFC 5322 format, as a multipart/alternative
+// message when both the text and HTML bodies are set.
+func (m Message) Bytes() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	from, _ := mail.ParseAddress(m.From)
+	domain := "localhost"
+	if at := strings.LastIndex(from.Address, "@"); at != -1 {
+		domain = from.Address[at+1:]
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bytes.Buffer{}
+	header := func(name, value string) {
+		buffer.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", from.String())
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+id+"@"+domain+">")
+	header("MIME-Version", "1.0")
+
+	if m.Text == "" || m.HTML == "" {
+		contentType, body := "text/plain", m.Text
+		if m.Text == "" {
+			contentType, body = "text/html", m.HTML
+		}
+
+		header("Content-Type", contentType+"; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buffer.WriteString("\r\n")
+
+		qp := quotedprintable.NewWriter(&buffer)
+		if _, err = qp.Write([]byte(body)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+
+		return buffer.Bytes(), nil
+	}
+
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+
+	header("Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+	buffer.WriteString("\r\n")
+
+	// the preferred alternative comes last
+	if err = writeQuotedPrintable(writer, "text/plain", m.Text); err != nil {
+		return nil, err
+	}
+
+	if err = writeQuotedPrintable(writer, "text/html", m.HTML); err != nil {
+		return nil, err
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	buffer.Write(body.Bytes())
+
+	return buffer.Bytes(), nil
+}
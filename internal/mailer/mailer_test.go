@@ -0,0 +1,242 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+)
+
+func parseMessage(t testing.TB, data []byte) (*mail.Message, map[string]string) {
+	t.Helper()
+	message, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("message is not valid RFC 5322: %s", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("invalid content type: %s", err)
+	}
+
+	parts := map[string]string{}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(message.Body)
+		parts[mediaType] = string(body)
+		return message, parts
+	}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid multipart body: %s", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// multipart.Reader transparently decodes quoted-printable parts
+		body, _ := io.ReadAll(part)
+		parts[partType] = string(body)
+	}
+
+	return message, parts
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	t.Run("multipart alternative", func(t *testing.T) {
+		data, err := Message{
+			From:    "Auth Test <no-reply@example.com>",
+			To:      []string{"user@example.com"},
+			Subject: "Réinitialisation",
+			Text:    "plain body with a long line that needs to be wrapped by the quoted printable encoder once it goes over 76 characters",
+			HTML:    "<p>html body</p>",
+		}.Bytes()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		message, parts := parseMessage(t, data)
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+		if err != nil || subject != "Réinitialisation" {
+			t.Errorf("unexpected subject. got: %s (%v)", subject, err)
+		}
+
+		if !strings.HasSuffix(message.Header.Get("Message-ID"), "@example.com>") {
+			t.Errorf("unexpected Message-ID: %s", message.Header.Get("Message-ID"))
+		}
+
+		if !strings.HasPrefix(parts["text/plain"], "plain body") || !strings.HasSuffix(parts["text/plain"], "76 characters") {
+			t.Errorf("unexpected text part: %q", parts["text/plain"])
+		}
+
+		if parts["text/html"] != "<p>html body</p>" {
+			t.Errorf("unexpected html part: %q", parts["text/html"])
+		}
+	})
+
+	t.Run("single part", func(t *testing.T) {
+		data, err := Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Text: "plain"}.Bytes()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, parts := parseMessage(t, data)
+		if parts["text/plain"] != "plain" {
+			t.Errorf("unexpected body: %v", parts)
+		}
+	})
+
+	t.Run("invalid messages", func(t *testing.T) {
+		testCases := []struct {
+			name    string
+			message Message
+		}{
+			{"no recipients", Message{From: "no-reply@example.com", Text: "body"}},
+			{"no body", Message{From: "no-reply@example.com", To: []string{"user@example.com"}}},
+			{"invalid sender", Message{From: "invalid", To: []string{"user@example.com"}, Text: "body"}},
+			{"invalid recipient", Message{From: "no-reply@example.com", To: []string{"invalid"}, Text: "body"}},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if _, err := tc.message.Bytes(); err == nil {
+					t.Errorf("expected error but none were returned")
+				}
+			})
+		}
+	})
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := NewFileMailer(dir)
+
+	err := mailer.Send(Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Text: "body"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file. got: %v (%v)", files, err)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("unexpected error while reading message: %s", err)
+	}
+
+	message, _ := parseMessage(t, data)
+	if message.Header.Get("To") != "user@example.com" {
+		t.Errorf("unexpected recipient: %s", message.Header.Get("To"))
+	}
+}
+
+func TestFileMailer_Ping(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := NewFileMailer(filepath.Join(dir, "mail")).Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error for a missing directory: %s", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := NewFileMailer(file).Ping(context.Background()); err == nil {
+		t.Errorf("expected an error when the path is a file")
+	}
+}
+
+func TestMemoryMailer_Send(t *testing.T) {
+	mailer := NewMemoryMailer()
+
+	if err := mailer.Send(Message{From: "no-reply@example.com", Text: "body"}); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrNoRecipients, err)
+	}
+
+	if err := mailer.Send(Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Text: "body"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(mailer.Messages()) != 1 {
+		t.Errorf("unexpected amount of messages. expected: 1. got: %d", len(mailer.Messages()))
+	}
+
+	mailer.Reset()
+	if len(mailer.Messages()) != 0 {
+		t.Errorf("messages were not reset")
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	html := htmltemplate.Must(htmltemplate.New("emails").Parse(
+		`{{define "password_reset.html"}}<a href="{{.Link}}">reset</a>{{end}}`))
+	text := texttemplate.Must(texttemplate.New("emails").Parse(
+		`{{define "password_reset.subject"}} Reset {{end}}{{define "password_reset.text"}}{{.Link}}{{end}}`))
+
+	mailer := NewMemoryMailer()
+	notifier := NewNotifier(mailer, NewTemplates(html, text), "no-reply@example.com", "https://example.com/")
+
+	if err := notifier.PasswordReset("user@example.com", "/password/reset?token=abc"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("unexpected amount of messages. expected: 1. got: %d", len(messages))
+	}
+
+	message := messages[0]
+	if message.Subject != "Reset" {
+		t.Errorf("unexpected subject: %q", message.Subject)
+	}
+
+	link := "https://example.com/password/reset?token=abc"
+	if strings.TrimSpace(message.Text) != link {
+		t.Errorf("unexpected text body: %q", message.Text)
+	}
+
+	if !strings.Contains(message.HTML, link) {
+		t.Errorf("html body does not contain the link: %q", message.HTML)
+	}
+}
+
+func TestAsyncMailer(t *testing.T) {
+	recorder := NewMemoryMailer()
+	mailer := NewAsyncMailer(recorder, 10)
+
+	for i := 0; i < 3; i++ {
+		if err := mailer.Send(Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Text: "body"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	mailer.Close()
+
+	if len(recorder.Messages()) != 3 {
+		t.Errorf("queued messages were not delivered on close. got: %d", len(recorder.Messages()))
+	}
+
+	err := mailer.Send(Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Text: "body"})
+	if !errors.Is(err, ErrMailerClosed) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrMailerClosed, err)
+	}
+
+	if err = mailer.Ping(context.Background()); !errors.Is(err, ErrMailerClosed) {
+		t.Errorf("unexpected ping error. expected: %s. got: %v", ErrMailerClosed, err)
+	}
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer records sent messages. Meant for tests.
+type MemoryMailer struct {
+	messages []Message
+	mu       sync.RWMutex
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+
+	return messages
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
+
+func (m *MemoryMailer) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import "strings"
+
+// Notifier sends the application's emails, turning relative links into absolute ones.
+type Notifier struct {
+	mailer    Mailer
+	templates *Templates
+	from      string
+	baseURL   string
+}
+
+func NewNotifier(mailer Mailer, templates *Templates, from, baseURL string) *Notifier {
+	return &Notifier{
+		mailer:    mailer,
+		templates: templates,
+		from:      from,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (n *Notifier) send(to, name string, data map[string]any) error {
+	message, err := n.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+
+	message.From = n.from
+	message.To = []string{to}
+
+	return n.mailer.Send(message)
+}
+
+func (n *Notifier) PasswordReset(to, path string) error {
+	return n.send(to, "password_reset", map[string]any{
+		"Email": to,
+		"Link":  n.baseURL + path,
+	})
+}
+
+func (n *Notifier) EmailConfirmation(to, path string) error {
+	return n.send(to, "email_confirmation", map[string]any{
+		"Email": to,
+		"Link":  n.baseURL + path,
+	})
+}
+
+// AccountExists warns the owner of an email address that someone tried to register with it.
+func (n *Notifier) AccountExists(to string) error {
+	return n.send(to, "account_exists", map[string]any{
+		"Email":         to,
+		"LoginLink":     n.baseURL + "/login",
+		"ResetPassword": n.baseURL + "/password/forgot",
+	})
+}
@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+var ErrStartTLSUnsupported = errors.New("smtp server does not support STARTTLS")
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// AllowInsecure permits sending without STARTTLS, e.g. to a local mail catcher
+	AllowInsecure bool
+}
+
+type SMTPMailer struct {
+	config    SMTPConfig
+	tlsConfig *tls.Config
+}
+
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		config:    config,
+		tlsConfig: &tls.Config{ServerName: config.Host},
+	}
+}
+
+// Ping connects to the server and waits for its greeting, nothing is sent
+func (s *SMTPMailer) Ping(ctx context.Context) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.config.Host, s.config.Port))
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	return client.Quit()
+}
+
+func (s *SMTPMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	from, _ := mail.ParseAddress(message.From)
+
+	client, err := smtp.Dial(net.JoinHostPort(s.config.Host, s.config.Port))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Hello("localhost"); err != nil {
+		return err
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(s.tlsConfig); err != nil {
+			return err
+		}
+	} else if !s.config.AllowInsecure {
+		return ErrStartTLSUnsupported
+	}
+
+	if s.config.Username != "" {
+		auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(from.Address); err != nil {
+		return err
+	}
+
+	for _, to := range message.To {
+		address, _ := mail.ParseAddress(to)
+		if err = client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		return err
+	}
+
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// startFakeSMTPServer accepts a single plaintext SMTP session and returns the received DATA
+func startFakeSMTPServer(t testing.TB) (string, string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake smtp server: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO":
+				_ = text.PrintfLine("250-localhost")
+				_ = text.PrintfLine("250 8BITMIME")
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				received <- string(data)
+				_ = text.PrintfLine("250 ok")
+			case "QUIT":
+				_ = text.PrintfLine("221 bye")
+				return
+			default:
+				_ = text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, received
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	message := Message{From: "no-reply@example.com", To: []string{"user@example.com"}, Subject: "hello", Text: "body"}
+
+	t.Run("require STARTTLS", func(t *testing.T) {
+		host, port, _ := startFakeSMTPServer(t)
+		mailer := NewSMTPMailer(SMTPConfig{Host: host, Port: port})
+
+		if err := mailer.Send(message); !errors.Is(err, ErrStartTLSUnsupported) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrStartTLSUnsupported, err)
+		}
+	})
+
+	t.Run("insecure delivery when allowed", func(t *testing.T) {
+		host, port, received := startFakeSMTPServer(t)
+		mailer := NewSMTPMailer(SMTPConfig{Host: host, Port: port, AllowInsecure: true})
+
+		if err := mailer.Send(message); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		data := <-received
+		parsed, _ := parseMessage(t, []byte(data))
+		if parsed.Header.Get("Subject") != "hello" {
+			t.Errorf("unexpected message received: %s", data)
+		}
+	})
+}
+
+func TestSMTPMailer_Ping(t *testing.T) {
+	t.Run("reachable", func(t *testing.T) {
+		host, port, _ := startFakeSMTPServer(t)
+		mailer := NewSMTPMailer(SMTPConfig{Host: host, Port: port})
+
+		if err := mailer.Ping(context.Background()); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to reserve a port: %s", err)
+		}
+		host, port, _ := net.SplitHostPort(listener.Addr().String())
+		_ = listener.Close()
+
+		mailer := NewSMTPMailer(SMTPConfig{Host: host, Port: port})
+		if err := mailer.Ping(context.Background()); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates renders emails. Each email named "x" is made of three templates:
+// "x.subject" and "x.text" in the text set, and "x.html" in the HTML set.
+type Templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+func NewTemplates(html *htmltemplate.Template, text *texttemplate.Template) *Templates {
+	return &Templates{html: html, text: text}
+}
+
+func (t *Templates) Render(name string, data any) (Message, error) {
+	subject := bytes.Buffer{}
+	if err := t.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return Message{}, err
+	}
+
+	text := bytes.Buffer{}
+	if err := t.text.ExecuteTemplate(&text, name+".text", data); err != nil {
+		return Message{}, err
+	}
+
+	html := bytes.Buffer{}
+	if err := t.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
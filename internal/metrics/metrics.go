@@ -0,0 +1,357 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit latencies in seconds, from a millisecond to ten seconds
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// collector writes every series of a metric in the Prometheus text format
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics exposed by Handler, names must be unique.
+// Metrics without labels are exposed from the start, labelled series once they are first updated.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+
+	r.collectors = append(r.collectors, c)
+	slices.SortFunc(r.collectors, func(a, b collector) int {
+		return strings.Compare(a.name(), b.name())
+	})
+}
+
+// WriteText writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteText(writer io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w := bufio.NewWriter(writer)
+	for _, c := range r.collectors {
+		c.write(w)
+	}
+
+	return w.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		_ = r.WriteText(w)
+	})
+}
+
+// Since returns the seconds elapsed since start, for histograms
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// desc holds what every metric type shares, series are keyed by their label values
+type desc struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, "\xff")
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, d.metricType)
+}
+
+// labels formats the label pairs, extra is appended as is, such as the le label of histogram buckets
+func (d desc) labels(labelValues []string, extra string) string {
+	if len(labelValues) == 0 && extra == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range d.labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labelValues[i]))
+		b.WriteByte('"')
+	}
+
+	if extra != "" {
+		if len(labelValues) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// series keeps the label values next to the value so they can be written back in order
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// Counter only goes up, such as the number of logins
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{desc: desc{metricName: name, help: help, metricType: "counter", labelNames: labelNames}, series: map[string]*series{}}
+	if len(labelNames) == 0 {
+		c.series[""] = &series{}
+	}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add panics on negative values, counters cannot go down
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		c.series[key] = s
+	}
+	s.value += value
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	writeSeries(w, c.desc, c.series)
+}
+
+// Gauge goes up and down, such as the number of active sessions
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help, metricType: "gauge", labelNames: labelNames}, series: map[string]*series{}}
+	if len(labelNames) == 0 {
+		g.series[""] = &series{}
+	}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(current float64) float64 { return value })
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.update(labelValues, func(current float64) float64 { return current + value })
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) update(labelValues []string, next func(current float64) float64) {
+	key := g.key(labelValues)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		g.series[key] = s
+	}
+	s.value = next(s.value)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(w)
+	writeSeries(w, g.desc, g.series)
+}
+
+func writeSeries(w *bufio.Writer, d desc, all map[string]*series) {
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := all[key]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", d.metricName, d.labels(s.labelValues, ""), formatValue(s.value))
+	}
+}
+
+// Histogram counts observations in cumulative buckets, such as request latencies
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram uses DefaultBuckets when buckets is empty, bucket bounds are sorted
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	h := &Histogram{
+		desc:    desc{metricName: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	if len(labelNames) == 0 {
+		h.series[""] = &histogramSeries{counts: make([]uint64, len(buckets))}
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(s.labelValues, `le="`+formatValue(bound)+`"`), s.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(s.labelValues, `le="+Inf"`), s.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels(s.labelValues, ""), formatValue(s.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels(s.labelValues, ""), s.count)
+	}
+}
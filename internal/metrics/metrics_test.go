@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	logins := registry.NewCounter("auth_logins_total", "Login attempts.", "result")
+	sessions := registry.NewGauge("auth_sessions_active", "Active sessions.")
+	latency := registry.NewHistogram("auth_login_duration_seconds", "Login latency.", []float64{0.5, 0.1})
+
+	logins.Inc("success")
+	logins.Add(2, "failure")
+	logins.Inc(`say "hi"`)
+	sessions.Inc()
+	sessions.Inc()
+	sessions.Dec()
+	latency.Observe(0.05)
+	latency.Observe(0.3)
+	latency.Observe(2)
+
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `# HELP auth_login_duration_seconds Login latency.
+# TYPE auth_login_duration_seconds histogram
+auth_login_duration_seconds_bucket{le="0.1"} 1
+auth_login_duration_seconds_bucket{le="0.5"} 2
+auth_login_duration_seconds_bucket{le="+Inf"} 3
+auth_login_duration_seconds_sum 2.35
+auth_login_duration_seconds_count 3
+# HELP auth_logins_total Login attempts.
+# TYPE auth_logins_total counter
+auth_logins_total{result="failure"} 2
+auth_logins_total{result="say \"hi\""} 1
+auth_logins_total{result="success"} 1
+# HELP auth_sessions_active Active sessions.
+# TYPE auth_sessions_active gauge
+auth_sessions_active 1
+`
+	if output.String() != expected {
+		t.Errorf("unexpected output. expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "Test.").Inc()
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := response.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("unexpected content type. got: %q", contentType)
+	}
+
+	if !strings.Contains(response.Body.String(), "test_total 1\n") {
+		t.Errorf("counter missing from the output. got: %q", response.Body.String())
+	}
+}
+
+func TestCounter_LabelMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic on missing label values")
+		}
+	}()
+
+	NewRegistry().NewCounter("test_total", "Test.", "result").Inc()
+}
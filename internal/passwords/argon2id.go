@@ -0,0 +1,96 @@
+package passwords
+
+import (
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strconv"
+	"strings"
+)
+
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2id hashes passwords with argon2id, encoded as $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$salt$hash
+type Argon2id struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (a Argon2id) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt, err := generateSalt(a.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", a.Memory, a.Iterations, a.Parallelism)
+
+	return encodePHC("argon2id", "v="+strconv.Itoa(argon2.Version)+"$"+params, salt, hash), nil
+}
+
+func (a Argon2id) decode(encoded string) (*phcHash, Argon2id, error) {
+	decoded, err := decodePHC(encoded)
+	if err != nil || decoded.id != "argon2id" || decoded.version != strconv.Itoa(argon2.Version) {
+		return nil, Argon2id{}, ErrInvalidHash
+	}
+
+	memory, err := decoded.uintParam("m", 32)
+	if err != nil {
+		return nil, Argon2id{}, err
+	}
+
+	iterations, err := decoded.uintParam("t", 32)
+	if err != nil {
+		return nil, Argon2id{}, err
+	}
+
+	parallelism, err := decoded.uintParam("p", 8)
+	if err != nil || parallelism == 0 {
+		return nil, Argon2id{}, ErrInvalidHash
+	}
+
+	return decoded, Argon2id{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  uint32(len(decoded.salt)),
+		KeyLength:   uint32(len(decoded.hash)),
+	}, nil
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	decoded, params, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	hash := argon2.IDKey([]byte(password), decoded.salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return constantTimeEqual(hash, decoded.hash), nil
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	_, params, err := a.decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < a.Memory ||
+		params.Iterations < a.Iterations ||
+		params.Parallelism < a.Parallelism ||
+		params.SaltLength < a.SaltLength ||
+		params.KeyLength < a.KeyLength
+}
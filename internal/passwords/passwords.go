@@ -0,0 +1,154 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrInvalidHash      = errors.New("invalid password hash")
+)
+
+// Hasher produces and verifies encoded password hashes in the PHC string format
+// ($id$params$salt$hash), or the modular crypt format for bcrypt.
+type Hasher interface {
+	// Matches reports whether encoded was produced by this algorithm
+	Matches(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded uses weaker parameters than the hasher's
+	NeedsRehash(encoded string) bool
+}
+
+// Manager hashes new passwords with the current hasher and verifies hashes made by any known hasher.
+type Manager struct {
+	current Hasher
+	hashers []Hasher
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func NewManager(current Hasher, legacy ...Hasher) *Manager {
+	return &Manager{
+		current: current,
+		hashers: append([]Hasher{current}, legacy...),
+	}
+}
+
+// DefaultManager hashes with argon2id and still accepts bcrypt and scrypt hashes.
+func DefaultManager() *Manager {
+	return NewManager(DefaultArgon2id, DefaultBcrypt, DefaultScrypt)
+}
+
+func (m *Manager) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Verify checks password against encoded. needsRehash is true when the password matched
+// but encoded was made with another algorithm or weaker parameters than the current hasher.
+func (m *Manager) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, hasher := range m.hashers {
+		if !hasher.Matches(encoded) {
+			continue
+		}
+
+		ok, err = hasher.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, hasher != m.current || m.current.NeedsRehash(encoded), nil
+	}
+
+	return false, false, ErrUnknownAlgorithm
+}
+
+// VerifyDummy does the same amount of work as Verify against a hash made by the current hasher.
+// Used when there is no hash to compare against, to keep timings consistent.
+func (m *Manager) VerifyDummy(password string) {
+	m.dummyOnce.Do(func() {
+		secret := make([]byte, 32)
+		_, _ = rand.Read(secret)
+		m.dummy, _ = m.current.Hash(base64.RawStdEncoding.EncodeToString(secret))
+	})
+
+	_, _ = m.current.Verify(password, m.dummy)
+}
+
+func generateSalt(length uint32) ([]byte, error) {
+	salt := make([]byte, length)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func encodePHC(id string, params string, salt, hash []byte) string {
+	return "$" + id + "$" + params + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(hash)
+}
+
+type phcHash struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+// decodePHC parses $id[$v=version]$params$salt$hash
+func decodePHC(encoded string) (*phcHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 && len(parts) != 6 || parts[0] != "" {
+		return nil, ErrInvalidHash
+	}
+
+	decoded := phcHash{id: parts[1], params: map[string]string{}}
+	rest := parts[2:]
+	if len(parts) == 6 {
+		if !strings.HasPrefix(parts[2], "v=") {
+			return nil, ErrInvalidHash
+		}
+		decoded.version = strings.TrimPrefix(parts[2], "v=")
+		rest = parts[3:]
+	}
+
+	for _, param := range strings.Split(rest[0], ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, ErrInvalidHash
+		}
+		decoded.params[key] = value
+	}
+
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(rest[1]); err != nil {
+		return nil, ErrInvalidHash
+	}
+
+	if decoded.hash, err = base64.RawStdEncoding.DecodeString(rest[2]); err != nil || len(decoded.hash) == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	return &decoded, nil
+}
+
+func (p *phcHash) uintParam(name string, bits int) (uint64, error) {
+	value, err := strconv.ParseUint(p.params[name], 10, bits)
+	if err != nil {
+		return 0, ErrInvalidHash
+	}
+	return value, nil
+}
+
+func constantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
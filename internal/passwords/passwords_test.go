@@ -0,0 +1,156 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	testArgon2id = Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testScrypt   = Scrypt{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = Bcrypt{Cost: 4}
+)
+
+func TestHashers(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hasher Hasher
+		prefix string
+	}{
+		{"argon2id", testArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"scrypt", testScrypt, "$scrypt$ln=4,r=8,p=1$"},
+		{"bcrypt", testBcrypt, "$2a$04$"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := tc.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !strings.HasPrefix(encoded, tc.prefix) {
+				t.Errorf("unexpected encoding. expected prefix: %s. got: %s", tc.prefix, encoded)
+			}
+
+			if !tc.hasher.Matches(encoded) {
+				t.Errorf("hasher does not recognise its own hash")
+			}
+
+			other, err := tc.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if other == encoded {
+				t.Errorf("hashes of the same password should be salted")
+			}
+
+			if ok, err := tc.hasher.Verify("correct horse", encoded); err != nil || !ok {
+				t.Errorf("valid password rejected. ok: %t. err: %v", ok, err)
+			}
+
+			if ok, err := tc.hasher.Verify("wrong horse", encoded); err != nil || ok {
+				t.Errorf("invalid password accepted. ok: %t. err: %v", ok, err)
+			}
+
+			if tc.hasher.NeedsRehash(encoded) {
+				t.Errorf("hash made with current parameters should not need a rehash")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Run("argon2id", func(t *testing.T) {
+		encoded, _ := testArgon2id.Hash("password")
+		stronger := testArgon2id
+		stronger.Iterations = 2
+
+		if !stronger.NeedsRehash(encoded) {
+			t.Errorf("weaker iterations should need a rehash")
+		}
+	})
+
+	t.Run("scrypt", func(t *testing.T) {
+		encoded, _ := testScrypt.Hash("password")
+		stronger := testScrypt
+		stronger.LogN = 5
+
+		if !stronger.NeedsRehash(encoded) {
+			t.Errorf("weaker cost should need a rehash")
+		}
+	})
+
+	t.Run("bcrypt", func(t *testing.T) {
+		encoded, _ := testBcrypt.Hash("password")
+
+		if !(Bcrypt{Cost: 5}).NeedsRehash(encoded) {
+			t.Errorf("weaker cost should need a rehash")
+		}
+	})
+}
+
+func TestInvalidHashes(t *testing.T) {
+	testCases := []struct {
+		name    string
+		hasher  Hasher
+		encoded string
+	}{
+		{"argon2id missing parts", testArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA"},
+		{"argon2id wrong version", testArgon2id, "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA"},
+		{"argon2id bad params", testArgon2id, "$argon2id$v=19$m=abc,t=1,p=1$c2FsdA$aGFzaA"},
+		{"argon2id bad salt", testArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA"},
+		{"scrypt bad cost", testScrypt, "$scrypt$ln=99,r=8,p=1$c2FsdA$aGFzaA"},
+		{"bcrypt truncated", testBcrypt, "$2a$04$short"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, err := tc.hasher.Verify("password", tc.encoded)
+			if ok || !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("unexpected result. ok: %t. err: %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestManager_Verify(t *testing.T) {
+	manager := NewManager(testArgon2id, testScrypt, testBcrypt)
+
+	current, _ := testArgon2id.Hash("password")
+	weaker, _ := Argon2id{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("password")
+	legacy, _ := testBcrypt.Hash("password")
+	scrypted, _ := testScrypt.Hash("password")
+
+	testCases := []struct {
+		name        string
+		password    string
+		encoded     string
+		ok          bool
+		needsRehash bool
+		err         error
+	}{
+		{"current", "password", current, true, false, nil},
+		{"current wrong password", "wrong", current, false, false, nil},
+		{"weaker parameters", "password", weaker, true, true, nil},
+		{"legacy bcrypt", "password", legacy, true, true, nil},
+		{"legacy scrypt", "password", scrypted, true, true, nil},
+		{"legacy wrong password", "wrong", legacy, false, false, nil},
+		{"unknown algorithm", "password", "$md5$abc", false, false, ErrUnknownAlgorithm},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, needsRehash, err := manager.Verify(tc.password, tc.encoded)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+
+			if ok != tc.ok || needsRehash != tc.needsRehash {
+				t.Errorf("unexpected result. expected: %t, %t. got: %t, %t", tc.ok, tc.needsRehash, ok, needsRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package passwords
+
+import (
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+)
+
+var DefaultScrypt = Scrypt{
+	LogN:       15,
+	R:          8,
+	P:          1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// Scrypt hashes passwords with scrypt, encoded as $scrypt$ln=<log2 N>,r=<r>,p=<p>$salt$hash
+type Scrypt struct {
+	// LogN is the base 2 logarithm of the CPU/memory cost parameter N
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+func (s Scrypt) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (s Scrypt) Hash(password string) (string, error) {
+	salt, err := generateSalt(s.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := scrypt.Key([]byte(password), salt, 1<<s.LogN, s.R, s.P, int(s.KeyLength))
+	if err != nil {
+		return "", err
+	}
+
+	return encodePHC("scrypt", fmt.Sprintf("ln=%d,r=%d,p=%d", s.LogN, s.R, s.P), salt, hash), nil
+}
+
+func (s Scrypt) decode(encoded string) (*phcHash, Scrypt, error) {
+	decoded, err := decodePHC(encoded)
+	if err != nil || decoded.id != "scrypt" || decoded.version != "" {
+		return nil, Scrypt{}, ErrInvalidHash
+	}
+
+	logN, err := decoded.uintParam("ln", 8)
+	if err != nil || logN == 0 || logN > 30 {
+		return nil, Scrypt{}, ErrInvalidHash
+	}
+
+	r, err := decoded.uintParam("r", 31)
+	if err != nil {
+		return nil, Scrypt{}, err
+	}
+
+	p, err := decoded.uintParam("p", 31)
+	if err != nil {
+		return nil, Scrypt{}, err
+	}
+
+	return decoded, Scrypt{
+		LogN:       uint8(logN),
+		R:          int(r),
+		P:          int(p),
+		SaltLength: uint32(len(decoded.salt)),
+		KeyLength:  uint32(len(decoded.hash)),
+	}, nil
+}
+
+func (s Scrypt) Verify(password, encoded string) (bool, error) {
+	decoded, params, err := s.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := scrypt.Key([]byte(password), decoded.salt, 1<<params.LogN, params.R, params.P, int(params.KeyLength))
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+
+	return constantTimeEqual(hash, decoded.hash), nil
+}
+
+func (s Scrypt) NeedsRehash(encoded string) bool {
+	_, params, err := s.decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.LogN < s.LogN ||
+		params.R < s.R ||
+		params.P < s.P ||
+		params.SaltLength < s.SaltLength ||
+		params.KeyLength < s.KeyLength
+}
@@ -0,0 +1,480 @@
+// Package qrcode encodes short strings as QR codes (ISO/IEC 18004) in byte mode with medium error correction.
+// Versions 1 to 20 are supported, which is plenty for otpauth URIs.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrDataTooLong = errors.New("data too long for a QR code")
+
+// Code is a square grid of modules, true being dark.
+type Code struct {
+	Version int
+	Size    int
+	modules [][]bool
+	// function marks modules that are part of patterns rather than data
+	function [][]bool
+}
+
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// blockLayout describes the error correction of a version at level M
+type blockLayout struct {
+	ecPerBlock  int
+	group1      int
+	group1Data  int
+	group2      int
+	group2Data  int
+	alignCenter []int
+}
+
+var layouts = [...]blockLayout{
+	1:  {10, 1, 16, 0, 0, nil},
+	2:  {16, 1, 28, 0, 0, []int{6, 18}},
+	3:  {26, 1, 44, 0, 0, []int{6, 22}},
+	4:  {18, 2, 32, 0, 0, []int{6, 26}},
+	5:  {24, 2, 43, 0, 0, []int{6, 30}},
+	6:  {16, 4, 27, 0, 0, []int{6, 34}},
+	7:  {18, 4, 31, 0, 0, []int{6, 22, 38}},
+	8:  {22, 2, 38, 2, 39, []int{6, 24, 42}},
+	9:  {22, 3, 36, 2, 37, []int{6, 26, 46}},
+	10: {26, 4, 43, 1, 44, []int{6, 28, 50}},
+	11: {30, 1, 50, 4, 51, []int{6, 30, 54}},
+	12: {22, 6, 36, 2, 37, []int{6, 32, 58}},
+	13: {22, 8, 37, 1, 38, []int{6, 34, 62}},
+	14: {24, 4, 40, 5, 41, []int{6, 26, 46, 66}},
+	15: {24, 5, 41, 5, 42, []int{6, 26, 48, 70}},
+	16: {28, 7, 45, 3, 46, []int{6, 26, 50, 74}},
+	17: {28, 10, 46, 1, 47, []int{6, 30, 54, 78}},
+	18: {26, 9, 43, 4, 44, []int{6, 30, 56, 82}},
+	19: {26, 3, 44, 11, 45, []int{6, 30, 58, 86}},
+	20: {26, 3, 41, 13, 42, []int{6, 34, 62, 90}},
+}
+
+const maxVersion = len(layouts) - 1
+
+func (l blockLayout) dataCodewords() int {
+	return l.group1*l.group1Data + l.group2*l.group2Data
+}
+
+// rawDataModules is the number of modules available for data and error correction codewords
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		alignments := version/7 + 2
+		result -= (25*alignments-10)*alignments - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+
+	return result
+}
+
+// Encode returns the smallest QR code holding data.
+func Encode(data string) (*Code, error) {
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		if bitLength(v, len(data)) <= layouts[v].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, ErrDataTooLong
+	}
+
+	code := newCode(version)
+	code.drawFunctionPatterns()
+	code.drawCodewords(code.addErrorCorrection(encodeData(version, []byte(data))))
+	code.applyBestMask()
+
+	return code, nil
+}
+
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func bitLength(version, length int) int {
+	return 4 + countBits(version) + length*8
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+// encodeData builds the data codewords: byte mode indicator, length, data, terminator and padding
+func encodeData(version int, data []byte) []byte {
+	capacity := layouts[version].dataCodewords() * 8
+
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	return codewords
+}
+
+// addErrorCorrection splits the data into blocks, computes their error correction and interleaves everything
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	layout := layouts[c.Version]
+	divisor := reedSolomonDivisor(layout.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < layout.group1+layout.group2; i++ {
+		length := layout.group1Data
+		if i >= layout.group1 {
+			length = layout.group2Data
+		}
+
+		block := data[offset : offset+length]
+		offset += length
+
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i < max(layout.group1Data, layout.group2Data); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	code := &Code{Version: version, Size: size}
+	code.modules = make([][]bool, size)
+	code.function = make([][]bool, size)
+	for i := range code.modules {
+		code.modules[i] = make([]bool, size)
+		code.function[i] = make([]bool, size)
+	}
+
+	return code
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	centers := layouts[c.Version].alignCenter
+	last := len(centers) - 1
+	for i, x := range centers {
+		for j, y := range centers {
+			// skip the corners taken by finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// reserve the format areas, drawn for real once the mask is known
+	c.drawFormat(0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern centered on x, y along with its separator
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.Size || yy < 0 || yy >= c.Size {
+				continue
+			}
+
+			distance := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, distance != 2 && distance != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// formatBits returns the BCH encoded error correction level (M) and mask
+func formatBits(mask int) int {
+	data := 0b00<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = remainder<<1 ^ (remainder>>9)*0x537
+	}
+
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool {
+		return (bits>>i)&1 == 1
+	}
+
+	// around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// split between the top right and bottom left finders
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+
+	// always dark
+	c.setFunction(8, c.Size-8, true)
+}
+
+// versionBits returns the BCH encoded version, only drawn from version 7
+func versionBits(version int) int {
+	remainder := version
+	for i := 0; i < 12; i++ {
+		remainder = remainder<<1 ^ (remainder>>11)*0x1F25
+	}
+
+	return version<<12 | remainder
+}
+
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag pattern, two columns at a time from the bottom right
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern is skipped entirely
+		if right == 6 {
+			right = 5
+		}
+
+		for vertical := 0; vertical < c.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vertical
+				}
+
+				if c.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+
+				c.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+var masks = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// applyMask flips data modules, applying it twice restores the original
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y][x] && masks[mask](x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+func (c *Code) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := range masks {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask)
+	}
+
+	c.applyMask(best)
+	c.drawFormat(best)
+}
+
+// penalty scores how hard the code is to scan, following the four rules of the specification
+func (c *Code) penalty() int {
+	penalty := 0
+	dark := 0
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+
+			// blocks of 2x2 modules of the same color
+			if x < c.Size-1 && y < c.Size-1 {
+				m := c.modules[y][x]
+				if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	for i := 0; i < c.Size; i++ {
+		penalty += linePenalty(c.Size, func(j int) bool { return c.modules[i][j] })
+		penalty += linePenalty(c.Size, func(j int) bool { return c.modules[j][i] })
+	}
+
+	total := c.Size * c.Size
+	deviation := abs(dark*20-total*10) / total
+	penalty += deviation * 10
+
+	return penalty
+}
+
+// finderLike matches dark-light-dark-dark-dark-light-dark preceded or followed by 4 light modules
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func linePenalty(size int, module func(i int) bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= size; i++ {
+		if i < size && module(i) == module(i-1) {
+			run++
+			continue
+		}
+
+		if run >= 5 {
+			penalty += 3 + run - 5
+		}
+		run = 1
+	}
+
+	for i := 0; i+11 <= size; i++ {
+		for _, pattern := range finderLike {
+			matched := true
+			for j, dark := range pattern {
+				if module(i+j) != dark {
+					matched = false
+					break
+				}
+			}
+
+			if matched {
+				penalty += 40
+			}
+		}
+	}
+
+	return penalty
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// SVG renders the code with a 4 module quiet zone, each module being scale pixels wide.
+func (c *Code) SVG(scale int) string {
+	const quietZone = 4
+	dimension := (c.Size + quietZone*2) * scale
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		dimension, dimension, c.Size+quietZone*2, c.Size+quietZone*2, path.String())
+}
@@ -0,0 +1,118 @@
+package qrcode
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLayouts(t *testing.T) {
+	for version := 1; version <= maxVersion; version++ {
+		layout := layouts[version]
+		codewords := layout.dataCodewords() + layout.ecPerBlock*(layout.group1+layout.group2)
+
+		if codewords != rawDataModules(version)/8 {
+			t.Errorf("version %d: codewords do not fill the symbol. expected: %d. got: %d", version, rawDataModules(version)/8, codewords)
+		}
+	}
+}
+
+// example from the thonky.com QR code tutorial, "HELLO WORLD" at 1-M
+func TestReedSolomonRemainder(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if ec := reedSolomonRemainder(data, reedSolomonDivisor(10)); !slices.Equal(ec, expected) {
+		t.Errorf("unexpected error correction. expected: %v. got: %v", expected, ec)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	testCases := []struct {
+		mask int
+		bits int
+	}{
+		{0, 0b101010000010010},
+		{1, 0b101000100100101},
+		{4, 0b100010111111001},
+		{7, 0b100101010100000},
+	}
+
+	for _, tc := range testCases {
+		if bits := formatBits(tc.mask); bits != tc.bits {
+			t.Errorf("mask %d: expected: %015b. got: %015b", tc.mask, tc.bits, bits)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	if bits := versionBits(7); bits != 0b000111110010010100 {
+		t.Errorf("unexpected version bits: %018b", bits)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	t.Run("smallest version", func(t *testing.T) {
+		testCases := []struct {
+			length  int
+			version int
+		}{
+			{1, 1},
+			{14, 1},
+			{15, 2},
+			{213, 10},
+			{214, 11},
+		}
+
+		for _, tc := range testCases {
+			code, err := Encode(strings.Repeat("a", tc.length))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if code.Version != tc.version || code.Size != tc.version*4+17 {
+				t.Errorf("length %d: expected version %d. got: %d (size %d)", tc.length, tc.version, code.Version, code.Size)
+			}
+		}
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		if _, err := Encode(strings.Repeat("a", 1000)); !errors.Is(err, ErrDataTooLong) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrDataTooLong, err)
+		}
+	})
+
+	t.Run("finder patterns", func(t *testing.T) {
+		code, err := Encode("otpauth://totp/Auth%20Test:alice@example.com?secret=ABC")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+			if !code.Dark(corner[0], corner[1]) || code.Dark(corner[0]+1, corner[1]+1) || !code.Dark(corner[0]+3, corner[1]+3) {
+				t.Errorf("missing finder pattern at %v", corner)
+			}
+		}
+
+		if !code.Dark(8, code.Size-8) {
+			t.Errorf("dark module missing")
+		}
+	})
+}
+
+func TestCode_SVG(t *testing.T) {
+	code, err := Encode("hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	svg := code.SVG(4)
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="116" height="116" viewBox="0 0 29 29"`) {
+		t.Errorf("unexpected svg header: %.120s", svg)
+	}
+
+	if !strings.Contains(svg, `d="M4,4h1v1h-1z`) {
+		t.Errorf("top left module should be drawn first")
+	}
+}
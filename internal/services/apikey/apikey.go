@@ -0,0 +1,196 @@
+// Package apikey manages long-lived API keys for scripts and CI jobs, scoped to a subset of the API.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key expired")
+	ErrUnknownScope  = errors.New("unknown scope")
+	ErrMissingScope  = errors.New("at least one scope is required")
+	ErrInvalidExpiry = errors.New("expiry must be in the future")
+)
+
+const (
+	// KeyPrefix starts every API key so that they are easy to tell apart from access tokens and to scan for
+	KeyPrefix        = "ak_"
+	DefaultKeyName   = "API key"
+	maxKeyNameLength = 64
+	// lastUsedResolution avoids a store write on every request made with the same key
+	lastUsedResolution = time.Minute
+)
+
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeSessionsRead  = "sessions:read"
+	ScopeSessionsWrite = "sessions:write"
+)
+
+type Scope struct {
+	Name        string
+	Description string
+}
+
+// Scopes lists the scopes users can grant, in the order they are shown.
+var Scopes = []Scope{
+	{ScopeProfileRead, "Read your account details"},
+	{ScopeSessionsRead, "List your active sessions"},
+	{ScopeSessionsWrite, "Revoke your sessions"},
+}
+
+type ServiceInterface interface {
+	Create(userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*apikeys.APIKey, string, error)
+	Keys(userID uuid.UUID) ([]apikeys.APIKey, error)
+	Revoke(userID, id uuid.UUID) error
+	Authenticate(key string) (*users.User, *apikeys.APIKey, error)
+}
+
+type Service struct {
+	apiKeyStore apikeys.APIKeyStoreInterface
+	userStore   users.UserStoreInterface
+}
+
+func NewService(apiKeyStore apikeys.APIKeyStoreInterface, userStore users.UserStoreInterface) *Service {
+	return &Service{apiKeyStore: apiKeyStore, userStore: userStore}
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(value), nil
+}
+
+// Create returns the new key along with its plaintext value, which is not stored and cannot be shown again.
+func (s *Service) Create(userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*apikeys.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrMissingScope
+	}
+
+	for _, scope := range scopes {
+		if !slices.ContainsFunc(Scopes, func(s Scope) bool { return s.Name == scope }) {
+			return nil, "", ErrUnknownScope
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DefaultKeyName
+	}
+
+	if runes := []rune(name); len(runes) > maxKeyNameLength {
+		name = string(runes[:maxKeyNameLength])
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prefix := KeyPrefix + id
+	plaintext := prefix + "_" + secret
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	key, err := s.apiKeyStore.Create(apikeys.APIKey{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashKey(plaintext),
+		Scopes:     slices.Compact(scopes),
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, plaintext, nil
+}
+
+func (s *Service) Keys(userID uuid.UUID) ([]apikeys.APIKey, error) {
+	return s.apiKeyStore.GetForUser(userID)
+}
+
+// Revoke deletes a key, making sure it belongs to the given user.
+func (s *Service) Revoke(userID, id uuid.UUID) error {
+	key, err := s.apiKeyStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if key.UserID != userID {
+		return apikeys.ErrAPIKeyNotFound
+	}
+
+	return s.apiKeyStore.Delete(id)
+}
+
+// Authenticate resolves a plaintext key to its owner. Expired keys are kept so users can see why they stopped working.
+func (s *Service) Authenticate(plaintext string) (*users.User, *apikeys.APIKey, error) {
+	if !strings.HasPrefix(plaintext, KeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	prefix, _, found := strings.Cut(strings.TrimPrefix(plaintext, KeyPrefix), "_")
+	if !found {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyStore.GetByPrefix(KeyPrefix + prefix)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(plaintext)), []byte(key.SecretHash)) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	u, err := s.userStore.Get(key.UserID)
+	if err != nil || u.Disabled() {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) >= lastUsedResolution {
+		key.LastUsed = &now
+		if _, err = s.apiKeyStore.Update(*key); err != nil {
+			log.Printf("failed to update API key %s: %s", key.ID, err)
+		}
+	}
+
+	return u, key, nil
+}
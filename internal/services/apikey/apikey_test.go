@@ -0,0 +1,152 @@
+package apikey
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestService(t testing.TB) (*Service, *apikeys.APIKeyMemoryStore, *users.User) {
+	t.Helper()
+	userStore := users.NewUserMemoryStore()
+	apiKeyStore := apikeys.NewAPIKeyMemoryStore()
+
+	u, err := userStore.Create(users.User{Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return NewService(apiKeyStore, userStore), apiKeyStore, u
+}
+
+func TestService_Create(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		key, plaintext, err := service.Create(u.ID, " CI ", []string{ScopeSessionsRead, ScopeProfileRead, ScopeProfileRead}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !strings.HasPrefix(plaintext, key.Prefix+"_") || !strings.HasPrefix(key.Prefix, KeyPrefix) {
+			t.Errorf("the key should start with its prefix. key: %s. prefix: %s", plaintext, key.Prefix)
+		}
+
+		if strings.Contains(key.SecretHash, plaintext[len(key.Prefix)+1:]) {
+			t.Errorf("the secret should not be stored in plaintext")
+		}
+
+		if key.Name != "CI" || len(key.Scopes) != 2 || key.Scopes[0] != ScopeProfileRead {
+			t.Errorf("unexpected key. got: %v", key)
+		}
+	})
+
+	t.Run("default name", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		key, _, err := service.Create(u.ID, "", []string{ScopeProfileRead}, nil)
+		if err != nil || key.Name != DefaultKeyName {
+			t.Errorf("unexpected result. got: %v. error: %v", key, err)
+		}
+	})
+
+	past := time.Now().Add(-time.Hour)
+
+	testCases := []struct {
+		name      string
+		scopes    []string
+		expiresAt *time.Time
+		err       error
+	}{
+		{"no scope", nil, nil, ErrMissingScope},
+		{"unknown scope", []string{ScopeProfileRead, "admin"}, nil, ErrUnknownScope},
+		{"expiry in the past", []string{ScopeProfileRead}, &past, ErrInvalidExpiry},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, _, u := newTestService(t)
+			if _, _, err := service.Create(u.ID, "key", tc.scopes, tc.expiresAt); !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. expected: %s. got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestService_Authenticate(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		service, store, u := newTestService(t)
+		key, plaintext, _ := service.Create(u.ID, "CI", []string{ScopeProfileRead}, nil)
+
+		authenticated, authKey, err := service.Authenticate(plaintext)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if authenticated.ID != u.ID || authKey.ID != key.ID || !authKey.HasScope(ScopeProfileRead) {
+			t.Errorf("unexpected result. user: %v. key: %v", authenticated, authKey)
+		}
+
+		stored, _ := store.Get(key.ID)
+		if stored.LastUsed == nil {
+			t.Errorf("last used was not tracked")
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		key, plaintext, _ := service.Create(u.ID, "CI", []string{ScopeProfileRead}, nil)
+
+		for _, candidate := range []string{"", "ak_", plaintext[:len(plaintext)-1] + "0", key.Prefix + "_secret", "ak_unknown_secret", strings.TrimPrefix(plaintext, KeyPrefix)} {
+			if candidate == plaintext {
+				continue
+			}
+
+			if _, _, err := service.Authenticate(candidate); !errors.Is(err, ErrInvalidAPIKey) {
+				t.Errorf("unexpected error for %q. expected: %s. got: %v", candidate, ErrInvalidAPIKey, err)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		service, store, u := newTestService(t)
+		expiresAt := time.Now().Add(time.Hour)
+		key, plaintext, _ := service.Create(u.ID, "CI", []string{ScopeProfileRead}, &expiresAt)
+
+		past := time.Now().Add(-time.Minute)
+		key.ExpiresAt = &past
+		_, _ = store.Update(*key)
+
+		if _, _, err := service.Authenticate(plaintext); !errors.Is(err, ErrAPIKeyExpired) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrAPIKeyExpired, err)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		key, plaintext, _ := service.Create(u.ID, "CI", []string{ScopeProfileRead}, nil)
+
+		if err := service.Revoke(u.ID, key.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, _, err := service.Authenticate(plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidAPIKey, err)
+		}
+	})
+}
+
+func TestService_Revoke(t *testing.T) {
+	service, store, u := newTestService(t)
+	key, _, _ := service.Create(u.ID, "CI", []string{ScopeProfileRead}, nil)
+
+	if err := service.Revoke(uuid.New(), key.ID); !errors.Is(err, apikeys.ErrAPIKeyNotFound) {
+		t.Fatalf("unexpected error. expected: %s. got: %v", apikeys.ErrAPIKeyNotFound, err)
+	}
+
+	if _, err := store.Get(key.ID); err != nil {
+		t.Errorf("key of another user should not be revoked. got: %v", err)
+	}
+}
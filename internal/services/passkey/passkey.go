@@ -0,0 +1,288 @@
+// Package passkey registers WebAuthn credentials on user accounts and signs users in with them.
+package passkey
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/throttle"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCeremony   = errors.New("invalid or expired passkey ceremony")
+	ErrUnknownCredential = errors.New("unknown passkey")
+)
+
+const (
+	DefaultCeremonyLifetime = time.Minute * 5
+	// DefaultMaxCeremonies bounds the ceremonies kept in memory, the oldest one is dropped to make room
+	DefaultMaxCeremonies = 10000
+	// DefaultMaxLoginBegins is how many sign-ins an IP can start per loginBeginWindow
+	DefaultMaxLoginBegins   = 30
+	DefaultCredentialName   = "Passkey"
+	loginBeginWindow        = time.Minute
+	maxCredentialNameLength = 64
+)
+
+type ServiceInterface interface {
+	BeginRegistration(user *users.User) (string, *webauthn.CreationOptions, error)
+	FinishRegistration(user *users.User, ceremonyID, name string, response webauthn.RegistrationResponse) (*credentials.Credential, error)
+	BeginLogin(ip string) (string, *webauthn.RequestOptions, error)
+	FinishLogin(ceremonyID string, response webauthn.AssertionResponse) (*users.User, *sessions.Session, error)
+	Credentials(userID uuid.UUID) ([]credentials.Credential, error)
+	DeleteCredential(userID, id uuid.UUID) error
+}
+
+// ceremony is the server side state between the begin and finish steps
+type ceremony struct {
+	challenge []byte
+	// userID is only set for registrations
+	userID    uuid.UUID
+	expiresAt time.Time
+	// element is the ceremony's place in Service.order
+	element *list.Element
+}
+
+type Service struct {
+	relyingParty    *webauthn.RelyingParty
+	credentialStore credentials.CredentialStoreInterface
+	userStore       users.UserStoreInterface
+	userService     user.ServiceInterface
+	lifetime        time.Duration
+	maxCeremonies   int
+	loginBegins     *throttle.SlidingWindow
+	maxLoginBegins  int
+
+	mu         sync.Mutex
+	ceremonies map[string]ceremony
+	// order holds the ceremony IDs oldest first. They share the same lifetime, so expired ones are at the front.
+	order *list.List
+}
+
+func NewService(
+	relyingParty *webauthn.RelyingParty,
+	credentialStore credentials.CredentialStoreInterface,
+	userStore users.UserStoreInterface,
+	userService user.ServiceInterface,
+) *Service {
+	return &Service{
+		relyingParty:    relyingParty,
+		credentialStore: credentialStore,
+		userStore:       userStore,
+		userService:     userService,
+		lifetime:        DefaultCeremonyLifetime,
+		maxCeremonies:   DefaultMaxCeremonies,
+		loginBegins:     throttle.NewSlidingWindow(loginBeginWindow),
+		maxLoginBegins:  DefaultMaxLoginBegins,
+		ceremonies:      make(map[string]ceremony),
+		order:           list.New(),
+	}
+}
+
+func (s *Service) begin(userID uuid.UUID) (string, []byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", nil, err
+	}
+
+	rawID := make([]byte, 16)
+	if _, err = rand.Read(rawID); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(rawID)
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// drop the expired ceremonies, and the oldest ones once the limit is reached
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		key := front.Value.(string)
+		if s.order.Len() < s.maxCeremonies && !now.After(s.ceremonies[key].expiresAt) {
+			break
+		}
+
+		s.order.Remove(front)
+		delete(s.ceremonies, key)
+	}
+
+	s.ceremonies[id] = ceremony{
+		challenge: challenge,
+		userID:    userID,
+		expiresAt: now.Add(s.lifetime),
+		element:   s.order.PushBack(id),
+	}
+
+	return id, challenge, nil
+}
+
+// finish consumes the ceremony, a challenge is never accepted twice
+func (s *Service) finish(id string, userID uuid.UUID) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[id]
+	if ok {
+		s.order.Remove(c.element)
+		delete(s.ceremonies, id)
+	}
+
+	if !ok || time.Now().After(c.expiresAt) || c.userID != userID {
+		return nil, ErrInvalidCeremony
+	}
+
+	return c.challenge, nil
+}
+
+// BeginRegistration returns the ceremony ID to pass to FinishRegistration and the options for the browser.
+func (s *Service) BeginRegistration(u *users.User) (string, *webauthn.CreationOptions, error) {
+	existing, err := s.credentialStore.GetForUser(u.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(existing))
+	for _, credential := range existing {
+		exclude = append(exclude, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: credential.Transports,
+		})
+	}
+
+	id, challenge, err := s.begin(u.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	options := s.relyingParty.CreationOptions(webauthn.User{
+		ID:          u.ID[:],
+		Name:        u.Email,
+		DisplayName: u.Email,
+	}, challenge, exclude)
+
+	return id, &options, nil
+}
+
+func (s *Service) FinishRegistration(u *users.User, ceremonyID, name string, response webauthn.RegistrationResponse) (*credentials.Credential, error) {
+	challenge, err := s.finish(ceremonyID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := s.relyingParty.VerifyRegistration(challenge, response)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DefaultCredentialName
+	}
+
+	if runes := []rune(name); len(runes) > maxCredentialNameLength {
+		name = string(runes[:maxCredentialNameLength])
+	}
+
+	return s.credentialStore.Create(credentials.Credential{
+		UserID:            u.ID,
+		CredentialID:      verified.ID,
+		PublicKey:         verified.PublicKey,
+		SignCount:         verified.SignCount,
+		AAGUID:            verified.AAGUID,
+		Transports:        verified.Transports,
+		AttestationFormat: verified.AttestationFormat,
+		Name:              name,
+	})
+}
+
+// BeginLogin starts a usernameless sign-in, the browser lets the user pick one of their passkeys.
+// Anyone can start one, it returns a user.ThrottledError once ip started too many.
+func (s *Service) BeginLogin(ip string) (string, *webauthn.RequestOptions, error) {
+	if s.loginBegins.Count(ip) >= s.maxLoginBegins {
+		return "", nil, user.ThrottledError{RetryAfter: s.loginBegins.RetryAfter(ip)}
+	}
+	s.loginBegins.Add(ip)
+
+	id, challenge, err := s.begin(uuid.UUID{})
+	if err != nil {
+		return "", nil, err
+	}
+
+	options := s.relyingParty.RequestOptions(challenge)
+
+	return id, &options, nil
+}
+
+// FinishLogin verifies the assertion and opens a session through user.Service.Login.
+// A passkey with user verification counts as two factors, TOTP is not asked for.
+// Failures come with the user once the credential is known, like user.Service.LoginWithCredentials.
+func (s *Service) FinishLogin(ceremonyID string, response webauthn.AssertionResponse) (*users.User, *sessions.Session, error) {
+	challenge, err := s.finish(ceremonyID, uuid.UUID{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credential, err := s.credentialStore.GetByCredentialID(response.RawID)
+	if err != nil {
+		return nil, nil, ErrUnknownCredential
+	}
+
+	if len(response.Response.UserHandle) != 0 && !bytes.Equal(response.Response.UserHandle, credential.UserID[:]) {
+		return nil, nil, ErrUnknownCredential
+	}
+
+	u, err := s.userStore.Get(credential.UserID)
+	if err != nil {
+		return nil, nil, ErrUnknownCredential
+	}
+
+	now := time.Now()
+	if u.Locked(now) {
+		return u, nil, user.ThrottledError{RetryAfter: u.LockedUntil.Sub(now)}
+	}
+
+	signCount, err := s.relyingParty.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, response)
+	if err != nil {
+		return u, nil, err
+	}
+
+	credential.SignCount = signCount
+	credential.LastUsed = &now
+	if _, err = s.credentialStore.Update(*credential); err != nil {
+		log.Printf("failed to update passkey %s: %s", credential.ID, err)
+	}
+
+	return s.userService.Login(u)
+}
+
+func (s *Service) Credentials(userID uuid.UUID) ([]credentials.Credential, error) {
+	return s.credentialStore.GetForUser(userID)
+}
+
+// DeleteCredential removes a passkey, making sure it belongs to the given user.
+func (s *Service) DeleteCredential(userID, id uuid.UUID) error {
+	credential, err := s.credentialStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if credential.UserID != userID {
+		return credentials.ErrCredentialNotFound
+	}
+
+	return s.credentialStore.Delete(id)
+}
@@ -0,0 +1,248 @@
+package passkey
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"github.com/mathieuhays/auth/internal/webauthn/webauthntest"
+	"testing"
+	"time"
+)
+
+const testOrigin = "https://auth.example.com"
+
+func newTestService(t testing.TB) (*Service, *users.UserMemoryStore, *users.User) {
+	t.Helper()
+	userStore := users.NewUserMemoryStore()
+	userService := user.NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore())
+	relyingParty := webauthn.New(webauthn.Config{RPID: "auth.example.com", RPName: "Auth Test", Origins: []string{testOrigin}})
+
+	u, err := userStore.Create(users.User{Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return NewService(relyingParty, credentials.NewCredentialMemoryStore(), userStore, userService), userStore, u
+}
+
+func registerPasskey(t testing.TB, service *Service, u *users.User, authenticator *webauthntest.Authenticator) *credentials.Credential {
+	t.Helper()
+	ceremonyID, options, err := service.BeginRegistration(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	response, err := authenticator.Create(*options)
+	if err != nil {
+		t.Fatalf("unexpected authenticator error: %s", err)
+	}
+
+	credential, err := service.FinishRegistration(u, ceremonyID, " Laptop ", response)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return credential
+}
+
+func TestService_Registration(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		credential := registerPasskey(t, service, u, webauthntest.New(testOrigin))
+
+		if credential.UserID != u.ID || credential.Name != "Laptop" || credential.AttestationFormat != "none" {
+			t.Errorf("unexpected credential. got: %v", credential)
+		}
+
+		// registered passkeys are excluded from the next registration
+		_, options, _ := service.BeginRegistration(u)
+		if len(options.ExcludeCredentials) != 1 || string(options.ExcludeCredentials[0].ID) != string(credential.CredentialID) {
+			t.Errorf("unexpected exclude list. got: %v", options.ExcludeCredentials)
+		}
+	})
+
+	t.Run("ceremony of another user", func(t *testing.T) {
+		service, userStore, u := newTestService(t)
+		other, _ := userStore.Create(users.User{Email: "other@example.com"})
+
+		ceremonyID, options, _ := service.BeginRegistration(u)
+		response, _ := webauthntest.New(testOrigin).Create(*options)
+
+		if _, err := service.FinishRegistration(other, ceremonyID, "", response); !errors.Is(err, ErrInvalidCeremony) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidCeremony, err)
+		}
+	})
+
+	t.Run("expired ceremony", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		service.lifetime = -time.Second
+
+		ceremonyID, options, _ := service.BeginRegistration(u)
+		response, _ := webauthntest.New(testOrigin).Create(*options)
+
+		if _, err := service.FinishRegistration(u, ceremonyID, "", response); !errors.Is(err, ErrInvalidCeremony) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidCeremony, err)
+		}
+	})
+}
+
+func TestService_Login(t *testing.T) {
+	login := func(service *Service, authenticator *webauthntest.Authenticator) (*users.User, *sessions.Session, error) {
+		ceremonyID, options, err := service.BeginLogin("10.0.0.1")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		response, err := authenticator.Get(*options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return service.FinishLogin(ceremonyID, response)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		authenticator := webauthntest.New(testOrigin)
+		credential := registerPasskey(t, service, u, authenticator)
+
+		loggedIn, session, err := login(service, authenticator)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if loggedIn.ID != u.ID || session == nil || session.UserID != u.ID {
+			t.Errorf("unexpected login. user: %v. session: %v", loggedIn, session)
+		}
+
+		stored, _ := service.credentialStore.Get(credential.ID)
+		if stored.SignCount != 1 || stored.LastUsed == nil {
+			t.Errorf("credential usage not recorded. got: %v", stored)
+		}
+	})
+
+	t.Run("challenge is single use", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		authenticator := webauthntest.New(testOrigin)
+		registerPasskey(t, service, u, authenticator)
+
+		ceremonyID, options, _ := service.BeginLogin("10.0.0.1")
+		response, _ := authenticator.Get(*options)
+		if _, _, err := service.FinishLogin(ceremonyID, response); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, _, err := service.FinishLogin(ceremonyID, response); !errors.Is(err, ErrInvalidCeremony) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidCeremony, err)
+		}
+	})
+
+	t.Run("deleted passkey", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		authenticator := webauthntest.New(testOrigin)
+		credential := registerPasskey(t, service, u, authenticator)
+
+		if err := service.DeleteCredential(u.ID, credential.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, _, err := login(service, authenticator); !errors.Is(err, ErrUnknownCredential) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownCredential, err)
+		}
+	})
+
+	t.Run("user handle mismatch", func(t *testing.T) {
+		service, _, u := newTestService(t)
+		authenticator := webauthntest.New(testOrigin)
+		registerPasskey(t, service, u, authenticator)
+
+		ceremonyID, options, _ := service.BeginLogin("10.0.0.1")
+		response, _ := authenticator.Get(*options)
+		response.Response.UserHandle = []byte("someone else")
+
+		if _, _, err := service.FinishLogin(ceremonyID, response); !errors.Is(err, ErrUnknownCredential) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownCredential, err)
+		}
+	})
+
+	t.Run("locked account", func(t *testing.T) {
+		service, userStore, u := newTestService(t)
+		authenticator := webauthntest.New(testOrigin)
+		registerPasskey(t, service, u, authenticator)
+
+		lockedUntil := time.Now().Add(time.Minute)
+		u.LockedUntil = &lockedUntil
+		_, _ = userStore.Update(*u)
+
+		var throttled user.ThrottledError
+		if _, _, err := login(service, authenticator); !errors.As(err, &throttled) {
+			t.Errorf("unexpected error. expected a throttled error. got: %v", err)
+		}
+	})
+}
+
+func TestService_CeremonyLimit(t *testing.T) {
+	service, _, _ := newTestService(t)
+	service.maxCeremonies = 3
+
+	var ceremonyIDs []string
+	for i := 0; i < 5; i++ {
+		ceremonyID, _, err := service.BeginLogin(fmt.Sprintf("10.0.0.%d", i))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ceremonyIDs = append(ceremonyIDs, ceremonyID)
+	}
+
+	if len(service.ceremonies) != service.maxCeremonies || service.order.Len() != service.maxCeremonies {
+		t.Errorf("unexpected ceremony count. expected: %d. got: %d", service.maxCeremonies, len(service.ceremonies))
+	}
+
+	// the oldest ceremonies made room for the new ones
+	if _, err := service.finish(ceremonyIDs[0], uuid.UUID{}); !errors.Is(err, ErrInvalidCeremony) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidCeremony, err)
+	}
+
+	if _, err := service.finish(ceremonyIDs[4], uuid.UUID{}); err != nil {
+		t.Errorf("unexpected error for the latest ceremony: %s", err)
+	}
+}
+
+func TestService_LoginBeginThrottle(t *testing.T) {
+	service, _, _ := newTestService(t)
+
+	for i := 0; i < service.maxLoginBegins; i++ {
+		if _, _, err := service.BeginLogin("10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d: unexpected error: %s", i, err)
+		}
+	}
+
+	var throttled user.ThrottledError
+	if _, _, err := service.BeginLogin("10.0.0.1"); !errors.As(err, &throttled) {
+		t.Errorf("unexpected error. expected throttled error. got: %v", err)
+	}
+
+	if _, _, err := service.BeginLogin("10.0.0.2"); err != nil {
+		t.Errorf("other IPs should not be throttled: %s", err)
+	}
+}
+
+func TestService_DeleteCredential(t *testing.T) {
+	service, userStore, u := newTestService(t)
+	credential := registerPasskey(t, service, u, webauthntest.New(testOrigin))
+	other, _ := userStore.Create(users.User{Email: "other@example.com"})
+
+	if err := service.DeleteCredential(other.ID, credential.ID); !errors.Is(err, credentials.ErrCredentialNotFound) {
+		t.Fatalf("unexpected error. expected: %s. got: %v", credentials.ErrCredentialNotFound, err)
+	}
+
+	if list, _ := service.Credentials(u.ID); len(list) != 1 {
+		t.Errorf("credential of another user should not be deleted")
+	}
+}
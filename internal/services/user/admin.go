@@ -0,0 +1,177 @@
+package user
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"slices"
+	"time"
+)
+
+const DefaultUsersPerPage = 25
+
+var (
+	ErrUnknownRole    = errors.New("unknown role")
+	ErrConfiguredRole = errors.New("role granted from configuration")
+)
+
+// UserPage is a page of SearchUsers results. Page starts at 1.
+type UserPage struct {
+	Users   []users.User
+	Query   string
+	Page    int
+	PerPage int
+	Total   int
+}
+
+func (p UserPage) Pages() int {
+	if p.PerPage <= 0 || p.Total == 0 {
+		return 1
+	}
+
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+func (p UserPage) HasPrevious() bool {
+	return p.Page > 1
+}
+
+func (p UserPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+func (p UserPage) PreviousPage() int {
+	return max(p.Page-1, 1)
+}
+
+func (p UserPage) NextPage() int {
+	return min(p.Page+1, p.Pages())
+}
+
+// SearchUsers looks users up by email, a blank query lists everyone.
+func (s Service) SearchUsers(query string, page, perPage int) (*UserPage, error) {
+	if perPage <= 0 {
+		perPage = DefaultUsersPerPage
+	}
+
+	page = max(page, 1)
+
+	found, total, err := s.userStore.Search(query, (page-1)*perPage, perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserPage{Users: found, Query: query, Page: page, PerPage: perPage, Total: total}, nil
+}
+
+func (s Service) GetUser(id uuid.UUID) (*users.User, error) {
+	return s.userStore.Get(id)
+}
+
+// DisableUser prevents the user from signing in and revokes all of their sessions.
+func (s Service) DisableUser(id uuid.UUID) error {
+	user, err := s.userStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if !user.Disabled() {
+		now := time.Now()
+		user.DisabledAt = &now
+		if _, err = s.userStore.Update(*user); err != nil {
+			return err
+		}
+	}
+
+	return s.RevokeAllSessions(id)
+}
+
+func (s Service) EnableUser(id uuid.UUID) error {
+	user, err := s.userStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	user.DisabledAt = nil
+	_, err = s.userStore.Update(*user)
+
+	return err
+}
+
+// ForcePasswordReset blocks password sign-ins until the user resets their password, and revokes all of
+// their sessions. It returns a reset token for the caller to send to the user.
+func (s Service) ForcePasswordReset(id uuid.UUID) (*users.User, string, error) {
+	user, err := s.userStore.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user.PasswordResetRequired = true
+	if user, err = s.userStore.Update(*user); err != nil {
+		return nil, "", err
+	}
+
+	if err = s.RevokeAllSessions(id); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.passwordResetToken(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+// GrantRole gives the user one of users.Roles.
+func (s Service) GrantRole(id uuid.UUID, role users.Role) error {
+	if !slices.Contains(users.Roles, role) {
+		return ErrUnknownRole
+	}
+
+	user, err := s.userStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if user.HasRole(role) {
+		return nil
+	}
+
+	user.Roles = append(user.Roles, role)
+	_, err = s.userStore.Update(*user)
+
+	return err
+}
+
+// RevokeRole takes a role back from the user. Roles granted from configuration cannot be revoked here,
+// they would come back on the next login, see WithAdminEmails.
+func (s Service) RevokeRole(id uuid.UUID, role users.Role) error {
+	if !slices.Contains(users.Roles, role) {
+		return ErrUnknownRole
+	}
+
+	user, err := s.userStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if user.HasConfiguredRole(role) {
+		return ErrConfiguredRole
+	}
+
+	if !user.HasRole(role) {
+		return nil
+	}
+
+	user.Roles = withoutRole(user.Roles, role)
+	_, err = s.userStore.Update(*user)
+
+	return err
+}
+
+func withoutRole(roles []users.Role, role users.Role) []users.Role {
+	return slices.DeleteFunc(slices.Clone(roles), func(r users.Role) bool {
+		return r == role
+	})
+}
@@ -0,0 +1,143 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"testing"
+)
+
+func TestService_SearchUsers(t *testing.T) {
+	service, _, _ := newTestService(t)
+	for i := 0; i < 5; i++ {
+		if _, err := service.Register(fmt.Sprintf("user%d@example.com", i), "correct horse battery"); err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+	}
+	if _, err := service.Register("someone@other.org", "correct horse battery"); err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	results, err := service.SearchUsers("EXAMPLE", 2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if results.Total != 5 || results.Pages() != 3 || len(results.Users) != 2 {
+		t.Fatalf("unexpected results. got: %d users out of %d", len(results.Users), results.Total)
+	}
+
+	if results.Users[0].Email != "user2@example.com" || !results.HasPrevious() || !results.HasNext() {
+		t.Errorf("unexpected page. got: %+v", results)
+	}
+
+	if results, err = service.SearchUsers("", 0, 0); err != nil || results.Page != 1 || results.Total != 6 {
+		t.Errorf("blank query should list everyone on the first page. got: %+v, %v", results, err)
+	}
+}
+
+func TestService_DisableUser(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+	session := createTestSessions(t, service, u.ID, 1)[0]
+
+	if err = service.DisableUser(u.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err = sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("session still exists after disabling the account. got: %v", err)
+	}
+
+	if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1"); !errors.Is(err, ErrAccountDisabled) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrAccountDisabled, err)
+	}
+
+	if err = service.EnableUser(u.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1"); err != nil {
+		t.Errorf("unexpected error after enabling the account: %s", err)
+	}
+}
+
+func TestService_ForcePasswordReset(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+	session := createTestSessions(t, service, u.ID, 1)[0]
+
+	_, token, err := service.ForcePasswordReset(u.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err = sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("session still exists after forcing a password reset. got: %v", err)
+	}
+
+	if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1"); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrPasswordResetRequired, err)
+	}
+
+	if err = service.ResetPassword(token, "a brand new passphrase"); err != nil {
+		t.Fatalf("unexpected error while resetting the password: %s", err)
+	}
+
+	var updated *users.User
+	if updated, _, err = service.LoginWithCredentials(u.Email, "a brand new passphrase", "10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error after resetting the password: %s", err)
+	}
+
+	if updated.PasswordResetRequired {
+		t.Errorf("password reset requirement was not cleared")
+	}
+}
+
+func TestService_GrantRevokeRole(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	if err = service.GrantRole(u.ID, "owner"); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrUnknownRole, err)
+	}
+
+	if err = service.GrantRole(u.ID, users.RoleSupport); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if stored, _ := userStore.Get(u.ID); !stored.Can(users.PermissionSessionsManage) || stored.Can(users.PermissionUsersManage) {
+		t.Errorf("unexpected permissions for roles %v", stored.Roles)
+	}
+
+	if err = service.RevokeRole(u.ID, users.RoleSupport); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if stored, _ := userStore.Get(u.ID); len(stored.Roles) != 0 {
+		t.Errorf("role was not revoked. got: %v", stored.Roles)
+	}
+
+	t.Run("configured role", func(t *testing.T) {
+		stored, _ := userStore.Get(u.ID)
+		stored.Roles = []users.Role{users.RoleAdmin}
+		stored.ConfiguredRoles = []users.Role{users.RoleAdmin}
+		if _, err := userStore.Update(*stored); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := service.RevokeRole(u.ID, users.RoleAdmin); !errors.Is(err, ErrConfiguredRole) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrConfiguredRole, err)
+		}
+	})
+}
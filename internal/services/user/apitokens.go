@@ -0,0 +1,223 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// TokenPair is handed to API clients. The access token is a JWT, the refresh token is opaque and single use.
+type TokenPair struct {
+	AccessToken  string
+	ExpiresAt    time.Time
+	RefreshToken string
+}
+
+func defaultTokenKeys() *jwt.KeySet {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	key, err := jwt.GenerateEdDSAKey(hex.EncodeToString(id))
+	if err != nil {
+		panic(err)
+	}
+
+	return jwt.NewKeySet(key)
+}
+
+func randomHex(size int) (string, error) {
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(value), nil
+}
+
+// newRefreshToken returns a token of the given family, as "family.secret"
+func newRefreshToken(family string) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	return family + "." + secret, nil
+}
+
+func (s Service) accessToken(session *sessions.Session, now time.Time) (string, time.Time, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(s.accessTokenLifetime)
+	token, err := s.tokenKeys.Sign(jwt.Claims{
+		Issuer:    s.tokenIssuer,
+		Subject:   session.UserID.String(),
+		SessionID: session.ID.String(),
+		ID:        id,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+
+	return token, expiresAt, err
+}
+
+// IssueTokens starts a new refresh token family on the session, previous refresh tokens stop working.
+func (s Service) IssueTokens(session *sessions.Session) (*TokenPair, error) {
+	family, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.rotateTokens(session, family, "", time.Now())
+}
+
+// rotateTokens issues a new pair in family. Without previousHash the family starts over, otherwise the refresh token
+// is only replaced if its hash is still previousHash, see sessions.SessionStoreInterface.RotateRefreshToken.
+func (s Service) rotateTokens(session *sessions.Session, family, previousHash string, now time.Time) (*TokenPair, error) {
+	refreshToken, err := newRefreshToken(family)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := s.accessToken(session, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if previousHash != "" {
+		_, err = s.sessionStore.RotateRefreshToken(session.ID, previousHash, sessions.HashToken(refreshToken))
+	} else {
+		session.RefreshFamily = family
+		session.RefreshTokenHash = sessions.HashToken(refreshToken)
+		session.LastUsed = now
+		_, err = s.sessionStore.Update(*session)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{AccessToken: accessToken, ExpiresAt: expiresAt, RefreshToken: refreshToken}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new pair. Presenting a refresh token that was already
+// exchanged means it leaked, the whole family is revoked along with its session.
+func (s Service) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	family, _, found := strings.Cut(refreshToken, ".")
+	if !found {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.sessionStore.GetForRefreshFamily(family)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	presentedHash := sessions.HashToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) != 1 {
+		return nil, s.revokeRefreshFamily(session)
+	}
+
+	now := time.Now()
+	if s.sessionExpired(session, now) {
+		if err = s.sessionStore.Delete(session.ID); err != nil {
+			log.Printf("failed to delete expired session: %s", err)
+		}
+
+		return nil, ErrSessionExpired
+	}
+
+	if u, err := s.userStore.Get(session.UserID); err != nil || u.Disabled() {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// a concurrent refresh may have rotated the token since it was read, only one of them wins
+	pair, err := s.rotateTokens(session, family, presentedHash, now)
+	switch {
+	case errors.Is(err, sessions.ErrRefreshTokenMismatch):
+		return nil, s.revokeRefreshFamily(session)
+	case errors.Is(err, sessions.ErrSessionNotFound):
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return pair, err
+}
+
+// revokeRefreshFamily deletes the session of a refresh token that was presented twice, it returns ErrRefreshTokenReused
+func (s Service) revokeRefreshFamily(session *sessions.Session) error {
+	log.Printf("refresh token reuse detected for session %s, revoking it", session.ID)
+	if err := s.sessionStore.Delete(session.ID); err != nil {
+		log.Printf("failed to revoke session %s: %s", session.ID, err)
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// VerifyAccessToken checks the signature and expiry only, it does not know whether the session was revoked since.
+func (s Service) VerifyAccessToken(token string) (*jwt.Claims, error) {
+	claims, err := s.tokenKeys.Verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != s.tokenIssuer {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// authenticateAccessToken verifies the access token and makes sure its session is still active
+func (s Service) authenticateAccessToken(token string) (*users.User, *sessions.Session, error) {
+	claims, err := s.VerifyAccessToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil, nil, jwt.ErrInvalidToken
+	}
+
+	session, err := s.sessionStore.Get(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session.UserID.String() != claims.Subject || s.sessionExpired(session, time.Now()) {
+		return nil, nil, ErrSessionExpired
+	}
+
+	user, err := s.userStore.Get(session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.Disabled() {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	return user, session, nil
+}
+
+// JWKS publishes the public keys access tokens can be verified with.
+func (s Service) JWKS() jwt.JWKS {
+	return s.tokenKeys.JWKS()
+}
@@ -0,0 +1,207 @@
+package user
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTokenTestSession(t testing.TB, service *Service, userStore *users.UserMemoryStore) *sessions.Session {
+	t.Helper()
+	u, err := userStore.Create(users.User{Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error while creating user: %s", err)
+	}
+
+	_, session, err := service.Login(u)
+	if err != nil {
+		t.Fatalf("unexpected error while logging in: %s", err)
+	}
+
+	return session
+}
+
+func TestService_IssueTokens(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	session := newTokenTestSession(t, service, userStore)
+
+	pair, err := service.IssueTokens(session)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	claims, err := service.VerifyAccessToken(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if claims.Subject != session.UserID.String() || claims.SessionID != session.ID.String() || claims.Issuer != DefaultTokenIssuer {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	if lifetime := time.Unix(claims.ExpiresAt, 0).Sub(time.Unix(claims.IssuedAt, 0)); lifetime != DefaultAccessTokenLifetime {
+		t.Errorf("unexpected lifetime: %s", lifetime)
+	}
+
+	// the published key verifies the token
+	jwks := service.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Algorithm != jwt.AlgorithmEdDSA {
+		t.Errorf("unexpected JWKS: %v", jwks)
+	}
+}
+
+func TestService_RefreshTokens(t *testing.T) {
+	t.Run("rotation", func(t *testing.T) {
+		service, userStore, _ := newTestService(t)
+		session := newTokenTestSession(t, service, userStore)
+		first, _ := service.IssueTokens(session)
+
+		second, err := service.RefreshTokens(first.RefreshToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+			t.Errorf("tokens were not rotated")
+		}
+
+		if _, err = service.RefreshTokens(second.RefreshToken); err != nil {
+			t.Errorf("unexpected error for the latest refresh token: %s", err)
+		}
+	})
+
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		service, userStore, sessionStore := newTestService(t)
+		session := newTokenTestSession(t, service, userStore)
+		first, _ := service.IssueTokens(session)
+		second, _ := service.RefreshTokens(first.RefreshToken)
+
+		if _, err := service.RefreshTokens(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrRefreshTokenReused, err)
+		}
+
+		if _, err := sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("session should be revoked. got: %v", err)
+		}
+
+		if _, err := service.RefreshTokens(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("latest refresh token should be revoked too. got: %v", err)
+		}
+	})
+
+	t.Run("concurrent refreshes", func(t *testing.T) {
+		service, userStore, sessionStore := newTestService(t)
+		session := newTokenTestSession(t, service, userStore)
+		first, _ := service.IssueTokens(session)
+
+		start := make(chan struct{})
+		errs := make(chan error, 2)
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_, err := service.RefreshTokens(first.RefreshToken)
+				errs <- err
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		var succeeded, reused int
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrRefreshTokenReused):
+				reused++
+			default:
+				t.Errorf("unexpected error: %s", err)
+			}
+		}
+
+		if succeeded != 1 || reused != 1 {
+			t.Errorf("exactly one refresh should succeed. succeeded: %d. reused: %d", succeeded, reused)
+		}
+
+		if _, err := sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("session should be revoked. got: %v", err)
+		}
+	})
+
+	t.Run("new family invalidates the previous one", func(t *testing.T) {
+		service, userStore, _ := newTestService(t)
+		session := newTokenTestSession(t, service, userStore)
+		first, _ := service.IssueTokens(session)
+		if _, err := service.IssueTokens(session); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := service.RefreshTokens(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		for _, token := range []string{"", "no-separator", ".secret"} {
+			if _, err := service.RefreshTokens(token); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("unexpected error for %q. got: %v", token, err)
+			}
+		}
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		userStore := users.NewUserMemoryStore()
+		sessionStore := sessions.NewSessionMemoryStore()
+		service := NewService(userStore, sessionStore, tokens.NewTokenMemoryStore(),
+			WithSessionPolicy(SessionPolicy{AbsoluteLifetime: time.Hour}))
+		session := newTokenTestSession(t, service, userStore)
+		pair, _ := service.IssueTokens(session)
+
+		stored, _ := sessionStore.Get(session.ID)
+		stored.CreatedAt = time.Now().Add(-time.Hour * 2)
+		_, _ = sessionStore.Update(*stored)
+
+		if _, err := service.RefreshTokens(pair.RefreshToken); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrSessionExpired, err)
+		}
+	})
+}
+
+func TestService_VerifyAccessToken(t *testing.T) {
+	t.Run("expired", func(t *testing.T) {
+		userStore := users.NewUserMemoryStore()
+		service := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+			WithAccessTokenLifetime(-time.Second))
+		pair, _ := service.IssueTokens(newTokenTestSession(t, service, userStore))
+
+		if _, err := service.VerifyAccessToken(pair.AccessToken); !errors.Is(err, jwt.ErrTokenExpired) {
+			t.Errorf("unexpected error. expected: %s. got: %v", jwt.ErrTokenExpired, err)
+		}
+	})
+
+	t.Run("other issuer", func(t *testing.T) {
+		secret := []byte("0123456789abcdef0123456789abcdef")
+		key, _ := jwt.NewHS256Key("shared", secret)
+		keys := jwt.NewKeySet(key)
+
+		userStore := users.NewUserMemoryStore()
+		issuer := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+			WithTokenKeys(keys), WithTokenIssuer("https://other.example.com"))
+		verifier := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+			WithTokenKeys(keys), WithTokenIssuer("https://auth.example.com"))
+
+		pair, _ := issuer.IssueTokens(newTokenTestSession(t, issuer, userStore))
+		if _, err := verifier.VerifyAccessToken(pair.AccessToken); !errors.Is(err, jwt.ErrInvalidToken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", jwt.ErrInvalidToken, err)
+		}
+	})
+}
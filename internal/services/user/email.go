@@ -0,0 +1,77 @@
+package user
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"log"
+	"time"
+)
+
+var (
+	ErrEmailAlreadyConfirmed = errors.New("email already confirmed")
+	ErrConfirmationThrottled = errors.New("confirmation email sent too recently")
+)
+
+// RequestEmailConfirmation issues a confirmation token for the user's email address.
+// Requests are throttled to one per resend interval.
+func (s Service) RequestEmailConfirmation(user *users.User) (string, error) {
+	if user.EmailConfirmed != nil {
+		return "", ErrEmailAlreadyConfirmed
+	}
+
+	existing, err := s.tokenStore.GetForUser(user.ID, tokens.PurposeEmailConfirmation)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	for _, token := range existing {
+		if now.Sub(token.CreatedAt) < s.confirmationResendInterval {
+			return "", ErrConfirmationThrottled
+		}
+	}
+
+	if err = s.tokenStore.DeleteForUser(user.ID, tokens.PurposeEmailConfirmation); err != nil {
+		return "", err
+	}
+
+	token, err := tokens.NewToken(user.ID, tokens.PurposeEmailConfirmation, s.emailConfirmationLifetime)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = s.tokenStore.Create(*token); err != nil {
+		return "", err
+	}
+
+	return token.Value, nil
+}
+
+func (s Service) ConfirmEmail(value string) (*users.User, error) {
+	token, user, err := s.validToken(tokens.PurposeEmailConfirmation, value)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err = s.tokenStore.MarkUsed(token.ID, now); err != nil {
+		if errors.Is(err, tokens.ErrTokenAlreadyUsed) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if user.EmailConfirmed == nil {
+		user.EmailConfirmed = &now
+		if user, err = s.userStore.Update(*user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.tokenStore.DeleteForUser(user.ID, tokens.PurposeEmailConfirmation); err != nil {
+		log.Printf("failed to clean up email confirmation tokens: %s", err)
+	}
+
+	return user, nil
+}
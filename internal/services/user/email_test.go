@@ -0,0 +1,96 @@
+package user
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"testing"
+)
+
+func TestService_RequestEmailConfirmation(t *testing.T) {
+	t.Run("throttled", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		u, err := service.Register("test@example.com", "correct horse battery")
+		if err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		if _, err = service.RequestEmailConfirmation(u); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err = service.RequestEmailConfirmation(u); !errors.Is(err, ErrConfirmationThrottled) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrConfirmationThrottled, err)
+		}
+	})
+
+	t.Run("resend replaces the previous token", func(t *testing.T) {
+		service := NewService(users.NewUserMemoryStore(), sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+			WithConfirmationResendInterval(0))
+		u, err := service.Register("test@example.com", "correct horse battery")
+		if err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		first, err := service.RequestEmailConfirmation(u)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err = service.RequestEmailConfirmation(u); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err = service.ConfirmEmail(first); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("previous token still valid. got: %v", err)
+		}
+	})
+
+	t.Run("already confirmed", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		u, err := service.Register("test@example.com", "correct horse battery")
+		if err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		u.EmailConfirmed = &u.CreatedAt
+		if _, err = service.RequestEmailConfirmation(u); !errors.Is(err, ErrEmailAlreadyConfirmed) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrEmailAlreadyConfirmed, err)
+		}
+	})
+}
+
+func TestService_ConfirmEmail(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	token, err := service.RequestEmailConfirmation(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err = service.ConfirmEmail("invalid"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unexpected error for invalid token. expected: %s. got: %v", ErrInvalidToken, err)
+	}
+
+	if _, err = service.ConfirmEmail(token); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stored, err := userStore.Get(u.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if stored.EmailConfirmed == nil {
+		t.Errorf("EmailConfirmed was not set")
+	}
+
+	if _, err = service.ConfirmEmail(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token should be single use. got: %v", err)
+	}
+}
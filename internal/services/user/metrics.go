@@ -0,0 +1,137 @@
+package user
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"time"
+)
+
+// passwordBuckets cover password hashing, which is deliberately slow
+var passwordBuckets = []float64{.01, .025, .05, .1, .25, .5, .75, 1, 2, 5}
+
+// InstrumentedService counts logins, registrations, password changes and token refreshes of the wrapped service.
+// Calls that verify or hash a password are timed, which is where the hashing cost shows.
+type InstrumentedService struct {
+	ServiceInterface
+	logins          *metrics.Counter
+	registrations   *metrics.Counter
+	passwordChanges *metrics.Counter
+	tokenRefreshes  *metrics.Counter
+	passwordTiming  *metrics.Histogram
+}
+
+func NewInstrumentedService(service ServiceInterface, registry *metrics.Registry) *InstrumentedService {
+	return &InstrumentedService{
+		ServiceInterface: service,
+		logins:           registry.NewCounter("auth_logins_total", "Login attempts by method and result.", "method", "result"),
+		registrations:    registry.NewCounter("auth_registrations_total", "Registration attempts by result.", "result"),
+		passwordChanges:  registry.NewCounter("auth_password_changes_total", "Passwords changed, or reset through a reset link.", "kind"),
+		tokenRefreshes:   registry.NewCounter("auth_token_refreshes_total", "API refresh token exchanges by result.", "result"),
+		passwordTiming: registry.NewHistogram("auth_password_duration_seconds",
+			"Latency of the calls verifying or hashing a password.", passwordBuckets, "operation"),
+	}
+}
+
+func loginResult(err error) string {
+	var throttled ThrottledError
+	var secondFactor SecondFactorRequiredError
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &secondFactor):
+		return "second_factor_required"
+	case errors.As(err, &throttled), errors.Is(err, ErrTooManyAttempts):
+		return "throttled"
+	case errors.Is(err, ErrInvalidCredentials):
+		return "invalid_credentials"
+	case errors.Is(err, ErrInvalidSecondFactor), errors.Is(err, ErrInvalidToken):
+		return "invalid_second_factor"
+	case errors.Is(err, ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, ErrPasswordResetRequired):
+		return "password_reset_required"
+	}
+
+	return "error"
+}
+
+// Login is only called through the decorator by passkey logins, which have already verified the assertion.
+// Password logins open their session within the wrapped service and are counted once, by LoginWithCredentials.
+func (i *InstrumentedService) Login(user *users.User) (*users.User, *sessions.Session, error) {
+	u, s, err := i.ServiceInterface.Login(user)
+	i.logins.Inc("passkey", loginResult(err))
+	return u, s, err
+}
+
+func (i *InstrumentedService) LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error) {
+	start := time.Now()
+	u, s, err := i.ServiceInterface.LoginWithCredentials(email, password, ip)
+	i.passwordTiming.Observe(metrics.Since(start), "login")
+	i.logins.Inc("password", loginResult(err))
+	return u, s, err
+}
+
+func (i *InstrumentedService) LoginWithSecondFactor(challenge, code, ip string) (*users.User, *sessions.Session, error) {
+	u, s, err := i.ServiceInterface.LoginWithSecondFactor(challenge, code, ip)
+	i.logins.Inc("second_factor", loginResult(err))
+	return u, s, err
+}
+
+func (i *InstrumentedService) Register(email, password string) (*users.User, error) {
+	start := time.Now()
+	u, err := i.ServiceInterface.Register(email, password)
+	i.passwordTiming.Observe(metrics.Since(start), "register")
+
+	var policyErr *validate.PolicyError
+	switch {
+	case err == nil:
+		i.registrations.Inc("success")
+	case errors.Is(err, users.ErrEmailAlreadyUsed):
+		i.registrations.Inc("email_taken")
+	case errors.As(err, &policyErr):
+		i.registrations.Inc("weak_password")
+	default:
+		i.registrations.Inc("error")
+	}
+
+	return u, err
+}
+
+func (i *InstrumentedService) ChangePassword(userID, currentSessionID uuid.UUID, currentPassword, newPassword string) error {
+	start := time.Now()
+	err := i.ServiceInterface.ChangePassword(userID, currentSessionID, currentPassword, newPassword)
+	i.passwordTiming.Observe(metrics.Since(start), "change")
+	if err == nil {
+		i.passwordChanges.Inc("change")
+	}
+	return err
+}
+
+func (i *InstrumentedService) ResetPassword(token, password string) error {
+	start := time.Now()
+	err := i.ServiceInterface.ResetPassword(token, password)
+	i.passwordTiming.Observe(metrics.Since(start), "reset")
+	if err == nil {
+		i.passwordChanges.Inc("reset")
+	}
+	return err
+}
+
+func (i *InstrumentedService) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	pair, err := i.ServiceInterface.RefreshTokens(refreshToken)
+
+	result := "success"
+	switch {
+	case errors.Is(err, ErrRefreshTokenReused):
+		result = "reused"
+	case err != nil:
+		result = "invalid"
+	}
+	i.tokenRefreshes.Inc(result)
+
+	return pair, err
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"github.com/mathieuhays/auth/internal/metrics"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentedService(t *testing.T) {
+	service, _, _ := newTestService(t)
+	registry := metrics.NewRegistry()
+	instrumented := NewInstrumentedService(service, registry)
+
+	u, err := instrumented.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+	_, _ = instrumented.Register("test@example.com", "correct horse battery")
+	_, _, _ = instrumented.LoginWithCredentials("test@example.com", "wrong", "10.0.0.1")
+	if _, _, err := instrumented.LoginWithCredentials("test@example.com", "correct horse battery", "10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error while logging in: %s", err)
+	}
+
+	// passkey logins open their session through Login once the assertion has been verified
+	if _, _, err = instrumented.Login(u); err != nil {
+		t.Fatalf("unexpected error while logging in: %s", err)
+	}
+
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, line := range []string{
+		`auth_registrations_total{result="success"} 1`,
+		`auth_registrations_total{result="email_taken"} 1`,
+		`auth_logins_total{method="password",result="invalid_credentials"} 1`,
+		`auth_logins_total{method="password",result="success"} 1`,
+		`auth_logins_total{method="passkey",result="success"} 1`,
+		`auth_password_duration_seconds_count{operation="login"} 2`,
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("missing %q from the metrics:\n%s", line, output.String())
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package user
+
+import (
+	"github.com/mathieuhays/auth/internal/encryption"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/passwords"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"net/http"
+	"time"
+)
+
+type Option func(service *Service)
+
+type SessionPolicy struct {
+	// IdleTimeout is the maximum time a session can go unused. Zero disables the check.
+	IdleTimeout time.Duration
+	// AbsoluteLifetime is the maximum age of a session regardless of activity. Zero disables the check.
+	AbsoluteLifetime time.Duration
+}
+
+var DefaultSessionPolicy = SessionPolicy{
+	IdleTimeout:      time.Hour * 24,
+	AbsoluteLifetime: time.Hour * 24 * 30,
+}
+
+// CookiePolicy holds the cookie attributes that depend on the deployment rather than on the cookie
+type CookiePolicy struct {
+	// Secure cookies are only sent over HTTPS, only disable it for local development over plain HTTP
+	Secure bool
+	// Domain shares cookies with subdomains, cookies are scoped to the request host when empty
+	Domain string
+}
+
+var DefaultCookiePolicy = CookiePolicy{Secure: true}
+
+// Apply sets the policy attributes on cookie
+func (p CookiePolicy) Apply(cookie *http.Cookie) *http.Cookie {
+	cookie.Secure = p.Secure
+	cookie.Domain = p.Domain
+	return cookie
+}
+
+const (
+	DefaultPasswordResetLifetime     = time.Hour
+	DefaultEmailConfirmationLifetime = time.Hour * 48
+	// DefaultConfirmationResendInterval is the minimum delay between two confirmation emails
+	DefaultConfirmationResendInterval = time.Minute
+	// DefaultSecondFactorLifetime is how long a user has to enter their second factor after their password
+	DefaultSecondFactorLifetime = time.Minute * 5
+	DefaultTOTPIssuer           = "Auth Test"
+	DefaultAccessTokenLifetime  = time.Minute * 15
+	DefaultTokenIssuer          = "auth"
+)
+
+func WithPasswordResetLifetime(lifetime time.Duration) Option {
+	return func(service *Service) {
+		service.passwordResetLifetime = lifetime
+	}
+}
+
+func WithEmailConfirmationLifetime(lifetime time.Duration) Option {
+	return func(service *Service) {
+		service.emailConfirmationLifetime = lifetime
+	}
+}
+
+func WithConfirmationResendInterval(interval time.Duration) Option {
+	return func(service *Service) {
+		service.confirmationResendInterval = interval
+	}
+}
+
+type LoginThrottlePolicy struct {
+	// Window is the period over which failed attempts are counted
+	Window time.Duration
+	// MaxAccountFailures is the amount of failures on a single account before it gets locked
+	MaxAccountFailures int
+	// MaxIPFailures is the amount of failures from a single IP before it gets throttled
+	MaxIPFailures int
+	// BaseLockout is doubled for every failure past MaxAccountFailures, up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+var DefaultLoginThrottlePolicy = LoginThrottlePolicy{
+	Window:             time.Minute * 15,
+	MaxAccountFailures: 5,
+	MaxIPFailures:      50,
+	BaseLockout:        time.Minute,
+	MaxLockout:         time.Hour,
+}
+
+func WithLoginThrottlePolicy(policy LoginThrottlePolicy) Option {
+	return func(service *Service) {
+		service.loginThrottle = newLoginThrottle(policy)
+	}
+}
+
+func WithSessionPolicy(policy SessionPolicy) Option {
+	return func(service *Service) {
+		service.sessionPolicy = policy
+	}
+}
+
+// WithCookiePolicy sets the attributes of the session cookie
+func WithCookiePolicy(policy CookiePolicy) Option {
+	return func(service *Service) {
+		service.cookiePolicy = policy
+	}
+}
+
+// WithPasswordHasher sets the manager used to hash new passwords and verify existing ones.
+// Hashes from algorithms it no longer uses as current are upgraded on the next successful login.
+func WithPasswordHasher(manager *passwords.Manager) Option {
+	return func(service *Service) {
+		service.passwords = manager
+	}
+}
+
+func WithPasswordPolicy(policy validate.PasswordPolicy) Option {
+	return func(service *Service) {
+		service.passwordPolicy = policy
+	}
+}
+
+// WithSecretBox sets how secrets stored on users are encrypted.
+// Without it a random key is generated, which is lost on restart.
+func WithSecretBox(box *encryption.Box) Option {
+	return func(service *Service) {
+		service.secretBox = box
+	}
+}
+
+// WithTOTPIssuer sets the name authenticator apps show next to the account.
+func WithTOTPIssuer(issuer string) Option {
+	return func(service *Service) {
+		service.totpIssuer = issuer
+	}
+}
+
+func WithSecondFactorLifetime(lifetime time.Duration) Option {
+	return func(service *Service) {
+		service.secondFactorLifetime = lifetime
+	}
+}
+
+// WithTokenKeys sets the keys access tokens are signed with. Without it a random EdDSA key is generated,
+// which is lost on restart.
+func WithTokenKeys(keys *jwt.KeySet) Option {
+	return func(service *Service) {
+		service.tokenKeys = keys
+	}
+}
+
+// WithTokenIssuer sets the "iss" claim of access tokens, usually the base URL of the service.
+func WithTokenIssuer(issuer string) Option {
+	return func(service *Service) {
+		service.tokenIssuer = issuer
+	}
+}
+
+func WithAccessTokenLifetime(lifetime time.Duration) Option {
+	return func(service *Service) {
+		service.accessTokenLifetime = lifetime
+	}
+}
+
+// WithAdminEmails grants the admin role to these accounts on their next login, once their email is confirmed.
+// Removing an email revokes the role on the next login, unless an administrator granted it, see Service.GrantRole.
+func WithAdminEmails(emails ...string) Option {
+	return func(service *Service) {
+		for _, email := range emails {
+			if email = users.NormalizeEmail(email); email != "" {
+				service.adminEmails = append(service.adminEmails, email)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package user
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"log"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// RequestPasswordReset issues a reset token for the account matching email.
+// Previously issued reset tokens are discarded. The plaintext token is only returned here.
+func (s Service) RequestPasswordReset(email string) (*users.User, string, error) {
+	user, err := s.userStore.GetByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.passwordResetToken(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+// passwordResetToken replaces the pending reset tokens of the user with a new one
+func (s Service) passwordResetToken(user *users.User) (string, error) {
+	if err := s.tokenStore.DeleteForUser(user.ID, tokens.PurposePasswordReset); err != nil {
+		return "", err
+	}
+
+	token, err := tokens.NewToken(user.ID, tokens.PurposePasswordReset, s.passwordResetLifetime)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = s.tokenStore.Create(*token); err != nil {
+		return "", err
+	}
+
+	return token.Value, nil
+}
+
+func (s Service) validToken(purpose tokens.Purpose, value string) (*tokens.Token, *users.User, error) {
+	token, err := s.tokenStore.GetForValue(purpose, value)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if token.Used() || token.Expired(time.Now()) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	user, err := s.userStore.Get(token.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	return token, user, nil
+}
+
+func (s Service) VerifyPasswordResetToken(token string) (*users.User, error) {
+	_, user, err := s.validToken(tokens.PurposePasswordReset, token)
+	return user, err
+}
+
+// ResetPassword consumes the reset token, sets the new password and revokes every session of the user.
+func (s Service) ResetPassword(value, password string) error {
+	token, user, err := s.validToken(tokens.PurposePasswordReset, value)
+	if err != nil {
+		return err
+	}
+
+	if err = s.passwordPolicy.Check(password, user.Email); err != nil {
+		return err
+	}
+
+	if _, err = s.tokenStore.MarkUsed(token.ID, time.Now()); err != nil {
+		if errors.Is(err, tokens.ErrTokenAlreadyUsed) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	passwordHash, err := s.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = passwordHash
+	user.PasswordResetRequired = false
+	if _, err = s.userStore.Update(*user); err != nil {
+		return err
+	}
+
+	if err = s.tokenStore.DeleteForUser(user.ID, tokens.PurposePasswordReset); err != nil {
+		log.Printf("failed to clean up password reset tokens: %s", err)
+	}
+
+	return s.RevokeAllSessions(user.ID)
+}
+
+// verifyPassword treats a hash that cannot be read as a wrong password
+func (s Service) verifyPassword(user *users.User, password string) bool {
+	ok, _, err := s.passwords.Verify(password, user.PasswordHash)
+	if err != nil {
+		log.Printf("failed to verify password hash for user %s: %s", user.ID, err)
+	}
+
+	return ok
+}
+
+// ChangePassword sets a new password once the current one has been verified.
+// Every other session of the user is revoked, the current one stays open.
+// Wrong current passwords count towards the login throttle, it returns a ThrottledError once there were too many.
+func (s Service) ChangePassword(userID, currentSessionID uuid.UUID, currentPassword, newPassword string) error {
+	user, err := s.userStore.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.verifyThrottled(user, currentPassword, s.verifyPassword, ErrInvalidCredentials); err != nil {
+		return err
+	}
+
+	if err = s.passwordPolicy.Check(newPassword, user.Email); err != nil {
+		return err
+	}
+
+	if user.PasswordHash, err = s.passwords.Hash(newPassword); err != nil {
+		return err
+	}
+	user.PasswordResetRequired = false
+
+	if _, err = s.userStore.Update(*user); err != nil {
+		return err
+	}
+
+	return s.RevokeOtherSessions(user.ID, currentSessionID)
+}
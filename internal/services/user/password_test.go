@@ -0,0 +1,181 @@
+package user
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"testing"
+	"time"
+)
+
+func TestService_RequestPasswordReset(t *testing.T) {
+	t.Run("unknown email", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		_, _, err := service.RequestPasswordReset("unknown@example.com")
+		if !errors.Is(err, users.ErrUserNotFound) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", users.ErrUserNotFound, err)
+		}
+	})
+
+	t.Run("new request invalidates the previous one", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		if _, err := service.Register("test@example.com", "correct horse battery"); err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		_, first, err := service.RequestPasswordReset("test@example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, second, err := service.RequestPasswordReset("test@example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err = service.VerifyPasswordResetToken(first); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("previous token still valid. got: %v", err)
+		}
+
+		if _, err = service.VerifyPasswordResetToken(second); err != nil {
+			t.Errorf("unexpected error for latest token: %s", err)
+		}
+	})
+}
+
+func TestService_ResetPassword(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		service, _, sessionStore := newTestService(t)
+		u, err := service.Register("test@example.com", "correct horse battery")
+		if err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+		createTestSessions(t, service, u.ID, 2)
+
+		_, token, err := service.RequestPasswordReset(u.Email)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err = service.ResetPassword(token, "new-password"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		remaining, _ := sessionStore.GetForUser(u.ID)
+		if len(remaining) != 0 {
+			t.Errorf("sessions were not revoked. remaining: %d", len(remaining))
+		}
+
+		if _, _, err = service.LoginWithCredentials(u.Email, "new-password", "127.0.0.1"); err != nil {
+			t.Errorf("cannot login with the new password: %s", err)
+		}
+
+		if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "127.0.0.1"); err == nil {
+			t.Errorf("old password still valid")
+		}
+
+		if err = service.ResetPassword(token, "another-password"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("token should be single use. got: %v", err)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		userStore := users.NewUserMemoryStore()
+		service := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+			WithPasswordResetLifetime(-time.Minute))
+		if _, err := service.Register("test@example.com", "correct horse battery"); err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		_, token, err := service.RequestPasswordReset("test@example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err = service.ResetPassword(token, "new-password"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidToken, err)
+		}
+	})
+
+	t.Run("password policy", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		if _, err := service.Register("alice@example.com", "correct horse battery"); err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+
+		_, token, err := service.RequestPasswordReset("alice@example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err = service.ResetPassword(token, "alice-was-here"); !errors.Is(err, validate.ErrPasswordContainsEmail) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", validate.ErrPasswordContainsEmail, err)
+		}
+
+		if err = service.ResetPassword(token, "another battery staple"); err != nil {
+			t.Errorf("rejected password should not consume the token. got: %v", err)
+		}
+	})
+}
+
+func TestService_ChangePassword(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		service, _, sessionStore := newTestService(t)
+		u, err := service.Register("test@example.com", "correct horse battery")
+		if err != nil {
+			t.Fatalf("unexpected error while registering: %s", err)
+		}
+		userSessions := createTestSessions(t, service, u.ID, 3)
+
+		if err = service.ChangePassword(u.ID, userSessions[0].ID, "correct horse battery", "another battery staple"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		remaining, _ := sessionStore.GetForUser(u.ID)
+		if len(remaining) != 1 || remaining[0].ID != userSessions[0].ID {
+			t.Errorf("only the current session should remain. got: %v", remaining)
+		}
+
+		if _, _, err = service.LoginWithCredentials(u.Email, "another battery staple", "127.0.0.1"); err != nil {
+			t.Errorf("cannot login with the new password: %s", err)
+		}
+	})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		u, _ := service.Register("test@example.com", "correct horse battery")
+
+		err := service.ChangePassword(u.ID, uuid.New(), "wrong", "another battery staple")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidCredentials, err)
+		}
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		service, _, u := newThrottledTestService(t)
+
+		for i := 0; i < testThrottlePolicy.MaxAccountFailures; i++ {
+			if err := service.ChangePassword(u.ID, uuid.New(), "wrong", "another battery staple"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("attempt %d: unexpected error. expected: %s. got: %v", i, ErrInvalidCredentials, err)
+			}
+		}
+
+		var throttled ThrottledError
+		if err := service.ChangePassword(u.ID, uuid.New(), "correct horse battery", "another battery staple"); !errors.As(err, &throttled) {
+			t.Errorf("unexpected error. expected throttled error. got: %v", err)
+		}
+	})
+
+	t.Run("password policy", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		u, _ := service.Register("test@example.com", "correct horse battery")
+
+		err := service.ChangePassword(u.ID, uuid.New(), "correct horse battery", "short")
+		if !errors.Is(err, validate.ErrPasswordTooShort) {
+			t.Errorf("unexpected error. expected: %s. got: %v", validate.ErrPasswordTooShort, err)
+		}
+	})
+}
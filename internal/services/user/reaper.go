@@ -0,0 +1,34 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type sessionPurger interface {
+	PurgeExpiredSessions() (int, error)
+}
+
+// RunSessionReaper purges expired sessions every interval until ctx is cancelled.
+func RunSessionReaper(ctx context.Context, purger sessionPurger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := purger.PurgeExpiredSessions()
+			if err != nil {
+				log.Printf("session reaper error: %s", err)
+				continue
+			}
+
+			if deleted > 0 {
+				log.Printf("session reaper: %d expired sessions deleted", deleted)
+			}
+		}
+	}
+}
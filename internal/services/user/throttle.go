@@ -0,0 +1,140 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/throttle"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("too many login attempts")
+
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e ThrottledError) Error() string {
+	return fmt.Sprintf("%s. retry after %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e ThrottledError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// loginThrottle tracks failed logins per account and per IP.
+// Accounts are keyed by normalized email so unknown emails get throttled just like existing ones.
+type loginThrottle struct {
+	policy  LoginThrottlePolicy
+	account *throttle.SlidingWindow
+	ip      *throttle.SlidingWindow
+	// lockouts mirrors User.LockedUntil, including for emails without an account
+	lockouts map[string]time.Time
+	mu       sync.Mutex
+}
+
+func newLoginThrottle(policy LoginThrottlePolicy) *loginThrottle {
+	return &loginThrottle{
+		policy:   policy,
+		account:  throttle.NewSlidingWindow(policy.Window),
+		ip:       throttle.NewSlidingWindow(policy.Window),
+		lockouts: make(map[string]time.Time),
+	}
+}
+
+// check returns a ThrottledError when the account or the IP is not allowed to attempt a login
+func (l *loginThrottle) check(accountKey, ip string, now time.Time) error {
+	if ip != "" && l.ip.Count(ip) >= l.policy.MaxIPFailures {
+		return ThrottledError{RetryAfter: l.ip.RetryAfter(ip)}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until, ok := l.lockouts[accountKey]; ok {
+		if now.Before(until) {
+			return ThrottledError{RetryAfter: until.Sub(now)}
+		}
+
+		delete(l.lockouts, accountKey)
+	}
+
+	return nil
+}
+
+// failure records a failed attempt and returns the account failure count and lockout end, if any
+func (l *loginThrottle) failure(accountKey, ip string, now time.Time) (int, *time.Time) {
+	if ip != "" {
+		l.ip.Add(ip)
+	}
+
+	failures := l.account.Add(accountKey)
+	if failures < l.policy.MaxAccountFailures {
+		return failures, nil
+	}
+
+	until := now.Add(throttle.Backoff(l.policy.BaseLockout, l.policy.MaxLockout, failures-l.policy.MaxAccountFailures))
+
+	l.mu.Lock()
+	l.lockouts[accountKey] = until
+	l.mu.Unlock()
+
+	return failures, &until
+}
+
+func (l *loginThrottle) reset(accountKey string) {
+	l.account.Reset(accountKey)
+
+	l.mu.Lock()
+	delete(l.lockouts, accountKey)
+	l.mu.Unlock()
+}
+
+func (s Service) loginFailed(accountKey, ip string, user *users.User, now time.Time) {
+	failures, lockedUntil := s.loginThrottle.failure(accountKey, ip, now)
+	if user == nil {
+		return
+	}
+
+	user.FailedLogins = failures
+	if lockedUntil != nil {
+		user.LockedUntil = lockedUntil
+	}
+
+	if _, err := s.userStore.Update(*user); err != nil {
+		log.Printf("failed to record failed login: %s", err)
+	}
+}
+
+func (s Service) loginSucceeded(accountKey string, user *users.User) {
+	s.loginThrottle.reset(accountKey)
+
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if _, err := s.userStore.Update(*user); err != nil {
+		log.Printf("failed to reset failed logins: %s", err)
+	}
+}
+
+// UnlockAccount lifts the lockout of an account and forgets its failed attempts.
+func (s Service) UnlockAccount(userID uuid.UUID) error {
+	user, err := s.userStore.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	s.loginThrottle.reset(users.NormalizeEmail(user.Email))
+
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	_, err = s.userStore.Update(*user)
+
+	return err
+}
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"testing"
+	"time"
+)
+
+var testThrottlePolicy = LoginThrottlePolicy{
+	Window:             time.Minute,
+	MaxAccountFailures: 3,
+	MaxIPFailures:      5,
+	BaseLockout:        time.Minute,
+	MaxLockout:         time.Hour,
+}
+
+func newThrottledTestService(t testing.TB) (*Service, *users.UserMemoryStore, *users.User) {
+	t.Helper()
+	userStore := users.NewUserMemoryStore()
+	service := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		WithLoginThrottlePolicy(testThrottlePolicy), WithPasswordHasher(testPasswordHasher))
+
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	return service, userStore, u
+}
+
+func TestService_LoginWithCredentialsThrottling(t *testing.T) {
+	t.Run("account lockout", func(t *testing.T) {
+		service, userStore, u := newThrottledTestService(t)
+
+		for i := 0; i < testThrottlePolicy.MaxAccountFailures; i++ {
+			matched, _, err := service.LoginWithCredentials(u.Email, "wrong", "10.0.0.1")
+			if err == nil || errors.Is(err, ErrTooManyAttempts) {
+				t.Fatalf("attempt %d: unexpected error: %v", i, err)
+			}
+
+			if matched == nil || matched.ID != u.ID {
+				t.Errorf("attempt %d: failure should come with the matched user. got: %v", i, matched)
+			}
+		}
+
+		// correct password from another IP is still rejected
+		_, _, err := service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.2")
+		var throttled ThrottledError
+		if !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+			t.Fatalf("unexpected error. expected throttled error. got: %v", err)
+		}
+
+		stored, _ := userStore.Get(u.ID)
+		if !stored.Locked(time.Now()) || stored.FailedLogins != testThrottlePolicy.MaxAccountFailures {
+			t.Errorf("lockout not stored on the user. got: %v", stored)
+		}
+
+		if err = service.UnlockAccount(u.ID); err != nil {
+			t.Fatalf("unexpected error while unlocking: %s", err)
+		}
+
+		if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.2"); err != nil {
+			t.Errorf("unexpected error after unlock: %s", err)
+		}
+	})
+
+	t.Run("lockout expires", func(t *testing.T) {
+		service, userStore, u := newThrottledTestService(t)
+		past := time.Now().Add(-time.Second)
+		u.LockedUntil = &past
+		u.FailedLogins = 10
+		if _, err := userStore.Update(*u); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, _, err := service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		stored, _ := userStore.Get(u.ID)
+		if stored.LockedUntil != nil || stored.FailedLogins != 0 {
+			t.Errorf("lockout not cleared after successful login. got: %v", stored)
+		}
+	})
+
+	t.Run("unknown accounts are throttled too", func(t *testing.T) {
+		service, _, _ := newThrottledTestService(t)
+
+		for i := 0; i < testThrottlePolicy.MaxAccountFailures; i++ {
+			_, _, _ = service.LoginWithCredentials("unknown@example.com", "wrong", "10.0.0.1")
+		}
+
+		_, _, err := service.LoginWithCredentials("unknown@example.com", "wrong", "10.0.0.1")
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrTooManyAttempts, err)
+		}
+	})
+
+	t.Run("ip throttle", func(t *testing.T) {
+		service, _, u := newThrottledTestService(t)
+
+		for i := 0; i < testThrottlePolicy.MaxIPFailures; i++ {
+			_, _, _ = service.LoginWithCredentials("unknown@example.com", "wrong", "10.0.0.1")
+			service.loginThrottle.reset(users.NormalizeEmail("unknown@example.com"))
+		}
+
+		_, _, err := service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1")
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrTooManyAttempts, err)
+		}
+
+		if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.2"); err != nil {
+			t.Errorf("other IPs should not be throttled: %s", err)
+		}
+	})
+}
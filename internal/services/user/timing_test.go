@@ -0,0 +1,92 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"slices"
+	"testing"
+	"time"
+)
+
+// medianDuration runs fn several times and returns the median duration, which is less sensitive to scheduling noise
+func medianDuration(runs int, fn func(i int)) time.Duration {
+	durations := make([]time.Duration, runs)
+	for i := range durations {
+		start := time.Now()
+		fn(i)
+		durations[i] = time.Since(start)
+	}
+
+	slices.Sort(durations)
+	return durations[runs/2]
+}
+
+func assertTimingParity(t testing.TB, a, b time.Duration) {
+	t.Helper()
+	ratio := float64(a) / float64(b)
+	if ratio < 0.5 || ratio > 2 {
+		t.Errorf("timings differ too much. a: %s. b: %s. ratio: %.2f", a, b, ratio)
+	}
+}
+
+func newTimingTestService(t testing.TB) *Service {
+	t.Helper()
+	policy := DefaultLoginThrottlePolicy
+	policy.MaxAccountFailures = 1000
+	policy.MaxIPFailures = 1000
+
+	service := NewService(users.NewUserMemoryStore(), sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		WithLoginThrottlePolicy(policy))
+	if _, err := service.Register("known@example.com", "correct horse battery"); err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	return service
+}
+
+func TestLoginTimingParity(t *testing.T) {
+	service := newTimingTestService(t)
+	const runs = 7
+
+	// warm up the dummy hash so that its generation is not measured
+	_, _, _ = service.LoginWithCredentials("unknown@example.com", "password", "10.0.0.1")
+
+	unknownEmail := medianDuration(runs, func(i int) {
+		_, _, err := service.LoginWithCredentials("unknown@example.com", "password", "10.0.0.1")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("unexpected error for unknown email: %v", err)
+		}
+	})
+
+	wrongPassword := medianDuration(runs, func(i int) {
+		_, _, err := service.LoginWithCredentials("known@example.com", "wrong-password", "10.0.0.1")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("unexpected error for wrong password: %v", err)
+		}
+	})
+
+	assertTimingParity(t, unknownEmail, wrongPassword)
+}
+
+func TestRegisterTimingParity(t *testing.T) {
+	service := newTimingTestService(t)
+	const runs = 7
+
+	newAccount := medianDuration(runs, func(i int) {
+		if _, err := service.Register(fmt.Sprintf("new%d@example.com", i), "correct horse battery"); err != nil {
+			t.Errorf("unexpected error for new account: %s", err)
+		}
+	})
+
+	existingAccount := medianDuration(runs, func(i int) {
+		_, err := service.Register("known@example.com", "correct horse battery")
+		if !errors.Is(err, users.ErrEmailAlreadyUsed) {
+			t.Errorf("unexpected error for existing account: %v", err)
+		}
+	})
+
+	assertTimingParity(t, newAccount, existingAccount)
+}
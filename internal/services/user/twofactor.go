@@ -0,0 +1,327 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/encryption"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/totp"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSecondFactorRequired    = errors.New("second factor required")
+	ErrInvalidSecondFactor     = errors.New("invalid authentication code")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication not enabled")
+)
+
+const recoveryCodeCount = 10
+
+// SecondFactorRequiredError is returned by LoginWithCredentials once the password has been verified
+// for an account with two-factor authentication. Challenge is then passed to LoginWithSecondFactor.
+type SecondFactorRequiredError struct {
+	Challenge string
+}
+
+func (e SecondFactorRequiredError) Error() string {
+	return ErrSecondFactorRequired.Error()
+}
+
+func (e SecondFactorRequiredError) Is(target error) bool {
+	return target == ErrSecondFactorRequired
+}
+
+type TOTPEnrollment struct {
+	// Secret is base32 encoded, for users who cannot scan the QR code
+	Secret string
+	URI    string
+}
+
+func defaultSecretBox() *encryption.Box {
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		panic(err)
+	}
+
+	box, err := encryption.NewBox(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return box
+}
+
+func (s Service) totpSecret(user *users.User) ([]byte, error) {
+	return s.secretBox.Open(user.TOTPSecret, user.ID[:])
+}
+
+// BeginTOTPEnrollment stores a new pending secret, or reuses the pending one so that
+// reloading the enrollment page does not invalidate a code that has already been scanned.
+func (s Service) BeginTOTPEnrollment(user *users.User) (*TOTPEnrollment, error) {
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := s.totpSecret(user)
+	if user.TOTPSecret == "" || err != nil {
+		if secret, err = totp.GenerateSecret(); err != nil {
+			return nil, err
+		}
+
+		if user.TOTPSecret, err = s.secretBox.Seal(secret, user.ID[:]); err != nil {
+			return nil, err
+		}
+
+		if _, err = s.userStore.Update(*user); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TOTPEnrollment{
+		Secret: totp.Encoding.EncodeToString(secret),
+		URI:    s.totp.URI(s.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// EnableTOTP switches two-factor authentication on once code proves the authenticator has been set up.
+// The recovery codes are only returned here. Wrong codes count towards the login throttle of the account.
+func (s Service) EnableTOTP(userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.userStore.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	if err = s.verifyThrottled(user, code, s.verifyTOTP, ErrInvalidSecondFactor); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.RecoveryCodes = hashes
+	if _, err = s.userStore.Update(*user); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP requires a valid code, or a recovery code, from the second factor being removed.
+// Like EnableTOTP and RegenerateRecoveryCodes, it returns a ThrottledError once too many codes were wrong.
+func (s Service) DisableTOTP(userID uuid.UUID, code string) error {
+	user, err := s.enabledUserWithCode(userID, code)
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastCounter = 0
+	user.RecoveryCodes = nil
+	_, err = s.userStore.Update(*user)
+
+	return err
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not.
+func (s Service) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.enabledUserWithCode(userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.RecoveryCodes = hashes
+	if _, err = s.userStore.Update(*user); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s Service) enabledUserWithCode(userID uuid.UUID, code string) (*users.User, error) {
+	user, err := s.userStore.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if err = s.verifyThrottled(user, code, s.verifySecondFactor, ErrInvalidSecondFactor); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// verifyThrottled checks code with verify under the same per-account throttle as logins, a stolen session must not
+// be enough to guess codes or passwords. A wrong code returns invalid.
+func (s Service) verifyThrottled(user *users.User, code string, verify func(*users.User, string) bool, invalid error) error {
+	accountKey := users.NormalizeEmail(user.Email)
+	now := time.Now()
+
+	if err := s.loginThrottle.check(accountKey, "", now); err != nil {
+		return err
+	}
+
+	if user.Locked(now) {
+		return ThrottledError{RetryAfter: user.LockedUntil.Sub(now)}
+	}
+
+	if !verify(user, code) {
+		s.loginFailed(accountKey, "", user, now)
+		return invalid
+	}
+
+	s.loginSucceeded(accountKey, user)
+
+	return nil
+}
+
+// LoginWithSecondFactor completes a login started by LoginWithCredentials.
+// Failures count towards the same throttle as wrong passwords and come with the user, as in LoginWithCredentials.
+func (s Service) LoginWithSecondFactor(challenge, code, ip string) (*users.User, *sessions.Session, error) {
+	token, user, err := s.validToken(tokens.PurposeLoginChallenge, challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accountKey := users.NormalizeEmail(user.Email)
+	now := time.Now()
+
+	if err = s.loginThrottle.check(accountKey, ip, now); err != nil {
+		return user, nil, err
+	}
+
+	if user.Locked(now) {
+		return user, nil, ThrottledError{RetryAfter: user.LockedUntil.Sub(now)}
+	}
+
+	if !user.TwoFactorEnabled() || !s.verifySecondFactor(user, code) {
+		s.loginFailed(accountKey, ip, user, now)
+		return user, nil, ErrInvalidSecondFactor
+	}
+
+	if _, err = s.tokenStore.MarkUsed(token.ID, now); err != nil {
+		if errors.Is(err, tokens.ErrTokenAlreadyUsed) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	if user, err = s.userStore.Update(*user); err != nil {
+		return nil, nil, err
+	}
+
+	if err = s.tokenStore.DeleteForUser(user.ID, tokens.PurposeLoginChallenge); err != nil {
+		log.Printf("failed to clean up login challenges: %s", err)
+	}
+
+	s.loginSucceeded(accountKey, user)
+
+	return s.Login(user)
+}
+
+func (s Service) secondFactorChallenge(user *users.User) (string, error) {
+	token, err := tokens.NewToken(user.ID, tokens.PurposeLoginChallenge, s.secondFactorLifetime)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = s.tokenStore.Create(*token); err != nil {
+		return "", err
+	}
+
+	return token.Value, nil
+}
+
+// verifySecondFactor accepts a TOTP code or consumes a recovery code. The user is updated in place, not persisted.
+func (s Service) verifySecondFactor(user *users.User, code string) bool {
+	if s.verifyTOTP(user, code) {
+		return true
+	}
+
+	return consumeRecoveryCode(user, code)
+}
+
+// verifyTOTP rejects codes from time steps at or before the last accepted one
+func (s Service) verifyTOTP(user *users.User, code string) bool {
+	secret, err := s.totpSecret(user)
+	if err != nil {
+		if user.TOTPSecret != "" {
+			log.Printf("failed to decrypt TOTP secret for user %s: %s", user.ID, err)
+		}
+		return false
+	}
+
+	counter, ok := s.totp.Validate(secret, code, time.Now())
+	if !ok || counter <= user.TOTPLastCounter {
+		return false
+	}
+
+	user.TOTPLastCounter = counter
+	return true
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func consumeRecoveryCode(user *users.User, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+
+	hash := tokens.Hash(code)
+	for i, candidate := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			// build a new slice, the previous one may be shared with the store
+			remaining := append([]string{}, user.RecoveryCodes[:i]...)
+			user.RecoveryCodes = append(remaining, user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateRecoveryCodes returns codes formatted as xxxxx-xxxxx and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		value := strings.ToLower(totp.Encoding.EncodeToString(raw))[:10]
+		codes[i] = value[:5] + "-" + value[5:]
+		hashes[i] = tokens.Hash(value)
+	}
+
+	return codes, hashes, nil
+}
@@ -0,0 +1,257 @@
+package user
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/totp"
+	"testing"
+	"time"
+)
+
+func currentCode(t testing.TB, enrollment *TOTPEnrollment, offset int64) string {
+	t.Helper()
+	secret, err := totp.Encoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("unexpected error decoding secret: %s", err)
+	}
+
+	return totp.DefaultConfig.Code(secret, totp.DefaultConfig.Counter(time.Now())+offset)
+}
+
+// newTwoFactorUser registers a user and enables TOTP, returning the enrollment and recovery codes
+func newTwoFactorUser(t testing.TB, service *Service) (*users.User, *TOTPEnrollment, []string) {
+	t.Helper()
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	enrollment, err := service.BeginTOTPEnrollment(u)
+	if err != nil {
+		t.Fatalf("unexpected error while enrolling: %s", err)
+	}
+
+	codes, err := service.EnableTOTP(u.ID, currentCode(t, enrollment, -1))
+	if err != nil {
+		t.Fatalf("unexpected error while enabling TOTP: %s", err)
+	}
+
+	return u, enrollment, codes
+}
+
+func TestService_TOTPEnrollment(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	enrollment, err := service.BeginTOTPEnrollment(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stored, _ := userStore.Get(u.ID)
+	if stored.TOTPSecret == "" || stored.TOTPSecret == enrollment.Secret {
+		t.Errorf("secret should be stored encrypted. got: %q", stored.TOTPSecret)
+	}
+
+	if stored.TwoFactorEnabled() {
+		t.Errorf("two-factor should not be enabled before confirmation")
+	}
+
+	t.Run("pending secret is reused", func(t *testing.T) {
+		again, err := service.BeginTOTPEnrollment(stored)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if again.Secret != enrollment.Secret {
+			t.Errorf("pending secret should not change")
+		}
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		if _, err = service.EnableTOTP(u.ID, "000000"); !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidSecondFactor, err)
+		}
+	})
+
+	t.Run("confirmation", func(t *testing.T) {
+		codes, err := service.EnableTOTP(u.ID, currentCode(t, enrollment, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(codes) != recoveryCodeCount {
+			t.Errorf("unexpected amount of recovery codes: %d", len(codes))
+		}
+
+		stored, _ := userStore.Get(u.ID)
+		if !stored.TwoFactorEnabled() {
+			t.Errorf("two-factor should be enabled")
+		}
+
+		for _, hash := range stored.RecoveryCodes {
+			for _, code := range codes {
+				if hash == code {
+					t.Fatalf("recovery codes should be stored hashed")
+				}
+			}
+		}
+	})
+
+	t.Run("already enabled", func(t *testing.T) {
+		stored, _ := userStore.Get(u.ID)
+		if _, err = service.BeginTOTPEnrollment(stored); !errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrTwoFactorAlreadyEnabled, err)
+		}
+	})
+}
+
+func TestService_LoginWithSecondFactor(t *testing.T) {
+	loginChallenge := func(t *testing.T, service *Service) string {
+		t.Helper()
+		_, session, err := service.LoginWithCredentials("test@example.com", "correct horse battery", "10.0.0.1")
+
+		var required SecondFactorRequiredError
+		if !errors.As(err, &required) || session != nil {
+			t.Fatalf("expected a second factor challenge. got: %v, %v", session, err)
+		}
+
+		return required.Challenge
+	}
+
+	t.Run("totp code", func(t *testing.T) {
+		service, _, sessionStore := newTestService(t)
+		u, enrollment, _ := newTwoFactorUser(t, service)
+		challenge := loginChallenge(t, service)
+
+		if userSessions, _ := sessionStore.GetForUser(u.ID); len(userSessions) != 0 {
+			t.Fatalf("no session should exist before the second factor")
+		}
+
+		if _, _, err := service.LoginWithSecondFactor(challenge, "000000", "10.0.0.1"); !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrInvalidSecondFactor, err)
+		}
+
+		_, session, err := service.LoginWithSecondFactor(challenge, currentCode(t, enrollment, 0), "10.0.0.1")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if session == nil || session.UserID != u.ID {
+			t.Errorf("unexpected session: %v", session)
+		}
+
+		if _, _, err = service.LoginWithSecondFactor(challenge, currentCode(t, enrollment, 1), "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("challenge should be single use. got: %v", err)
+		}
+	})
+
+	t.Run("codes cannot be replayed", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		_, enrollment, _ := newTwoFactorUser(t, service)
+		code := currentCode(t, enrollment, 0)
+
+		if _, _, err := service.LoginWithSecondFactor(loginChallenge(t, service), code, "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, _, err := service.LoginWithSecondFactor(loginChallenge(t, service), code, "10.0.0.1"); !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidSecondFactor, err)
+		}
+	})
+
+	t.Run("recovery code is single use", func(t *testing.T) {
+		service, userStore, _ := newTestService(t)
+		u, _, codes := newTwoFactorUser(t, service)
+
+		if _, _, err := service.LoginWithSecondFactor(loginChallenge(t, service), " "+codes[3]+" ", "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if stored, _ := userStore.Get(u.ID); len(stored.RecoveryCodes) != recoveryCodeCount-1 {
+			t.Errorf("recovery code not consumed. remaining: %d", len(stored.RecoveryCodes))
+		}
+
+		if _, _, err := service.LoginWithSecondFactor(loginChallenge(t, service), codes[3], "10.0.0.1"); !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrInvalidSecondFactor, err)
+		}
+	})
+
+	t.Run("failures are throttled", func(t *testing.T) {
+		service, _, _ := newTestService(t)
+		newTwoFactorUser(t, service)
+		challenge := loginChallenge(t, service)
+
+		var err error
+		for i := 0; i < DefaultLoginThrottlePolicy.MaxAccountFailures+1; i++ {
+			_, _, err = service.LoginWithSecondFactor(challenge, "000000", "10.0.0.1")
+		}
+
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrTooManyAttempts, err)
+		}
+	})
+}
+
+func TestService_DisableTOTP(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	u, enrollment, codes := newTwoFactorUser(t, service)
+
+	if err := service.DisableTOTP(u.ID, "000000"); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Fatalf("unexpected error. expected: %s. got: %v", ErrInvalidSecondFactor, err)
+	}
+
+	newCodes, err := service.RegenerateRecoveryCodes(u.ID, currentCode(t, enrollment, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err = service.DisableTOTP(u.ID, codes[0]); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("previous recovery codes should be invalidated. got: %v", err)
+	}
+
+	if err = service.DisableTOTP(u.ID, newCodes[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stored, _ := userStore.Get(u.ID)
+	if stored.TwoFactorEnabled() || stored.TOTPSecret != "" || len(stored.RecoveryCodes) != 0 {
+		t.Errorf("two-factor settings should be cleared. got: %+v", stored)
+	}
+
+	if _, session, err := service.LoginWithCredentials("test@example.com", "correct horse battery", "10.0.0.1"); err != nil || session == nil {
+		t.Errorf("login should not require a second factor anymore. got: %v", err)
+	}
+}
+
+func TestService_TOTPManagementThrottling(t *testing.T) {
+	service, _, u := newThrottledTestService(t)
+
+	enrollment, err := service.BeginTOTPEnrollment(u)
+	if err != nil {
+		t.Fatalf("unexpected error while enrolling: %s", err)
+	}
+
+	if _, err = service.EnableTOTP(u.ID, currentCode(t, enrollment, -1)); err != nil {
+		t.Fatalf("unexpected error while enabling TOTP: %s", err)
+	}
+
+	for i := 0; i < testThrottlePolicy.MaxAccountFailures; i++ {
+		if err = service.DisableTOTP(u.ID, "000000"); !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Fatalf("attempt %d: unexpected error. expected: %s. got: %v", i, ErrInvalidSecondFactor, err)
+		}
+	}
+
+	// the right code is rejected too until the lockout expires
+	var throttled ThrottledError
+	if _, err = service.RegenerateRecoveryCodes(u.ID, currentCode(t, enrollment, 0)); !errors.As(err, &throttled) {
+		t.Errorf("unexpected error. expected throttled error. got: %v", err)
+	}
+
+	if _, _, err = service.LoginWithCredentials(u.Email, "correct horse battery", "10.0.0.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("logins share the throttle. expected: %s. got: %v", ErrTooManyAttempts, err)
+	}
+}
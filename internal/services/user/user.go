@@ -4,43 +4,164 @@ import (
 	"context"
 	"errors"
 	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/encryption"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/passwords"
 	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
 	"github.com/mathieuhays/auth/internal/stores/users"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/mathieuhays/auth/internal/totp"
+	"github.com/mathieuhays/auth/internal/validate"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
 	"time"
 )
 
 const authCookie = "session_token"
 
+var (
+	ErrSessionExpired     = errors.New("session expired")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrMissingBearerToken = errors.New("missing bearer token")
+	ErrAccountDisabled    = errors.New("account disabled")
+	// ErrPasswordResetRequired is returned for valid credentials once an administrator forced a password reset
+	ErrPasswordResetRequired = errors.New("password reset required")
+)
+
 type ContextKey string
 
 const UserContextKey = "user"
 const SessionContextKey = "session"
+const ScopesContextKey = "scopes"
+const ClientIPContextKey = "client_ip"
 
 type ServiceInterface interface {
 	Login(user *users.User) (*users.User, *sessions.Session, error)
-	LoginWithCredentials(email, password string) (*users.User, *sessions.Session, error)
+	LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error)
 	LoginWithToken(sessionToken string) (*users.User, *sessions.Session, error)
 	Register(email, password string) (*users.User, error)
 	SetAuthResponse(writer http.ResponseWriter, session *sessions.Session) error
 	RetrieveAuthFromRequest(request *http.Request) (*users.User, *sessions.Session, error)
+	SessionFromRequest(request *http.Request) (*sessions.Session, error)
+	RetrieveAuthFromBearer(request *http.Request) (*users.User, *sessions.Session, error)
+	IssueTokens(session *sessions.Session) (*TokenPair, error)
+	RefreshTokens(refreshToken string) (*TokenPair, error)
+	VerifyAccessToken(token string) (*jwt.Claims, error)
+	JWKS() jwt.JWKS
+	Logout(writer http.ResponseWriter, session *sessions.Session) error
+	RevokeSession(userID, sessionID uuid.UUID) error
+	RevokeAllSessions(userID uuid.UUID) error
+	RevokeOtherSessions(userID, currentSessionID uuid.UUID) error
+	Sessions(userID uuid.UUID) ([]sessions.Session, error)
+	RequestPasswordReset(email string) (*users.User, string, error)
+	VerifyPasswordResetToken(token string) (*users.User, error)
+	ResetPassword(token, password string) error
+	ChangePassword(userID, currentSessionID uuid.UUID, currentPassword, newPassword string) error
+	RequestEmailConfirmation(user *users.User) (string, error)
+	ConfirmEmail(token string) (*users.User, error)
+	UnlockAccount(userID uuid.UUID) error
+	SearchUsers(query string, page, perPage int) (*UserPage, error)
+	GetUser(id uuid.UUID) (*users.User, error)
+	DisableUser(id uuid.UUID) error
+	EnableUser(id uuid.UUID) error
+	ForcePasswordReset(id uuid.UUID) (*users.User, string, error)
+	GrantRole(id uuid.UUID, role users.Role) error
+	RevokeRole(id uuid.UUID, role users.Role) error
+	ValidatePassword(password, email string) (validate.Strength, error)
+	LoginWithSecondFactor(challenge, code, ip string) (*users.User, *sessions.Session, error)
+	BeginTOTPEnrollment(user *users.User) (*TOTPEnrollment, error)
+	EnableTOTP(userID uuid.UUID, code string) ([]string, error)
+	DisableTOTP(userID uuid.UUID, code string) error
+	RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error)
 }
 
 type Service struct {
-	userStore    users.UserStoreInterface
-	sessionStore sessions.SessionStoreInterface
+	userStore             users.UserStoreInterface
+	sessionStore          sessions.SessionStoreInterface
+	tokenStore            tokens.TokenStoreInterface
+	passwords             *passwords.Manager
+	passwordPolicy        validate.PasswordPolicy
+	sessionPolicy         SessionPolicy
+	cookiePolicy          CookiePolicy
+	passwordResetLifetime time.Duration
+
+	emailConfirmationLifetime  time.Duration
+	confirmationResendInterval time.Duration
+
+	loginThrottle *loginThrottle
+
+	totp                 totp.Config
+	totpIssuer           string
+	secondFactorLifetime time.Duration
+	// secretBox encrypts secrets stored on users, such as TOTP secrets
+	secretBox *encryption.Box
+
+	tokenKeys           *jwt.KeySet
+	tokenIssuer         string
+	accessTokenLifetime time.Duration
+
+	// adminEmails are normalized, see WithAdminEmails
+	adminEmails []string
 }
 
-func NewService(userStore users.UserStoreInterface, sessionStore sessions.SessionStoreInterface) *Service {
-	return &Service{
-		userStore:    userStore,
-		sessionStore: sessionStore,
+func NewService(
+	userStore users.UserStoreInterface,
+	sessionStore sessions.SessionStoreInterface,
+	tokenStore tokens.TokenStoreInterface,
+	options ...Option,
+) *Service {
+	service := &Service{
+		userStore:             userStore,
+		sessionStore:          sessionStore,
+		tokenStore:            tokenStore,
+		passwords:             passwords.DefaultManager(),
+		passwordPolicy:        validate.DefaultPasswordPolicy,
+		sessionPolicy:         DefaultSessionPolicy,
+		cookiePolicy:          DefaultCookiePolicy,
+		passwordResetLifetime: DefaultPasswordResetLifetime,
+
+		emailConfirmationLifetime:  DefaultEmailConfirmationLifetime,
+		confirmationResendInterval: DefaultConfirmationResendInterval,
+
+		loginThrottle: newLoginThrottle(DefaultLoginThrottlePolicy),
+
+		totp:                 totp.DefaultConfig,
+		totpIssuer:           DefaultTOTPIssuer,
+		secondFactorLifetime: DefaultSecondFactorLifetime,
+
+		tokenIssuer:         DefaultTokenIssuer,
+		accessTokenLifetime: DefaultAccessTokenLifetime,
+	}
+
+	for _, option := range options {
+		option(service)
+	}
+
+	// stores are in memory, secrets do not need to outlive the process unless a key is configured
+	if service.secretBox == nil {
+		service.secretBox = defaultSecretBox()
 	}
+
+	// access tokens issued with a random key do not survive a restart, clients fall back to their refresh token
+	if service.tokenKeys == nil {
+		service.tokenKeys = defaultTokenKeys()
+	}
+
+	return service
 }
 
+// Login opens a session for a user who proved who they are. Disabled users get ErrAccountDisabled along with their account.
 func (s Service) Login(user *users.User) (*users.User, *sessions.Session, error) {
+	if user.Disabled() {
+		return user, nil, ErrAccountDisabled
+	}
+
+	user = s.syncAdminRole(user)
+
 	session, err := sessions.NewSession(user.ID)
 	if err != nil {
 		return nil, nil, err
@@ -54,16 +175,93 @@ func (s Service) Login(user *users.User) (*users.User, *sessions.Session, error)
 	return user, session, nil
 }
 
-func (s Service) LoginWithCredentials(email, password string) (*users.User, *sessions.Session, error) {
-	user, err := s.userStore.GetByEmail(email)
+// syncAdminRole promotes users listed through WithAdminEmails and demotes them once they are no longer listed.
+// The email must be confirmed, otherwise anyone could register the address first. An admin role granted
+// by an administrator is left alone, see RevokeRole.
+func (s Service) syncAdminRole(user *users.User) *users.User {
+	listed := user.EmailConfirmed != nil && slices.Contains(s.adminEmails, users.NormalizeEmail(user.Email))
+
+	synced := *user
+	action, done := "grant", "granted"
+	switch {
+	case listed && !user.HasRole(users.RoleAdmin):
+		synced.Roles = append(slices.Clone(user.Roles), users.RoleAdmin)
+		synced.ConfiguredRoles = append(slices.Clone(user.ConfiguredRoles), users.RoleAdmin)
+	case !listed && user.HasConfiguredRole(users.RoleAdmin):
+		action, done = "revoke", "revoked"
+		synced.Roles = withoutRole(user.Roles, users.RoleAdmin)
+		synced.ConfiguredRoles = withoutRole(user.ConfiguredRoles, users.RoleAdmin)
+	default:
+		return user
+	}
+
+	updated, err := s.userStore.Update(synced)
 	if err != nil {
-		return nil, nil, err
+		log.Printf("failed to %s the admin role of user %s: %s", action, user.ID, err)
+		return user
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	log.Printf("%s the admin role of user %s", done, user.ID)
+	return updated
+}
+
+// LoginWithCredentials checks the credentials and opens a session.
+// Failed attempts are throttled per account and per IP, see LoginThrottlePolicy.
+// Accounts with two-factor authentication get a SecondFactorRequiredError instead of a session.
+// Once the email matches an account, failures come with the user so they can be attributed to it.
+func (s Service) LoginWithCredentials(email, password, ip string) (*users.User, *sessions.Session, error) {
+	accountKey := users.NormalizeEmail(email)
+	now := time.Now()
+
+	if err := s.loginThrottle.check(accountKey, ip, now); err != nil {
 		return nil, nil, err
 	}
 
+	// every path below pays for exactly one password hash comparison
+	user, err := s.userStore.GetByEmail(email)
+	if err != nil {
+		s.passwords.VerifyDummy(password)
+		s.loginFailed(accountKey, ip, nil, now)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	if user.Locked(now) {
+		s.passwords.VerifyDummy(password)
+		return user, nil, ThrottledError{RetryAfter: user.LockedUntil.Sub(now)}
+	}
+
+	ok, needsRehash, err := s.passwords.Verify(password, user.PasswordHash)
+	if err != nil {
+		log.Printf("failed to verify password hash for user %s: %s", user.ID, err)
+	}
+
+	if !ok {
+		s.loginFailed(accountKey, ip, user, now)
+		return user, nil, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		s.rehashPassword(user, password)
+	}
+
+	switch {
+	case user.Disabled():
+		return user, nil, ErrAccountDisabled
+	case user.PasswordResetRequired:
+		return user, nil, ErrPasswordResetRequired
+	}
+
+	if user.TwoFactorEnabled() {
+		challenge, err := s.secondFactorChallenge(user)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, SecondFactorRequiredError{Challenge: challenge}
+	}
+
+	s.loginSucceeded(accountKey, user)
+
 	return s.Login(user)
 }
 
@@ -73,11 +271,25 @@ func (s Service) LoginWithToken(sessionToken string) (*users.User, *sessions.Ses
 		return nil, nil, err
 	}
 
+	if s.sessionExpired(session, time.Now()) {
+		if err = s.sessionStore.Delete(session.ID); err != nil {
+			log.Printf("failed to delete expired session: %s", err)
+		}
+
+		return nil, nil, ErrSessionExpired
+	}
+
 	user, err := s.userStore.Get(session.UserID)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if user.Disabled() {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	// stores only keep the token hash, the client's token is needed to renew the cookie
+	session.Token = sessionToken
 	session.LastUsed = time.Now()
 	_, err = s.sessionStore.Update(*session)
 	if err != nil {
@@ -87,17 +299,75 @@ func (s Service) LoginWithToken(sessionToken string) (*users.User, *sessions.Ses
 	return user, session, nil
 }
 
+func (s Service) sessionExpired(session *sessions.Session, now time.Time) bool {
+	if s.sessionPolicy.AbsoluteLifetime > 0 && now.Sub(session.CreatedAt) > s.sessionPolicy.AbsoluteLifetime {
+		return true
+	}
+
+	lastUsed := session.LastUsed
+	if lastUsed.IsZero() {
+		lastUsed = session.CreatedAt
+	}
+
+	return s.sessionPolicy.IdleTimeout > 0 && now.Sub(lastUsed) > s.sessionPolicy.IdleTimeout
+}
+
+// PurgeExpiredSessions removes every session that no longer satisfies the session policy.
+func (s Service) PurgeExpiredSessions() (int, error) {
+	now := time.Now()
+	var idleCutoff, createdCutoff time.Time
+
+	if s.sessionPolicy.IdleTimeout > 0 {
+		idleCutoff = now.Add(-s.sessionPolicy.IdleTimeout)
+	}
+
+	if s.sessionPolicy.AbsoluteLifetime > 0 {
+		createdCutoff = now.Add(-s.sessionPolicy.AbsoluteLifetime)
+	}
+
+	if idleCutoff.IsZero() && createdCutoff.IsZero() {
+		return 0, nil
+	}
+
+	return s.sessionStore.DeleteExpired(idleCutoff, createdCutoff)
+}
+
+// rehashPassword upgrades the stored hash to the current algorithm and parameters.
+// Failing to do so does not prevent the login, it will be attempted again next time.
+func (s Service) rehashPassword(user *users.User, password string) {
+	passwordHash, err := s.passwords.Hash(password)
+	if err != nil {
+		log.Printf("failed to rehash password for user %s: %s", user.ID, err)
+		return
+	}
+
+	user.PasswordHash = passwordHash
+	if _, err = s.userStore.Update(*user); err != nil {
+		log.Printf("failed to store rehashed password for user %s: %s", user.ID, err)
+	}
+}
+
+// ValidatePassword scores the password and checks it against the password policy.
+// The error is a *validate.PolicyError when the password breaks the policy.
+func (s Service) ValidatePassword(password, email string) (validate.Strength, error) {
+	return s.passwordPolicy.Strength(password, email), s.passwordPolicy.Check(password, email)
+}
+
 func (s Service) Register(email, password string) (*users.User, error) {
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := s.passwordPolicy.Check(password, email); err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := s.passwords.Hash(password)
 	if err != nil {
 		return nil, err
 	}
 
 	user := users.User{
 		ID:             uuid.New(),
-		Email:          email,
+		Email:          users.NormalizeEmail(email),
 		EmailConfirmed: nil,
-		PasswordHash:   string(passwordHash),
+		PasswordHash:   passwordHash,
 		CreatedAt:      time.Now(),
 	}
 
@@ -109,15 +379,19 @@ func (s Service) SetAuthResponse(writer http.ResponseWriter, session *sessions.S
 		return errors.New("invalid session")
 	}
 
-	http.SetCookie(writer, &http.Cookie{
+	cookieLifetime := time.Hour * 24
+	if s.sessionPolicy.IdleTimeout > 0 {
+		cookieLifetime = s.sessionPolicy.IdleTimeout
+	}
+
+	http.SetCookie(writer, s.cookiePolicy.Apply(&http.Cookie{
 		Name:     authCookie,
 		Value:    session.Token,
 		Path:     "/",
-		Expires:  time.Now().Add(time.Hour * 24),
-		Secure:   true,
+		Expires:  time.Now().Add(cookieLifetime),
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-	})
+	}))
 
 	return nil
 }
@@ -142,6 +416,171 @@ func (s Service) RetrieveAuthFromRequest(request *http.Request) (*users.User, *s
 	return user, session, nil
 }
 
+// SessionFromRequest looks up the session of the cookie without touching it, unlike RetrieveAuthFromRequest the
+// session is neither renewed nor deleted once expired. It suits lookups made on every request, such as CSRF checks.
+func (s Service) SessionFromRequest(request *http.Request) (*sessions.Session, error) {
+	cookie, err := request.Cookie(authCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessionStore.GetForToken(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.sessionExpired(session, time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+// RetrieveAuthFromBearer is the API counterpart of RetrieveAuthFromRequest, a JWT access token
+// is read from the "Authorization: Bearer" header instead of the session cookie.
+func (s Service) RetrieveAuthFromBearer(request *http.Request) (*users.User, *sessions.Session, error) {
+	scheme, token, found := strings.Cut(request.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, nil, ErrMissingBearerToken
+	}
+
+	return s.authenticateAccessToken(token)
+}
+
+func (s Service) Logout(writer http.ResponseWriter, session *sessions.Session) error {
+	s.clearAuthResponse(writer)
+
+	// nothing to revoke, the cookie is cleared regardless
+	if session == nil {
+		return nil
+	}
+
+	return s.sessionStore.Delete(session.ID)
+}
+
+// RevokeSession deletes a single session, making sure it belongs to the given user.
+func (s Service) RevokeSession(userID, sessionID uuid.UUID) error {
+	session, err := s.sessionStore.Get(sessionID)
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != userID {
+		return sessions.ErrSessionNotFound
+	}
+
+	return s.sessionStore.Delete(session.ID)
+}
+
+// Sessions returns the sessions of the user, most recently used first.
+func (s Service) Sessions(userID uuid.UUID) ([]sessions.Session, error) {
+	userSessions, err := s.sessionStore.GetForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(userSessions, func(a, b sessions.Session) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+
+	return userSessions, nil
+}
+
+func (s Service) RevokeAllSessions(userID uuid.UUID) error {
+	return s.revokeSessions(userID, uuid.UUID{})
+}
+
+func (s Service) RevokeOtherSessions(userID, currentSessionID uuid.UUID) error {
+	return s.revokeSessions(userID, currentSessionID)
+}
+
+func (s Service) revokeSessions(userID, exceptSessionID uuid.UUID) error {
+	userSessions, err := s.sessionStore.GetForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range userSessions {
+		if session.ID == exceptSessionID {
+			continue
+		}
+
+		if err = s.sessionStore.Delete(session.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s Service) clearAuthResponse(writer http.ResponseWriter) {
+	http.SetCookie(writer, s.cookiePolicy.Apply(&http.Cookie{
+		Name:     authCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}))
+}
+
+// ClientIP returns the IP address of the client that sent the request, as resolved by AugmentRequestWithClientIP
+// when the request came through a trusted proxy.
+func ClientIP(request *http.Request) string {
+	if ip, ok := request.Context().Value(ClientIPContextKey).(string); ok {
+		return ip
+	}
+
+	return peerIP(request)
+}
+
+func peerIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// AugmentRequestWithClientIP reads the client IP from X-Forwarded-For, or X-Real-IP, when the peer is one of the
+// trusted proxies. Anyone else could set these headers to pick the IP they are throttled under, so they are ignored.
+// X-Forwarded-For is read from the right, skipping the trusted proxies the request went through.
+func AugmentRequestWithClientIP(request *http.Request, trustedProxies []netip.Prefix) *http.Request {
+	trusted := func(ip netip.Addr) bool {
+		return slices.ContainsFunc(trustedProxies, func(prefix netip.Prefix) bool {
+			return prefix.Contains(ip.Unmap())
+		})
+	}
+
+	peer, err := netip.ParseAddr(peerIP(request))
+	if err != nil || !trusted(peer) {
+		return request
+	}
+
+	client := peer
+	if values := request.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		forwarded := strings.Split(strings.Join(values, ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+			if err != nil {
+				break
+			}
+
+			client = hop
+			if !trusted(hop) {
+				break
+			}
+		}
+	} else if realIP, err := netip.ParseAddr(strings.TrimSpace(request.Header.Get("X-Real-IP"))); err == nil {
+		client = realIP
+	}
+
+	return request.WithContext(context.WithValue(request.Context(), ClientIPContextKey, client.Unmap().String()))
+}
+
 func AugmentRequestWithAuth(request *http.Request, user *users.User, session *sessions.Session) *http.Request {
 	ctx := context.WithValue(request.Context(), UserContextKey, *user)
 	ctx = context.WithValue(ctx, SessionContextKey, *session)
@@ -149,6 +588,36 @@ func AugmentRequestWithAuth(request *http.Request, user *users.User, session *se
 	return request.WithContext(ctx)
 }
 
+// AugmentRequestWithScopes is the counterpart of AugmentRequestWithAuth for API keys, which have no session.
+// The request is limited to the given scopes.
+func AugmentRequestWithScopes(request *http.Request, user *users.User, scopes []string) *http.Request {
+	ctx := context.WithValue(request.Context(), UserContextKey, *user)
+	ctx = context.WithValue(ctx, ScopesContextKey, slices.Clone(scopes))
+
+	return request.WithContext(ctx)
+}
+
+// RetrieveUser works whatever the authentication method, unlike RetrieveAuthDetails which needs a session.
+func RetrieveUser(request *http.Request) (*users.User, error) {
+	user, ok := request.Context().Value(UserContextKey).(users.User)
+	if !ok {
+		return nil, errors.New("no users found")
+	}
+
+	return &user, nil
+}
+
+// HasScope reports whether the request may use the given scope. Requests authenticated with a session
+// or an access token are not limited.
+func HasScope(request *http.Request, scope string) bool {
+	scopes, ok := request.Context().Value(ScopesContextKey).([]string)
+	if !ok {
+		return true
+	}
+
+	return slices.Contains(scopes, scope)
+}
+
 func RetrieveAuthDetails(request *http.Request) (*users.User, *sessions.Session, error) {
 	user, ok := request.Context().Value(UserContextKey).(users.User)
 	if !ok {
@@ -0,0 +1,473 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/jwt"
+	"github.com/mathieuhays/auth/internal/passwords"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/tokens"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"github.com/mathieuhays/auth/internal/validate"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// testPasswordHasher keeps the tests fast, timing tests use the default parameters
+var testPasswordHasher = passwords.NewManager(
+	passwords.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+)
+
+func newTestService(t testing.TB) (*Service, *users.UserMemoryStore, *sessions.SessionMemoryStore) {
+	t.Helper()
+	userStore := users.NewUserMemoryStore()
+	sessionStore := sessions.NewSessionMemoryStore()
+
+	return NewService(userStore, sessionStore, tokens.NewTokenMemoryStore(), WithPasswordHasher(testPasswordHasher)), userStore, sessionStore
+}
+
+func createTestSessions(t testing.TB, service *Service, userID uuid.UUID, count int) []*sessions.Session {
+	t.Helper()
+	var userSessions []*sessions.Session
+
+	for i := 0; i < count; i++ {
+		_, session, err := service.Login(&users.User{ID: userID})
+		if err != nil {
+			t.Fatalf("unexpected error while creating session: %s", err)
+		}
+
+		userSessions = append(userSessions, session)
+	}
+
+	return userSessions
+}
+
+func TestService_Logout(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	session := createTestSessions(t, service, uuid.New(), 1)[0]
+
+	response := httptest.NewRecorder()
+	if err := service.Logout(response, session); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("session still exists after logout. got: %v", err)
+	}
+
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != authCookie || cookies[0].MaxAge >= 0 {
+		t.Errorf("auth cookie was not expired. got: %v", cookies)
+	}
+}
+
+func TestService_RevokeSession(t *testing.T) {
+	t.Run("owned session", func(t *testing.T) {
+		service, _, sessionStore := newTestService(t)
+		userID := uuid.New()
+		session := createTestSessions(t, service, userID, 1)[0]
+
+		if err := service.RevokeSession(userID, session.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("session still exists after revocation. got: %v", err)
+		}
+	})
+
+	t.Run("session owned by another user", func(t *testing.T) {
+		service, _, sessionStore := newTestService(t)
+		session := createTestSessions(t, service, uuid.New(), 1)[0]
+
+		err := service.RevokeSession(uuid.New(), session.ID)
+		if !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", sessions.ErrSessionNotFound, err)
+		}
+
+		if _, err = sessionStore.Get(session.ID); err != nil {
+			t.Errorf("session should not have been revoked. got: %s", err)
+		}
+	})
+}
+
+func TestService_RevokeAllSessions(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	userID := uuid.New()
+	createTestSessions(t, service, userID, 3)
+	otherSession := createTestSessions(t, service, uuid.New(), 1)[0]
+
+	if err := service.RevokeAllSessions(userID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remaining, err := sessionStore.GetForUser(userID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(remaining) != 0 {
+		t.Errorf("unexpected amount of sessions remaining. expected: 0. got: %d", len(remaining))
+	}
+
+	if _, err = sessionStore.Get(otherSession.ID); err != nil {
+		t.Errorf("another user's session got revoked: %s", err)
+	}
+}
+
+func TestService_RevokeOtherSessions(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	userID := uuid.New()
+	userSessions := createTestSessions(t, service, userID, 3)
+	current := userSessions[1]
+
+	if err := service.RevokeOtherSessions(userID, current.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remaining, err := sessionStore.GetForUser(userID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(remaining) != 1 || remaining[0].ID != current.ID {
+		t.Errorf("only the current session should remain. got: %v", remaining)
+	}
+}
+
+func TestService_LoginWithToken(t *testing.T) {
+	policy := SessionPolicy{IdleTimeout: time.Hour, AbsoluteLifetime: time.Hour * 24}
+
+	testCases := []struct {
+		name      string
+		createdAt time.Time
+		lastUsed  time.Time
+		err       error
+	}{
+		{"active session", time.Now().Add(-time.Hour * 2), time.Now(), nil},
+		{"idle session", time.Now().Add(-time.Hour * 2), time.Now().Add(-time.Hour * 2), ErrSessionExpired},
+		{"session past absolute lifetime", time.Now().Add(-time.Hour * 25), time.Now(), ErrSessionExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userStore := users.NewUserMemoryStore()
+			sessionStore := sessions.NewSessionMemoryStore()
+			service := NewService(userStore, sessionStore, tokens.NewTokenMemoryStore(), WithSessionPolicy(policy))
+
+			u, err := userStore.Create(users.User{Email: "test@example.com"})
+			if err != nil {
+				t.Fatalf("unexpected error while creating user: %s", err)
+			}
+
+			session, err := sessions.NewSession(u.ID)
+			if err != nil {
+				t.Fatalf("unexpected error while creating session: %s", err)
+			}
+			session.CreatedAt = tc.createdAt
+			session.LastUsed = tc.lastUsed
+
+			if _, err = sessionStore.Create(*session); err != nil {
+				t.Fatalf("unexpected error while storing session: %s", err)
+			}
+
+			_, _, err = service.LoginWithToken(session.Token)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+
+			if tc.err != nil {
+				if _, err = sessionStore.Get(session.ID); !errors.Is(err, sessions.ErrSessionNotFound) {
+					t.Errorf("expired session should have been deleted. got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSessionReaper(t *testing.T) {
+	userStore := users.NewUserMemoryStore()
+	sessionStore := sessions.NewSessionMemoryStore()
+	service := NewService(userStore, sessionStore, tokens.NewTokenMemoryStore(), WithSessionPolicy(SessionPolicy{IdleTimeout: time.Minute}))
+
+	session, err := sessionStore.Create(sessions.Session{
+		UserID:    uuid.New(),
+		CreatedAt: time.Now().Add(-time.Hour),
+		LastUsed:  time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error while creating session: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		RunSessionReaper(ctx, service, time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.After(time.Second)
+	for {
+		if _, err = sessionStore.Get(session.ID); errors.Is(err, sessions.ErrSessionNotFound) {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("reaper did not delete the expired session")
+		case <-time.After(time.Millisecond * 5):
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("reaper did not stop after context cancellation")
+	}
+}
+
+func TestService_Register(t *testing.T) {
+	testCases := []struct {
+		name     string
+		email    string
+		password string
+		err      error
+	}{
+		{"valid", "test@example.com", "correct horse battery", nil},
+		{"too short", "short@example.com", "abc", validate.ErrPasswordTooShort},
+		{"common password", "common@example.com", "qwerty123", validate.ErrPasswordCommon},
+		{"contains email", "horse@example.com", "correct horse battery", validate.ErrPasswordContainsEmail},
+	}
+
+	service, _, _ := newTestService(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.Register(tc.email, tc.password)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestService_LoginWithCredentialsRehash(t *testing.T) {
+	legacy := passwords.Bcrypt{Cost: 4}
+	current := passwords.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	userStore := users.NewUserMemoryStore()
+	legacyService := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		WithPasswordHasher(passwords.NewManager(legacy)))
+	u, err := legacyService.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+
+	service := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		WithPasswordHasher(passwords.NewManager(current, legacy)))
+
+	t.Run("wrong password keeps the legacy hash", func(t *testing.T) {
+		if _, _, err = service.LoginWithCredentials("test@example.com", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrInvalidCredentials, err)
+		}
+
+		stored, err := userStore.Get(u.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !legacy.Matches(stored.PasswordHash) {
+			t.Errorf("password hash should not have changed. got: %s", stored.PasswordHash)
+		}
+	})
+
+	t.Run("successful login upgrades the hash", func(t *testing.T) {
+		if _, _, err = service.LoginWithCredentials("test@example.com", "correct horse battery", "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		stored, err := userStore.Get(u.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !current.Matches(stored.PasswordHash) {
+			t.Fatalf("password hash has not been upgraded. got: %s", stored.PasswordHash)
+		}
+
+		if _, _, err = service.LoginWithCredentials("test@example.com", "correct horse battery", "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error with the upgraded hash: %s", err)
+		}
+	})
+}
+
+func TestService_SessionFromRequest(t *testing.T) {
+	service, _, sessionStore := newTestService(t)
+	u, err := service.Register("test@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error while registering: %s", err)
+	}
+	session := createTestSessions(t, service, u.ID, 1)[0]
+	before, _ := sessionStore.Get(session.ID)
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(&http.Cookie{Name: authCookie, Value: session.Token})
+
+	found, err := service.SessionFromRequest(request)
+	if err != nil || found.ID != session.ID {
+		t.Fatalf("unexpected session: %v, %v", found, err)
+	}
+
+	if after, _ := sessionStore.Get(session.ID); !after.LastUsed.Equal(before.LastUsed) {
+		t.Errorf("the lookup should not renew the session. before: %s. after: %s", before.LastUsed, after.LastUsed)
+	}
+
+	if _, err = service.SessionFromRequest(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Errorf("expected an error without a session cookie")
+	}
+}
+
+func TestService_RetrieveAuthFromBearer(t *testing.T) {
+	service, userStore, _ := newTestService(t)
+	u, _ := userStore.Create(users.User{Email: "test@example.com"})
+	_, session, err := service.Login(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pair, err := service.IssueTokens(session)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name   string
+		header string
+		err    error
+	}{
+		{"valid token", "Bearer " + pair.AccessToken, nil},
+		{"case insensitive scheme", "bearer " + pair.AccessToken, nil},
+		{"missing header", "", ErrMissingBearerToken},
+		{"other scheme", "Basic " + pair.AccessToken, ErrMissingBearerToken},
+		{"empty token", "Bearer ", ErrMissingBearerToken},
+		{"session token", "Bearer " + session.Token, jwt.ErrInvalidToken},
+		{"refresh token", "Bearer " + pair.RefreshToken, jwt.ErrInvalidToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			if tc.header != "" {
+				request.Header.Set("Authorization", tc.header)
+			}
+
+			retrieved, _, err := service.RetrieveAuthFromBearer(request)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+
+			if tc.err == nil && retrieved.ID != u.ID {
+				t.Errorf("unexpected user. expected: %s. got: %s", u.ID, retrieved.ID)
+			}
+		})
+	}
+
+	t.Run("cookie is ignored", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		request.AddCookie(&http.Cookie{Name: authCookie, Value: session.Token})
+
+		if _, _, err := service.RetrieveAuthFromBearer(request); !errors.Is(err, ErrMissingBearerToken) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrMissingBearerToken, err)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		if err := service.RevokeSession(u.ID, session.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		if _, _, err := service.RetrieveAuthFromBearer(request); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("unexpected error. expected: %s. got: %v", sessions.ErrSessionNotFound, err)
+		}
+	})
+}
+
+func TestService_LoginGrantsAdminRole(t *testing.T) {
+	userStore := users.NewUserMemoryStore()
+	service := NewService(userStore, sessions.NewSessionMemoryStore(), tokens.NewTokenMemoryStore(),
+		WithAdminEmails(" Admin@Example.com ", ""))
+	confirmedAt := time.Now()
+
+	testCases := []struct {
+		name     string
+		user     users.User
+		expected bool
+	}{
+		{"listed and confirmed", users.User{Email: "admin@example.com", EmailConfirmed: &confirmedAt}, true},
+		{"listed but unconfirmed", users.User{Email: "ADMIN@example.com"}, false},
+		{"not listed", users.User{Email: "user@example.com", EmailConfirmed: &confirmedAt}, false},
+		{"no longer listed", users.User{Email: "former@example.com", EmailConfirmed: &confirmedAt, Roles: []users.Role{users.RoleAdmin}, ConfiguredRoles: []users.Role{users.RoleAdmin}}, false},
+		{"granted by hand", users.User{Email: "manual@example.com", EmailConfirmed: &confirmedAt, Roles: []users.Role{users.RoleAdmin}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := userStore.Create(tc.user)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer func() { _ = userStore.Delete(u.ID) }()
+
+			loggedIn, _, err := service.Login(u)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			stored, _ := userStore.Get(u.ID)
+			if loggedIn.HasRole(users.RoleAdmin) != tc.expected || stored.HasRole(users.RoleAdmin) != tc.expected {
+				t.Errorf("unexpected admin role. expected: %t. got: %v", tc.expected, stored.Roles)
+			}
+		})
+	}
+}
+
+func TestAugmentRequestWithClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1"}, "203.0.113.7"},
+		{"forwarded for", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed forwarded for", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"invalid forwarded for", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "unknown, 10.0.0.3"}, "10.0.0.3"},
+		{"real ip", "[::1]:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"trusted peer without headers", "10.0.0.2:1234", nil, "10.0.0.2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tc.remoteAddr
+			for key, value := range tc.headers {
+				request.Header.Set(key, value)
+			}
+
+			if ip := ClientIP(AugmentRequestWithClientIP(request, trustedProxies)); ip != tc.expected {
+				t.Errorf("unexpected client IP. expected: %s. got: %s", tc.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package apikeys
+
+import (
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+type APIKeyMemoryStore struct {
+	items map[uuid.UUID]APIKey
+	// prefixes indexes IDs by key prefix
+	prefixes map[string]uuid.UUID
+	mu       sync.RWMutex
+}
+
+func NewAPIKeyMemoryStore() *APIKeyMemoryStore {
+	return &APIKeyMemoryStore{
+		items:    make(map[uuid.UUID]APIKey),
+		prefixes: make(map[string]uuid.UUID),
+		mu:       sync.RWMutex{},
+	}
+}
+
+// clone makes sure callers never share the scopes or timestamps with the stored entry
+func clone(key APIKey) *APIKey {
+	key.Scopes = slices.Clone(key.Scopes)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		key.ExpiresAt = &expiresAt
+	}
+	if key.LastUsed != nil {
+		lastUsed := *key.LastUsed
+		key.LastUsed = &lastUsed
+	}
+	return &key
+}
+
+func (a *APIKeyMemoryStore) Create(key APIKey) (*APIKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	emptyUUID := uuid.UUID{}
+	if key.ID == emptyUUID {
+		key.ID = uuid.New()
+	}
+
+	if _, ok := a.items[key.ID]; ok {
+		return nil, ErrAPIKeyAlreadyExist
+	}
+
+	if key.UserID == emptyUUID {
+		return nil, ErrAPIKeyMissingUserID
+	}
+
+	if _, ok := a.prefixes[key.Prefix]; ok {
+		return nil, ErrAPIKeyPrefixAlreadyExists
+	}
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+
+	a.items[key.ID] = *clone(key)
+	a.prefixes[key.Prefix] = key.ID
+
+	return clone(key), nil
+}
+
+func (a *APIKeyMemoryStore) Get(id uuid.UUID) (*APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if key, ok := a.items[id]; ok {
+		return clone(key), nil
+	}
+
+	return nil, ErrAPIKeyNotFound
+}
+
+func (a *APIKeyMemoryStore) GetByPrefix(prefix string) (*APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if id, ok := a.prefixes[prefix]; ok {
+		if key, ok := a.items[id]; ok {
+			return clone(key), nil
+		}
+	}
+
+	return nil, ErrAPIKeyNotFound
+}
+
+// GetForUser returns the keys of the user, oldest first
+func (a *APIKeyMemoryStore) GetForUser(userID uuid.UUID) ([]APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var keys []APIKey
+
+	for _, key := range a.items {
+		if key.UserID == userID {
+			keys = append(keys, *clone(key))
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// Update does not allow changing the owner, the prefix or the secret
+func (a *APIKeyMemoryStore) Update(key APIKey) (*APIKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	existing, ok := a.items[key.ID]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	key.UserID = existing.UserID
+	key.Prefix = existing.Prefix
+	key.SecretHash = existing.SecretHash
+	a.items[key.ID] = *clone(key)
+
+	return clone(key), nil
+}
+
+func (a *APIKeyMemoryStore) Delete(id uuid.UUID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key, ok := a.items[id]; ok {
+		delete(a.prefixes, key.Prefix)
+		delete(a.items, id)
+	}
+
+	return nil
+}
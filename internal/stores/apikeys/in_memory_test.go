@@ -0,0 +1,160 @@
+package apikeys
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyMemoryStore_Create(t *testing.T) {
+	t.Run("require user id", func(t *testing.T) {
+		store := NewAPIKeyMemoryStore()
+		_, err := store.Create(APIKey{Prefix: "ak_prefix"})
+		if !errors.Is(err, ErrAPIKeyMissingUserID) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrAPIKeyMissingUserID, err)
+		}
+	})
+
+	t.Run("fallbacks", func(t *testing.T) {
+		store := NewAPIKeyMemoryStore()
+		key, err := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if key.ID == (uuid.UUID{}) || key.CreatedAt.IsZero() {
+			t.Errorf("fallback values not applied. got: %v", key)
+		}
+	})
+
+	t.Run("duplicate prefix", func(t *testing.T) {
+		store := NewAPIKeyMemoryStore()
+		if _, err := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, err := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix"})
+		if !errors.Is(err, ErrAPIKeyPrefixAlreadyExists) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrAPIKeyPrefixAlreadyExists, err)
+		}
+	})
+
+	t.Run("stored copy is isolated", func(t *testing.T) {
+		store := NewAPIKeyMemoryStore()
+		scopes := []string{"profile:read"}
+		key, err := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix", Scopes: scopes})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		scopes[0] = "sessions:write"
+		key.Scopes[0] = "sessions:write"
+
+		stored, _ := store.Get(key.ID)
+		if stored.Scopes[0] != "profile:read" {
+			t.Errorf("stored scopes were modified. got: %v", stored.Scopes)
+		}
+	})
+}
+
+func TestAPIKeyMemoryStore_GetByPrefix(t *testing.T) {
+	store := NewAPIKeyMemoryStore()
+	key, _ := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix"})
+
+	found, err := store.GetByPrefix("ak_prefix")
+	if err != nil || found.ID != key.ID {
+		t.Fatalf("unexpected result. got: %v. error: %v", found, err)
+	}
+
+	if _, err = store.GetByPrefix("ak_unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrAPIKeyNotFound, err)
+	}
+}
+
+func TestAPIKeyMemoryStore_GetForUser(t *testing.T) {
+	store := NewAPIKeyMemoryStore()
+	userID := uuid.New()
+	now := time.Now()
+
+	newer, _ := store.Create(APIKey{UserID: userID, Prefix: "ak_newer", CreatedAt: now})
+	older, _ := store.Create(APIKey{UserID: userID, Prefix: "ak_older", CreatedAt: now.Add(-time.Hour)})
+	_, _ = store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_other"})
+
+	keys, err := store.GetForUser(userID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != older.ID || keys[1].ID != newer.ID {
+		t.Errorf("unexpected keys. got: %v", keys)
+	}
+}
+
+func TestAPIKeyMemoryStore_Update(t *testing.T) {
+	store := NewAPIKeyMemoryStore()
+	key, _ := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix", SecretHash: "hash"})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := store.Update(APIKey{ID: uuid.New()})
+		if !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrAPIKeyNotFound, err)
+		}
+	})
+
+	t.Run("owner, prefix and secret are kept", func(t *testing.T) {
+		now := time.Now()
+		update := *key
+		update.UserID = uuid.New()
+		update.Prefix = "ak_hijacked"
+		update.SecretHash = "other"
+		update.LastUsed = &now
+
+		updated, err := store.Update(update)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if updated.UserID != key.UserID || updated.Prefix != "ak_prefix" || updated.SecretHash != "hash" || updated.LastUsed == nil {
+			t.Errorf("unexpected update. got: %v", updated)
+		}
+	})
+}
+
+func TestAPIKeyMemoryStore_Delete(t *testing.T) {
+	store := NewAPIKeyMemoryStore()
+	key, _ := store.Create(APIKey{UserID: uuid.New(), Prefix: "ak_prefix"})
+
+	if err := store.Delete(key.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := store.GetByPrefix("ak_prefix"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("prefix index not cleaned up. got: %v", err)
+	}
+}
+
+func TestAPIKey_Expired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	testCases := []struct {
+		name      string
+		expiresAt *time.Time
+		expired   bool
+	}{
+		{"never", nil, false},
+		{"past", &past, true},
+		{"now", &now, true},
+		{"future", &future, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if expired := (APIKey{ExpiresAt: tc.expiresAt}).Expired(now); expired != tc.expired {
+				t.Errorf("unexpected result. expected: %t. got: %t", tc.expired, expired)
+			}
+		})
+	}
+}
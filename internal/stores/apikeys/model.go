@@ -0,0 +1,48 @@
+package apikeys
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound            = errors.New("API key not found")
+	ErrAPIKeyAlreadyExist        = errors.New("API key already exist")
+	ErrAPIKeyMissingUserID       = errors.New("user ID missing")
+	ErrAPIKeyPrefixAlreadyExists = errors.New("API key prefix already used")
+)
+
+// APIKey is a long-lived credential for scripts and CI jobs. Only the hash of the full key is stored.
+type APIKey struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// Name is a label chosen by the user to tell keys apart
+	Name string
+	// Prefix is the public part of the key, used for lookups and shown to users to identify it
+	Prefix     string
+	SecretHash string
+	Scopes     []string
+	// ExpiresAt is nil for keys that never expire
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	LastUsed  *time.Time
+}
+
+func (k APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+type APIKeyStoreInterface interface {
+	Create(key APIKey) (*APIKey, error)
+	Get(id uuid.UUID) (*APIKey, error)
+	GetByPrefix(prefix string) (*APIKey, error)
+	GetForUser(userID uuid.UUID) ([]APIKey, error)
+	Update(key APIKey) (*APIKey, error)
+	Delete(id uuid.UUID) error
+}
@@ -0,0 +1,136 @@
+package credentials
+
+import (
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+type CredentialMemoryStore struct {
+	items map[uuid.UUID]Credential
+	// credentialIDs indexes IDs by authenticator credential ID
+	credentialIDs map[string]uuid.UUID
+	mu            sync.RWMutex
+}
+
+func NewCredentialMemoryStore() *CredentialMemoryStore {
+	return &CredentialMemoryStore{
+		items:         make(map[uuid.UUID]Credential),
+		credentialIDs: make(map[string]uuid.UUID),
+		mu:            sync.RWMutex{},
+	}
+}
+
+// clone makes sure callers never share slices with the stored entry
+func clone(credential Credential) *Credential {
+	credential.CredentialID = slices.Clone(credential.CredentialID)
+	credential.PublicKey = slices.Clone(credential.PublicKey)
+	credential.AAGUID = slices.Clone(credential.AAGUID)
+	credential.Transports = slices.Clone(credential.Transports)
+	return &credential
+}
+
+func (c *CredentialMemoryStore) Create(credential Credential) (*Credential, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	emptyUUID := uuid.UUID{}
+	if credential.ID == emptyUUID {
+		credential.ID = uuid.New()
+	}
+
+	if _, ok := c.items[credential.ID]; ok {
+		return nil, ErrCredentialAlreadyExist
+	}
+
+	if credential.UserID == emptyUUID {
+		return nil, ErrCredentialMissingUserID
+	}
+
+	if _, ok := c.credentialIDs[string(credential.CredentialID)]; ok {
+		return nil, ErrCredentialIDAlreadyExists
+	}
+
+	if credential.CreatedAt.IsZero() {
+		credential.CreatedAt = time.Now().UTC()
+	}
+
+	c.items[credential.ID] = *clone(credential)
+	c.credentialIDs[string(credential.CredentialID)] = credential.ID
+
+	return clone(credential), nil
+}
+
+func (c *CredentialMemoryStore) Get(id uuid.UUID) (*Credential, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if credential, ok := c.items[id]; ok {
+		return clone(credential), nil
+	}
+
+	return nil, ErrCredentialNotFound
+}
+
+func (c *CredentialMemoryStore) GetByCredentialID(credentialID []byte) (*Credential, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if id, ok := c.credentialIDs[string(credentialID)]; ok {
+		if credential, ok := c.items[id]; ok {
+			return clone(credential), nil
+		}
+	}
+
+	return nil, ErrCredentialNotFound
+}
+
+// GetForUser returns the credentials of the user, oldest first
+func (c *CredentialMemoryStore) GetForUser(userID uuid.UUID) ([]Credential, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var credentials []Credential
+
+	for _, credential := range c.items {
+		if credential.UserID == userID {
+			credentials = append(credentials, *clone(credential))
+		}
+	}
+
+	slices.SortFunc(credentials, func(a, b Credential) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return credentials, nil
+}
+
+// Update does not allow changing the owner or the authenticator credential ID
+func (c *CredentialMemoryStore) Update(credential Credential) (*Credential, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	existing, ok := c.items[credential.ID]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+
+	credential.UserID = existing.UserID
+	credential.CredentialID = existing.CredentialID
+	c.items[credential.ID] = *clone(credential)
+
+	return clone(credential), nil
+}
+
+func (c *CredentialMemoryStore) Delete(id uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if credential, ok := c.items[id]; ok {
+		delete(c.credentialIDs, string(credential.CredentialID))
+		delete(c.items, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package credentials
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCredentialMemoryStore_Create(t *testing.T) {
+	t.Run("require user id", func(t *testing.T) {
+		store := NewCredentialMemoryStore()
+		_, err := store.Create(Credential{CredentialID: []byte("credential")})
+		if !errors.Is(err, ErrCredentialMissingUserID) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrCredentialMissingUserID, err)
+		}
+	})
+
+	t.Run("fallbacks", func(t *testing.T) {
+		store := NewCredentialMemoryStore()
+		credential, err := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if credential.ID == (uuid.UUID{}) || credential.CreatedAt.IsZero() {
+			t.Errorf("fallback values not applied. got: %v", credential)
+		}
+	})
+
+	t.Run("duplicate credential ID", func(t *testing.T) {
+		store := NewCredentialMemoryStore()
+		if _, err := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, err := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")})
+		if !errors.Is(err, ErrCredentialIDAlreadyExists) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrCredentialIDAlreadyExists, err)
+		}
+	})
+
+	t.Run("stored copy is isolated", func(t *testing.T) {
+		store := NewCredentialMemoryStore()
+		publicKey := []byte("public key")
+		credential, err := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential"), PublicKey: publicKey})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		publicKey[0] = 'X'
+		credential.PublicKey[1] = 'X'
+
+		stored, _ := store.Get(credential.ID)
+		if string(stored.PublicKey) != "public key" {
+			t.Errorf("stored public key was modified. got: %s", stored.PublicKey)
+		}
+	})
+}
+
+func TestCredentialMemoryStore_GetByCredentialID(t *testing.T) {
+	store := NewCredentialMemoryStore()
+	credential, _ := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")})
+
+	found, err := store.GetByCredentialID([]byte("credential"))
+	if err != nil || found.ID != credential.ID {
+		t.Fatalf("unexpected result. got: %v. error: %v", found, err)
+	}
+
+	if _, err = store.GetByCredentialID([]byte("unknown")); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrCredentialNotFound, err)
+	}
+}
+
+func TestCredentialMemoryStore_GetForUser(t *testing.T) {
+	store := NewCredentialMemoryStore()
+	userID := uuid.New()
+	now := time.Now()
+
+	newer, _ := store.Create(Credential{UserID: userID, CredentialID: []byte("newer"), CreatedAt: now})
+	older, _ := store.Create(Credential{UserID: userID, CredentialID: []byte("older"), CreatedAt: now.Add(-time.Hour)})
+	_, _ = store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("other")})
+
+	credentials, err := store.GetForUser(userID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(credentials) != 2 || credentials[0].ID != older.ID || credentials[1].ID != newer.ID {
+		t.Errorf("unexpected credentials. got: %v", credentials)
+	}
+}
+
+func TestCredentialMemoryStore_Update(t *testing.T) {
+	store := NewCredentialMemoryStore()
+	credential, _ := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := store.Update(Credential{ID: uuid.New()})
+		if !errors.Is(err, ErrCredentialNotFound) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrCredentialNotFound, err)
+		}
+	})
+
+	t.Run("owner and credential ID are kept", func(t *testing.T) {
+		update := *credential
+		update.UserID = uuid.New()
+		update.CredentialID = []byte("hijacked")
+		update.SignCount = 5
+
+		updated, err := store.Update(update)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if updated.UserID != credential.UserID || string(updated.CredentialID) != "credential" || updated.SignCount != 5 {
+			t.Errorf("unexpected update. got: %v", updated)
+		}
+	})
+}
+
+func TestCredentialMemoryStore_Delete(t *testing.T) {
+	store := NewCredentialMemoryStore()
+	credential, _ := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")})
+
+	if err := store.Delete(credential.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := store.GetByCredentialID([]byte("credential")); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("credential ID index not cleaned up. got: %v", err)
+	}
+
+	// the credential ID can be registered again
+	if _, err := store.Create(Credential{UserID: uuid.New(), CredentialID: []byte("credential")}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,41 @@
+package credentials
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+var (
+	ErrCredentialNotFound        = errors.New("credential not found")
+	ErrCredentialAlreadyExist    = errors.New("credential already exist")
+	ErrCredentialMissingUserID   = errors.New("user ID missing")
+	ErrCredentialIDAlreadyExists = errors.New("credential ID already registered")
+)
+
+// Credential is a WebAuthn public key credential, also known as a passkey.
+type Credential struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// CredentialID is the identifier chosen by the authenticator
+	CredentialID []byte
+	// PublicKey is COSE encoded
+	PublicKey         []byte
+	SignCount         uint32
+	AAGUID            []byte
+	Transports        []string
+	AttestationFormat string
+	// Name is a label chosen by the user to tell credentials apart
+	Name      string
+	CreatedAt time.Time
+	LastUsed  *time.Time
+}
+
+type CredentialStoreInterface interface {
+	Create(credential Credential) (*Credential, error)
+	Get(id uuid.UUID) (*Credential, error)
+	GetByCredentialID(credentialID []byte) (*Credential, error)
+	GetForUser(userID uuid.UUID) ([]Credential, error)
+	Update(credential Credential) (*Credential, error)
+	Delete(id uuid.UUID) error
+}
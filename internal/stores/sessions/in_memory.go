@@ -1,6 +1,8 @@
 package sessions
 
 import (
+	"context"
+	"crypto/subtle"
 	"github.com/google/uuid"
 	"sync"
 	"time"
@@ -8,11 +10,58 @@ import (
 
 type SessionMemoryStore struct {
 	items map[uuid.UUID]Session
-	mu    sync.RWMutex
+	// tokens indexes session IDs by token hash
+	tokens map[string]uuid.UUID
+	// families indexes session IDs by refresh token family
+	families map[string]uuid.UUID
+	mu       sync.RWMutex
 }
 
 func NewSessionMemoryStore() *SessionMemoryStore {
-	return &SessionMemoryStore{make(map[uuid.UUID]Session), sync.RWMutex{}}
+	return &SessionMemoryStore{
+		items:    make(map[uuid.UUID]Session),
+		tokens:   make(map[string]uuid.UUID),
+		families: make(map[string]uuid.UUID),
+		mu:       sync.RWMutex{},
+	}
+}
+
+// prepareForStorage strips the plaintext token so that only its hash is kept in memory.
+func prepareForStorage(session Session) Session {
+	if session.Token != "" {
+		session.TokenHash = HashToken(session.Token)
+		session.Token = ""
+	}
+
+	return session
+}
+
+func (s *SessionMemoryStore) index(session Session) {
+	if session.TokenHash != "" {
+		s.tokens[session.TokenHash] = session.ID
+	}
+
+	if session.RefreshFamily != "" {
+		s.families[session.RefreshFamily] = session.ID
+	}
+}
+
+func (s *SessionMemoryStore) unindex(session Session) {
+	if id, ok := s.tokens[session.TokenHash]; ok && id == session.ID {
+		delete(s.tokens, session.TokenHash)
+	}
+
+	if id, ok := s.families[session.RefreshFamily]; ok && id == session.ID {
+		delete(s.families, session.RefreshFamily)
+	}
+}
+
+// Ping waits for the store lock, so a store stuck behind a writer fails the readiness check once ctx is done
+func (s *SessionMemoryStore) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return ctx.Err()
 }
 
 func (s *SessionMemoryStore) Create(session Session) (*Session, error) {
@@ -37,8 +86,13 @@ func (s *SessionMemoryStore) Create(session Session) (*Session, error) {
 		session.CreatedAt = time.Now().UTC()
 	}
 
-	s.items[session.ID] = session
-	localSession := s.items[session.ID]
+	stored := prepareForStorage(session)
+	s.items[session.ID] = stored
+	s.index(stored)
+
+	// the plaintext token is only handed back to the creator
+	localSession := stored
+	localSession.Token = session.Token
 
 	return &localSession, nil
 }
@@ -70,11 +124,38 @@ func (s *SessionMemoryStore) GetForUser(userID uuid.UUID) ([]Session, error) {
 }
 
 func (s *SessionMemoryStore) GetForToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	hash := HashToken(token)
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, session := range s.items {
-		if session.Token == token {
+	id, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	session, ok := s.items[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(hash)) != 1 {
+		return nil, ErrSessionNotFound
+	}
+
+	return &session, nil
+}
+
+func (s *SessionMemoryStore) GetForRefreshFamily(family string) (*Session, error) {
+	if family == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id, ok := s.families[family]; ok {
+		if session, ok := s.items[id]; ok {
 			return &session, nil
 		}
 	}
@@ -86,20 +167,78 @@ func (s *SessionMemoryStore) Update(session Session) (*Session, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.items[session.ID]; !ok {
+	existing, ok := s.items[session.ID]
+	if !ok {
 		return nil, ErrSessionNotFound
 	}
 
-	s.items[session.ID] = session
-	localSession := s.items[session.ID]
+	// the token hash can only change through a new plaintext token
+	session.TokenHash = existing.TokenHash
+	stored := prepareForStorage(session)
+
+	s.unindex(existing)
+	s.items[session.ID] = stored
+	s.index(stored)
+
+	localSession := stored
+	localSession.Token = session.Token
 
 	return &localSession, nil
 }
 
+func (s *SessionMemoryStore) RotateRefreshToken(sessionID uuid.UUID, oldHash, newHash string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.items[sessionID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	if oldHash == "" || subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(oldHash)) != 1 {
+		return nil, ErrRefreshTokenMismatch
+	}
+
+	session.RefreshTokenHash = newHash
+	session.LastUsed = time.Now()
+	s.items[sessionID] = session
+
+	return &session, nil
+}
+
 func (s *SessionMemoryStore) Delete(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.items, id)
+	if session, ok := s.items[id]; ok {
+		s.unindex(session)
+		delete(s.items, id)
+	}
+
 	return nil
 }
+
+func (s *SessionMemoryStore) DeleteExpired(lastUsedBefore, createdBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+
+	for id, session := range s.items {
+		lastUsed := session.LastUsed
+		if lastUsed.IsZero() {
+			lastUsed = session.CreatedAt
+		}
+
+		idle := !lastUsedBefore.IsZero() && lastUsed.Before(lastUsedBefore)
+		tooOld := !createdBefore.IsZero() && session.CreatedAt.Before(createdBefore)
+
+		if idle || tooOld {
+			s.unindex(session)
+			delete(s.items, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
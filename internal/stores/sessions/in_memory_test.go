@@ -183,6 +183,36 @@ func TestSessionMemoryStore_GetForToken(t *testing.T) {
 	}
 }
 
+func TestSessionMemoryStore_GetForRefreshFamily(t *testing.T) {
+	store := NewSessionMemoryStore()
+	session, err := store.Create(Session{UserID: uuid.New(), Token: "test_token"})
+	if err != nil {
+		t.Fatalf("unexpected error when creating session: %s", err)
+	}
+
+	if _, err = store.GetForRefreshFamily("family"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("unexpected error. expected: %s. got: %v", ErrSessionNotFound, err)
+	}
+
+	session.RefreshFamily = "family"
+	if _, err = store.Update(*session); err != nil {
+		t.Fatalf("unexpected error while updating session: %s", err)
+	}
+
+	s, err := store.GetForRefreshFamily("family")
+	if err != nil || s.ID != session.ID {
+		t.Fatalf("unexpected result. got: %v. error: %v", s, err)
+	}
+
+	if err = store.Delete(session.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err = store.GetForRefreshFamily("family"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("family index not cleaned up. got: %v", err)
+	}
+}
+
 func TestSessionMemoryStore_Update(t *testing.T) {
 	store := NewSessionMemoryStore()
 	session := Session{
@@ -223,6 +253,31 @@ func TestSessionMemoryStore_Update(t *testing.T) {
 	}
 }
 
+func TestSessionMemoryStore_RotateRefreshToken(t *testing.T) {
+	store := NewSessionMemoryStore()
+	session, err := store.Create(Session{UserID: uuid.New(), Token: "test_token", RefreshFamily: "family", RefreshTokenHash: "first"})
+	if err != nil {
+		t.Fatalf("unexpected error when creating session: %s", err)
+	}
+
+	rotated, err := store.RotateRefreshToken(session.ID, "first", "second")
+	if err != nil || rotated.RefreshTokenHash != "second" {
+		t.Fatalf("unexpected result. got: %v. error: %v", rotated, err)
+	}
+
+	if _, err = store.RotateRefreshToken(session.ID, "first", "third"); !errors.Is(err, ErrRefreshTokenMismatch) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrRefreshTokenMismatch, err)
+	}
+
+	if stored, _ := store.GetForRefreshFamily("family"); stored.RefreshTokenHash != "second" {
+		t.Errorf("a mismatch should leave the hash as is. got: %s", stored.RefreshTokenHash)
+	}
+
+	if _, err = store.RotateRefreshToken(uuid.New(), "second", "third"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrSessionNotFound, err)
+	}
+}
+
 func TestSessionMemoryStore_Delete(t *testing.T) {
 	store := NewSessionMemoryStore()
 	session := Session{
@@ -250,3 +305,116 @@ func TestSessionMemoryStore_Delete(t *testing.T) {
 		t.Fatalf("unexpected error when retrieving deleted session: %s", err)
 	}
 }
+
+func TestSessionMemoryStore_DeleteExpired(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name           string
+		createdAt      time.Time
+		lastUsed       time.Time
+		lastUsedBefore time.Time
+		createdBefore  time.Time
+		deleted        bool
+	}{
+		{"active", now.Add(-time.Hour), now, now.Add(-time.Minute), now.Add(-time.Hour * 2), false},
+		{"idle", now.Add(-time.Hour), now.Add(-time.Hour), now.Add(-time.Minute), time.Time{}, true},
+		{"too old", now.Add(-time.Hour * 3), now, time.Time{}, now.Add(-time.Hour * 2), true},
+		{"never used falls back to creation", now.Add(-time.Hour), time.Time{}, now.Add(-time.Minute), time.Time{}, true},
+		{"checks disabled", now.Add(-time.Hour * 3), now.Add(-time.Hour), time.Time{}, time.Time{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewSessionMemoryStore()
+			session := Session{
+				ID:        uuid.New(),
+				UserID:    uuid.New(),
+				CreatedAt: tc.createdAt,
+				LastUsed:  tc.lastUsed,
+			}
+
+			if _, err := store.Create(session); err != nil {
+				t.Fatalf("unexpected error when creating session: %s", err)
+			}
+
+			deleted, err := store.DeleteExpired(tc.lastUsedBefore, tc.createdBefore)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, err = store.Get(session.ID)
+			if tc.deleted {
+				if deleted != 1 || !errors.Is(err, ErrSessionNotFound) {
+					t.Errorf("session should have been deleted. count: %d. err: %v", deleted, err)
+				}
+			} else if deleted != 0 || err != nil {
+				t.Errorf("session should have been kept. count: %d. err: %v", deleted, err)
+			}
+		})
+	}
+}
+
+func TestSessionMemoryStore_TokenStorage(t *testing.T) {
+	store := NewSessionMemoryStore()
+	session, err := NewSession(uuid.New())
+	if err != nil {
+		t.Fatalf("unexpected error when generating session: %s", err)
+	}
+
+	created, err := store.Create(*session)
+	if err != nil {
+		t.Fatalf("unexpected error when creating session: %s", err)
+	}
+
+	if created.Token != session.Token {
+		t.Errorf("plaintext token should be handed back on creation. expected: %s. got: %s", session.Token, created.Token)
+	}
+
+	t.Run("plaintext token is not kept", func(t *testing.T) {
+		s, err := store.Get(session.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if s.Token != "" {
+			t.Errorf("plaintext token returned from storage: %s", s.Token)
+		}
+
+		if s.TokenHash != HashToken(session.Token) {
+			t.Errorf("unexpected token hash. expected: %s. got: %s", HashToken(session.Token), s.TokenHash)
+		}
+	})
+
+	t.Run("stored hash cannot be used as token", func(t *testing.T) {
+		_, err := store.GetForToken(HashToken(session.Token))
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrSessionNotFound, err)
+		}
+	})
+
+	t.Run("rotated token replaces the previous one", func(t *testing.T) {
+		rotated := *session
+		rotated.Token = "rotated"
+		if _, err := store.Update(rotated); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := store.GetForToken(session.Token); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("previous token still valid. got: %v", err)
+		}
+
+		if _, err := store.GetForToken("rotated"); err != nil {
+			t.Errorf("unexpected error while retrieving rotated token: %s", err)
+		}
+	})
+
+	t.Run("deleted session is removed from the index", func(t *testing.T) {
+		if err := store.Delete(session.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := store.GetForToken("rotated"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("unexpected error. expected: %s. got: %v", ErrSessionNotFound, err)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"time"
+)
+
+// InstrumentedSessionStore records the latency of every call to the wrapped store, its errors, and keeps
+// count of the active sessions. The count starts at zero, it is only accurate for stores that start empty.
+type InstrumentedSessionStore struct {
+	store    SessionStoreInterface
+	duration *metrics.Histogram
+	errors   *metrics.Counter
+	active   *metrics.Gauge
+	expired  *metrics.Counter
+}
+
+func NewInstrumentedSessionStore(store SessionStoreInterface, registry *metrics.Registry) *InstrumentedSessionStore {
+	return &InstrumentedSessionStore{
+		store:    store,
+		duration: registry.NewHistogram("auth_session_store_duration_seconds", "Latency of session store calls.", nil, "operation"),
+		errors:   registry.NewCounter("auth_session_store_errors_total", "Session store calls that failed.", "operation"),
+		active:   registry.NewGauge("auth_sessions_active", "Sessions currently stored."),
+		expired:  registry.NewCounter("auth_sessions_expired_total", "Sessions removed once expired."),
+	}
+}
+
+func (i *InstrumentedSessionStore) observe(operation string, start time.Time, err error) {
+	i.duration.Observe(metrics.Since(start), operation)
+	// a missing session or an already rotated refresh token is an answer, not a store failure
+	if err != nil && !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrRefreshTokenMismatch) {
+		i.errors.Inc(operation)
+	}
+}
+
+func (i *InstrumentedSessionStore) Create(session Session) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.Create(session)
+	i.observe("create", start, err)
+	if err == nil {
+		i.active.Inc()
+	}
+	return s, err
+}
+
+func (i *InstrumentedSessionStore) Get(id uuid.UUID) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.Get(id)
+	i.observe("get", start, err)
+	return s, err
+}
+
+func (i *InstrumentedSessionStore) GetForUser(userID uuid.UUID) ([]Session, error) {
+	start := time.Now()
+	found, err := i.store.GetForUser(userID)
+	i.observe("get_for_user", start, err)
+	return found, err
+}
+
+func (i *InstrumentedSessionStore) GetForToken(token string) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.GetForToken(token)
+	i.observe("get_for_token", start, err)
+	return s, err
+}
+
+func (i *InstrumentedSessionStore) GetForRefreshFamily(family string) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.GetForRefreshFamily(family)
+	i.observe("get_for_refresh_family", start, err)
+	return s, err
+}
+
+func (i *InstrumentedSessionStore) Update(session Session) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.Update(session)
+	i.observe("update", start, err)
+	return s, err
+}
+
+func (i *InstrumentedSessionStore) RotateRefreshToken(sessionID uuid.UUID, oldHash, newHash string) (*Session, error) {
+	start := time.Now()
+	s, err := i.store.RotateRefreshToken(sessionID, oldHash, newHash)
+	i.observe("rotate_refresh_token", start, err)
+	return s, err
+}
+
+// Delete looks the session up first, deleting a missing session succeeds and must not lower the count
+func (i *InstrumentedSessionStore) Delete(id uuid.UUID) error {
+	_, lookupErr := i.store.Get(id)
+
+	start := time.Now()
+	err := i.store.Delete(id)
+	i.observe("delete", start, err)
+	if err == nil && lookupErr == nil {
+		i.active.Dec()
+	}
+	return err
+}
+
+func (i *InstrumentedSessionStore) DeleteExpired(lastUsedBefore, createdBefore time.Time) (int, error) {
+	start := time.Now()
+	count, err := i.store.DeleteExpired(lastUsedBefore, createdBefore)
+	i.observe("delete_expired", start, err)
+	i.active.Add(-float64(count))
+	i.expired.Add(float64(count))
+	return count, err
+}
@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedSessionStore(t *testing.T) {
+	registry := metrics.NewRegistry()
+	store := NewInstrumentedSessionStore(NewSessionMemoryStore(), registry)
+	userID := uuid.New()
+
+	var created []*Session
+	for i := 0; i < 3; i++ {
+		session, err := NewSession(userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if i == 0 {
+			session.LastUsed = time.Now().Add(-time.Hour)
+		}
+
+		s, err := store.Create(*session)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		created = append(created, s)
+	}
+
+	if err := store.Delete(created[1].ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_ = store.Delete(uuid.New())
+
+	if _, err := store.DeleteExpired(time.Now().Add(-time.Minute), time.Time{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var output strings.Builder
+	if err := registry.WriteText(&output); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, line := range []string{
+		"auth_sessions_active 1",
+		"auth_sessions_expired_total 1",
+		`auth_session_store_duration_seconds_count{operation="create"} 3`,
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("missing %q from the metrics:\n%s", line, output.String())
+		}
+	}
+
+	if strings.Contains(output.String(), "auth_session_store_errors_total{") {
+		t.Errorf("missing sessions should not count as errors:\n%s", output.String())
+	}
+}
@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"github.com/google/uuid"
@@ -12,15 +13,24 @@ var (
 	ErrSessionNotFound      = errors.New("session not found")
 	ErrSessionAlreadyExist  = errors.New("session already exist")
 	ErrSessionMissingUserID = errors.New("user ID missing")
+	// ErrRefreshTokenMismatch means the refresh token was already rotated, by a concurrent refresh or a replay
+	ErrRefreshTokenMismatch = errors.New("refresh token mismatch")
 )
 
 type Session struct {
-	ID        uuid.UUID
-	UserID    uuid.UUID
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// Token is the plaintext token handed to the client. Stores never persist it,
+	// it is only populated on sessions that were just created or provided by the client.
 	Token     string
+	TokenHash string
 	CSRFToken string
 	CreatedAt time.Time
 	LastUsed  time.Time
+	// RefreshFamily identifies the chain of refresh tokens issued for the session.
+	// Only the latest one, stored as RefreshTokenHash, can be exchanged.
+	RefreshFamily    string
+	RefreshTokenHash string
 }
 
 func generateToken() (string, error) {
@@ -31,6 +41,12 @@ func generateToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashToken returns the digest under which a session token is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func NewSession(userID uuid.UUID) (*Session, error) {
 	token, err := generateToken()
 	if err != nil {
@@ -46,6 +62,7 @@ func NewSession(userID uuid.UUID) (*Session, error) {
 		ID:        uuid.New(),
 		UserID:    userID,
 		Token:     token,
+		TokenHash: HashToken(token),
 		CSRFToken: csrfToken,
 		CreatedAt: time.Now(),
 		LastUsed:  time.Now(),
@@ -57,6 +74,13 @@ type SessionStoreInterface interface {
 	Get(id uuid.UUID) (*Session, error)
 	GetForUser(userID uuid.UUID) ([]Session, error)
 	GetForToken(token string) (*Session, error)
+	GetForRefreshFamily(family string) (*Session, error)
 	Update(session Session) (*Session, error)
+	// RotateRefreshToken replaces the refresh token hash of the session and marks it as used, provided the current
+	// hash is still oldHash. It returns ErrRefreshTokenMismatch otherwise, the check and the swap are atomic.
+	RotateRefreshToken(sessionID uuid.UUID, oldHash, newHash string) (*Session, error)
 	Delete(id uuid.UUID) error
+	// DeleteExpired removes sessions last used before lastUsedBefore or created before createdBefore.
+	// A zero time disables the corresponding check.
+	DeleteExpired(lastUsedBefore, createdBefore time.Time) (int, error)
 }
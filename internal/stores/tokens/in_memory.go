@@ -0,0 +1,159 @@
+package tokens
+
+import (
+	"crypto/subtle"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+type TokenMemoryStore struct {
+	items map[uuid.UUID]Token
+	// hashes indexes token IDs by value hash
+	hashes map[string]uuid.UUID
+	mu     sync.RWMutex
+}
+
+func NewTokenMemoryStore() *TokenMemoryStore {
+	return &TokenMemoryStore{
+		items:  make(map[uuid.UUID]Token),
+		hashes: make(map[string]uuid.UUID),
+		mu:     sync.RWMutex{},
+	}
+}
+
+func (s *TokenMemoryStore) Create(token Token) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	emptyUUID := uuid.UUID{}
+
+	if token.ID == emptyUUID {
+		token.ID = uuid.New()
+	}
+
+	if _, ok := s.items[token.ID]; ok {
+		return nil, ErrTokenAlreadyExist
+	}
+
+	if token.UserID == emptyUUID {
+		return nil, ErrTokenMissingUserID
+	}
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now().UTC()
+	}
+
+	value := token.Value
+	if value != "" {
+		token.Hash = Hash(value)
+		token.Value = ""
+	}
+
+	if _, ok := s.hashes[token.Hash]; ok {
+		return nil, ErrTokenAlreadyExist
+	}
+
+	s.items[token.ID] = token
+	s.hashes[token.Hash] = token.ID
+
+	// the plaintext value is only handed back to the creator
+	localToken := token
+	localToken.Value = value
+
+	return &localToken, nil
+}
+
+func (s *TokenMemoryStore) Get(id uuid.UUID) (*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if token, ok := s.items[id]; ok {
+		return &token, nil
+	}
+
+	return nil, ErrTokenNotFound
+}
+
+func (s *TokenMemoryStore) GetForValue(purpose Purpose, value string) (*Token, error) {
+	if value == "" {
+		return nil, ErrTokenNotFound
+	}
+
+	hash := Hash(value)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.hashes[hash]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	token, ok := s.items[id]
+	if !ok || token.Purpose != purpose || subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+		return nil, ErrTokenNotFound
+	}
+
+	return &token, nil
+}
+
+func (s *TokenMemoryStore) GetForUser(userID uuid.UUID, purpose Purpose) ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []Token
+
+	for _, token := range s.items {
+		if token.UserID == userID && token.Purpose == purpose {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (s *TokenMemoryStore) MarkUsed(id uuid.UUID, usedAt time.Time) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.items[id]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	if token.Used() {
+		return nil, ErrTokenAlreadyUsed
+	}
+
+	token.UsedAt = &usedAt
+	s.items[id] = token
+
+	return &token, nil
+}
+
+func (s *TokenMemoryStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.items[id]; ok {
+		delete(s.hashes, token.Hash)
+		delete(s.items, id)
+	}
+
+	return nil
+}
+
+func (s *TokenMemoryStore) DeleteForUser(userID uuid.UUID, purpose Purpose) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.items {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(s.hashes, token.Hash)
+			delete(s.items, id)
+		}
+	}
+
+	return nil
+}
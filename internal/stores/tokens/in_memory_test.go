@@ -0,0 +1,162 @@
+package tokens
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestTokenMemoryStore_Create(t *testing.T) {
+	t.Run("require user id", func(t *testing.T) {
+		store := NewTokenMemoryStore()
+		_, err := store.Create(Token{Purpose: PurposePasswordReset, Value: "test"})
+		if !errors.Is(err, ErrTokenMissingUserID) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrTokenMissingUserID, err)
+		}
+	})
+
+	t.Run("plaintext value is not kept", func(t *testing.T) {
+		store := NewTokenMemoryStore()
+		token, err := NewToken(uuid.New(), PurposePasswordReset, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error while generating token: %s", err)
+		}
+
+		created, err := store.Create(*token)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if created.Value != token.Value {
+			t.Errorf("plaintext value should be handed back on creation. expected: %s. got: %s", token.Value, created.Value)
+		}
+
+		stored, err := store.Get(token.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if stored.Value != "" || stored.Hash != Hash(token.Value) {
+			t.Errorf("token not stored hashed. got: %v", stored)
+		}
+	})
+
+	t.Run("duplicate value", func(t *testing.T) {
+		store := NewTokenMemoryStore()
+		if _, err := store.Create(Token{UserID: uuid.New(), Value: "test"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, err := store.Create(Token{UserID: uuid.New(), Value: "test"})
+		if !errors.Is(err, ErrTokenAlreadyExist) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrTokenAlreadyExist, err)
+		}
+	})
+}
+
+func TestTokenMemoryStore_GetForValue(t *testing.T) {
+	store := NewTokenMemoryStore()
+	token := Token{UserID: uuid.New(), Purpose: PurposePasswordReset, Value: "test"}
+	created, err := store.Create(token)
+	if err != nil {
+		t.Fatalf("unexpected error while creating token: %s", err)
+	}
+
+	testCases := []struct {
+		name    string
+		purpose Purpose
+		value   string
+		err     error
+	}{
+		{"matching value", PurposePasswordReset, "test", nil},
+		{"unknown value", PurposePasswordReset, "unknown", ErrTokenNotFound},
+		{"hash used as value", PurposePasswordReset, Hash("test"), ErrTokenNotFound},
+		{"other purpose", Purpose("other"), "test", ErrTokenNotFound},
+		{"empty value", PurposePasswordReset, "", ErrTokenNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := store.GetForValue(tc.purpose, tc.value)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+
+			if tc.err == nil && found.ID != created.ID {
+				t.Errorf("unexpected token. expected: %s. got: %s", created.ID, found.ID)
+			}
+		})
+	}
+}
+
+func TestTokenMemoryStore_GetForUser(t *testing.T) {
+	store := NewTokenMemoryStore()
+	userID := uuid.New()
+
+	for _, token := range []Token{
+		{UserID: userID, Purpose: PurposePasswordReset, Value: "1"},
+		{UserID: userID, Purpose: PurposePasswordReset, Value: "2"},
+		{UserID: userID, Purpose: Purpose("other"), Value: "3"},
+		{UserID: uuid.New(), Purpose: PurposePasswordReset, Value: "4"},
+	} {
+		if _, err := store.Create(token); err != nil {
+			t.Fatalf("unexpected error while creating token: %s", err)
+		}
+	}
+
+	userTokens, err := store.GetForUser(userID, PurposePasswordReset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(userTokens) != 2 {
+		t.Errorf("unexpected amount of tokens. expected: 2. got: %d", len(userTokens))
+	}
+}
+
+func TestTokenMemoryStore_MarkUsed(t *testing.T) {
+	store := NewTokenMemoryStore()
+	token, err := store.Create(Token{UserID: uuid.New(), Value: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error while creating token: %s", err)
+	}
+
+	used, err := store.MarkUsed(token.ID, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !used.Used() {
+		t.Errorf("token not flagged as used")
+	}
+
+	if _, err = store.MarkUsed(token.ID, time.Now()); !errors.Is(err, ErrTokenAlreadyUsed) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrTokenAlreadyUsed, err)
+	}
+
+	if _, err = store.MarkUsed(uuid.New(), time.Now()); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrTokenNotFound, err)
+	}
+}
+
+func TestTokenMemoryStore_DeleteForUser(t *testing.T) {
+	store := NewTokenMemoryStore()
+	userID := uuid.New()
+	token, err := store.Create(Token{UserID: userID, Purpose: PurposePasswordReset, Value: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error while creating token: %s", err)
+	}
+
+	if err = store.DeleteForUser(userID, PurposePasswordReset); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err = store.Get(token.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("unexpected error. expected: %s. got: %v", ErrTokenNotFound, err)
+	}
+
+	if _, err = store.GetForValue(PurposePasswordReset, "test"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("token still indexed after deletion. got: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+var (
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrTokenAlreadyExist  = errors.New("token already exist")
+	ErrTokenAlreadyUsed   = errors.New("token already used")
+	ErrTokenMissingUserID = errors.New("user ID missing")
+)
+
+type Purpose string
+
+const (
+	PurposePasswordReset     Purpose = "password_reset"
+	PurposeEmailConfirmation Purpose = "email_confirmation"
+	// PurposeLoginChallenge links the password step of a login to its second factor step
+	PurposeLoginChallenge Purpose = "login_challenge"
+)
+
+// Token is a single-use secret sent to a user, e.g. in a password reset link.
+type Token struct {
+	ID      uuid.UUID
+	UserID  uuid.UUID
+	Purpose Purpose
+	// Value is the plaintext token. Stores never persist it, it is only populated on creation.
+	Value     string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (t Token) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+func (t Token) Used() bool {
+	return t.UsedAt != nil
+}
+
+// Hash returns the digest under which a token value is stored.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateValue() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
+
+func NewToken(userID uuid.UUID, purpose Purpose, lifetime time.Duration) (*Token, error) {
+	value, err := generateValue()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &Token{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Value:     value,
+		Hash:      Hash(value),
+		ExpiresAt: now.Add(lifetime),
+		CreatedAt: now,
+	}, nil
+}
+
+type TokenStoreInterface interface {
+	Create(token Token) (*Token, error)
+	Get(id uuid.UUID) (*Token, error)
+	GetForValue(purpose Purpose, value string) (*Token, error)
+	GetForUser(userID uuid.UUID, purpose Purpose) ([]Token, error)
+	// MarkUsed flags the token as used, failing with ErrTokenAlreadyUsed if it already was.
+	MarkUsed(id uuid.UUID, usedAt time.Time) (*Token, error)
+	Delete(id uuid.UUID) error
+	DeleteForUser(userID uuid.UUID, purpose Purpose) error
+}
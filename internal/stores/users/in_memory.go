@@ -1,19 +1,68 @@
 package users
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/mathieuhays/auth/internal/validate"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 type UserMemoryStore struct {
 	items map[uuid.UUID]User
-	mu    sync.RWMutex
+	// emails indexes user IDs by normalized email
+	emails map[string]uuid.UUID
+	mu     sync.RWMutex
 }
 
 func NewUserMemoryStore() *UserMemoryStore {
-	return &UserMemoryStore{items: make(map[uuid.UUID]User), mu: sync.RWMutex{}}
+	return &UserMemoryStore{
+		items:  make(map[uuid.UUID]User),
+		emails: make(map[string]uuid.UUID),
+		mu:     sync.RWMutex{},
+	}
+}
+
+// emailTaken reports whether the email is used by another user than id
+func (u *UserMemoryStore) emailTaken(email string, id uuid.UUID) bool {
+	key := NormalizeEmail(email)
+	if key == "" {
+		return false
+	}
+
+	owner, ok := u.emails[key]
+	return ok && owner != id
+}
+
+func (u *UserMemoryStore) index(user User) {
+	if key := NormalizeEmail(user.Email); key != "" {
+		u.emails[key] = user.ID
+	}
+}
+
+func (u *UserMemoryStore) unindex(user User) {
+	key := NormalizeEmail(user.Email)
+	if owner, ok := u.emails[key]; ok && owner == user.ID {
+		delete(u.emails, key)
+	}
+}
+
+// clone makes sure callers never share slices with the stored entry
+func clone(user User) *User {
+	user.RecoveryCodes = slices.Clone(user.RecoveryCodes)
+	user.Roles = slices.Clone(user.Roles)
+	user.ConfiguredRoles = slices.Clone(user.ConfiguredRoles)
+	return &user
+}
+
+// Ping waits for the store lock, so a store stuck behind a writer fails the readiness check once ctx is done
+func (u *UserMemoryStore) Ping(ctx context.Context) error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return ctx.Err()
 }
 
 func (u *UserMemoryStore) Create(user User) (*User, error) {
@@ -29,14 +78,18 @@ func (u *UserMemoryStore) Create(user User) (*User, error) {
 		return nil, ErrUserAlreadyExist
 	}
 
+	if u.emailTaken(user.Email, user.ID) {
+		return nil, ErrEmailAlreadyUsed
+	}
+
 	if user.CreatedAt.IsZero() {
 		user.CreatedAt = time.Now().UTC()
 	}
 
-	u.items[user.ID] = user
-	localUser := u.items[user.ID]
+	u.items[user.ID] = *clone(user)
+	u.index(user)
 
-	return &localUser, nil
+	return clone(u.items[user.ID]), nil
 }
 
 func (u *UserMemoryStore) Get(id uuid.UUID) (*User, error) {
@@ -44,7 +97,7 @@ func (u *UserMemoryStore) Get(id uuid.UUID) (*User, error) {
 	defer u.mu.RUnlock()
 
 	if localUser, ok := u.items[id]; ok {
-		return &localUser, nil
+		return clone(localUser), nil
 	}
 
 	return nil, ErrUserNotFound
@@ -54,10 +107,11 @@ func (u *UserMemoryStore) GetByEmail(email string) (*User, error) {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
 
-	if validate.Email(email) == nil {
-		for _, user := range u.items {
-			if user.Email == email {
-				return &user, nil
+	key := NormalizeEmail(email)
+	if validate.Email(key) == nil {
+		if id, ok := u.emails[key]; ok {
+			if user, ok := u.items[id]; ok {
+				return clone(user), nil
 			}
 		}
 	}
@@ -65,24 +119,66 @@ func (u *UserMemoryStore) GetByEmail(email string) (*User, error) {
 	return nil, ErrUserNotFound
 }
 
+func (u *UserMemoryStore) Search(query string, offset, limit int) ([]User, int, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	query = NormalizeEmail(query)
+	var matches []User
+
+	for _, user := range u.items {
+		if strings.Contains(NormalizeEmail(user.Email), query) {
+			matches = append(matches, user)
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b User) int {
+		return strings.Compare(NormalizeEmail(a.Email), NormalizeEmail(b.Email))
+	})
+
+	total := len(matches)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+
+	found := make([]User, 0, end-offset)
+	for _, user := range matches[offset:end] {
+		found = append(found, *clone(user))
+	}
+
+	return found, total, nil
+}
+
 func (u *UserMemoryStore) Update(user User) (*User, error) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
-	if _, ok := u.items[user.ID]; !ok {
+	existing, ok := u.items[user.ID]
+	if !ok {
 		return nil, ErrUserNotFound
 	}
 
-	u.items[user.ID] = user
-	localUser := u.items[user.ID]
+	if u.emailTaken(user.Email, user.ID) {
+		return nil, ErrEmailAlreadyUsed
+	}
+
+	u.unindex(existing)
+	u.items[user.ID] = *clone(user)
+	u.index(user)
 
-	return &localUser, nil
+	return clone(u.items[user.ID]), nil
 }
 
 func (u *UserMemoryStore) Delete(id uuid.UUID) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
-	delete(u.items, id)
+	if user, ok := u.items[id]; ok {
+		u.unindex(user)
+		delete(u.items, id)
+	}
+
 	return nil
 }
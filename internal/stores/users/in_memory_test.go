@@ -3,6 +3,7 @@ package users
 import (
 	"errors"
 	"github.com/google/uuid"
+	"strings"
 	"testing"
 	"time"
 )
@@ -100,6 +101,35 @@ func TestUserMemoryStore_Create(t *testing.T) {
 	})
 }
 
+func TestUserMemoryStore_CreateUniqueEmail(t *testing.T) {
+	store := NewUserMemoryStore()
+	if _, err := store.Create(User{Email: "Bob@Example.com"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name  string
+		email string
+		err   error
+	}{
+		{"same email", "Bob@Example.com", ErrEmailAlreadyUsed},
+		{"different case", "bob@example.com", ErrEmailAlreadyUsed},
+		{"surrounding spaces", " bob@example.com ", ErrEmailAlreadyUsed},
+		{"other email", "alice@example.com", nil},
+		{"empty email is not indexed", "", nil},
+		{"second empty email", "", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := store.Create(User{Email: tc.email})
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. expected: %v. got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
 func TestUserMemoryStore_Get(t *testing.T) {
 	t.Run("fetch existing record", func(t *testing.T) {
 		store := NewUserMemoryStore()
@@ -170,6 +200,26 @@ func TestUserMemoryStore_GetByEmail(t *testing.T) {
 		}
 	})
 
+	t.Run("case insensitive", func(t *testing.T) {
+		store := NewUserMemoryStore()
+		user := User{
+			ID:    uuid.New(),
+			Email: "Test@Example.com",
+		}
+		if _, err := store.Create(user); err != nil {
+			t.Fatalf("error while creating user: %s", err)
+		}
+
+		u, err := store.GetByEmail("test@EXAMPLE.com")
+		if err != nil {
+			t.Fatalf("unexpected error while retrieving user by email: %s", err)
+		}
+
+		if u.ID != user.ID {
+			t.Fatalf("IDs do not match. expected: %v. got: %v", user.ID, u.ID)
+		}
+	})
+
 	t.Run("invalid email", func(t *testing.T) {
 		store := NewUserMemoryStore()
 		_, err := store.GetByEmail("invalid")
@@ -207,6 +257,44 @@ func TestUserMemoryStore_Update(t *testing.T) {
 		}
 	})
 
+	t.Run("email used by another user", func(t *testing.T) {
+		store := NewUserMemoryStore()
+		if _, err := store.Create(User{Email: "taken@example.com"}); err != nil {
+			t.Fatalf("unexpected error while creating user: %s", err)
+		}
+
+		u, err := store.Create(User{Email: "test@example.com"})
+		if err != nil {
+			t.Fatalf("unexpected error while creating user: %s", err)
+		}
+
+		u.Email = "Taken@example.com"
+		if _, err = store.Update(*u); !errors.Is(err, ErrEmailAlreadyUsed) {
+			t.Fatalf("unexpected error. expected: %s. got: %v", ErrEmailAlreadyUsed, err)
+		}
+	})
+
+	t.Run("email change releases the previous one", func(t *testing.T) {
+		store := NewUserMemoryStore()
+		u, err := store.Create(User{Email: "before@example.com"})
+		if err != nil {
+			t.Fatalf("unexpected error while creating user: %s", err)
+		}
+
+		u.Email = "after@example.com"
+		if _, err = store.Update(*u); err != nil {
+			t.Fatalf("unexpected error while updating user: %s", err)
+		}
+
+		if _, err = store.GetByEmail("before@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("previous email still indexed. got: %v", err)
+		}
+
+		if _, err = store.Create(User{Email: "before@example.com"}); err != nil {
+			t.Errorf("previous email should be available. got: %s", err)
+		}
+	})
+
 	t.Run("missing record", func(t *testing.T) {
 		store := NewUserMemoryStore()
 		user := User{
@@ -262,3 +350,50 @@ func TestUserMemoryStore_Delete(t *testing.T) {
 		}
 	})
 }
+
+func TestUserMemoryStore_Search(t *testing.T) {
+	store := NewUserMemoryStore()
+	for _, email := range []string{"carol@example.com", "alice@example.com", "Bob@Example.com", "dave@other.com"} {
+		if _, err := store.Create(User{Email: email}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		offset   int
+		limit    int
+		expected []string
+		total    int
+	}{
+		{"everyone", "", 0, 0, []string{"alice@example.com", "Bob@Example.com", "carol@example.com", "dave@other.com"}, 4},
+		{"case insensitive", "EXAMPLE", 0, 10, []string{"alice@example.com", "Bob@Example.com", "carol@example.com"}, 3},
+		{"first page", "example", 0, 2, []string{"alice@example.com", "Bob@Example.com"}, 3},
+		{"last page", "example", 2, 2, []string{"carol@example.com"}, 3},
+		{"past the end", "example", 10, 2, []string{}, 3},
+		{"no match", "nobody", 0, 10, []string{}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found, total, err := store.Search(tc.query, tc.offset, tc.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if total != tc.total {
+				t.Errorf("unexpected total. expected: %d. got: %d", tc.total, total)
+			}
+
+			emails := make([]string, 0, len(found))
+			for _, user := range found {
+				emails = append(emails, user.Email)
+			}
+
+			if strings.Join(emails, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("unexpected users. expected: %v. got: %v", tc.expected, emails)
+			}
+		})
+	}
+}
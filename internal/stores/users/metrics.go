@@ -0,0 +1,73 @@
+package users
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"time"
+)
+
+// InstrumentedUserStore records the latency of every call to the wrapped store, and its errors.
+// Missing users are expected and not counted as errors.
+type InstrumentedUserStore struct {
+	store    UserStoreInterface
+	duration *metrics.Histogram
+	errors   *metrics.Counter
+}
+
+func NewInstrumentedUserStore(store UserStoreInterface, registry *metrics.Registry) *InstrumentedUserStore {
+	return &InstrumentedUserStore{
+		store:    store,
+		duration: registry.NewHistogram("auth_user_store_duration_seconds", "Latency of user store calls.", nil, "operation"),
+		errors:   registry.NewCounter("auth_user_store_errors_total", "User store calls that failed.", "operation"),
+	}
+}
+
+func (i *InstrumentedUserStore) observe(operation string, start time.Time, err error) {
+	i.duration.Observe(metrics.Since(start), operation)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		i.errors.Inc(operation)
+	}
+}
+
+func (i *InstrumentedUserStore) Create(user User) (*User, error) {
+	start := time.Now()
+	u, err := i.store.Create(user)
+	i.observe("create", start, err)
+	return u, err
+}
+
+func (i *InstrumentedUserStore) Get(id uuid.UUID) (*User, error) {
+	start := time.Now()
+	u, err := i.store.Get(id)
+	i.observe("get", start, err)
+	return u, err
+}
+
+func (i *InstrumentedUserStore) GetByEmail(email string) (*User, error) {
+	start := time.Now()
+	u, err := i.store.GetByEmail(email)
+	i.observe("get_by_email", start, err)
+	return u, err
+}
+
+func (i *InstrumentedUserStore) Search(query string, offset, limit int) ([]User, int, error) {
+	start := time.Now()
+	found, total, err := i.store.Search(query, offset, limit)
+	i.observe("search", start, err)
+	return found, total, err
+}
+
+func (i *InstrumentedUserStore) Update(user User) (*User, error) {
+	start := time.Now()
+	u, err := i.store.Update(user)
+	i.observe("update", start, err)
+	return u, err
+}
+
+func (i *InstrumentedUserStore) Delete(id uuid.UUID) error {
+	start := time.Now()
+	err := i.store.Delete(id)
+	i.observe("delete", start, err)
+	return err
+}
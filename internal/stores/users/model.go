@@ -3,26 +3,64 @@ package users
 import (
 	"errors"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
 var (
 	ErrUserNotFound     = errors.New("user not found")
 	ErrUserAlreadyExist = errors.New("user already exist")
+	ErrEmailAlreadyUsed = errors.New("email already used")
 )
 
+// NormalizeEmail returns the canonical form of an email address, used to compare addresses.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 type User struct {
 	ID             uuid.UUID
 	Email          string
 	EmailConfirmed *time.Time
 	PasswordHash   string
 	CreatedAt      time.Time
+	// FailedLogins counts recent failed login attempts, LockedUntil is set once they reach the lockout threshold
+	FailedLogins int
+	LockedUntil  *time.Time
+	// TOTPSecret is encrypted. It is set when enrollment starts, TOTPEnabledAt once the first code has been confirmed.
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	// TOTPLastCounter is the time step of the last accepted code, so that a code cannot be used twice
+	TOTPLastCounter int64
+	// RecoveryCodes holds the hashes of the unused recovery codes
+	RecoveryCodes []string
+	Roles         []Role
+	// ConfiguredRoles are the roles among Roles granted from configuration, they are taken back once it no longer lists the user
+	ConfiguredRoles []Role
+	// DisabledAt is set by administrators, disabled accounts cannot sign in
+	DisabledAt *time.Time
+	// PasswordResetRequired blocks password sign-ins until the password has been reset
+	PasswordResetRequired bool
+}
+
+func (u User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+func (u User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 type UserStoreInterface interface {
 	Create(user User) (*User, error)
 	Get(id uuid.UUID) (*User, error)
 	GetByEmail(email string) (*User, error)
+	// Search returns a page of the users whose email contains the query, sorted by email, and the total match count
+	Search(query string, offset, limit int) ([]User, int, error)
 	Update(user User) (*User, error)
 	Delete(id uuid.UUID) error
 }
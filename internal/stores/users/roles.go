@@ -0,0 +1,58 @@
+package users
+
+import "slices"
+
+// Role groups permissions. Users hold roles, code checks permissions.
+type Role string
+
+type Permission string
+
+const (
+	RoleAdmin Role = "admin"
+	// RoleSupport can look up accounts and sign users out, without changing their settings
+	RoleSupport Role = "support"
+)
+
+// Roles lists every role, in the order administrators see them
+var Roles = []Role{RoleAdmin, RoleSupport}
+
+const (
+	PermissionAdminAccess    Permission = "admin:access"
+	PermissionUsersRead      Permission = "users:read"
+	PermissionUsersManage    Permission = "users:manage"
+	PermissionSessionsManage Permission = "sessions:manage"
+)
+
+// RolePermissions lists the permissions granted by each role. Unknown roles grant nothing.
+var RolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionAdminAccess,
+		PermissionUsersRead,
+		PermissionUsersManage,
+		PermissionSessionsManage,
+	},
+	RoleSupport: {
+		PermissionAdminAccess,
+		PermissionUsersRead,
+		PermissionSessionsManage,
+	},
+}
+
+func (u User) HasRole(role Role) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// HasConfiguredRole tells whether the user holds the role because of configuration rather than an administrator
+func (u User) HasConfiguredRole(role Role) bool {
+	return slices.Contains(u.ConfiguredRoles, role)
+}
+
+func (u User) Can(permission Permission) bool {
+	for _, role := range u.Roles {
+		if slices.Contains(RolePermissions[role], permission) {
+			return true
+		}
+	}
+
+	return false
+}
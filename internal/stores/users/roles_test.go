@@ -0,0 +1,27 @@
+package users
+
+import "testing"
+
+func TestUser_Can(t *testing.T) {
+	testCases := []struct {
+		name       string
+		roles      []Role
+		permission Permission
+		expected   bool
+	}{
+		{"no role", nil, PermissionAdminAccess, false},
+		{"admin", []Role{RoleAdmin}, PermissionUsersManage, true},
+		{"support", []Role{RoleSupport}, PermissionSessionsManage, true},
+		{"support cannot manage users", []Role{RoleSupport}, PermissionUsersManage, false},
+		{"combined roles", []Role{RoleSupport, RoleAdmin}, PermissionUsersManage, true},
+		{"unknown role", []Role{"owner"}, PermissionAdminAccess, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if can := (User{Roles: tc.roles}).Can(tc.permission); can != tc.expected {
+				t.Errorf("unexpected result. expected: %t. got: %t", tc.expected, can)
+			}
+		})
+	}
+}
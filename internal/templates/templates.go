@@ -1,32 +1,86 @@
 package templates
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mathieuhays/auth/internal/audit"
 	"github.com/mathieuhays/auth/internal/forms"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/apikeys"
+	"github.com/mathieuhays/auth/internal/stores/credentials"
 	"github.com/mathieuhays/auth/internal/stores/sessions"
 	"github.com/mathieuhays/auth/internal/stores/users"
 	"html/template"
 	"io"
 )
 
+var ErrNotLoaded = errors.New("templates are not loaded")
+
+// requiredTemplates lists every template rendered by the Engine
+var requiredTemplates = []string{
+	"admin_user", "admin_users", "api_keys", "confirm_email", "confirm_email_pending", "dashboard", "error", "index",
+	"login", "login_verify", "passkeys", "password_forgot", "password_reset", "register", "security_activity",
+	"two_factor",
+}
+
 type Engine struct {
 	tpl *template.Template
 }
 
+// csrfTokenWriter is implemented by response writers carrying the CSRF token of the current request
+type csrfTokenWriter interface {
+	CSRFToken() string
+}
+
+// page holds the values shared by every template
+type page struct {
+	CSRFToken string
+}
+
+func newPage(writer io.Writer) page {
+	p := page{}
+
+	if w, ok := writer.(csrfTokenWriter); ok {
+		p.CSRFToken = w.CSRFToken()
+	}
+
+	return p
+}
+
 func NewEngine(tpl *template.Template) Engine {
 	return Engine{tpl: tpl}
 }
 
+// Check reports whether every template rendered by the Engine has been parsed, for readiness checks
+func (t Engine) Check(ctx context.Context) error {
+	if t.tpl == nil {
+		return ErrNotLoaded
+	}
+
+	for _, name := range requiredTemplates {
+		if t.tpl.Lookup(name) == nil {
+			return fmt.Errorf("%w: %s is missing", ErrNotLoaded, name)
+		}
+	}
+
+	return ctx.Err()
+}
+
 func (t Engine) Index(writer io.Writer) error {
-	return t.tpl.ExecuteTemplate(writer, "index", nil)
+	return t.tpl.ExecuteTemplate(writer, "index", newPage(writer))
 }
 
 func (t Engine) Error(writer io.Writer, title, description string) error {
 	return t.tpl.ExecuteTemplate(writer, "error", struct {
+		page
 		Error struct {
 			Title       string
 			Description string
 		}
 	}{
+		page: newPage(writer),
 		Error: struct {
 			Title       string
 			Description string
@@ -34,28 +88,190 @@ func (t Engine) Error(writer io.Writer, title, description string) error {
 	})
 }
 
-func (t Engine) Register(writer io.Writer, form *forms.Form) error {
+func (t Engine) Register(writer io.Writer, form *forms.Form, registered bool) error {
 	return t.tpl.ExecuteTemplate(writer, "register", struct {
-		Form *forms.Form
+		page
+		Form       *forms.Form
+		Registered bool
 	}{
-		Form: form,
+		page:       newPage(writer),
+		Form:       form,
+		Registered: registered,
 	})
 }
 
 func (t Engine) Login(writer io.Writer, form *forms.Form) error {
 	return t.tpl.ExecuteTemplate(writer, "login", struct {
+		page
 		Form *forms.Form
 	}{
+		page: newPage(writer),
 		Form: form,
 	})
 }
 
 func (t Engine) Dashboard(writer io.Writer, u *users.User, s *sessions.Session) error {
 	return t.tpl.ExecuteTemplate(writer, "dashboard", struct {
+		page
 		User    *users.User
 		Session *sessions.Session
 	}{
+		page:    newPage(writer),
 		User:    u,
 		Session: s,
 	})
 }
+
+func (t Engine) ForgotPassword(writer io.Writer, form *forms.Form, sent bool) error {
+	return t.tpl.ExecuteTemplate(writer, "password_forgot", struct {
+		page
+		Form *forms.Form
+		Sent bool
+	}{
+		page: newPage(writer),
+		Form: form,
+		Sent: sent,
+	})
+}
+
+func (t Engine) ResetPassword(writer io.Writer, form *forms.Form, token string, done bool) error {
+	return t.tpl.ExecuteTemplate(writer, "password_reset", struct {
+		page
+		Form  *forms.Form
+		Token string
+		Done  bool
+	}{
+		page:  newPage(writer),
+		Form:  form,
+		Token: token,
+		Done:  done,
+	})
+}
+
+func (t Engine) ConfirmEmail(writer io.Writer, confirmed bool) error {
+	return t.tpl.ExecuteTemplate(writer, "confirm_email", struct {
+		page
+		Confirmed bool
+	}{
+		page:      newPage(writer),
+		Confirmed: confirmed,
+	})
+}
+
+func (t Engine) ConfirmEmailPending(writer io.Writer, u *users.User, sent bool, err error) error {
+	return t.tpl.ExecuteTemplate(writer, "confirm_email_pending", struct {
+		page
+		User  *users.User
+		Sent  bool
+		Error error
+	}{
+		page:  newPage(writer),
+		User:  u,
+		Sent:  sent,
+		Error: err,
+	})
+}
+
+func (t Engine) LoginVerify(writer io.Writer, form *forms.Form) error {
+	return t.tpl.ExecuteTemplate(writer, "login_verify", struct {
+		page
+		Form *forms.Form
+	}{
+		page: newPage(writer),
+		Form: form,
+	})
+}
+
+// TwoFactor renders the enrollment when enrollment is set, the freshly issued recovery codes
+// when there are any, and the management forms otherwise. qrCode is an SVG document.
+func (t Engine) TwoFactor(writer io.Writer, u *users.User, enrollment *user.TOTPEnrollment, qrCode string, recoveryCodes []string, err error) error {
+	return t.tpl.ExecuteTemplate(writer, "two_factor", struct {
+		page
+		User          *users.User
+		Enrollment    *user.TOTPEnrollment
+		QRCode        template.HTML
+		RecoveryCodes []string
+		Error         error
+	}{
+		page:          newPage(writer),
+		User:          u,
+		Enrollment:    enrollment,
+		QRCode:        template.HTML(qrCode),
+		RecoveryCodes: recoveryCodes,
+		Error:         err,
+	})
+}
+
+func (t Engine) Passkeys(writer io.Writer, u *users.User, passkeys []credentials.Credential, err error) error {
+	return t.tpl.ExecuteTemplate(writer, "passkeys", struct {
+		page
+		User     *users.User
+		Passkeys []credentials.Credential
+		Error    error
+	}{
+		page:     newPage(writer),
+		User:     u,
+		Passkeys: passkeys,
+		Error:    err,
+	})
+}
+
+func (t Engine) APIKeys(writer io.Writer, u *users.User, keys []apikeys.APIKey, scopes []apikey.Scope, created string, err error) error {
+	return t.tpl.ExecuteTemplate(writer, "api_keys", struct {
+		page
+		User    *users.User
+		Keys    []apikeys.APIKey
+		Scopes  []apikey.Scope
+		Created string
+		Error   error
+	}{
+		page:    newPage(writer),
+		User:    u,
+		Keys:    keys,
+		Scopes:  scopes,
+		Created: created,
+		Error:   err,
+	})
+}
+
+func (t Engine) AdminUsers(writer io.Writer, u *users.User, results *user.UserPage) error {
+	return t.tpl.ExecuteTemplate(writer, "admin_users", struct {
+		page
+		User    *users.User
+		Results *user.UserPage
+	}{
+		page:    newPage(writer),
+		User:    u,
+		Results: results,
+	})
+}
+
+func (t Engine) AdminUser(writer io.Writer, u *users.User, target *users.User, userSessions []sessions.Session, message string) error {
+	return t.tpl.ExecuteTemplate(writer, "admin_user", struct {
+		page
+		User     *users.User
+		Target   *users.User
+		Sessions []sessions.Session
+		Message  string
+		Roles    []users.Role
+	}{
+		page:     newPage(writer),
+		User:     u,
+		Target:   target,
+		Sessions: userSessions,
+		Message:  message,
+		Roles:    users.Roles,
+	})
+}
+
+func (t Engine) SecurityActivity(writer io.Writer, u *users.User, events []audit.Event) error {
+	return t.tpl.ExecuteTemplate(writer, "security_activity", struct {
+		page
+		User   *users.User
+		Events []audit.Event
+	}{
+		page:   newPage(writer),
+		User:   u,
+		Events: events,
+	})
+}
@@ -0,0 +1,109 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindow counts events per key over a rolling time window.
+type SlidingWindow struct {
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewSlidingWindow(window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		window: window,
+		events: make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// prune drops the events of key that fell out of the window. Expects the lock to be held.
+func (s *SlidingWindow) prune(key string, now time.Time) []time.Time {
+	events := s.events[key]
+	cutoff := now.Add(-s.window)
+
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+
+	events = events[i:]
+	if len(events) == 0 {
+		delete(s.events, key)
+	} else {
+		s.events[key] = events
+	}
+
+	return events
+}
+
+// sweep prunes every key, at most once per window, so that idle keys do not pile up
+func (s *SlidingWindow) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.window {
+		return
+	}
+
+	for key := range s.events {
+		s.prune(key, now)
+	}
+	s.lastSweep = now
+}
+
+// Add records an event for key and returns the amount of events within the window.
+func (s *SlidingWindow) Add(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	events := append(s.prune(key, now), now)
+	s.events[key] = events
+
+	return len(events)
+}
+
+func (s *SlidingWindow) Count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.prune(key, s.now()))
+}
+
+// RetryAfter returns how long until the oldest event of key leaves the window.
+func (s *SlidingWindow) RetryAfter(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	events := s.prune(key, now)
+	if len(events) == 0 {
+		return 0
+	}
+
+	return events[0].Add(s.window).Sub(now)
+}
+
+func (s *SlidingWindow) Reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.events, key)
+}
+
+// Backoff returns base doubled for every attempt, capped at max.
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	return min(delay, max)
+}
@@ -0,0 +1,83 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestWindow(window time.Duration) (*SlidingWindow, *time.Time) {
+	now := time.Now()
+	s := NewSlidingWindow(window)
+	s.now = func() time.Time {
+		return now
+	}
+
+	return s, &now
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Run("counts events within the window", func(t *testing.T) {
+		s, now := newTestWindow(time.Minute)
+
+		s.Add("a")
+		*now = now.Add(time.Second * 30)
+		s.Add("a")
+		s.Add("b")
+
+		if count := s.Count("a"); count != 2 {
+			t.Errorf("unexpected count. expected: 2. got: %d", count)
+		}
+
+		*now = now.Add(time.Second * 31)
+		if count := s.Count("a"); count != 1 {
+			t.Errorf("oldest event should have left the window. expected: 1. got: %d", count)
+		}
+
+		if retryAfter := s.RetryAfter("a"); retryAfter != time.Second*29 {
+			t.Errorf("unexpected retry after. expected: 29s. got: %s", retryAfter)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		s, _ := newTestWindow(time.Minute)
+		s.Add("a")
+		s.Reset("a")
+
+		if count := s.Count("a"); count != 0 {
+			t.Errorf("unexpected count after reset. expected: 0. got: %d", count)
+		}
+
+		if retryAfter := s.RetryAfter("a"); retryAfter != 0 {
+			t.Errorf("unexpected retry after. expected: 0. got: %s", retryAfter)
+		}
+	})
+
+	t.Run("idle keys are swept", func(t *testing.T) {
+		s, now := newTestWindow(time.Minute)
+		s.Add("idle")
+		*now = now.Add(time.Minute * 2)
+		s.Add("active")
+
+		if _, ok := s.events["idle"]; ok {
+			t.Errorf("idle key was not swept")
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute * 2},
+		{3, time.Minute * 8},
+		{10, time.Hour},
+	}
+
+	for _, tc := range testCases {
+		if got := Backoff(time.Minute, time.Hour, tc.attempt); got != tc.want {
+			t.Errorf("attempt %d: unexpected backoff. expected: %s. got: %s", tc.attempt, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Encoding is used for secrets shown to users and in otpauth URIs
+var Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Config holds the parameters shared with the authenticator app.
+// Only SHA-1 is used as most apps ignore the algorithm parameter.
+type Config struct {
+	Digits int
+	Period time.Duration
+	// Skew is the number of periods accepted before and after the current one
+	Skew int
+}
+
+var DefaultConfig = Config{
+	Digits: 6,
+	Period: time.Second * 30,
+	Skew:   1,
+}
+
+// GenerateSecret returns a random 160 bits secret, the size recommended by RFC 4226.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// Counter returns the time step t falls in.
+func (c Config) Counter(t time.Time) int64 {
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+// Code returns the code for the given time step, see RFC 4226 section 5.3.
+func (c Config) Code(secret []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < c.Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", c.Digits, value%modulo)
+}
+
+// Validate checks code against the time steps around t. It returns the matching time step,
+// callers should reject steps that are not after the last accepted one to prevent replays.
+func (c Config) Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != c.Digits {
+		return 0, false
+	}
+
+	current := c.Counter(t)
+	for offset := -c.Skew; offset <= c.Skew; offset++ {
+		counter := current + int64(offset)
+		if subtle.ConstantTimeCompare([]byte(c.Code(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth URI understood by authenticator apps, usually shown as a QR code.
+func (c Config) URI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", Encoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(c.Digits))
+	query.Set("period", fmt.Sprint(int(c.Period/time.Second)))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
@@ -0,0 +1,84 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// test vectors from RFC 6238 appendix B, SHA-1 only
+func TestConfig_Code(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	config := Config{Digits: 8, Period: time.Second * 30}
+
+	testCases := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code, func(t *testing.T) {
+			code := config.Code(secret, config.Counter(time.Unix(tc.time, 0)))
+			if code != tc.code {
+				t.Errorf("unexpected code. expected: %s. got: %s", tc.code, code)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	counter := DefaultConfig.Counter(now)
+
+	testCases := []struct {
+		name    string
+		code    string
+		ok      bool
+		counter int64
+	}{
+		{"current", DefaultConfig.Code(secret, counter), true, counter},
+		{"with spaces", DefaultConfig.Code(secret, counter)[:3] + " " + DefaultConfig.Code(secret, counter)[3:], true, counter},
+		{"previous period", DefaultConfig.Code(secret, counter-1), true, counter - 1},
+		{"next period", DefaultConfig.Code(secret, counter+1), true, counter + 1},
+		{"outside skew", DefaultConfig.Code(secret, counter-2), false, 0},
+		{"wrong length", "12345", false, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, ok := DefaultConfig.Validate(secret, tc.code, now)
+			if ok != tc.ok || matched != tc.counter {
+				t.Errorf("unexpected result. expected: %d, %t. got: %d, %t", tc.counter, tc.ok, matched, ok)
+			}
+		})
+	}
+}
+
+func TestConfig_URI(t *testing.T) {
+	uri := DefaultConfig.URI("Auth Test", "alice@example.com", []byte("12345678901234567890"))
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Auth Test:alice@example.com" {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+
+	if secret := parsed.Query().Get("secret"); secret != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("unexpected secret: %s", secret)
+	}
+
+	if issuer := parsed.Query().Get("issuer"); issuer != "Auth Test" {
+		t.Errorf("unexpected issuer: %s", issuer)
+	}
+}
@@ -0,0 +1,56 @@
+package validate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedPasswordDirectory looks passwords up in an offline copy of the Have I Been Pwned
+// range files: <dir>/<first 5 hex chars of the SHA-1>.txt containing SUFFIX:COUNT lines.
+// A missing range file is treated as no match.
+type BreachedPasswordDirectory struct {
+	Dir string
+	// MinCount ignores passwords seen fewer times than this
+	MinCount int
+}
+
+func (d BreachedPasswordDirectory) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+
+		if d.MinCount > 0 {
+			seen, err := strconv.Atoi(count)
+			if err != nil || seen < d.MinCount {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, scanner.Err()
+}
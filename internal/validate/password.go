@@ -1,25 +1,169 @@
 package validate
 
 import (
+	"bufio"
+	_ "embed"
 	"errors"
+	"fmt"
+	"io"
 	"strings"
+	"unicode/utf8"
 )
 
 var (
-	ErrPasswordEmpty    = errors.New("password is empty")
-	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordEmpty         = errors.New("password is empty")
+	ErrPasswordTooShort      = errors.New("password is too short")
+	ErrPasswordTooLong       = errors.New("password is too long")
+	ErrPasswordCommon        = errors.New("password is too common")
+	ErrPasswordContainsEmail = errors.New("password contains the email address")
+	ErrPasswordBreached      = errors.New("password appeared in a data breach")
 )
 
-func Password(value string) error {
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// Violation is a single rule the password failed. Err is one of the ErrPasswordX values.
+type Violation struct {
+	Err     error
+	Message string
+}
+
+func (v Violation) Error() string {
+	return v.Message
+}
+
+func (v Violation) Unwrap() error {
+	return v.Err
+}
+
+// PolicyError lists every rule a password failed, errors.Is matches any of them.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+func (e *PolicyError) Unwrap() []error {
+	errs := make([]error, len(e.Violations))
+	for i, violation := range e.Violations {
+		errs[i] = violation
+	}
+
+	return errs
+}
+
+// BreachChecker reports whether a password is known to have leaked.
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+type PasswordPolicy struct {
+	// MinLength and MaxLength are counted in characters. Zero disables the check.
+	MinLength int
+	MaxLength int
+	// DenyList holds lowercased passwords that are rejected regardless of length
+	DenyList map[string]struct{}
+	// RejectEmail rejects passwords containing the local part of the account email
+	RejectEmail bool
+	// Breaches is optional
+	Breaches BreachChecker
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:   8,
+	MaxLength:   128,
+	DenyList:    ParseDenyList(strings.NewReader(commonPasswords)),
+	RejectEmail: true,
+}
+
+// ParseDenyList reads one password per line, blank lines and lines starting with # are ignored.
+func ParseDenyList(reader io.Reader) map[string]struct{} {
+	list := map[string]struct{}{}
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		list[strings.ToLower(line)] = struct{}{}
+	}
+
+	return list
+}
+
+// Check returns a *PolicyError listing every violation, nil if the password is acceptable.
+// Any other error means the breach lookup failed.
+func (p PasswordPolicy) Check(value, email string) error {
 	password := strings.Trim(value, " ")
 
 	if password == "" {
-		return ErrPasswordEmpty
+		return &PolicyError{Violations: []Violation{{ErrPasswordEmpty, "password is empty"}}}
+	}
+
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, Violation{ErrPasswordTooShort, fmt.Sprintf("must be at least %d characters", p.MinLength)})
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{ErrPasswordTooLong, fmt.Sprintf("must be at most %d characters", p.MaxLength)})
+	}
+
+	if p.denied(password) {
+		violations = append(violations, Violation{ErrPasswordCommon, "is one of the most common passwords"})
+	}
+
+	if p.RejectEmail && containsEmail(password, email) {
+		violations = append(violations, Violation{ErrPasswordContainsEmail, "must not contain your email address"})
 	}
 
-	if len(password) < 8 {
-		return ErrPasswordTooShort
+	if p.Breaches != nil {
+		breached, err := p.Breaches.Breached(value)
+		if err != nil {
+			return fmt.Errorf("breached password lookup: %w", err)
+		}
+
+		if breached {
+			violations = append(violations, Violation{ErrPasswordBreached, "appeared in a data breach, choose another one"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
 	}
 
 	return nil
 }
+
+func (p PasswordPolicy) denied(password string) bool {
+	_, ok := p.DenyList[strings.ToLower(password)]
+	return ok
+}
+
+// containsEmail ignores local parts too short to be meaningful
+func containsEmail(password, email string) bool {
+	local, _, _ := strings.Cut(email, "@")
+	local = strings.ToLower(strings.TrimSpace(local))
+
+	if utf8.RuneCountInString(local) < 3 {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(password), local)
+}
+
+// Password checks a password against DefaultPasswordPolicy.
+func Password(value string) error {
+	return DefaultPasswordPolicy.Check(value, "")
+}
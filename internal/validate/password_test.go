@@ -2,6 +2,9 @@ package validate
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -15,8 +18,10 @@ func TestPassword(t *testing.T) {
 		{"false empty", "   ", ErrPasswordEmpty},
 		{"too short", "test", ErrPasswordTooShort},
 		{"too short padded", "  test  ", ErrPasswordTooShort},
-		{"good pass", "test1234", nil},
-		{"good pass padded", "  test1234  ", nil},
+		{"good pass", "test1235", nil},
+		{"good pass padded", "  test1235  ", nil},
+		{"common password", "Password123", ErrPasswordCommon},
+		{"multibyte counted as characters", "ééééé", ErrPasswordTooShort},
 	}
 
 	for _, tc := range testCases {
@@ -29,3 +34,119 @@ func TestPassword(t *testing.T) {
 		})
 	}
 }
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "breached-secret" is FCDB8 98A63...
+	if err := os.WriteFile(filepath.Join(dir, "FCDB8.txt"), []byte("0000000000000000000000000000000000A:3\r\n98A634B869418B61A3FE31F11EEC4860987:42\r\n"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	policy := PasswordPolicy{
+		MinLength:   10,
+		MaxLength:   20,
+		DenyList:    ParseDenyList(strings.NewReader("# comment\n\nletmein\n")),
+		RejectEmail: true,
+		Breaches:    BreachedPasswordDirectory{Dir: dir},
+	}
+
+	testCases := []struct {
+		name     string
+		password string
+		email    string
+		errs     []error
+	}{
+		{"valid", "correct horse", "", nil},
+		{"too short in characters", "ééééééééé", "", []error{ErrPasswordTooShort}},
+		{"long enough in characters", "éééééééééé", "", nil},
+		{"too long", "aaaaaaaaaaaaaaaaaaaaa", "", []error{ErrPasswordTooLong}},
+		{"deny list is case insensitive", "LetMeIn", "", []error{ErrPasswordTooShort, ErrPasswordCommon}},
+		{"contains email local part", "my-alice.smith-pass", "Alice.Smith@example.com", []error{ErrPasswordContainsEmail}},
+		{"short local parts are ignored", "bobcat-is-here", "bo@example.com", nil},
+		{"breached", "breached-secret", "", []error{ErrPasswordBreached}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Check(tc.password, tc.email)
+			if tc.errs == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("expected a policy error. got: %v", err)
+			}
+
+			if len(policyErr.Violations) != len(tc.errs) {
+				t.Fatalf("unexpected violations. expected: %v. got: %v", tc.errs, policyErr.Violations)
+			}
+
+			for _, expected := range tc.errs {
+				if !errors.Is(err, expected) {
+					t.Errorf("missing violation: %s", expected)
+				}
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "FCDB8.txt"), []byte("98A634B869418B61A3FE31F11EEC4860987:2\n"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name     string
+		minCount int
+		password string
+		breached bool
+	}{
+		{"match", 0, "breached-secret", true},
+		{"below min count", 5, "breached-secret", false},
+		{"missing range file", 0, "something else entirely", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			breached, err := BreachedPasswordDirectory{Dir: dir, MinCount: tc.minCount}.Breached(tc.password)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if breached != tc.breached {
+				t.Errorf("unexpected result. expected: %t. got: %t", tc.breached, breached)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy_Strength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		password string
+		email    string
+		strength Strength
+	}{
+		{"empty", "", "", StrengthVeryWeak},
+		{"deny listed", "password123", "", StrengthVeryWeak},
+		{"sequence", "abcdefgh", "", StrengthVeryWeak},
+		{"short lowercase", "kqzmtr", "", StrengthWeak},
+		{"mixed", "Tr0ub4dor", "", StrengthFair},
+		{"contains email", "alice-Tr0ub4dor&3-xyz", "alice@example.com", StrengthVeryWeak},
+		{"long passphrase", "correct horse battery staple", "", StrengthStrong},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strength := DefaultPasswordPolicy.Strength(tc.password, tc.email)
+			if strength != tc.strength {
+				t.Errorf("unexpected strength. expected: %s. got: %s", tc.strength, strength)
+			}
+		})
+	}
+}
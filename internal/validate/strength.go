@@ -0,0 +1,97 @@
+package validate
+
+import (
+	"math"
+	"unicode"
+)
+
+type Strength int
+
+const (
+	StrengthVeryWeak Strength = iota
+	StrengthWeak
+	StrengthFair
+	StrengthGood
+	StrengthStrong
+)
+
+func (s Strength) String() string {
+	switch s {
+	case StrengthWeak:
+		return "weak"
+	case StrengthFair:
+		return "fair"
+	case StrengthGood:
+		return "good"
+	case StrengthStrong:
+		return "strong"
+	default:
+		return "very weak"
+	}
+}
+
+// Strength scores a password from its estimated entropy.
+// Deny-listed passwords and passwords containing the email local part always score StrengthVeryWeak.
+func (p PasswordPolicy) Strength(password, email string) Strength {
+	if password == "" || p.denied(password) || containsEmail(password, email) {
+		return StrengthVeryWeak
+	}
+
+	bits := entropy(password)
+	switch {
+	case bits < 28:
+		return StrengthVeryWeak
+	case bits < 36:
+		return StrengthWeak
+	case bits < 60:
+		return StrengthFair
+	case bits < 80:
+		return StrengthGood
+	default:
+		return StrengthStrong
+	}
+}
+
+// entropy estimates the bits of a password from the character classes it uses.
+// Repeated characters and runs like "abc" or "321" only count once.
+func entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	var previous rune
+	effective := 0
+
+	for i, r := range password {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i == 0 || !(r == previous || r == previous+1 || r == previous-1) {
+			effective++
+		}
+		previous = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(effective) * math.Log2(float64(pool))
+}
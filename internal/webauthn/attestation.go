@@ -0,0 +1,126 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+var (
+	ErrInvalidAttestation     = errors.New("invalid attestation")
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+)
+
+// oidFIDOGenCEAAGUID is the certificate extension holding the authenticator AAGUID
+var oidFIDOGenCEAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	format    string
+	statement map[any]any
+	authData  *authenticatorData
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidAttestation
+	}
+
+	fields, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, ErrInvalidAttestation
+	}
+
+	format, _ := fields["fmt"].(string)
+	statement, _ := fields["attStmt"].(map[any]any)
+	rawAuthData, _ := fields["authData"].([]byte)
+	if format == "" || statement == nil || rawAuthData == nil {
+		return nil, ErrInvalidAttestation
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attestationObject{format: format, statement: statement, authData: authData}, nil
+}
+
+// verify checks the attestation statement. Attestation certificates are not chained to
+// trusted roots, the statement only proves the authenticator holds the credential key.
+func (a *attestationObject) verify(clientDataHash []byte, credentialKey *PublicKey) error {
+	switch a.format {
+	case "none":
+		if len(a.statement) != 0 {
+			return ErrInvalidAttestation
+		}
+		return nil
+	case "packed":
+		return a.verifyPacked(clientDataHash, credentialKey)
+	default:
+		return ErrUnsupportedAttestation
+	}
+}
+
+// verifyPacked implements https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation
+func (a *attestationObject) verifyPacked(clientDataHash []byte, credentialKey *PublicKey) error {
+	algorithm, _ := a.statement["alg"].(int64)
+	signature, _ := a.statement["sig"].([]byte)
+	if signature == nil {
+		return ErrInvalidAttestation
+	}
+
+	signed := append(append([]byte{}, a.authData.raw...), clientDataHash...)
+
+	chain, hasChain := a.statement["x5c"].([]any)
+	if !hasChain {
+		// self attestation, signed by the credential key itself
+		if algorithm != credentialKey.Algorithm {
+			return ErrInvalidAttestation
+		}
+		return credentialKey.Verify(signed, signature)
+	}
+
+	if len(chain) == 0 {
+		return ErrInvalidAttestation
+	}
+
+	rawCert, _ := chain[0].([]byte)
+	cert, err := x509.ParseCertificate(rawCert)
+	if err != nil {
+		return ErrInvalidAttestation
+	}
+
+	if err = verifySignature(algorithm, cert.PublicKey, signed, signature); err != nil {
+		return err
+	}
+
+	return verifyPackedCertificate(cert, a.authData.aaguid)
+}
+
+// verifyPackedCertificate checks the requirements on packed attestation certificates
+func verifyPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 || cert.IsCA {
+		return ErrInvalidAttestation
+	}
+
+	subject := cert.Subject
+	if len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" ||
+		len(subject.OrganizationalUnit) != 1 || subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return ErrInvalidAttestation
+	}
+
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidFIDOGenCEAAGUID) {
+			continue
+		}
+
+		var value []byte
+		if _, err := asn1.Unmarshal(extension.Value, &value); err != nil || extension.Critical || !bytes.Equal(value, aaguid) {
+			return ErrInvalidAttestation
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// authenticator data flags
+const (
+	FlagUserPresent    byte = 0x01
+	FlagUserVerified   byte = 0x04
+	FlagBackupEligible byte = 0x08
+	FlagBackedUp       byte = 0x10
+	FlagAttestedData   byte = 0x40
+	FlagExtensionData  byte = 0x80
+)
+
+var ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+
+type authenticatorData struct {
+	raw       []byte
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// attested credential data, only present during registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (a authenticatorData) has(flag byte) bool {
+	return a.flags&flag == flag
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	parsed := &authenticatorData{
+		raw:       data,
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if parsed.has(FlagAttestedData) {
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthenticatorData
+		}
+
+		parsed.aaguid = rest[:16]
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > 1023 || len(rest) < length {
+			return nil, ErrInvalidAuthenticatorData
+		}
+
+		parsed.credentialID = rest[:length]
+		rest = rest[length:]
+
+		// the public key is followed by the extensions, if any
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthenticatorData
+		}
+
+		parsed.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if parsed.has(FlagExtensionData) {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthenticatorData
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	return parsed, nil
+}
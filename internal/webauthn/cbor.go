@@ -0,0 +1,132 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("invalid CBOR")
+
+// maxCBORDepth bounds nesting, authenticator data never goes deeper than a few levels
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR item of data and returns the bytes that follow it.
+// Only the subset used by WebAuthn is supported: integers, byte and text strings, arrays, maps and simple values.
+// Integers decode as int64, byte strings as []byte, maps as map[any]any keyed by int64 or string.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 || depth > maxCBORDepth {
+		return nil, nil, ErrInvalidCBOR
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// simple values, floats are not used by WebAuthn
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, ErrInvalidCBOR
+		}
+	}
+
+	argument, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, ErrInvalidCBOR
+		}
+		return int64(argument), data, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, ErrInvalidCBOR
+		}
+		return -1 - int64(argument), data, nil
+	case 2, 3:
+		if argument > uint64(len(data)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		value := data[:argument]
+		if major == 3 {
+			return string(value), data[argument:], nil
+		}
+		return append([]byte{}, value...), data[argument:], nil
+	case 4:
+		// every item takes at least one byte
+		if argument > uint64(len(data)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		items := make([]any, argument)
+		for i := range items {
+			if items[i], data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 5:
+		if argument > uint64(len(data))/2 {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		items := make(map[any]any, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value any
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrInvalidCBOR
+			}
+
+			if _, ok := items[key]; ok {
+				return nil, nil, ErrInvalidCBOR
+			}
+
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		// tags and indefinite lengths are not allowed in WebAuthn structures
+		return nil, nil, ErrInvalidCBOR
+	}
+}
+
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, ErrInvalidCBOR
+	}
+}
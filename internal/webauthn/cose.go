@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers, see the IANA COSE Algorithms registry
+const (
+	AlgES256 int64 = -7
+	AlgRS256 int64 = -257
+)
+
+const (
+	coseKeyType       = 1
+	coseAlgorithm     = 3
+	coseKeyTypeEC2    = 2
+	coseKeyTypeRSA    = 3
+	coseCurveP256     = 1
+	coseEC2Curve      = -1
+	coseEC2X          = -2
+	coseEC2Y          = -3
+	coseRSAModulus    = -1
+	coseRSAExponent   = -2
+	minRSAKeyBits     = 2048
+	maxRSAExponentLen = 4
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported public key algorithm")
+	ErrInvalidPublicKey     = errors.New("invalid public key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// PublicKey is a credential public key decoded from its COSE_Key representation.
+type PublicKey struct {
+	Algorithm int64
+	key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key, as stored with a credential.
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return publicKeyFromCOSE(decoded)
+}
+
+func publicKeyFromCOSE(decoded any) (*PublicKey, error) {
+	fields, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+
+	keyType, _ := fields[int64(coseKeyType)].(int64)
+	algorithm, _ := fields[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case algorithm == AlgES256 && keyType == coseKeyTypeEC2:
+		curve, _ := fields[int64(coseEC2Curve)].(int64)
+		x, _ := fields[int64(coseEC2X)].([]byte)
+		y, _ := fields[int64(coseEC2Y)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidPublicKey
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrInvalidPublicKey
+		}
+
+		return &PublicKey{Algorithm: algorithm, key: key}, nil
+	case algorithm == AlgRS256 && keyType == coseKeyTypeRSA:
+		modulus, _ := fields[int64(coseRSAModulus)].([]byte)
+		exponent, _ := fields[int64(coseRSAExponent)].([]byte)
+		if len(exponent) == 0 || len(exponent) > maxRSAExponentLen {
+			return nil, ErrInvalidPublicKey
+		}
+
+		e := 0
+		for _, b := range exponent {
+			e = e<<8 | int(b)
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: e}
+		if key.N.BitLen() < minRSAKeyBits || e < 3 || e%2 == 0 {
+			return nil, ErrInvalidPublicKey
+		}
+
+		return &PublicKey{Algorithm: algorithm, key: key}, nil
+	case algorithm == AlgES256 || algorithm == AlgRS256:
+		return nil, ErrInvalidPublicKey
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// Verify checks signature over data with the algorithm of the key.
+func (k *PublicKey) Verify(data, signature []byte) error {
+	return verifySignature(k.Algorithm, k.key, data, signature)
+}
+
+func verifySignature(algorithm int64, key crypto.PublicKey, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch algorithm {
+	case AlgES256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || !ecdsa.VerifyASN1(ecKey, digest[:], signature) {
+			return ErrInvalidSignature
+		}
+	case AlgRS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return nil
+}
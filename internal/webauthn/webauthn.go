@@ -0,0 +1,351 @@
+// Package webauthn implements the relying party side of WebAuthn registration and authentication ceremonies.
+// Supported attestation formats are "none" and "packed", supported algorithms ES256 and RS256.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidClientData      = errors.New("invalid client data")
+	ErrChallengeMismatch      = errors.New("challenge mismatch")
+	ErrOriginMismatch         = errors.New("origin mismatch")
+	ErrRelyingPartyMismatch   = errors.New("relying party mismatch")
+	ErrUserNotPresent         = errors.New("user presence required")
+	ErrUserNotVerified        = errors.New("user verification required")
+	ErrSignCountRegression    = errors.New("signature counter did not increase, the authenticator may be cloned")
+	ErrMissingCredentialData  = errors.New("missing attested credential data")
+	ErrCredentialIDMismatch   = errors.New("credential ID mismatch")
+	ErrInvalidChallengeLength = errors.New("challenge must be at least 16 bytes")
+)
+
+// URLEncodedBytes marshals to and from base64url without padding, as used by the browser APIs.
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. example.com
+	RPID   string
+	RPName string
+	// Origins lists the accepted origins, e.g. https://example.com
+	Origins []string
+	Timeout time.Duration
+}
+
+type RelyingParty struct {
+	config Config
+}
+
+func New(config Config) *RelyingParty {
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute * 5
+	}
+
+	return &RelyingParty{config: config}
+}
+
+// NewChallenge returns 32 random bytes, challenges must be single use.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+type User struct {
+	// ID is the user handle, it must not contain personal information
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+type CredentialDescriptor struct {
+	Type       string          `json:"type"`
+	ID         URLEncodedBytes `json:"id"`
+	Transports []string        `json:"transports,omitempty"`
+}
+
+type credentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+type relyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is passed to navigator.credentials.create() once its binary fields are decoded.
+type CreationOptions struct {
+	Challenge              URLEncodedBytes        `json:"challenge"`
+	RelyingParty           relyingPartyEntity     `json:"rp"`
+	User                   userEntity             `json:"user"`
+	Parameters             []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is passed to navigator.credentials.get() once its binary fields are decoded.
+type RequestOptions struct {
+	Challenge        URLEncodedBytes        `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential with user verification, so that it can be used as a passkey.
+// exclude lists the credentials the user already has, to avoid registering the same authenticator twice.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude []CredentialDescriptor) CreationOptions {
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return CreationOptions{
+		Challenge:    challenge,
+		RelyingParty: relyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User:         userEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName},
+		Parameters: []credentialParameter{
+			{Type: "public-key", Algorithm: AlgES256},
+			{Type: "public-key", Algorithm: AlgRS256},
+		},
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions leaves allowCredentials empty so that the browser offers the passkeys it knows for the relying party.
+func (rp *RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.config.RPID,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+// RegistrationResponse is the JSON serialized PublicKeyCredential returned by navigator.credentials.create()
+type RegistrationResponse struct {
+	ID       string          `json:"id"`
+	RawID    URLEncodedBytes `json:"rawId"`
+	Type     string          `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+		AttestationObject URLEncodedBytes `json:"attestationObject"`
+		Transports        []string        `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON serialized PublicKeyCredential returned by navigator.credentials.get()
+type AssertionResponse struct {
+	ID       string          `json:"id"`
+	RawID    URLEncodedBytes `json:"rawId"`
+	Type     string          `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+		AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+		Signature         URLEncodedBytes `json:"signature"`
+		UserHandle        URLEncodedBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is the outcome of a successful registration.
+type Credential struct {
+	ID []byte
+	// PublicKey is COSE encoded, see ParsePublicKey
+	PublicKey         []byte
+	Algorithm         int64
+	SignCount         uint32
+	AAGUID            []byte
+	Transports        []string
+	AttestationFormat string
+	BackupEligible    bool
+	BackedUp          bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil || data.Type != ceremony {
+		return ErrInvalidClientData
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if !slices.Contains(rp.config.Origins, data.Origin) {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.config.RPID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return ErrRelyingPartyMismatch
+	}
+
+	if !authData.has(FlagUserPresent) {
+		return ErrUserNotPresent
+	}
+
+	if !authData.has(FlagUserVerified) {
+		return ErrUserNotVerified
+	}
+
+	return nil
+}
+
+// VerifyRegistration runs the registration ceremony checks against the challenge issued with CreationOptions.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response RegistrationResponse) (*Credential, error) {
+	if len(challenge) < 16 {
+		return nil, ErrInvalidChallengeLength
+	}
+
+	if response.Type != "public-key" {
+		return nil, ErrInvalidClientData
+	}
+
+	if err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	attestation, err := parseAttestationObject(response.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := attestation.authData
+	if err = rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	if !authData.has(FlagAttestedData) {
+		return nil, ErrMissingCredentialData
+	}
+
+	if !bytes.Equal(authData.credentialID, response.RawID) {
+		return nil, ErrCredentialIDMismatch
+	}
+
+	publicKey, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	if err = attestation.verify(clientDataHash[:], publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:                append([]byte{}, authData.credentialID...),
+		PublicKey:         append([]byte{}, authData.publicKey...),
+		Algorithm:         publicKey.Algorithm,
+		SignCount:         authData.signCount,
+		AAGUID:            append([]byte{}, authData.aaguid...),
+		Transports:        response.Response.Transports,
+		AttestationFormat: attestation.format,
+		BackupEligible:    authData.has(FlagBackupEligible),
+		BackedUp:          authData.has(FlagBackedUp),
+	}, nil
+}
+
+// VerifyAssertion runs the authentication ceremony checks for a previously registered credential.
+// It returns the new signature counter, which should be stored with the credential.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, signCount uint32, response AssertionResponse) (uint32, error) {
+	if len(challenge) < 16 {
+		return 0, ErrInvalidChallengeLength
+	}
+
+	if response.Type != "public-key" {
+		return 0, ErrInvalidClientData
+	}
+
+	if err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := append(append([]byte{}, authData.raw...), clientDataHash[:]...)
+	if err = key.Verify(signed, response.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators that do not implement a counter always report zero
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, ErrSignCountRegression
+	}
+
+	return authData.signCount, nil
+}
@@ -0,0 +1,223 @@
+package webauthn_test
+
+import (
+	"errors"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"github.com/mathieuhays/auth/internal/webauthn/webauthntest"
+	"testing"
+)
+
+const testOrigin = "https://auth.example.com"
+
+func newTestRelyingParty() *webauthn.RelyingParty {
+	return webauthn.New(webauthn.Config{
+		RPID:    "auth.example.com",
+		RPName:  "Auth Test",
+		Origins: []string{testOrigin},
+	})
+}
+
+func newChallenge(t testing.TB) []byte {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return challenge
+}
+
+func register(t testing.TB, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge := newChallenge(t)
+	options := rp.CreationOptions(webauthn.User{ID: []byte("user-1"), Name: "test@example.com"}, challenge, nil)
+
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("unexpected authenticator error: %s", err)
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, response)
+	if err != nil {
+		t.Fatalf("unexpected registration error: %s", err)
+	}
+
+	return credential
+}
+
+func TestRelyingParty_Ceremonies(t *testing.T) {
+	testCases := []struct {
+		name        string
+		algorithm   int64
+		attestation webauthntest.Attestation
+		format      string
+	}{
+		{"ES256 none", webauthn.AlgES256, webauthntest.AttestationNone, "none"},
+		{"ES256 packed self", webauthn.AlgES256, webauthntest.AttestationSelf, "packed"},
+		{"ES256 packed basic", webauthn.AlgES256, webauthntest.AttestationBasic, "packed"},
+		{"RS256 none", webauthn.AlgRS256, webauthntest.AttestationNone, "none"},
+		{"RS256 packed self", webauthn.AlgRS256, webauthntest.AttestationSelf, "packed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := webauthntest.New(testOrigin)
+			authenticator.Algorithm = tc.algorithm
+			authenticator.Attestation = tc.attestation
+
+			credential := register(t, rp, authenticator)
+			if credential.AttestationFormat != tc.format || credential.Algorithm != tc.algorithm {
+				t.Errorf("unexpected credential. format: %s. algorithm: %d", credential.AttestationFormat, credential.Algorithm)
+			}
+
+			signCount := credential.SignCount
+			for i := 0; i < 2; i++ {
+				challenge := newChallenge(t)
+				response, err := authenticator.Get(rp.RequestOptions(challenge))
+				if err != nil {
+					t.Fatalf("unexpected authenticator error: %s", err)
+				}
+
+				if string(response.Response.UserHandle) != "user-1" {
+					t.Errorf("unexpected user handle: %s", response.Response.UserHandle)
+				}
+
+				if signCount, err = rp.VerifyAssertion(challenge, credential.PublicKey, signCount, response); err != nil {
+					t.Fatalf("unexpected assertion error: %s", err)
+				}
+			}
+
+			if signCount != 2 {
+				t.Errorf("unexpected sign count. expected: 2. got: %d", signCount)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_VerifyRegistration(t *testing.T) {
+	rp := newTestRelyingParty()
+	user := webauthn.User{ID: []byte("user-1"), Name: "test@example.com"}
+
+	testCases := []struct {
+		name     string
+		origin   string
+		rpID     string
+		skipUV   bool
+		tamper   func(response *webauthn.RegistrationResponse, challenge []byte) []byte
+		expected error
+	}{
+		{"wrong origin", "https://evil.example.com", "auth.example.com", false, nil, webauthn.ErrOriginMismatch},
+		{"wrong relying party", testOrigin, "example.com", false, nil, webauthn.ErrRelyingPartyMismatch},
+		{"user not verified", testOrigin, "auth.example.com", true, nil, webauthn.ErrUserNotVerified},
+		{"other challenge", testOrigin, "auth.example.com", false, func(_ *webauthn.RegistrationResponse, _ []byte) []byte {
+			return newChallenge(t)
+		}, webauthn.ErrChallengeMismatch},
+		{"short challenge", testOrigin, "auth.example.com", false, func(_ *webauthn.RegistrationResponse, challenge []byte) []byte {
+			return challenge[:8]
+		}, webauthn.ErrInvalidChallengeLength},
+		{"credential ID mismatch", testOrigin, "auth.example.com", false, func(response *webauthn.RegistrationResponse, challenge []byte) []byte {
+			response.RawID = []byte("another credential")
+			return challenge
+		}, webauthn.ErrCredentialIDMismatch},
+		{"truncated attestation", testOrigin, "auth.example.com", false, func(response *webauthn.RegistrationResponse, challenge []byte) []byte {
+			response.Response.AttestationObject = response.Response.AttestationObject[:20]
+			return challenge
+		}, webauthn.ErrInvalidAttestation},
+		{"assertion client data", testOrigin, "auth.example.com", false, func(response *webauthn.RegistrationResponse, challenge []byte) []byte {
+			response.Response.ClientDataJSON = []byte(`{"type":"webauthn.get"}`)
+			return challenge
+		}, webauthn.ErrInvalidClientData},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := webauthntest.New(tc.origin)
+			authenticator.SkipUserVerification = tc.skipUV
+
+			challenge := newChallenge(t)
+			options := rp.CreationOptions(user, challenge, nil)
+			options.RelyingParty.ID = tc.rpID
+
+			response, err := authenticator.Create(options)
+			if err != nil {
+				t.Fatalf("unexpected authenticator error: %s", err)
+			}
+
+			if tc.tamper != nil {
+				challenge = tc.tamper(&response, challenge)
+			}
+
+			if _, err = rp.VerifyRegistration(challenge, response); !errors.Is(err, tc.expected) {
+				t.Errorf("unexpected error. expected: %s. got: %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_VerifyAssertion(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := webauthntest.New(testOrigin)
+	credential := register(t, rp, authenticator)
+
+	t.Run("invalid signature", func(t *testing.T) {
+		challenge := newChallenge(t)
+		response, _ := authenticator.Get(rp.RequestOptions(challenge))
+		response.Response.Signature[len(response.Response.Signature)-1] ^= 0xff
+
+		if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, response); !errors.Is(err, webauthn.ErrInvalidSignature) {
+			t.Errorf("unexpected error. expected: %s. got: %v", webauthn.ErrInvalidSignature, err)
+		}
+	})
+
+	t.Run("other credential key", func(t *testing.T) {
+		other := register(t, rp, webauthntest.New(testOrigin))
+		challenge := newChallenge(t)
+		response, _ := authenticator.Get(rp.RequestOptions(challenge))
+
+		if _, err := rp.VerifyAssertion(challenge, other.PublicKey, 0, response); !errors.Is(err, webauthn.ErrInvalidSignature) {
+			t.Errorf("unexpected error. expected: %s. got: %v", webauthn.ErrInvalidSignature, err)
+		}
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		challenge := newChallenge(t)
+		response, _ := authenticator.Get(rp.RequestOptions(challenge))
+
+		if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 1000, response); !errors.Is(err, webauthn.ErrSignCountRegression) {
+			t.Errorf("unexpected error. expected: %s. got: %v", webauthn.ErrSignCountRegression, err)
+		}
+	})
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		challenge := newChallenge(t)
+		response, _ := authenticator.Get(rp.RequestOptions(challenge))
+
+		if _, err := rp.VerifyAssertion(newChallenge(t), credential.PublicKey, 0, response); !errors.Is(err, webauthn.ErrChallengeMismatch) {
+			t.Errorf("unexpected error. expected: %s. got: %v", webauthn.ErrChallengeMismatch, err)
+		}
+	})
+}
+
+func TestParsePublicKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, webauthn.ErrInvalidCBOR},
+		{"not a map", []byte{0x01}, webauthn.ErrInvalidPublicKey},
+		// {1: 1, 3: -8}, an OKP EdDSA key
+		{"unsupported algorithm", []byte{0xa2, 0x01, 0x01, 0x03, 0x27}, webauthn.ErrUnsupportedAlgorithm},
+		// {1: 2, 3: -7} without coordinates
+		{"missing coordinates", []byte{0xa2, 0x01, 0x02, 0x03, 0x26}, webauthn.ErrInvalidPublicKey},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := webauthn.ParsePublicKey(tc.data); !errors.Is(err, tc.expected) {
+				t.Errorf("unexpected error. expected: %s. got: %v", tc.expected, err)
+			}
+		})
+	}
+}
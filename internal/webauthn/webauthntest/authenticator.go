@@ -0,0 +1,317 @@
+// Package webauthntest provides a software authenticator to exercise WebAuthn ceremonies in tests.
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/mathieuhays/auth/internal/webauthn"
+	"math/big"
+	"sync"
+	"time"
+)
+
+type Attestation int
+
+const (
+	// AttestationNone produces the "none" format
+	AttestationNone Attestation = iota
+	// AttestationSelf produces the "packed" format signed by the credential key
+	AttestationSelf
+	// AttestationBasic produces the "packed" format signed by an attestation certificate
+	AttestationBasic
+)
+
+var ErrNoCredential = errors.New("no credential available for this relying party")
+
+// AAGUID identifies the software authenticator model
+var AAGUID = []byte("webauthntest-sw!")
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	algorithm  int64
+	key        crypto.Signer
+	signCount  uint32
+}
+
+// Authenticator behaves like a platform authenticator holding discoverable credentials.
+// The zero value is not usable, see New.
+type Authenticator struct {
+	Origin      string
+	Algorithm   int64
+	Attestation Attestation
+	// SkipUserVerification clears the UV flag, as an authenticator without PIN or biometrics would
+	SkipUserVerification bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+// New returns an ES256 authenticator with "none" attestation used from origin.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, Algorithm: webauthn.AlgES256}
+}
+
+// Create mirrors navigator.credentials.create()
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	var response webauthn.RegistrationResponse
+
+	cred := &credential{
+		id:         make([]byte, 32),
+		rpID:       options.RelyingParty.ID,
+		userHandle: append([]byte{}, options.User.ID...),
+		algorithm:  a.Algorithm,
+	}
+	if _, err := rand.Read(cred.id); err != nil {
+		return response, err
+	}
+
+	var err error
+	switch a.Algorithm {
+	case webauthn.AlgES256:
+		cred.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgRS256:
+		cred.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		err = webauthn.ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return response, err
+	}
+
+	publicKey, err := encodePublicKey(a.Algorithm, cred.key.Public())
+	if err != nil {
+		return response, err
+	}
+
+	clientDataJSON := a.clientData("webauthn.create", options.Challenge)
+
+	attested := make([]byte, 0, 18+len(cred.id)+len(publicKey))
+	attested = append(attested, AAGUID...)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(cred.id)))
+	attested = append(attested, cred.id...)
+	attested = append(attested, publicKey...)
+
+	authData := a.authenticatorData(cred.rpID, webauthn.FlagAttestedData, 0, attested)
+
+	format, statement, err := a.attest(cred, authData, clientDataJSON)
+	if err != nil {
+		return response, err
+	}
+
+	attestationObject := encodeCBOR(orderedMap{
+		{"fmt", format},
+		{"attStmt", statement},
+		{"authData", authData},
+	})
+
+	a.mu.Lock()
+	a.credentials = append(a.credentials, cred)
+	a.mu.Unlock()
+
+	response.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	response.RawID = cred.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AttestationObject = attestationObject
+	response.Response.Transports = []string{"internal"}
+
+	return response, nil
+}
+
+// Get mirrors navigator.credentials.get(). Without allowed credentials, the most recent
+// credential registered for the relying party is used.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var response webauthn.AssertionResponse
+
+	cred := a.find(options)
+	if cred == nil {
+		return response, ErrNoCredential
+	}
+
+	a.mu.Lock()
+	cred.signCount++
+	signCount := cred.signCount
+	a.mu.Unlock()
+
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authenticatorData(cred.rpID, 0, signCount, nil)
+
+	signature, err := sign(cred.algorithm, cred.key, authData, clientDataJSON)
+	if err != nil {
+		return response, err
+	}
+
+	response.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	response.RawID = cred.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = cred.userHandle
+
+	return response, nil
+}
+
+func (a *Authenticator) find(options webauthn.RequestOptions) *credential {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		cred := a.credentials[i]
+		if cred.rpID != options.RPID {
+			continue
+		}
+
+		if len(options.AllowCredentials) == 0 {
+			return cred
+		}
+
+		for _, allowed := range options.AllowCredentials {
+			if string(allowed.ID) == string(cred.id) {
+				return cred
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+
+	return data
+}
+
+func (a *Authenticator) authenticatorData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if !a.SkipUserVerification {
+		flags |= webauthn.FlagUserVerified
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+
+	return append(data, attested...)
+}
+
+func (a *Authenticator) attest(cred *credential, authData, clientDataJSON []byte) (string, orderedMap, error) {
+	switch a.Attestation {
+	case AttestationSelf:
+		signature, err := sign(cred.algorithm, cred.key, authData, clientDataJSON)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "packed", orderedMap{{"alg", cred.algorithm}, {"sig", signature}}, nil
+	case AttestationBasic:
+		key, certificate, err := attestationCertificate()
+		if err != nil {
+			return "", nil, err
+		}
+
+		signature, err := sign(webauthn.AlgES256, key, authData, clientDataJSON)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "packed", orderedMap{
+			{"alg", webauthn.AlgES256},
+			{"sig", signature},
+			{"x5c", []any{certificate}},
+		}, nil
+	default:
+		return "none", orderedMap{}, nil
+	}
+}
+
+func sign(algorithm int64, key crypto.Signer, authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	switch algorithm {
+	case webauthn.AlgES256:
+		return ecdsa.SignASN1(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+	case webauthn.AlgRS256:
+		return rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	default:
+		return nil, webauthn.ErrUnsupportedAlgorithm
+	}
+}
+
+func encodePublicKey(algorithm int64, key crypto.PublicKey) ([]byte, error) {
+	switch algorithm {
+	case webauthn.AlgES256:
+		ecKey := key.(*ecdsa.PublicKey)
+		return encodeCBOR(orderedMap{
+			{int64(1), int64(2)},
+			{int64(3), algorithm},
+			{int64(-1), int64(1)},
+			{int64(-2), ecKey.X.FillBytes(make([]byte, 32))},
+			{int64(-3), ecKey.Y.FillBytes(make([]byte, 32))},
+		}), nil
+	case webauthn.AlgRS256:
+		rsaKey := key.(*rsa.PublicKey)
+		return encodeCBOR(orderedMap{
+			{int64(1), int64(3)},
+			{int64(3), algorithm},
+			{int64(-1), rsaKey.N.Bytes()},
+			{int64(-2), big.NewInt(int64(rsaKey.E)).Bytes()},
+		}), nil
+	default:
+		return nil, webauthn.ErrUnsupportedAlgorithm
+	}
+}
+
+// attestationCertificate returns a self-signed certificate meeting the packed attestation requirements
+func attestationCertificate() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aaguid, err := asn1.Marshal(AAGUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"webauthntest"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "webauthntest software authenticator",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: []int{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguid},
+		},
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, certificate, nil
+}
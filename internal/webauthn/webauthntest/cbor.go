@@ -0,0 +1,57 @@
+package webauthntest
+
+import "encoding/binary"
+
+type pair struct {
+	key   any
+	value any
+}
+
+// orderedMap keeps the encoding deterministic, keys are written in the given order
+type orderedMap []pair
+
+// encodeCBOR supports the subset of types used by attestation objects and COSE keys
+func encodeCBOR(value any) []byte {
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return cborHeader(1, uint64(-1-v))
+		}
+		return cborHeader(0, uint64(v))
+	case []byte:
+		return append(cborHeader(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHeader(3, uint64(len(v))), v...)
+	case []any:
+		out := cborHeader(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case orderedMap:
+		out := cborHeader(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encodeCBOR(p.key)...)
+			out = append(out, encodeCBOR(p.value)...)
+		}
+		return out
+	default:
+		panic("webauthntest: unsupported CBOR type")
+	}
+}
+
+func cborHeader(major byte, argument uint64) []byte {
+	major <<= 5
+	switch {
+	case argument < 24:
+		return []byte{major | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major | 27}, argument)
+	}
+}
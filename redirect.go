@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewHTTPSRedirect sends every request to the same URL over HTTPS on httpsPort.
+// 308 keeps the method and body, so form submissions to a plain HTTP URL are not turned into GET requests.
+func NewHTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		host = strings.Trim(host, "[]")
+
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			// IPv6 addresses keep their brackets once the port is dropped
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"github.com/mathieuhays/auth/internal/asserts"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewHTTPSRedirect(t *testing.T) {
+	testCases := []struct {
+		name      string
+		httpsPort string
+		target    string
+		location  string
+	}{
+		{"custom port", "8443", "http://example.com:8080/login?next=%2Fdashboard", "https://example.com:8443/login?next=%2Fdashboard"},
+		{"default port", "443", "http://example.com/", "https://example.com/"},
+		{"ipv6", "443", "http://[::1]:8080/", "https://[::1]/"},
+		{"ipv6 without port", "8443", "http://[::1]/", "https://[::1]:8443/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			NewHTTPSRedirect(tc.httpsPort).ServeHTTP(response, httptest.NewRequest(http.MethodPost, tc.target, nil))
+
+			asserts.StatusCode(t, response, http.StatusPermanentRedirect)
+			if location := response.Header().Get("Location"); location != tc.location {
+				t.Errorf("unexpected redirect. expected: %q. got: %q", tc.location, location)
+			}
+		})
+	}
+}
@@ -1,51 +1,209 @@
 package auth
 
 import (
+	"github.com/mathieuhays/auth/internal/audit"
 	"github.com/mathieuhays/auth/internal/handlers"
+	"github.com/mathieuhays/auth/internal/health"
+	"github.com/mathieuhays/auth/internal/logging"
+	"github.com/mathieuhays/auth/internal/mailer"
+	"github.com/mathieuhays/auth/internal/metrics"
+	"github.com/mathieuhays/auth/internal/services/apikey"
+	"github.com/mathieuhays/auth/internal/services/passkey"
 	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/users"
 	"github.com/mathieuhays/auth/internal/templates"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"strings"
 )
 
-func NewServer(tpl *templates.Engine, userService user.ServiceInterface) http.Handler {
+type ServerOption func(options *serverOptions)
+
+type errorTemplates interface {
+	Error(writer io.Writer, title, description string) error
+}
+
+type serverOptions struct {
+	requireConfirmedEmail bool
+	logger                *slog.Logger
+	metrics               *metrics.Registry
+	health                *health.Health
+	cookies               user.CookiePolicy
+	staticDir             string
+	trustedProxies        []netip.Prefix
+}
+
+// WithRequireConfirmedEmail restricts accounts with an unconfirmed email to the "please verify" page
+func WithRequireConfirmedEmail() ServerOption {
+	return func(options *serverOptions) {
+		options.requireConfirmedEmail = true
+	}
+}
+
+// WithLogger sets the logger requests are logged with, handlers get it from the request context
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(options *serverOptions) {
+		options.logger = logger
+	}
+}
+
+// WithMetrics exposes the registry on /metrics in the Prometheus text format
+func WithMetrics(registry *metrics.Registry) ServerOption {
+	return func(options *serverOptions) {
+		options.metrics = registry
+	}
+}
+
+// WithCookiePolicy sets the attributes of the cookies set by the server, it should match the user service's
+func WithCookiePolicy(policy user.CookiePolicy) ServerOption {
+	return func(options *serverOptions) {
+		options.cookies = policy
+	}
+}
+
+// WithStaticDir sets the directory served on /static/, ./static by default
+func WithStaticDir(dir string) ServerOption {
+	return func(options *serverOptions) {
+		options.staticDir = dir
+	}
+}
+
+// WithTrustedProxies lets the proxies in front of the server report the client IP the login throttle and the audit
+// log use, see user.AugmentRequestWithClientIP
+func WithTrustedProxies(prefixes []netip.Prefix) ServerOption {
+	return func(options *serverOptions) {
+		options.trustedProxies = prefixes
+	}
+}
+
+// WithHealth serves the liveness probe on /healthz and the readiness checks on /readyz
+func WithHealth(h *health.Health) ServerOption {
+	return func(options *serverOptions) {
+		options.health = h
+	}
+}
+
+func NewServer(
+	tpl *templates.Engine,
+	userService user.ServiceInterface,
+	passkeyService passkey.ServiceInterface,
+	apiKeyService apikey.ServiceInterface,
+	notifier *mailer.Notifier,
+	auditLog *audit.Logger,
+	options ...ServerOption,
+) http.Handler {
+	opts := serverOptions{logger: slog.Default(), cookies: user.DefaultCookiePolicy, staticDir: "./static"}
+	for _, option := range options {
+		option(&opts)
+	}
+
 	mux := http.NewServeMux()
 	requireAuthMiddleware := newRequireAuthMiddleware(userService)
+	csrfMiddleware := newCSRFMiddleware(tpl, userService, opts.cookies)
+	requireConfirmedMiddleware := requireAuthMiddleware
+	if opts.requireConfirmedEmail {
+		requireConfirmedMiddleware = func(next http.Handler) http.Handler {
+			return requireAuthMiddleware(requireConfirmedEmailMiddleware(next))
+		}
+	}
 
 	mux.Handle("/", handlers.ErrorHandler(tpl))
 	mux.Handle("GET /{$}", handlers.HomeHandler(tpl))
-	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
-
-	mux.Handle("/login", handlers.LoginHandler(tpl, userService))
-	mux.Handle("/register", handlers.RegisterHandler(tpl, userService))
-
-	mux.Handle("/dashboard", requireAuthMiddleware(handlers.DashboardHandler(tpl)))
-
-	// 1. home
-	// 2. dashboard -- use requireLogin middleware
-	// 3. register
-	// 4. login
-	// 5. lost password
-
-	return loggerMiddleware(mux)
-}
-
-/*
-ANSI Color codes, 30-base, 90 range for bright variant
-0: Black
-1: Red
-2: Green
-3: Yellow
-4: Blue
-5: Magenta
-6: Cyan
-7: White
-*/
-func loggerMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("\u001b[32m%s\u001b[0m %s\n", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir(opts.staticDir))))
+
+	mux.Handle("/login", handlers.LoginHandler(tpl, userService, opts.cookies, auditLog))
+	mux.Handle("/login/verify", handlers.LoginVerifyHandler(tpl, userService, opts.cookies, auditLog))
+	mux.Handle("POST /login/passkey/begin", handlers.PasskeyLoginBeginHandler(passkeyService))
+	mux.Handle("POST /login/passkey/finish", handlers.PasskeyLoginFinishHandler(passkeyService, userService, auditLog))
+	mux.Handle("/register", handlers.RegisterHandler(tpl, userService, notifier, auditLog))
+	mux.Handle("POST /logout", handlers.LogoutHandler(userService, auditLog))
+	mux.Handle("/password/forgot", handlers.ForgotPasswordHandler(tpl, userService, notifier))
+	mux.Handle("/password/reset", handlers.ResetPasswordHandler(tpl, userService, auditLog))
+	mux.Handle("POST /password/strength", handlers.PasswordStrengthHandler(userService))
+
+	mux.Handle("GET /confirm-email", handlers.ConfirmEmailHandler(tpl, userService))
+	confirmEmailPendingHandler := handlers.ConfirmEmailPendingHandler(tpl, userService, notifier)
+	mux.Handle("GET /confirm-email/pending", requireAuthMiddleware(confirmEmailPendingHandler))
+	mux.Handle("POST /confirm-email/resend", requireAuthMiddleware(confirmEmailPendingHandler))
+
+	mux.Handle("/dashboard", requireConfirmedMiddleware(handlers.DashboardHandler(tpl)))
+	mux.Handle("/account/two-factor", requireConfirmedMiddleware(handlers.TwoFactorHandler(tpl, userService)))
+	mux.Handle("/account/passkeys", requireConfirmedMiddleware(handlers.PasskeysHandler(tpl, passkeyService)))
+	mux.Handle("POST /account/passkeys/register/begin", requireConfirmedMiddleware(handlers.PasskeyRegistrationBeginHandler(passkeyService)))
+	mux.Handle("POST /account/passkeys/register/finish", requireConfirmedMiddleware(handlers.PasskeyRegistrationFinishHandler(passkeyService)))
+	mux.Handle("/account/api-keys", requireConfirmedMiddleware(handlers.APIKeysHandler(tpl, apiKeyService)))
+	mux.Handle("GET /account/activity", requireConfirmedMiddleware(handlers.SecurityActivityHandler(tpl, auditLog)))
+
+	requirePermission := func(permission users.Permission, next http.Handler) http.Handler {
+		return requireConfirmedMiddleware(newRequirePermissionMiddleware(tpl, permission)(next))
+	}
+	adminAction := func(action handlers.AdminAction) http.Handler {
+		return handlers.AdminUserActionHandler(tpl, userService, notifier, auditLog, action)
+	}
+
+	mux.Handle("GET /admin", requirePermission(users.PermissionUsersRead, handlers.AdminUsersHandler(tpl, userService)))
+	mux.Handle("GET /admin/users/{id}", requirePermission(users.PermissionUsersRead, handlers.AdminUserHandler(tpl, userService)))
+	mux.Handle("POST /admin/users/{id}/disable", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionDisable)))
+	mux.Handle("POST /admin/users/{id}/enable", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionEnable)))
+	mux.Handle("POST /admin/users/{id}/reset-password", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionResetPassword)))
+	mux.Handle("POST /admin/users/{id}/grant-role", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionGrantRole)))
+	mux.Handle("POST /admin/users/{id}/revoke-role", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionRevokeRole)))
+	mux.Handle("POST /admin/users/{id}/unlock", requirePermission(users.PermissionUsersManage, adminAction(handlers.AdminActionUnlock)))
+	mux.Handle("POST /admin/users/{id}/revoke-sessions", requirePermission(users.PermissionSessionsManage, adminAction(handlers.AdminActionRevokeSessions)))
+
+	// the API only accepts bearer tokens, which browsers never attach on their own, so it sits outside CSRF
+	root := http.NewServeMux()
+	root.Handle("/api/v1/", newAPIHandler(userService, apiKeyService, notifier, auditLog, opts))
+	root.Handle("GET /.well-known/jwks.json", handlers.JWKSHandler(userService))
+	if opts.metrics != nil {
+		root.Handle("GET /metrics", opts.metrics.Handler())
+	}
+	if opts.health != nil {
+		root.Handle("GET /healthz", opts.health.LivenessHandler())
+		root.Handle("GET /readyz", opts.health.ReadinessHandler())
+	}
+	root.Handle("/", csrfMiddleware(mux))
+
+	return logging.Middleware(opts.logger)(newClientIPMiddleware(opts.trustedProxies)(root))
+}
+
+func newClientIPMiddleware(trustedProxies []netip.Prefix) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, user.AugmentRequestWithClientIP(r, trustedProxies))
+		})
+	}
+}
+
+func newAPIHandler(
+	userService user.ServiceInterface,
+	apiKeyService apikey.ServiceInterface,
+	notifier *mailer.Notifier,
+	auditLog *audit.Logger,
+	opts serverOptions,
+) http.Handler {
+	mux := http.NewServeMux()
+	requireBearerMiddleware := newRequireBearerMiddleware(userService, apiKeyService)
+	requireConfirmedMiddleware := requireBearerMiddleware
+	if opts.requireConfirmedEmail {
+		requireConfirmedMiddleware = func(next http.Handler) http.Handler {
+			return requireBearerMiddleware(requireConfirmedEmailAPIMiddleware(next))
+		}
+	}
+
+	mux.Handle("/api/v1/", handlers.APINotFoundHandler())
+	mux.Handle("POST /api/v1/register", handlers.APIRegisterHandler(userService, notifier, auditLog))
+	mux.Handle("POST /api/v1/login", handlers.APILoginHandler(userService, auditLog))
+	mux.Handle("POST /api/v1/login/verify", handlers.APILoginVerifyHandler(userService, auditLog))
+	mux.Handle("POST /api/v1/token/refresh", handlers.APIRefreshTokenHandler(userService))
+	mux.Handle("GET /api/v1/me", requireBearerMiddleware(requireScopeMiddleware(apikey.ScopeProfileRead, handlers.APIMeHandler())))
+	mux.Handle("PUT /api/v1/me/password", requireConfirmedMiddleware(requireScopeMiddleware("", handlers.APIChangePasswordHandler(userService, auditLog))))
+	mux.Handle("GET /api/v1/sessions", requireConfirmedMiddleware(requireScopeMiddleware(apikey.ScopeSessionsRead, handlers.APISessionsHandler(userService))))
+	mux.Handle("DELETE /api/v1/sessions/{id}", requireConfirmedMiddleware(requireScopeMiddleware(apikey.ScopeSessionsWrite, handlers.APIRevokeSessionHandler(userService, auditLog))))
+
+	return mux
 }
 
 func newRequireAuthMiddleware(userService user.ServiceInterface) func(next http.Handler) http.Handler {
@@ -60,7 +218,7 @@ func newRequireAuthMiddleware(userService user.ServiceInterface) func(next http.
 			// renew auth
 			err = userService.SetAuthResponse(w, session)
 			if err != nil {
-				log.Printf("error renewing auth: %s", err)
+				logging.FromContext(r.Context()).Error("error renewing auth", "error", err)
 			}
 
 			request := user.AugmentRequestWithAuth(r, u, session)
@@ -69,3 +227,126 @@ func newRequireAuthMiddleware(userService user.ServiceInterface) func(next http.
 		})
 	}
 }
+
+// newRequirePermissionMiddleware renders a 403 page to users lacking the permission.
+// It expects to run after newRequireAuthMiddleware.
+func newRequirePermissionMiddleware(tpl errorTemplates, permission users.Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, _, err := user.RetrieveAuthDetails(r)
+			if err != nil {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+
+			if !u.Can(permission) {
+				logging.FromContext(r.Context()).Warn("permission denied", "user_id", u.ID, "permission", permission)
+				w.WriteHeader(http.StatusForbidden)
+				if err = tpl.Error(w, "Error 403", "You do not have access to this page."); err != nil {
+					logging.FromContext(r.Context()).Error("template error", "error", err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKeyFromRequest returns the API key sent through X-API-Key, or as a bearer token
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if token = strings.TrimSpace(token); strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(token, apikey.KeyPrefix) {
+		return token
+	}
+
+	return ""
+}
+
+// newRequireBearerMiddleware is the API counterpart of newRequireAuthMiddleware, session cookies are not accepted.
+// Requests made with an API key carry its scopes instead of a session, see requireScopeMiddleware.
+func newRequireBearerMiddleware(userService user.ServiceInterface, apiKeyService apikey.ServiceInterface) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := apiKeyFromRequest(r); key != "" {
+				u, apiKey, err := apiKeyService.Authenticate(key)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+					handlers.APIError(w, r, http.StatusUnauthorized, handlers.APIErrorUnauthorized, "the API key is invalid or expired")
+					return
+				}
+
+				next.ServeHTTP(w, user.AugmentRequestWithScopes(r, u, apiKey.Scopes))
+				return
+			}
+
+			u, session, err := userService.RetrieveAuthFromBearer(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				handlers.APIError(w, r, http.StatusUnauthorized, handlers.APIErrorUnauthorized, "a valid bearer token is required")
+				return
+			}
+
+			next.ServeHTTP(w, user.AugmentRequestWithAuth(r, u, session))
+		})
+	}
+}
+
+// requireScopeMiddleware rejects API keys missing the scope. An empty scope rejects API keys altogether,
+// for endpoints that need a signed-in user. It expects to run after newRequireBearerMiddleware.
+func requireScopeMiddleware(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !user.HasScope(r, scope) {
+			message := "this endpoint cannot be used with an API key"
+			if scope != "" {
+				message = "the API key is missing the " + scope + " scope"
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="insufficient_scope", scope="`+scope+`"`)
+			}
+
+			handlers.APIError(w, r, http.StatusForbidden, handlers.APIErrorInsufficientScope, message)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireConfirmedEmailAPIMiddleware expects to run after newRequireBearerMiddleware
+func requireConfirmedEmailAPIMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := user.RetrieveUser(r)
+		if err != nil {
+			handlers.APIError(w, r, http.StatusUnauthorized, handlers.APIErrorUnauthorized, "a valid bearer token is required")
+			return
+		}
+
+		if u.EmailConfirmed == nil {
+			handlers.APIError(w, r, http.StatusForbidden, handlers.APIErrorEmailNotConfirmed, "confirm your email address first")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireConfirmedEmailMiddleware expects to run after newRequireAuthMiddleware
+func requireConfirmedEmailMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _, err := user.RetrieveAuthDetails(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		if u.EmailConfirmed == nil {
+			http.Redirect(w, r, "/confirm-email/pending", http.StatusFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
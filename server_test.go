@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"github.com/mathieuhays/auth/internal/asserts"
+	"github.com/mathieuhays/auth/internal/services/user"
+	"github.com/mathieuhays/auth/internal/stores/sessions"
+	"github.com/mathieuhays/auth/internal/stores/users"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireConfirmedEmailMiddleware(t *testing.T) {
+	confirmedAt := time.Now()
+	testCases := []struct {
+		name     string
+		user     *users.User
+		status   int
+		location string
+	}{
+		{"anonymous", nil, http.StatusFound, "/login"},
+		{"unconfirmed", &users.User{Email: "test@example.com"}, http.StatusFound, "/confirm-email/pending"},
+		{"confirmed", &users.User{Email: "test@example.com", EmailConfirmed: &confirmedAt}, http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := requireConfirmedEmailMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			request := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			if tc.user != nil {
+				request = user.AugmentRequestWithAuth(request, tc.user, &sessions.Session{})
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			asserts.StatusCode(t, response, tc.status)
+			if location := response.Header().Get("Location"); location != tc.location {
+				t.Errorf("unexpected redirect. expected: %q. got: %q", tc.location, location)
+			}
+		})
+	}
+}
+
+type errorTemplatesStub struct {
+	title string
+}
+
+func (e *errorTemplatesStub) Error(writer io.Writer, title, description string) error {
+	e.title = title
+	return nil
+}
+
+func TestRequirePermissionMiddleware(t *testing.T) {
+	testCases := []struct {
+		name   string
+		user   *users.User
+		status int
+		title  string
+	}{
+		{"anonymous", nil, http.StatusFound, ""},
+		{"no role", &users.User{Email: "test@example.com"}, http.StatusForbidden, "Error 403"},
+		{"support", &users.User{Email: "test@example.com", Roles: []users.Role{users.RoleSupport}}, http.StatusForbidden, "Error 403"},
+		{"admin", &users.User{Email: "test@example.com", Roles: []users.Role{users.RoleAdmin}}, http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tpl := &errorTemplatesStub{}
+			middleware := newRequirePermissionMiddleware(tpl, users.PermissionUsersManage)
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			request := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tc.user != nil {
+				request = user.AugmentRequestWithAuth(request, tc.user, &sessions.Session{})
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			asserts.StatusCode(t, response, tc.status)
+			if tpl.title != tc.title {
+				t.Errorf("unexpected error page. expected: %q. got: %q", tc.title, tpl.title)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+(() => {
+    'use strict'
+
+    const colors = ['bg-danger', 'bg-danger', 'bg-warning', 'bg-info', 'bg-success']
+
+    document.querySelectorAll('input[data-password-strength]').forEach(input => {
+        const form = input.form
+        const meter = form.querySelector('[data-password-strength-meter]')
+        const bar = meter.querySelector('.progress-bar')
+        const label = form.querySelector('[data-password-strength-label]')
+        const csrf = form.querySelector('input[name="csrf_token"]')
+        const emailField = input.dataset.emailField ? form.elements[input.dataset.emailField] : null
+        let timer = null
+
+        const update = async () => {
+            if (input.value === '') {
+                meter.classList.add('d-none')
+                label.textContent = ''
+                return
+            }
+
+            const body = new URLSearchParams({password: input.value, email: emailField ? emailField.value : ''})
+            const response = await fetch('/password/strength', {
+                method: 'POST',
+                headers: {'X-CSRF-Token': csrf ? csrf.value : ''},
+                body,
+            })
+
+            if (!response.ok) {
+                return
+            }
+
+            const result = await response.json()
+            meter.classList.remove('d-none')
+            bar.className = 'progress-bar ' + colors[result.score]
+            bar.style.width = ((result.score + 1) * 20) + '%'
+            label.textContent = 'Strength: ' + result.label +
+                (result.violations.length ? ' — ' + result.violations.join(', ') : '')
+        }
+
+        input.addEventListener('input', () => {
+            clearTimeout(timer)
+            timer = setTimeout(update, 250)
+        })
+    })
+})()
@@ -0,0 +1,97 @@
+(() => {
+    'use strict'
+
+    if (!window.PublicKeyCredential) {
+        return
+    }
+
+    const decode = value => Uint8Array.from(atob(value.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0))
+
+    const encode = buffer => btoa(String.fromCharCode(...new Uint8Array(buffer)))
+        .replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+
+    const post = async (form, url, body) => {
+        const csrf = form.querySelector('input[name="csrf_token"]')
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': csrf ? csrf.value : ''},
+            body: JSON.stringify(body || {}),
+        })
+
+        const result = await response.json()
+        if (!response.ok) {
+            throw new Error(result.error || 'something went wrong. please try again')
+        }
+
+        return result
+    }
+
+    const showError = (form, message) => {
+        const alert = form.querySelector('[data-passkey-error]') || document.querySelector('[data-passkey-error]')
+        alert.textContent = message
+        alert.classList.remove('d-none')
+    }
+
+    const register = async form => {
+        const {ceremony, publicKey} = await post(form, '/account/passkeys/register/begin')
+        publicKey.challenge = decode(publicKey.challenge)
+        publicKey.user.id = decode(publicKey.user.id)
+        publicKey.excludeCredentials.forEach(c => c.id = decode(c.id))
+
+        const credential = await navigator.credentials.create({publicKey})
+        return post(form, '/account/passkeys/register/finish', {
+            ceremony,
+            name: form.elements.name.value,
+            credential: {
+                id: credential.id,
+                rawId: encode(credential.rawId),
+                type: credential.type,
+                response: {
+                    clientDataJSON: encode(credential.response.clientDataJSON),
+                    attestationObject: encode(credential.response.attestationObject),
+                    transports: credential.response.getTransports ? credential.response.getTransports() : [],
+                },
+            },
+        })
+    }
+
+    const login = async form => {
+        const {ceremony, publicKey} = await post(form, '/login/passkey/begin')
+        publicKey.challenge = decode(publicKey.challenge)
+        publicKey.allowCredentials.forEach(c => c.id = decode(c.id))
+
+        const credential = await navigator.credentials.get({publicKey})
+        return post(form, '/login/passkey/finish', {
+            ceremony,
+            credential: {
+                id: credential.id,
+                rawId: encode(credential.rawId),
+                type: credential.type,
+                response: {
+                    clientDataJSON: encode(credential.response.clientDataJSON),
+                    authenticatorData: encode(credential.response.authenticatorData),
+                    signature: encode(credential.response.signature),
+                    userHandle: credential.response.userHandle ? encode(credential.response.userHandle) : '',
+                },
+            },
+        })
+    }
+
+    const bind = (selector, ceremony) => {
+        document.querySelectorAll(selector).forEach(form => {
+            form.classList.remove('d-none')
+            form.addEventListener('submit', async event => {
+                event.preventDefault()
+                try {
+                    const {redirect} = await ceremony(form)
+                    window.location.assign(redirect)
+                } catch (e) {
+                    showError(form, e.name === 'NotAllowedError' ? 'The request was cancelled.' : e.message)
+                }
+            })
+        })
+    }
+
+    bind('form[data-passkey-register]', register)
+    bind('form[data-passkey-login]', login)
+})()
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"embed"
+	"github.com/mathieuhays/auth/internal/mailer"
+	"github.com/mathieuhays/auth/internal/stores/users"
 	"html/template"
+	texttemplate "text/template"
 )
 
 //go:embed templates/fragments templates
@@ -13,5 +16,38 @@ func Templates() (*template.Template, error) {
 		"assets": func(file string) string {
 			return "/static/" + file
 		},
+		"errorMessages": func(err error) []string {
+			if errs, ok := err.(interface{ Unwrap() []error }); ok {
+				var messages []string
+				for _, e := range errs.Unwrap() {
+					messages = append(messages, e.Error())
+				}
+				return messages
+			}
+
+			return []string{err.Error()}
+		},
+		// can checks a permission of the user, it is safe to call without a user
+		"can": func(u *users.User, permission users.Permission) bool {
+			return u != nil && u.Can(permission)
+		},
+		"csrfField": func(token string) template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` +
+				template.HTMLEscapeString(token) + `">`)
+		},
 	}).ParseFS(templatesFS, "templates/*.gohtml", "templates/fragments/*.gohtml")
 }
+
+func EmailTemplates() (*mailer.Templates, error) {
+	html, err := template.New("emails").ParseFS(templatesFS, "templates/emails/*.html.gohtml")
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := texttemplate.New("emails").ParseFS(templatesFS, "templates/emails/*.txt.gohtml")
+	if err != nil {
+		return nil, err
+	}
+
+	return mailer.NewTemplates(html, text), nil
+}